@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// auditRecord is a line of the -audit file.
+type auditRecord struct {
+	File     string `json:"file"`
+	Variable string `json:"variable"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Source   string `json:"source,omitempty"`
+	Default  bool   `json:"default"`
+	Value    string `json:"value"`
+}
+
+// auditLog writes the substitutions of the files rendered in parallel to
+// the -audit file. Each record is written at once, so nothing is lost when
+// the command exits.
+type auditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// openAudit creates the audit file at path, only readable by the user as
+// unmasked values may be secrets.
+func openAudit(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{enc: json.NewEncoder(f)}, nil
+}
+
+// record writes the record of s.
+func (l *auditLog) record(s parse.Substitution) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(auditRecord{s.Template, s.Variable, s.Line, s.Col, s.Source, s.Default, s.Value}); err != nil {
+		logger.Warn("failed to write audit record", "error", err)
+	}
+}
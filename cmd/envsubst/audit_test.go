@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+var auditTests = []cliTest{
+	{name: "audit", args: []string{"-env-file", "b.env", "-mask", "*_TOKEN", "-audit", "audit.json"}, env: []string{"A=1", "API_TOKEN=s3cret"},
+		stdin: "$A ${B} ${C:-x} $API_TOKEN", files: map[string]string{"b.env": "B=2\n"}, stdout: "1 2 x s3cret",
+		output: map[string]string{"audit.json": `{"file":"-","variable":"A","line":1,"column":1,"source":"environment","default":false,"value":"1"}
+{"file":"-","variable":"B","line":1,"column":4,"source":"b.env","default":false,"value":"2"}
+{"file":"-","variable":"C","line":1,"column":9,"default":true,"value":"x"}
+{"file":"-","variable":"API_TOKEN","line":1,"column":17,"source":"environment","default":false,"value":"***"}
+`}},
+	{name: "audit directory", args: []string{"-audit", "dir/audit.json"}, stdin: "$A", code: 1, stderr: "dir/audit.json"},
+}
+
+func TestAudit(t *testing.T) {
+	for _, test := range auditTests {
+		runMain(t, test)
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"github.com/hellt/envsubst/parse"
+	"github.com/hellt/envsubst/resolver"
+)
+
+// awsClient reads the AWS SSM parameters and Secrets Manager secrets, with
+// the credentials, profile and region configured for the AWS CLI unless
+// overridden with -aws-profile and -aws-region.
+var awsClient = &resolver.AWS{}
+
+func init() {
+	parse.RegisterResolver("ssm", awsClient.SSM())
+	parse.RegisterResolver("secretsmanager", awsClient.SecretsManager())
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// parseBuiltins returns the pseudo-variables of the comma separated groups
+// of -builtins.
+func parseBuiltins(groups string) (parse.Builtins, error) {
+	var builtins parse.Builtins
+	for _, group := range strings.Split(groups, ",") {
+		switch strings.TrimSpace(group) {
+		case "":
+		case "time":
+			now, err := clock()
+			if err != nil {
+				return nil, err
+			}
+			builtins = builtins.With(parse.TimeBuiltins(now))
+		case "host":
+			builtins = builtins.With(parse.HostBuiltins())
+		case "random":
+			if randomSeed != "" {
+				s, err := strconv.ParseInt(randomSeed, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid random seed: %s.", randomSeed)
+				}
+				seed = &s
+			}
+			builtins = builtins.With(parse.RandomBuiltins(nil))
+		default:
+			return nil, fmt.Errorf("Unknown builtins: %s.", group)
+		}
+	}
+	return builtins, nil
+}
+
+// clock returns the time of the time builtins, fixed to SOURCE_DATE_EPOCH
+// if set as the specification of reproducible builds asks for.
+func clock() (func() time.Time, error) {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return time.Now, nil
+	}
+	sec, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid SOURCE_DATE_EPOCH: %s.", epoch)
+	}
+	t := time.Unix(sec, 0).UTC()
+	return func() time.Time { return t }, nil
+}
+
+// builtinsFor returns the builtins of the input name. Seeded random builtins
+// get a generator of their own for each input, seeded from the seed and name,
+// so that their values do not depend on the inputs rendered in parallel.
+func builtinsFor(name string) parse.Builtins {
+	if seed == nil {
+		return builtins
+	}
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	r := rand.New(rand.NewSource(*seed ^ int64(h.Sum64())))
+	return builtins.With(parse.RandomBuiltins(r))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// seeded returns the ${__RANDOM:hex:8} of each input with -random-seed s.
+func seeded(t *testing.T, s int64, names ...string) []string {
+	t.Helper()
+	defer func(b parse.Builtins, s *int64) { builtins, seed = b, s }(builtins, seed)
+	builtins, seed = parse.RandomBuiltins(nil), &s
+	values := make([]string, len(names))
+	for i, name := range names {
+		v, err := builtinsFor(name)["__RANDOM"]("hex:8")
+		if err != nil {
+			t.Fatal(err)
+		}
+		values[i] = v
+	}
+	return values
+}
+
+func TestBuiltinsFor(t *testing.T) {
+	a, b := seeded(t, 42, "a.tmpl", "b.tmpl"), seeded(t, 42, "b.tmpl", "a.tmpl")
+	if a[0] != b[1] || a[1] != b[0] {
+		t.Errorf("got %v and %v, expected the values of each input whatever the order", a, b)
+	}
+	if a[0] == a[1] {
+		t.Errorf("got %v, expected the inputs to have their own values", a)
+	}
+	if c := seeded(t, 43, "a.tmpl"); c[0] == a[0] {
+		t.Errorf("got %s with seeds 42 and 43", c[0])
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	names := []string{"a.tmpl", "b.tmpl", "c.tmpl", "d.tmpl", "e.tmpl", "f.tmpl"}
+	files := map[string]string{}
+	for _, name := range names {
+		files[name] = "${__RANDOM:hex:8}\n"
+	}
+	random := strings.Join(seeded(t, 42, names...), "\n") + "\n"
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []cliTest{
+		{name: "time", args: []string{"-builtins", "time"}, env: []string{"SOURCE_DATE_EPOCH=86400"},
+			stdin: "$__NOW ${__DATE:2006} $__UNIX_TS", stdout: "1970-01-02T00:00:00Z 1970 86400"},
+		{name: "date layout", args: []string{"-builtins", "time"}, env: []string{"SOURCE_DATE_EPOCH=86400"},
+			stdin: "${__DATE} ${__DATE:Jan 2}", stdout: "1970-01-02 Jan 2"},
+		{name: "time argument", args: []string{"-builtins", "time"}, stdin: "${__NOW:2006}", code: 1, stderr: "__NOW takes no argument"},
+		{name: "invalid epoch", args: []string{"-builtins", "time"}, env: []string{"SOURCE_DATE_EPOCH=x"},
+			code: 1, stderr: "Invalid SOURCE_DATE_EPOCH: x."},
+		{name: "host", args: []string{"-builtins", "host"}, env: []string{"HOSTNAME=env"},
+			stdin: "$__HOSTNAME $__OS/$__ARCH $__UID", stdout: hostname + " " + runtime.GOOS + "/" + runtime.GOARCH + " " + strconv.Itoa(os.Getuid())},
+		{name: "disabled", stdin: "x$__UUID", stdout: "x"},
+		{name: "unknown builtins", args: []string{"-builtins", "time,disk"}, code: 1, stderr: "Unknown builtins: disk."},
+		{name: "invalid seed", args: []string{"-builtins", "random", "-random-seed", "x"}, code: 1,
+			stderr: "Invalid random seed: x."},
+		{name: "seed", args: append([]string{"render", "-builtins", "random", "-random-seed", "42"}, names...),
+			files: files, stdout: random},
+		{name: "seed jobs", args: append([]string{"render", "-builtins", "random", "-random-seed", "42", "-jobs", "4"}, names...),
+			files: files, stdout: random},
+	}
+	for _, test := range tests {
+		runMain(t, test)
+	}
+}
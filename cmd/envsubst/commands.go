@@ -0,0 +1,232 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// command is a subcommand of envsubst.
+type command struct {
+	usage string                 // usage of the command specific options
+	flags func(fs *flag.FlagSet) // registers the command specific options
+	run   func(jobs []job) []diagnostic
+}
+
+// commands are the subcommands of envsubst. Without a command
+// the arguments are passed to render.
+var commands = map[string]*command{
+	"render": {
+		usage: renderUsage,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&output, "o", "", "")
+			fs.StringVar(&stripExt, "strip-ext", "", "")
+			fs.BoolVar(&copyOther, "copy-other", false, "")
+			fs.BoolVar(&inPlace, "in-place", false, "")
+			fs.Var(&fileMode, "chmod", "")
+			fs.Var(&dirMode, "dir-mode", "")
+			fs.BoolVar(&ifChanged, "if-changed", false, "")
+		},
+		run: func(jobs []job) []diagnostic {
+			return writeResults(runJobs(jobs, numJobs, job.render))
+		},
+	},
+	"check": {
+		flags: func(fs *flag.FlagSet) {},
+		run: func(jobs []job) []diagnostic {
+			return writeResults(runJobs(jobs, numJobs, job.check))
+		},
+	},
+	"vars": {
+		usage: varsUsage,
+		flags: func(fs *flag.FlagSet) {
+			fs.BoolVar(&listUnset, "list-unset", false, "")
+			fs.BoolVar(&locations, "locations", false, "")
+		},
+		run: runVars,
+	},
+	"diff": {
+		usage: diffUsage,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&output, "o", "", "")
+			fs.StringVar(&stripExt, "strip-ext", "", "")
+		},
+		run: func(jobs []job) []diagnostic {
+			return writeResults(runJobs(jobs, numJobs, job.diff))
+		},
+	},
+	"compare": {
+		usage: compareUsage,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&beforeFile, "before", "", "")
+			fs.StringVar(&afterFile, "after", "", "")
+		},
+		run: runCompare,
+	},
+	"exec": {
+		usage: execUsage,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&output, "o", "", "")
+			fs.StringVar(&stripExt, "strip-ext", "", "")
+			fs.BoolVar(&inPlace, "in-place", false, "")
+			fs.Var(&fileMode, "chmod", "")
+			fs.BoolVar(&renderEnv, "render-env", false, "")
+		},
+		run: runExec,
+	},
+	"serve": {
+		usage: serveUsage,
+		flags: func(fs *flag.FlagSet) {
+			fs.StringVar(&serveRoot, "root", ".", "")
+			fs.StringVar(&listen, "listen", "localhost:8080", "")
+		},
+		run: runServe,
+	},
+}
+
+var renderUsage = `  -o         Specify file output. If none is specified, write to stdout.
+             With several inputs or a directory input, -o names the output
+             directory the inputs are rendered into, keeping their relative
+             paths. Several files without -o are written to stdout in order.
+             The path may reference variables, e.g. 'out/${ENVIRONMENT}/'.
+  -strip-ext Only render inputs with this extension, e.g. .tmpl, and remove it
+             from their output path: config.yaml.tmpl renders to config.yaml.
+  -copy-other
+             With -strip-ext, copy inputs without the extension to the output
+             directory unchanged instead of skipping them.
+  -in-place  Replace each input file with its rendered content, e.g.
+             find . -name '*.yaml' -print0 | envsubst -files-from - -0 -in-place
+  -chmod     Octal permissions of the written files, e.g. 0640, as rendered
+             files often hold secrets. Defaults to 0666 less the umask for new
+             files and keeps the permissions of existing ones.
+  -dir-mode  Octal permissions of the directories created for the outputs,
+             e.g. 0750. Defaults to 0755 less the umask.
+  -if-changed
+             Only write output files whose content changes, keeping the
+             modification time of the others for make and file watchers.
+`
+
+var varsUsage = `  -list-unset
+             Only list the variables that are not set.
+  -locations List every reference as NAME file:line:column in order of
+             appearance instead of the sorted names.
+`
+
+var diffUsage = `  -o         Compare with the outputs at this path, as written by render -o.
+             Without -o the rendered inputs are compared with the inputs.
+  -strip-ext Only compare inputs with this extension, removing it from the
+             path of their output, see render.
+`
+
+// renderInPlace makes the jobs write their output to their input.
+func renderInPlace(jobs []job) []job {
+	var inPlace []job
+	for _, j := range jobs {
+		if !j.copy {
+			inPlace = append(inPlace, job{in: j.in, out: j.in})
+		}
+	}
+	return inPlace
+}
+
+// check renders the input without writing it anywhere.
+func (j job) check() jobResult {
+	if j.copy {
+		return jobResult{}
+	}
+	data, diags := j.read()
+	if diags != nil {
+		return jobResult{diags: diags}
+	}
+	if _, diags := j.substitute(data); diags != nil {
+		return jobResult{diags: diags}
+	}
+	return jobResult{}
+}
+
+// vars returns the variables referenced by the input, one per line.
+// With -locations each line is followed by the location of the reference.
+// With -list-unset only variables that are not set are returned.
+func (j job) vars() jobResult {
+	if j.copy {
+		return jobResult{}
+	}
+	data, diags := j.read()
+	if diags != nil {
+		return jobResult{diags: diags}
+	}
+	refs, err := newParser(j.name()).References(data)
+	if err != nil {
+		return jobResult{diags: diagnostics(j.name(), err)}
+	}
+	var names strings.Builder
+	for _, ref := range refs {
+		if listUnset && parse.Env(env).Has(ref.Name) {
+			continue
+		}
+		if locations {
+			fmt.Fprintf(&names, "%s %s:%d:%d\n", ref.Name, j.name(), ref.Line, ref.Col)
+		} else {
+			names.WriteString(ref.Name + "\n")
+		}
+	}
+	return jobResult{data: names.String()}
+}
+
+// runVars prints the variables referenced by all inputs. Names are printed
+// once in sorted order, with -locations every reference is printed in order.
+func runVars(jobs []job) []diagnostic {
+	results := runJobs(jobs, numJobs, job.vars)
+	if locations {
+		return writeResults(results)
+	}
+	seen := map[string]bool{}
+	for i, res := range results {
+		if res.diags != nil {
+			continue
+		}
+		for _, name := range splitLines(res.data) {
+			seen[strings.TrimSuffix(name, "\n")] = true
+		}
+		results[i].data = ""
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		results = append(results, jobResult{data: strings.Join(names, "\n") + "\n"})
+	}
+	return writeResults(results)
+}
+
+// diff returns the differences between the current output, or the input
+// if there is no output, and the rendered input. Values of masked variables
+// are redacted.
+func (j job) diff() jobResult {
+	if j.copy {
+		return jobResult{}
+	}
+	data, diags := j.read()
+	if diags != nil {
+		return jobResult{diags: diags}
+	}
+	result, diags := j.substitute(data)
+	if diags != nil {
+		return jobResult{diags: diags}
+	}
+	name, current := j.name(), data
+	if j.out != "" {
+		name = j.out
+		current, diags = job{in: j.out}.read()
+		if diags != nil {
+			return jobResult{diags: diags}
+		}
+	}
+	d := unifiedDiff(name, fmt.Sprintf("%s (rendered)", j.name()), current, result)
+	return jobResult{data: masks.mask(d)}
+}
@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+var commandTests = []cliTest{
+	{name: "vars", args: []string{"vars", "a.tmpl", "b.tmpl"}, files: map[string]string{
+		"a.tmpl": "a=$B ${A:-x}\n$$C", "b.tmpl": "${B|upper} $D"}, stdout: "A\nB\nD\n"},
+	{name: "vars stdin", args: []string{"vars"}, stdin: "$Z $Y", stdout: "Y\nZ\n"},
+	{name: "vars syntax error", args: []string{"vars"}, stdin: "${A", code: 1, stderr: "closing brace expected"},
+	{name: "list unset", args: []string{"vars", "-list-unset", "a.tmpl", "b.tmpl"}, env: []string{"A=1", "E="},
+		files: map[string]string{"a.tmpl": "$A ${B:-x} $E", "b.tmpl": "$C $B"}, stdout: "B\nC\n"},
+	{name: "list unset none", args: []string{"vars", "-list-unset"}, env: []string{"A=1"}, stdin: "$A"},
+	{name: "locations", args: []string{"vars", "-locations", "a.tmpl", "b.tmpl"}, env: []string{"A=1"},
+		files:  map[string]string{"a.tmpl": "a=$A\n  ${B}", "b.tmpl": "$A"},
+		stdout: "A a.tmpl:1:3\nB a.tmpl:2:3\nA b.tmpl:1:1\n"},
+	{name: "locations unset", args: []string{"vars", "-list-unset", "-locations"}, env: []string{"A=1"},
+		stdin: "$A $B\n$B", stdout: "B -:1:4\nB -:2:1\n"},
+	{name: "diff", args: []string{"diff", "-o", "a.out", "a.tmpl"}, env: []string{"A=1"},
+		files:  map[string]string{"a.tmpl": "a=$A\nb\n", "a.out": "a=0\nb\n"},
+		stdout: "--- a.out\n+++ a.tmpl (rendered)\n@@ -1,2 +1,2 @@\n-a=0\n+a=1\n b\n"},
+	{name: "diff input", args: []string{"diff", "a.tmpl"}, env: []string{"A=1"},
+		files: map[string]string{"a.tmpl": "a=$A\n"}, stdout: "--- a.tmpl\n+++ a.tmpl (rendered)\n@@ -1 +1 @@\n-a=$A\n+a=1\n"},
+	{name: "diff unchanged", args: []string{"diff", "a.tmpl"}, files: map[string]string{"a.tmpl": "a\n"}},
+	{name: "diff missing output", args: []string{"diff", "-o", "a.out", "a.tmpl"},
+		files: map[string]string{"a.tmpl": "a\n"}, code: 1, stderr: "Error to open file input: a.out."},
+	{name: "check", args: []string{"check", "a.tmpl"}, env: []string{"A=1"}, files: map[string]string{"a.tmpl": "$A"}},
+	{name: "check output flag", args: []string{"check", "-o", "out", "a.tmpl"}, code: 2,
+		stderr: "flag provided but not defined: -o"},
+	{name: "compare", args: []string{"compare", "-before", "staging.env", "-after", "prod.env", "-mask", "*_TOKEN", "a.tmpl"},
+		files: map[string]string{"staging.env": "A=1\nB=2\nAPI_TOKEN=s\n", "prod.env": "A=1\nB=3\nC=c\nAPI_TOKEN=p\n",
+			"a.tmpl": "a=$A b=$B\nc=${C:-none} t=$API_TOKEN\n"},
+		stdout: "a.tmpl:1:8: B: \"2\" -> \"3\"\na.tmpl:2:3: C: \"none\" -> \"c\"\na.tmpl:2:16: API_TOKEN: *** -> ***\n"},
+	{name: "compare same", args: []string{"compare", "-before", "a.env", "-after", "a.env"}, stdin: "$A",
+		files: map[string]string{"a.env": "A=1\n"}},
+	{name: "compare after", args: []string{"compare", "-before", "a.env"}, stdin: "$A",
+		files: map[string]string{"a.env": "A=1\n"}, code: 1, stderr: "compare needs -before and -after."},
+}
+
+func TestCommands(t *testing.T) {
+	for _, test := range commandTests {
+		runMain(t, test)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+var compareUsage = `  -before    File of the variables the inputs are rendered with first, either
+             NAME=VALUE lines or, for .yaml and .yml files, a mapping of names
+             to values, over the environment.
+  -after     File of the variables the inputs are rendered with second, like
+             -before. The substitutions whose values differ are printed as
+             file:line:column: NAME: BEFORE -> AFTER, with the values of the
+             variables masked like -mask redacted. Lines and columns are
+             those of the scalars in yaml and csv mode.
+`
+
+// comparing are the environments of -before and -after.
+var comparing [2][]string
+
+// runCompare prints the substitutions of the inputs whose values differ
+// between the environments of -before and -after.
+func runCompare(jobs []job) []diagnostic {
+	for i, path := range []string{beforeFile, afterFile} {
+		if path == "" {
+			usageAndExit("compare needs -before and -after.")
+		}
+		vars, err := readVarsFile(path)
+		if err != nil {
+			return []diagnostic{ioDiagnostic(path, fmt.Sprintf("Error to read variables: %v", err))}
+		}
+		comparing[i] = append(vars, env...)
+	}
+	return writeResults(runJobs(jobs, numJobs, job.compare))
+}
+
+// compare renders the input with the environments of -before and -after
+// and lists the substitutions whose values differ.
+func (j job) compare() jobResult {
+	if j.copy {
+		return jobResult{}
+	}
+	data, diags := j.read()
+	if diags != nil {
+		return jobResult{diags: diags}
+	}
+	var subs [2][]parse.Substitution
+	for i, vars := range comparing {
+		p := j.parser()
+		p.Env = vars
+		// Values are compared as substituted and masked once compared.
+		p.Mask, p.Transform, p.Provenance, p.Lock = nil, nil, nil, nil
+		p.Audit = func(s parse.Substitution) { subs[i] = append(subs[i], s) }
+		if _, _, err := j.renderer()(p, data); err != nil {
+			return jobResult{diags: withSource(diagnostics(j.name(), err), data)}
+		}
+	}
+	before := newMasker(maskFlag, secretVars, comparing[0])
+	after := newMasker(maskFlag, secretVars, comparing[1])
+	var out strings.Builder
+	for _, c := range parse.Changes(subs[0], subs[1]) {
+		fmt.Fprintf(&out, "%s:%d:%d: %s: %s -> %s\n", c.Template, c.Line, c.Col, c.Variable,
+			compared(before, c.Before), compared(after, c.After))
+	}
+	return jobResult{data: out.String()}
+}
+
+// compared returns the value of s quoted and masked with m, or a note that
+// there was no substitution.
+func compared(m *masker, s *parse.Substitution) string {
+	switch {
+	case s == nil:
+		return "(not substituted)"
+	case m.matches(s.Variable):
+		return parse.Masked
+	}
+	return m.mask(fmt.Sprintf("%q", s.Value))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfig is read from the working directory when -config is not given.
+const defaultConfig = ".envsubst.yaml"
+
+// safeOptions are the options the default config of the working directory
+// may set: those choosing how the inputs are rendered and checked. Running
+// envsubst in a repository must not let its config file run commands, read
+// or write files, or fetch variables from secret stores and remote sources,
+// so the other options are only read from the command line and from config
+// files named with -config.
+var safeOptions = map[string]bool{
+	"annotate":              true,
+	"builtins":              true,
+	"copy-other":            true,
+	"deny":                  true,
+	"deprecated":            true,
+	"directives":            true,
+	"eol":                   true,
+	"exclude":               true,
+	"explain":               true,
+	"fail-fast":             true,
+	"fail-on-empty-default": true,
+	"fail-on-empty-output":  true,
+	"flatten-case":          true,
+	"flatten-join":          true,
+	"flatten-sep":           true,
+	"format":                true,
+	"hcl-allow":             true,
+	"if-changed":            true,
+	"include":               true,
+	"jobs":                  true,
+	"library":               true,
+	"list-unset":            true,
+	"locations":             true,
+	"log-format":            true,
+	"log-level":             true,
+	"map-deprecated":        true,
+	"mask":                  true,
+	"max-depth":             true,
+	"max-input":             true,
+	"max-size":              true,
+	"mode":                  true,
+	"no-digit":              true,
+	"no-empty":              true,
+	"no-unset":              true,
+	"profile":               true,
+	"provenance":            true,
+	"redact":                true,
+	"require-substitution":  true,
+	"strip-ext":             true,
+	"transform":             true,
+}
+
+// configPath returns the config file named with -config in args, or the
+// default config if it exists. The boolean reports whether the file was
+// named explicitly.
+func configPath(args []string) (string, bool) {
+	for i, arg := range args {
+		if arg == "--" || !strings.HasPrefix(arg, "-") {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name != "config" {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			value = args[i+1]
+		}
+		return value, true
+	}
+	return defaultConfig, false
+}
+
+// loadConfig applies the options of the config file at path to flags. Its keys
+// are flag names, its values scalars or, for flags that may be repeated,
+// lists. Flags given on the command line are parsed afterwards and take
+// precedence. The default config may only set the safeOptions.
+//
+//	profile: strict
+//	mode: auto
+//	exclude: ["*.png", vendor]
+func loadConfig(flags *flag.FlagSet, path string, explicit bool) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("Error to read config file: %s: %v", path, err)
+	}
+	var options map[string]interface{}
+	if err := yaml.Unmarshal(b, &options); err != nil {
+		return fmt.Errorf("Error to parse config file: %s: %v", path, err)
+	}
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if flags.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("Unknown option in config file: %s: %s", path, name)
+		}
+		if !safeOptions[name] && !explicit {
+			return fmt.Errorf("Option not allowed in config file: %s: %s, give it on the command line or name the file with -config", path, name)
+		}
+		values, ok := options[name].([]interface{})
+		if !ok {
+			values = []interface{}{options[name]}
+		}
+		for _, v := range values {
+			if err := flags.Set(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("Invalid option in config file: %s: %s: %v", path, name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// debugFlag is the -debug-ast flag, tree when given without a value.
+type debugFlag string
+
+func (d *debugFlag) String() string {
+	return string(*d)
+}
+
+func (d *debugFlag) Set(value string) error {
+	switch value {
+	case "true", "tree":
+		*d = "tree"
+	case "false":
+		*d = ""
+	case "tokens":
+		*d = "tokens"
+	default:
+		return fmt.Errorf("expected tree or tokens, got %q", value)
+	}
+	return nil
+}
+
+func (d *debugFlag) IsBoolFlag() bool {
+	return true
+}
+
+// dump returns the tokens or the parse tree of the input, see -debug-ast,
+// headed by its name if there are several inputs.
+func (j job) dump() jobResult {
+	if j.copy {
+		return jobResult{}
+	}
+	data, diags := j.read()
+	if diags != nil {
+		return jobResult{diags: diags}
+	}
+	var out strings.Builder
+	if showFiles {
+		fmt.Fprintf(&out, "# %s\n", j.name())
+	}
+	p := newParser(j.name())
+	dump := p.DumpTree
+	if debugAST == "tokens" {
+		dump = p.DumpTokens
+	}
+	if err := dump(&out, data); err != nil {
+		return jobResult{diags: diagnostics(j.name(), err)}
+	}
+	return jobResult{data: out.String()}
+}
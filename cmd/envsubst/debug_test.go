@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+var debugTests = []cliTest{
+	{name: "debug tree", args: []string{"-debug-ast"}, stdin: "a ${B:-x}\n",
+		stdout: "1:1\tText \"a \"\n1:3\tSubstitution op=\":-\"\n1:3\t  Variable B\n1:8\t  Text \"x\"\n1:10\tText \"\\n\"\n"},
+	{name: "debug tokens", args: []string{"-debug-ast=tokens"}, stdin: "a ${B:-x}",
+		stdout: "1:1\tTEXT\t\"a \"\n1:3\tSTART EXP\t\"${\"\n1:5\tVAR\t\"B\"\n1:6\tOP\t\":-\"\n1:8\tTEXT\t\"x\"\n1:9\tEND EXP\t\"}\"\n1:10\tEOF\t\"\"\n"},
+	{name: "debug files", args: []string{"render", "-debug-ast", "a.tmpl", "c.tmpl"},
+		files:  map[string]string{"a.tmpl": "a", "c.tmpl": "$C"},
+		stdout: "# a.tmpl\n1:1\tText \"a\"\n# c.tmpl\n1:1\tVariable C\n", absent: []string{"a", "c"}},
+	{name: "debug syntax error", args: []string{"-debug-ast"}, stdin: "a ${B", code: 1, stderr: "closing brace expected"},
+	{name: "debug unknown", args: []string{"-debug-ast=nodes"}, code: 2, stderr: `expected tree or tokens, got "nodes"`},
+}
+
+func TestDebug(t *testing.T) {
+	for _, test := range debugTests {
+		runMain(t, test)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// diagnostic is a single error or warning reported by the command.
+type diagnostic struct {
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Variable string `json:"variable,omitempty"`
+	Kind     string `json:"kind"`
+	Code     string `json:"code,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	source   string // line of the input the diagnostic refers to, see -explain
+}
+
+// Severities of a diagnostic
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// Kinds of diagnostics besides the parse.ErrorKind ones
+const (
+	kindIO             = "io"              // not caused by the template itself
+	kindNoSubstitution = "no-substitution" // see -require-substitution
+	kindEmptyOutput    = "empty-output"    // see -fail-on-empty-output
+)
+
+// codes are the stable codes of the kinds of diagnostics besides the
+// parse.ErrorKind ones, following theirs.
+var codes = map[string]string{
+	kindNoSubstitution: "ENV008",
+	kindEmptyOutput:    "ENV009",
+	kindIO:             "ENV010",
+	kindLock:           "ENV012",
+}
+
+// code returns the stable code of the diagnostics of kind, see
+// parse.ErrorKind.Code.
+func code(kind string) string {
+	if c, ok := codes[kind]; ok {
+		return c
+	}
+	return parse.ErrorKind(kind).Code()
+}
+
+// ioDiagnostic returns an error diagnostic that is not caused by the template itself.
+func ioDiagnostic(file, msg string) diagnostic {
+	return diagnostic{File: file, Kind: kindIO, Severity: severityError, Message: msg}
+}
+
+// diagnostics converts an error returned by the parser into diagnostics for file.
+func diagnostics(file string, err error) []diagnostic {
+	var list parse.ErrorList
+	if !errors.As(err, &list) {
+		var e *parse.Error
+		if !errors.As(err, &e) {
+			return []diagnostic{ioDiagnostic(file, err.Error())}
+		}
+		list = parse.ErrorList{e}
+	}
+	diags := make([]diagnostic, 0, len(list))
+	for _, e := range list {
+		diags = append(diags, diagnostic{
+			File:     file,
+			Line:     e.Line,
+			Column:   e.Col,
+			Variable: e.Variable,
+			Kind:     string(e.Kind),
+			Severity: severityError,
+			Message:  e.Message(),
+		})
+	}
+	return diags
+}
+
+// showFiles prefixes text diagnostics with their file name when several files are rendered.
+var showFiles bool
+
+// writeDiagnostics prints diags to w in the given format.
+func writeDiagnostics(w io.Writer, format string, diags []diagnostic) {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		for _, d := range diags {
+			d.Code = code(d.Kind)
+			enc.Encode(d)
+		}
+	default:
+		for _, d := range diags {
+			if explain {
+				writeExplained(w, d)
+				continue
+			}
+			if showFiles && d.File != "" {
+				fmt.Fprintf(w, "%s: ", d.File)
+			}
+			fmt.Fprintln(w, d.Message)
+		}
+		if !explain {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// writeAnnotations prints diags as workflow commands of the given CI system,
+// so they show up inline on the annotated files.
+func writeAnnotations(w io.Writer, system string, diags []diagnostic) {
+	switch system {
+	case "github":
+		for _, d := range diags {
+			var props []string
+			if d.File != "" && d.File != "-" {
+				props = append(props, "file="+escapeGitHubProperty(d.File))
+			}
+			if d.Line > 0 {
+				props = append(props, fmt.Sprintf("line=%d", d.Line))
+			}
+			if d.Column > 0 {
+				props = append(props, fmt.Sprintf("col=%d", d.Column))
+			}
+			if c := code(d.Kind); c != "" {
+				props = append(props, "title="+c)
+			}
+			cmd := d.Severity
+			if len(props) > 0 {
+				cmd += " " + strings.Join(props, ",")
+			}
+			fmt.Fprintf(w, "::%s::%s\n", cmd, escapeGitHubData(d.Message))
+		}
+	}
+}
+
+func escapeGitHubData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func escapeGitHubProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+var diagTests = []cliTest{
+	{name: "json", args: []string{"-no-unset", "-format", "json"}, stdin: "a=$A", code: 1,
+		stderr: `{"file":"-","line":1,"column":3,"variable":"A","kind":"unset","code":"ENV001","severity":"error","message":"variable ${A} not set"}` + "\n"},
+	{name: "json file", args: []string{"-format", "json", "a.tmpl"}, files: map[string]string{"a.tmpl": "\n${A"}, code: 1,
+		stderr: `{"file":"a.tmpl","line":2,"column":4,"kind":"syntax","code":"ENV003"`},
+	{name: "json io", args: []string{"-format", "json", "missing.tmpl"}, code: 1,
+		stderr: `"kind":"io","code":"ENV010","severity":"error"`},
+	{name: "json empty", args: []string{"-no-empty", "-format", "json"}, env: []string{"A="}, stdin: "$A", code: 1,
+		stderr: `"variable":"A","kind":"empty","code":"ENV002"`},
+	{name: "json limit", args: []string{"-max-size", "3", "-format", "json"}, env: []string{"A=long"}, stdin: "$A", code: 1,
+		stderr: `"kind":"limit","code":"ENV004"`},
+	{name: "json resolve", args: []string{"-resolve", "-format", "json"}, env: []string{"DB=keyring:x"}, stdin: "$DB", code: 1,
+		stderr: `"variable":"DB","kind":"resolve","code":"ENV005"`},
+	{name: "json schema", args: []string{"-schema", "schema.yaml", "-format", "json"}, env: []string{"PORT=x"}, stdin: "$PORT",
+		files: map[string]string{"schema.yaml": "PORT: port\n"}, code: 1, stderr: `"variable":"PORT","kind":"schema","code":"ENV006"`},
+	{name: "annotate", args: []string{"-no-unset", "-annotate", "github", "a.tmpl"}, files: map[string]string{"a.tmpl": "a=$A"},
+		code: 1, stderr: "::error file=a.tmpl,line=1,col=3,title=ENV001::variable ${A} not set\n"},
+	{name: "annotate stdin", args: []string{"-no-unset", "-annotate", "github"}, stdin: "$A",
+		code: 1, stderr: "::error line=1,col=1,title=ENV001::variable ${A} not set\n"},
+}
+
+func TestDiagnostics(t *testing.T) {
+	for _, test := range diagTests {
+		runMain(t, test)
+	}
+}
+
+func TestWriteAnnotations(t *testing.T) {
+	var b bytes.Buffer
+	writeAnnotations(&b, "github", []diagnostic{
+		{File: "dir,1/a:b.tmpl", Line: 1, Kind: "syntax", Severity: severityError, Message: "100% wrong\nhere"},
+		{Kind: kindEmptyOutput, Severity: severityWarning, Message: "empty"},
+	})
+	expected := "::error file=dir%2C1/a%3Ab.tmpl,line=1,title=ENV003::100%25 wrong%0Ahere\n" +
+		"::warning title=ENV009::empty\n"
+	if got := b.String(); got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
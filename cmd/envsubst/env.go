@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hellt/envsubst/dotenv"
+	"github.com/hellt/envsubst/source"
+	"gopkg.in/yaml.v3"
+)
+
+// environ returns the environment templates are rendered with: the variables
+// loaded from Vault, AWS Secrets Manager and SSM, Kubernetes, URLs and files,
+// the process environment and the fallback values of the defaults file.
+// Lookups use the first match, so variables from Vault override those from
+// AWS Secrets Manager, which override those from AWS SSM, which override
+// those from Kubernetes, which override those from URLs, which override those
+// from files, which override the process environment. Later sources override
+// earlier ones of the same kind and fallbacks only apply to variables that are
+// not set at all. The names of variables loaded from secret stores and SOPS
+// encrypted files are added to secretVars, the sources of all variables to
+// origins.
+func environ() ([]string, error) {
+	var env []string
+	origins = map[string]string{}
+	// layer adds the variables loaded from source over those loaded so far.
+	layer := func(vars []string, source string) {
+		env = append(vars, env...)
+		for _, name := range names(vars) {
+			origins[name] = source
+		}
+	}
+	load := func(files []string, fileType string, read func([]byte) ([]string, error)) error {
+		for _, path := range files {
+			b, err := readEnvFile(path, fileType)
+			if err == nil {
+				var vars []string
+				if vars, err = read(b); err == nil {
+					logger.Debug("loaded variables", "source", path, "count", len(vars))
+					layer(vars, path)
+					if sops {
+						secretVars = append(secretVars, names(vars)...)
+					}
+					continue
+				}
+			}
+			return fmt.Errorf("Error to read variables from: %s: %v", path, err)
+		}
+		return nil
+	}
+	if err := load(envFiles, "dotenv", parseDotenv); err != nil {
+		return nil, err
+	}
+	if err := load(envJSONFiles, "json", parseJSONVars); err != nil {
+		return nil, err
+	}
+	if err := load(envYAMLFiles, "yaml", parseYAMLTree); err != nil {
+		return nil, err
+	}
+	// sops encrypts TOML files as a whole.
+	if err := load(envTOMLFiles, "binary", parseTOMLTree); err != nil {
+		return nil, err
+	}
+	for _, u := range envURLs {
+		vars, err := urlVars(u)
+		if err != nil {
+			return nil, fmt.Errorf("Error to read variables from: %s: %v", u, err)
+		}
+		logger.Debug("loaded variables", "source", u, "count", len(vars))
+		layer(vars, u)
+	}
+	for _, ref := range k8sSources {
+		vars, err := k8sVars(ref)
+		if err != nil {
+			return nil, fmt.Errorf("Error to read variables from: %s: %v", ref, err)
+		}
+		logger.Debug("loaded variables", "source", ref, "count", len(vars))
+		layer(vars, ref)
+		if strings.HasPrefix(ref, "secret/") {
+			secretVars = append(secretVars, names(vars)...)
+		}
+	}
+	for _, prefix := range ssmPrefixes {
+		vars, secrets, err := awsClient.Parameters(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("Error to read variables from AWS SSM: %s: %v", prefix, err)
+		}
+		logger.Debug("loaded variables", "source", prefix, "count", len(vars))
+		layer(vars, "ssm:"+prefix)
+		secretVars = append(secretVars, secrets...)
+	}
+	for _, id := range awsSecrets {
+		vars, err := awsClient.SecretVars(id)
+		if err != nil {
+			return nil, fmt.Errorf("Error to read variables from AWS Secrets Manager: %s: %v", id, err)
+		}
+		logger.Debug("loaded variables", "source", id, "count", len(vars))
+		layer(vars, "secretsmanager:"+id)
+		secretVars = append(secretVars, names(vars)...)
+	}
+	for _, path := range vaultPaths {
+		vars, err := vaultVars(path)
+		if err != nil {
+			return nil, fmt.Errorf("Error to read variables from Vault: %s: %v", path, err)
+		}
+		logger.Debug("loaded variables", "source", path, "count", len(vars))
+		layer(vars, "vault:"+path)
+		secretVars = append(secretVars, names(vars)...)
+	}
+	for _, name := range names(os.Environ()) {
+		if _, ok := origins[name]; !ok {
+			origins[name] = "environment"
+		}
+	}
+	env = append(env, os.Environ()...)
+	if defaultsFile != "" {
+		defaults, err := readVarsFile(defaultsFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error to read defaults file: %s: %v", defaultsFile, err)
+		}
+		logger.Debug("loaded variables", "source", defaultsFile, "count", len(defaults))
+		for _, name := range names(defaults) {
+			if _, ok := origins[name]; !ok {
+				origins[name] = defaultsFile
+			}
+		}
+		env = append(env, defaults...)
+	}
+	return env, nil
+}
+
+// readEnvFile reads the variable file at path of the SOPS file type dotenv,
+// json, yaml or binary. With -sops the file is decrypted with sops first.
+func readEnvFile(path, fileType string) ([]byte, error) {
+	if !sops {
+		return os.ReadFile(path)
+	}
+	return source.SOPS{Command: sopsCLI}.Decrypt(path, fileType)
+}
+
+// names returns the names of the NAME=VALUE pairs in vars.
+func names(vars []string) []string {
+	names := make([]string, len(vars))
+	for i, pair := range vars {
+		names[i], _, _ = strings.Cut(pair, "=")
+	}
+	return names
+}
+
+// readVarsFile reads a file of variables as NAME=VALUE pairs. Files with a
+// .yaml or .yml extension hold a mapping of names to values, all other files
+// hold one NAME=VALUE assignment per line.
+func readVarsFile(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return parseYAMLVars(b)
+	}
+	return parseDotenv(b)
+}
+
+// parseDotenv parses a .env file, see package dotenv.
+func parseDotenv(b []byte) ([]string, error) {
+	vars, err := dotenv.ParseBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	return dotenv.Environ(vars), nil
+}
+
+// parseYAMLVars parses a mapping of variable names to scalar values.
+func parseYAMLVars(b []byte) ([]string, error) {
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	vars := make([]string, 0, len(m))
+	for name, value := range m {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("value of %s is not a scalar", name)
+		case nil:
+			value = ""
+		}
+		vars = append(vars, fmt.Sprintf("%s=%v", name, value))
+	}
+	sort.Strings(vars)
+	return vars, nil
+}
+
+// parseJSONVars flattens a JSON object into variables, see -flatten-sep.
+func parseJSONVars(b []byte) ([]string, error) {
+	return source.JSON(b, flattening)
+}
+
+// parseYAMLTree flattens a YAML mapping into variables, see -flatten-sep.
+func parseYAMLTree(b []byte) ([]string, error) {
+	return source.YAML(b, flattening)
+}
+
+// parseTOMLTree flattens a TOML document into variables, see -flatten-sep.
+func parseTOMLTree(b []byte) ([]string, error) {
+	return source.TOML(b, flattening)
+}
+
+// urlVars returns the variables of the document served at u, see
+// -env-from-url. Responses are cached in the user cache directory, if any.
+func urlVars(u string) ([]string, error) {
+	header := http.Header{}
+	for _, h := range urlHeaders {
+		name, value, _ := strings.Cut(h, ":")
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	r := &source.Remote{URL: u, Header: header, Timeout: urlTimeout}
+	if dir, err := os.UserCacheDir(); err == nil {
+		r.CacheDir = filepath.Join(dir, "envsubst", "http")
+	}
+	return r.Vars(flattening)
+}
+
+// varName turns key into an upper-case variable name, see
+// source.Flattening.Name.
+func varName(key string) string {
+	return source.Flattening{}.Name(key)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+var envTests = []cliTest{
+	{name: "defaults file", args: []string{"-defaults-file", "defaults.env"}, env: []string{"A=env", "E="},
+		stdin: "$A $B [$E]", files: map[string]string{"defaults.env": "A=default\nB=b\nE=e\n"}, stdout: "env b []"},
+	{name: "defaults yaml", args: []string{"-defaults-file", "defaults.yaml"}, stdin: "$PORT $NAME",
+		files: map[string]string{"defaults.yaml": "PORT: 8080\nNAME: app\n"}, stdout: "8080 app"},
+	{name: "defaults under env file", args: []string{"-env-file", ".env", "-defaults-file", "defaults.env"}, stdin: "$A",
+		files: map[string]string{".env": "A=file\n", "defaults.env": "A=default\n"}, stdout: "file"},
+	{name: "defaults no unset", args: []string{"-no-unset", "-defaults-file", "defaults.env"}, stdin: "$A",
+		files: map[string]string{"defaults.env": "A=default\n"}, stdout: "default"},
+	{name: "missing defaults file", args: []string{"-defaults-file", "missing.env"}, stdin: "$A",
+		code: 1, stderr: "Error to read defaults file: missing.env"},
+	{name: "env files", args: []string{"-env-file", "a.env", "-env-file", "b.env"}, env: []string{"A=env", "C=env"},
+		stdin: "$A $B $C", files: map[string]string{"a.env": "A=a\nB=a\n", "b.env": "# b\nB='b c'\n"}, stdout: "a b c env"},
+	{name: "env file syntax", args: []string{"-env-file", ".env"}, stdin: "$A|$B|$C",
+		files: map[string]string{".env": "A=\"multi\nline\" # comment\nexport B='$x'\nC=${A}\n"}, stdout: "multi\nline|$x|${A}"},
+	{name: "env file unclosed", args: []string{"-env-file", ".env"}, stdin: "$A",
+		files: map[string]string{".env": "A=\"x\n"}, code: 1, stderr: "Error to read variables from: .env: line 1: missing closing \""},
+	{name: "env from json", args: []string{"-env-from-json", "vars.json"}, stdin: "$DB_HOSTS_0 $DB_PORT $NAME",
+		files: map[string]string{"vars.json": `{"db": {"hosts": ["h"], "port": 5432}, "name": "app"}`}, stdout: "h 5432 app"},
+	{name: "flatten", args: []string{"-env-from-json", "vars.json", "-flatten-sep", "__", "-flatten-case", "lower"},
+		stdin: "$db__host $db__ports__1", files: map[string]string{"vars.json": `{"db": {"host": "h", "ports": [1, 2]}}`},
+		stdout: "h 2"},
+	{name: "unknown flatten case", args: []string{"-flatten-case", "nope"}, code: 1, stderr: "Unknown flatten case: nope."},
+	{name: "env from yaml", args: []string{"-env-from-yaml", "values.yaml"}, stdin: "$IMAGE_TAG $REPLICAS",
+		files: map[string]string{"values.yaml": "image:\n  tag: v1\nreplicas: 2\n"}, stdout: "v1 2"},
+	{name: "flatten join", args: []string{"-env-from-yaml", "values.yaml", "-flatten-join", ","}, stdin: "$HOSTS",
+		files: map[string]string{"values.yaml": "hosts: [a, b]\n"}, stdout: "a,b"},
+	{name: "env from toml", args: []string{"-env-from-toml", "vars.toml"}, stdin: "$DB_HOST $DB_PORT",
+		files: map[string]string{"vars.toml": "[db]\nhost = \"h\"\nport = 5\n"}, stdout: "h 5"},
+	{name: "invalid toml", args: []string{"-env-from-toml", "vars.toml"}, stdin: "$A",
+		files: map[string]string{"vars.toml": "[db\n"}, code: 1, stderr: "Error to read variables from: vars.toml: toml: line 2"},
+	{name: "json over env file", args: []string{"-env-file", ".env", "-env-from-json", "vars.json"}, stdin: "$A",
+		files: map[string]string{".env": "A=file\n", "vars.json": `{"a": "json"}`}, stdout: "json"},
+	{name: "missing env file", args: []string{"-env-file", "missing.env"}, stdin: "$A",
+		code: 1, stderr: "Error to read variables from: missing.env"},
+	{name: "invalid json", args: []string{"-env-from-json", "vars.json"}, stdin: "$A",
+		files: map[string]string{"vars.json": "[1"}, code: 1, stderr: "Error to read variables from: vars.json"},
+	{name: "deprecated", args: []string{"-deprecated", "OLD=NEW"}, env: []string{"OLD=o", "NEW=n"}, stdin: "$OLD $NEW",
+		stdout: "o n", stderr: `msg="variable ${OLD} is deprecated, use ${NEW}" template=- variable=OLD line=1 col=1`},
+	{name: "deprecated env", args: []string{"-deprecated", "OLD"}, env: []string{"OLD=o"}, stdin: "x",
+		stdout: "x", stderr: `msg="variable OLD is deprecated" variable=OLD`},
+	{name: "deprecated strict", args: []string{"-deprecated", "OLD=NEW", "-profile", "strict"}, env: []string{"OLD=o"}, stdin: "$OLD",
+		code: 1, stderr: "variable ${OLD} is deprecated, use ${NEW}"},
+	{name: "map deprecated", args: []string{"-deprecated", "OLD=NEW", "-map-deprecated"}, env: []string{"OLD=o", "NEW=n"},
+		stdin: "$OLD", stdout: "n"},
+	{name: "map deprecated env", args: []string{"-deprecated", "OLD=NEW", "-map-deprecated"}, env: []string{"OLD=o"},
+		stdin: "$NEW", stdout: "o"},
+	{name: "invalid deprecation", args: []string{"-deprecated", "=NEW"}, stdin: "$A",
+		code: 1, stderr: `Invalid deprecation: expected OLD=NEW or OLD, got "=NEW".`},
+}
+
+func TestEnv(t *testing.T) {
+	for _, test := range envTests {
+		runMain(t, test)
+	}
+}
+
+func TestEnvFromURL(t *testing.T) {
+	var revalidated int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("Authorization") != "Bearer t0ken":
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		case r.URL.Path == "/vars.json":
+			w.Write([]byte(`{"db": {"host": "h"}}`))
+		case r.URL.Path == "/vars":
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				atomic.AddInt32(&revalidated, 1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Write([]byte("A=remote\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	header := "Authorization: Bearer t0ken"
+	// The user cache directory is the same for the runs of the tests.
+	dir := t.TempDir()
+	cache := []string{"XDG_CACHE_HOME=" + dir, "HOME=" + dir, "LocalAppData=" + dir}
+	tests := []cliTest{
+		{name: "env from url json", args: []string{"-env-from-url", srv.URL + "/vars.json", "-env-url-header", header},
+			stdin: "$DB_HOST", stdout: "h"},
+		{name: "env from url", args: []string{"-env-file", ".env", "-env-from-url", srv.URL + "/vars", "-env-url-header", header},
+			env: cache, files: map[string]string{".env": "A=file\n"}, stdin: "$A", stdout: "remote"},
+		{name: "env from url cached", args: []string{"-env-from-url", srv.URL + "/vars", "-env-url-header", header},
+			env: cache, stdin: "$A", stdout: "remote"},
+		{name: "env from url unauthorized", args: []string{"-env-from-url", srv.URL + "/vars"}, stdin: "$A",
+			code: 1, stderr: "401 Unauthorized"},
+		{name: "env url header", args: []string{"-env-url-header", "Bearer"}, code: 1, stderr: "Invalid header: Bearer"},
+	}
+	for _, test := range tests {
+		runMain(t, test)
+	}
+	if revalidated != 1 {
+		t.Errorf("got %d responses revalidated, expected the cached one", revalidated)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+var execUsage = `  -o         Specify file output, as for render. Inputs are rendered before
+             the command is run, none if there are no inputs.
+  -strip-ext Only render inputs with this extension, removing it from their
+             output path, see render.
+  -in-place  Replace each input file with its rendered content.
+  -chmod     Octal permissions of the written files, see render.
+  -render-env
+             Substitute the variables in the values of the environment of the
+             command, e.g. DATABASE_URL='postgres://${DB_USER}@${DB_HOST}/app'.
+`
+
+var (
+	renderEnv bool
+	// execArgs are the command exec runs and its arguments, and execEnv its
+	// environment.
+	execArgs []string
+	execEnv  []string
+)
+
+// runExec renders the inputs and the environment of the command, which
+// main then runs once the reports are written.
+func runExec(jobs []job) []diagnostic {
+	if diags := writeResults(runJobs(jobs, numJobs, job.render)); diags != nil {
+		return diags
+	}
+	// The variables loaded first take precedence, see environ.
+	seen := map[string]bool{}
+	var diags []diagnostic
+	for _, v := range env {
+		name, value, _ := strings.Cut(v, "=")
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if renderEnv {
+			var err error
+			if value, err = newParser(name).Parse(value); err != nil {
+				diags = append(diags, diagnostics(name, err)...)
+				continue
+			}
+		}
+		execEnv = append(execEnv, name+"="+value)
+	}
+	return diags
+}
+
+// execAndExit replaces the process with the command, or runs it and exits
+// with its status where it cannot be replaced.
+func execAndExit() {
+	if err := execCommand(execArgs, execEnv); err != nil {
+		failAndExit("", fmt.Sprintf("Error to run command: %v", err))
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// withSource sets the source line of the diagnostics of text, shown by
+// -explain.
+func withSource(diags []diagnostic, text string) []diagnostic {
+	lines := strings.SplitAfter(text, "\n")
+	for i, d := range diags {
+		if d.Line > 0 && d.Line <= len(lines) {
+			diags[i].source = strings.TrimRight(lines[d.Line-1], "\r\n")
+		}
+	}
+	return diags
+}
+
+// writeExplained prints d to w with its source line, a caret under the
+// reference, the restriction that failed and a suggested fix.
+func writeExplained(w io.Writer, d diagnostic) {
+	if c := code(d.Kind); c != "" {
+		fmt.Fprintf(w, "error[%s]: %s\n", c, d.Message)
+	} else {
+		fmt.Fprintf(w, "error: %s\n", d.Message)
+	}
+	if d.File != "" && d.Line > 0 {
+		fmt.Fprintf(w, "  --> %s:%d:%d\n", d.File, d.Line, d.Column)
+	} else if d.File != "" {
+		fmt.Fprintf(w, "  --> %s\n", d.File)
+	}
+	if d.source != "" && d.Column > 0 && d.Column <= len(d.source)+1 {
+		number := fmt.Sprint(d.Line)
+		margin := strings.Repeat(" ", len(number))
+		fmt.Fprintf(w, "%s |\n%s | %s\n", margin, number, d.source)
+		// Tabs are kept so that the caret lines up with the source.
+		indent := strings.Map(func(r rune) rune {
+			if r == '\t' {
+				return r
+			}
+			return ' '
+		}, d.source[:d.Column-1])
+		fmt.Fprintf(w, "%s | %s%s\n", margin, indent, strings.Repeat("^", referenceWidth(d.source[d.Column-1:])))
+	}
+	restriction, help := explanation(d)
+	if restriction != "" {
+		fmt.Fprintf(w, "  = restriction: %s\n", restriction)
+	}
+	if help != "" {
+		fmt.Fprintf(w, "  = help: %s\n", help)
+	}
+	fmt.Fprintln(w)
+}
+
+// referenceWidth returns the width of the reference s starts with, such as
+// ${NAME:-default} or $NAME, or 1 if there is none.
+func referenceWidth(s string) int {
+	if strings.HasPrefix(s, "${") {
+		depth := 0
+		for i, r := range s {
+			switch r {
+			case '{':
+				depth++
+			case '}':
+				if depth--; depth == 0 {
+					return i + 1
+				}
+			}
+		}
+		return len(s)
+	}
+	if strings.HasPrefix(s, "$") {
+		n := 1
+		for n < len(s) && (s[n] == '_' || s[n] >= 'A' && s[n] <= 'Z' || s[n] >= 'a' && s[n] <= 'z' || s[n] >= '0' && s[n] <= '9') {
+			n++
+		}
+		return n
+	}
+	return 1
+}
+
+// explanation returns the restriction d failed and the suggested fix.
+func explanation(d diagnostic) (restriction, help string) {
+	name := d.Variable
+	if name == "" {
+		name = "NAME"
+	}
+	switch parse.ErrorKind(d.Kind) {
+	case parse.KindUnset:
+		return restricting("unset variables", "-no-unset"),
+			fmt.Sprintf("add a default: ${%s:-value}, or set %s", name, name)
+	case parse.KindEmpty:
+		return restricting("variables set to an empty string", "-no-empty"),
+			fmt.Sprintf("set %s to a value, or add a default used when it is empty: ${%s:-value}", name, name)
+	case parse.KindSyntax:
+		return "", "close the substitution with }, or write $$ for a literal $"
+	case parse.KindLimit:
+		return "resource limits of -max-input, -max-size and -max-depth",
+			"raise the limit, or split the template"
+	case parse.KindResolve:
+		return "", fmt.Sprintf("check the reference of %s and the access to its backend", name)
+	case parse.KindSchema:
+		return "the -schema file", fmt.Sprintf("set %s to a value following the schema, or change the schema", name)
+	case parse.KindDeprecated:
+		return restricting("deprecated variables", "-no-unset and -no-empty"),
+			"use the replacement of the variable"
+	case parse.KindEmptyDefault:
+		return "-fail-on-empty-default", fmt.Sprintf("set %s to a value, or unset it if the default is wanted", name)
+	case parse.KindPolicy:
+		return "the rules of -deny", fmt.Sprintf("keep %s out of this output, or change the rules", name)
+	case kindNoSubstitution:
+		return "-require-substitution", "reference a variable that is set, or drop the option for static files"
+	case kindEmptyOutput:
+		return "-fail-on-empty-output", "set the variables the template needs"
+	case kindLock:
+		return "the -verify-lock file", fmt.Sprintf("set %s to its locked value, or lock the new configuration with -lock", name)
+	}
+	return "", ""
+}
+
+// restricting returns the restriction failing on what with the given flags,
+// or those of the -profile in use.
+func restricting(what, flags string) string {
+	if profile != "relaxed" {
+		return fmt.Sprintf("-profile %s fails on %s", profile, what)
+	}
+	return fmt.Sprintf("%s fails on %s", flags, what)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReferenceWidth(t *testing.T) {
+	for s, expected := range map[string]int{
+		"${A:-${B}} rest": 10,
+		"${A":             3,
+		"$A_1.b":          4,
+		"$":               1,
+		"x":               1,
+	} {
+		if got := referenceWidth(s); got != expected {
+			t.Errorf("%q: got %d, expected %d", s, got, expected)
+		}
+	}
+}
+
+func TestWriteExplained(t *testing.T) {
+	profile = "relaxed"
+	defer func() { profile = "" }()
+	var b bytes.Buffer
+	writeExplained(&b, diagnostic{File: "a.tmpl", Line: 12, Column: 3, Variable: "HOST", Kind: "unset",
+		Message: "variable ${HOST} not set", source: "\t\t${HOST}:80"})
+	expected := "error[ENV001]: variable ${HOST} not set\n" +
+		"  --> a.tmpl:12:3\n" +
+		"   |\n" +
+		"12 | \t\t${HOST}:80\n" +
+		"   | \t\t^^^^^^^\n" +
+		"  = restriction: -no-unset fails on unset variables\n" +
+		"  = help: add a default: ${HOST:-value}, or set HOST\n\n"
+	if got := b.String(); got != expected {
+		t.Errorf("got\n%s\nexpected\n%s", got, expected)
+	}
+	b.Reset()
+	writeExplained(&b, ioDiagnostic("b.tmpl", "Error to open file input: b.tmpl."))
+	if got, expected := b.String(), "error[ENV010]: Error to open file input: b.tmpl.\n  --> b.tmpl\n\n"; got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+}
+
+var explainTests = []cliTest{
+	{name: "explain", args: []string{"-explain", "-profile", "strict", "a.tmpl"}, env: []string{"E="},
+		files: map[string]string{"a.tmpl": "a\nb=[$E]\n"}, code: 1,
+		stderr: "error[ENV002]: variable ${E} set but empty\n  --> a.tmpl:2:4\n  |\n2 | b=[$E]\n  |    ^^\n" +
+			"  = restriction: -profile strict fails on variables set to an empty string\n" +
+			"  = help: set E to a value, or add a default used when it is empty: ${E:-value}\n\n"},
+	{name: "explain stream", args: []string{"-explain"}, stdin: "a=1\nb=${B\n", code: 1, stdout: "a=1\n",
+		stderr: "  --> -:2:6\n  |\n2 | b=${B\n  |      ^\n  = help: close the substitution with }"},
+	{name: "explain json", args: []string{"-explain", "-no-unset", "-format", "json"}, stdin: "$A", code: 1,
+		stderr: `"code":"ENV001","severity":"error","message":"variable ${A} not set"}`},
+}
+
+func TestExplain(t *testing.T) {
+	for _, test := range explainTests {
+		runMain(t, test)
+	}
+}
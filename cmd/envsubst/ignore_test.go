@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIgnoreFile(t *testing.T) {
+	dir := t.TempDir()
+	rules := "# comment\n\n*.png\n!keep.png\nbuild/\n/top.txt\ndocs/**/*.md\n\\#hash\n"
+	if err := os.WriteFile(filepath.Join(dir, ignoreFile), []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := readIgnoreFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		rel     string
+		isDir   bool
+		ignored bool
+	}{
+		{"logo.png", false, true},
+		{"img/logo.png", false, true},
+		{"img/keep.png", false, false},
+		{"build", true, true},
+		{"src/build", true, true},
+		{"build", false, false},
+		{"top.txt", false, true},
+		{"sub/top.txt", false, false},
+		{"docs/a.md", false, true},
+		{"docs/x/y/a.md", false, true},
+		{"a.md", false, false},
+		{"#hash", false, true},
+		{"comment", false, false},
+	}
+	for _, test := range tests {
+		if got := l.ignored(test.rel, test.isDir); got != test.ignored {
+			t.Errorf("%s: got ignored %v, expected %v", test.rel, got, test.ignored)
+		}
+	}
+	if l, err := readIgnoreFile(t.TempDir()); l != nil || err != nil {
+		t.Errorf("got %v, %v without an ignore file", l, err)
+	}
+}
+
+var ignoreTests = []cliTest{
+	{name: "ignore file", args: []string{"-o", "out", "in"}, env: []string{"A=1"}, files: map[string]string{
+		"in/.envsubstignore": "*.png\nvendor/\n", "in/a.conf": "a=$A", "in/logo.png": "$A",
+		"in/vendor/b.conf": "$A", "in/sub/c.conf": "c=$A"},
+		output: map[string]string{"out/a.conf": "a=1", "out/sub/c.conf": "c=1"},
+		absent: []string{"out/logo.png", "out/vendor", "out/.envsubstignore"}},
+	{name: "ignore negated", args: []string{"-o", "out", "in"}, files: map[string]string{
+		"in/.envsubstignore": "*.txt\n!keep.txt\n", "in/a.txt": "a", "in/keep.txt": "k"},
+		output: map[string]string{"out/keep.txt": "k"}, absent: []string{"out/a.txt"}},
+	{name: "ignore file input", args: []string{"-o", "out", "in/a.txt", "in/b.txt"}, files: map[string]string{
+		"in/.envsubstignore": "*.txt\n", "in/a.txt": "a", "in/b.txt": "b"},
+		output: map[string]string{"out/a.txt": "a", "out/b.txt": "b"}},
+}
+
+func TestIgnore(t *testing.T) {
+	for _, test := range ignoreTests {
+		runMain(t, test)
+	}
+}
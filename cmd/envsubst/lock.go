@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// kindLock is the kind of the diagnostics of -verify-lock.
+const kindLock = "lock"
+
+// readLock reads the lock file of -verify-lock at path.
+func readLock(path string) (*parse.Lock, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parse.ReadLock(f)
+}
+
+// writeLock writes the variables the inputs were rendered with to the lock
+// file of -lock at path.
+func writeLock(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := lock.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// verifyLock returns the diagnostics of the variables used, as recorded
+// rendering file, whose values differ from those of -verify-lock, and adds
+// them to those of -lock.
+func verifyLock(file string, used *parse.Lock) []diagnostic {
+	if used == nil {
+		return nil
+	}
+	if lock != nil {
+		lock.Merge(used)
+	}
+	if locked == nil {
+		return nil
+	}
+	var diags []diagnostic
+	for _, m := range locked.Verify(used) {
+		diags = append(diags, diagnostic{File: file, Variable: m.Variable, Kind: kindLock,
+			Severity: severityError, Message: m.String()})
+	}
+	return diags
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+// envLock is the -lock file of A=1, B=2 and C unset.
+const envLock = `{
+  "version": 1,
+  "variables": {
+    "A": "sha256:6b86b273ff34fce19d6b804eff5a3f5747ada4eaa22f1d49c01e52ddb7875b4b",
+    "B": "sha256:d4735e3a265e16eee03f59718b9b5d03019c07d8b6c51f90da3a666eec13ab35",
+    "C": "unset"
+  }
+}
+`
+
+var lockTests = []cliTest{
+	{name: "lock", args: []string{"-lock", "env.lock"}, env: []string{"A=1", "B=2"}, stdin: "$A $B ${C:-x}",
+		stdout: "1 2 x", output: map[string]string{"env.lock": envLock}},
+	{name: "lock failure", args: []string{"-no-unset", "-lock", "env.lock"}, env: []string{"A=1"}, stdin: "$A $C",
+		code: 1, stderr: "variable ${C} not set", absent: []string{"env.lock"}},
+	{name: "verify lock", args: []string{"-verify-lock", "env.lock"}, env: []string{"A=1", "B=2"}, stdin: "$A $B ${C:-x}",
+		files: map[string]string{"env.lock": envLock}, stdout: "1 2 x"},
+	{name: "verify lock unused", args: []string{"-verify-lock", "env.lock"}, env: []string{"A=1", "B=3"}, stdin: "$A",
+		files: map[string]string{"env.lock": envLock}, stdout: "1"},
+	{name: "verify lock differs", args: []string{"-verify-lock", "env.lock"}, env: []string{"A=1", "B=3"}, stdin: "$A $B",
+		files: map[string]string{"env.lock": envLock}, code: 1, stderr: "variable ${B} differs from its locked value"},
+	{name: "verify lock set", args: []string{"-verify-lock", "env.lock"}, env: []string{"C=1"}, stdin: "$C",
+		files: map[string]string{"env.lock": envLock}, code: 1, stderr: "variable ${C} is set, unlike when locked"},
+	{name: "verify lock missing", args: []string{"-verify-lock", "env.lock"}, env: []string{"D=1"}, stdin: "$D",
+		files: map[string]string{"env.lock": envLock}, code: 1, stderr: "variable ${D} is not locked"},
+	{name: "verify lock file", args: []string{"-verify-lock", "env.lock"}, stdin: "$A", code: 1, stderr: "Error to read lock file"},
+}
+
+func TestLock(t *testing.T) {
+	for _, test := range lockTests {
+		runMain(t, test)
+	}
+}
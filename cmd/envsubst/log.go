@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// logger logs what the command does to stderr, configured by -log-format
+// and -log-level. Findings in the templates are reported as diagnostics,
+// not logged.
+var logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// newLogger returns a logger writing records of at least level to w in the
+// format text or json.
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("Unknown log level: %s.", level)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("Unknown log format: %s.", format)
+}
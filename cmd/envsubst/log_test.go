@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var b bytes.Buffer
+	l, err := newLogger(&b, "json", "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("hidden")
+	l.Info("wrote", "file", "a.conf")
+	var record map[string]any
+	if err := json.Unmarshal(b.Bytes(), &record); err != nil {
+		t.Fatalf("%v: %s", err, b.String())
+	}
+	if record["level"] != "INFO" || record["msg"] != "wrote" || record["file"] != "a.conf" {
+		t.Errorf("got %v", record)
+	}
+	b.Reset()
+	if l, err = newLogger(&b, "text", "WARN"); err != nil {
+		t.Fatal(err)
+	}
+	l.Info("hidden")
+	l.Warn("deprecated", "variable", "OLD")
+	if got := b.String(); strings.Contains(got, "hidden") || !strings.Contains(got, "level=WARN msg=deprecated variable=OLD") {
+		t.Errorf("got %q", got)
+	}
+	if _, err := newLogger(&b, "xml", "info"); err == nil || err.Error() != "Unknown log format: xml." {
+		t.Errorf("got %v, expected the format to be unknown", err)
+	}
+	if _, err := newLogger(&b, "text", "verbose"); err == nil || err.Error() != "Unknown log level: verbose." {
+		t.Errorf("got %v, expected the level to be unknown", err)
+	}
+}
+
+var logTests = []cliTest{
+	{name: "log json", args: []string{"-log-format", "json", "-log-level", "info", "-o", "a.conf"}, stdin: "a",
+		stderr: `"level":"INFO","msg":"wrote","file":"a.conf"}`},
+	{name: "log text", args: []string{"-log-level", "debug", "-o", "a.conf", "a.tmpl"}, files: map[string]string{"a.tmpl": "a"},
+		stderr: "level=DEBUG msg=processed file=a.tmpl diagnostics=0"},
+	{name: "log substitutions", args: []string{"-log-level", "debug"}, env: []string{"A=1"}, stdin: "$A ${B:-x}", stdout: "1 x",
+		stderr: `level=DEBUG msg=substituted template=- variable=A line=1 col=1`},
+	{name: "log defaults", args: []string{"-log-level", "debug"}, env: []string{"A=1"}, stdin: "$A ${B:-x}", stdout: "1 x",
+		stderr: `level=DEBUG msg="default applied" template=- variable=B line=1 col=4`},
+	{name: "log unknown format", args: []string{"-log-format", "xml"}, code: 1, stderr: "Unknown log format: xml."},
+}
+
+func TestLog(t *testing.T) {
+	for _, test := range logTests {
+		runMain(t, test)
+	}
+}
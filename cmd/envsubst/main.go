@@ -2,110 +2,938 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
-	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hellt/envsubst/parse"
+	"github.com/hellt/envsubst/resolver"
+	"github.com/hellt/envsubst/source"
+	"github.com/hellt/envsubst/syntax"
+	"golang.org/x/term"
 )
 
 var (
-	input    = flag.String("i", "", "")
-	output   = flag.String("o", "", "")
-	noDigit  = flag.Bool("no-digit", false, "")
-	noUnset  = flag.Bool("no-unset", false, "")
-	noEmpty  = flag.Bool("no-empty", false, "")
-	failFast = flag.Bool("fail-fast", false, "")
+	input        string
+	output       string
+	stripExt     string
+	copyOther    bool
+	listUnset    bool
+	locations    bool
+	noDigit      bool
+	noUnset      bool
+	noEmpty      bool
+	failFast     bool
+	explain      bool
+	requireSubst bool
+	failEmpty    bool
+	failDefault  bool
+	library      bool
+	builtinsFlag string
+	randomSeed   string
+	resolve      bool
+	interactive  bool
+	inPlace      bool
+	filesFrom    string
+	nulSep       bool
+	sops         bool
+	ifChanged    bool
+	fileMode     permFlag
+	debugAST     debugFlag
+	dirMode      permFlag
+	format       string
+	mode         string
+	hclAllow     string
+	includeRoot  string
+	directives   bool
+	flattenCase  string
+	logFormat    string
+	logLevel     string
+	annotate     string
+	numJobs      int
+	defaultsFile string
+	maxSize      int
+	maxInput     int
+	maxDepth     int
+	profile      string
+	maskFlag     string
+	auditPath    string
+	tracePath    string
+	lockPath     string
+	verifyPath   string
+	beforeFile   string
+	afterFile    string
+	redact       string
+	schemaPath   string
+	mapDeprec    bool
+	provenance   bool
+	eol          string
+	reports      reportList
+	transformed  transformList
+	envFiles     stringList
+	envJSONFiles stringList
+	envYAMLFiles stringList
+	envTOMLFiles stringList
+	envURLs      stringList
+	urlHeaders   stringList
+	urlTimeout   time.Duration
+	k8sSources   stringList
+	vaultPaths   stringList
+	ssmPrefixes  stringList
+	awsSecrets   stringList
+	resolveCmds  stringList
+	resolveEnv   stringList
+	resolveWait  time.Duration
+	resolveMax   int
+	fileRoots    stringList
+	deprecFlags  stringList
+	denyFlags    stringList
+	awsRegion    string
+	awsProfile   string
+	includes     stringList
+	excludes     stringList
 )
 
-var usage = `Usage: envsubst [options...] <input>
+var (
+	// flags are the options of the command being run.
+	flags *flag.FlagSet
+	// env is the environment the templates are rendered with.
+	env []string
+	// masks redacts secret values from everything but the rendered output.
+	masks *masker
+	// flattening flattens the documents of -env-from-json and the like.
+	flattening source.Flattening
+	// builtins are the pseudo-variables enabled with -builtins.
+	builtins parse.Builtins
+	// seed is the -random-seed of the random builtins, nil if not seeded.
+	seed *int64
+	// secretVars are the names of the variables loaded from secret stores,
+	// which are always masked.
+	secretVars []string
+	// origins are the sources of the values of the variables, see -audit.
+	origins map[string]string
+	// audits records the substitutions for -audit.
+	audits *auditLog
+	// traces exports the spans of the command for -trace.
+	traces *tracing
+	// schema declares the values of variables, see -schema.
+	schema parse.Schema
+	// deprecated maps deprecated variables to their replacements, see
+	// -deprecated.
+	deprecated map[string]string
+	// policy denies the substitutions of -deny.
+	policy parse.Policy
+	// lock records the variables the inputs are rendered with for -lock.
+	lock *parse.Lock
+	// locked are the variables of -verify-lock.
+	locked *parse.Lock
+)
+
+// commonFlags registers the options shared by all commands on fs.
+func commonFlags(fs *flag.FlagSet) {
+	fs.StringVar(&input, "i", "", "")
+	fs.StringVar(&filesFrom, "files-from", "", "")
+	fs.BoolVar(&nulSep, "0", false, "")
+	fs.BoolVar(&noDigit, "no-digit", false, "")
+	fs.BoolVar(&noUnset, "no-unset", false, "")
+	fs.BoolVar(&noEmpty, "no-empty", false, "")
+	fs.BoolVar(&failFast, "fail-fast", false, "")
+	fs.BoolVar(&explain, "explain", false, "")
+	fs.BoolVar(&requireSubst, "require-substitution", false, "")
+	fs.BoolVar(&failEmpty, "fail-on-empty-output", false, "")
+	fs.BoolVar(&failDefault, "fail-on-empty-default", false, "")
+	fs.BoolVar(&interactive, "interactive", false, "")
+	fs.StringVar(&format, "format", "text", "")
+	fs.StringVar(&mode, "mode", "text", "")
+	fs.StringVar(&hclAllow, "hcl-allow", "", "")
+	fs.StringVar(&includeRoot, "include-root", "", "")
+	fs.BoolVar(&directives, "directives", false, "")
+	fs.StringVar(&annotate, "annotate", "", "")
+	fs.StringVar(&logFormat, "log-format", "text", "")
+	fs.StringVar(&logLevel, "log-level", "warn", "")
+	fs.IntVar(&numJobs, "jobs", runtime.NumCPU(), "")
+	fs.StringVar(&defaultsFile, "defaults-file", "", "")
+	fs.IntVar(&maxSize, "max-size", 0, "")
+	fs.IntVar(&maxInput, "max-input", 0, "")
+	fs.IntVar(&maxDepth, "max-depth", 0, "")
+	fs.StringVar(&profile, "profile", "relaxed", "")
+	fs.StringVar(&maskFlag, "mask", "", "")
+	fs.StringVar(&auditPath, "audit", "", "")
+	fs.StringVar(&tracePath, "trace", "", "")
+	fs.StringVar(&lockPath, "lock", "", "")
+	fs.StringVar(&verifyPath, "verify-lock", "", "")
+	fs.StringVar(&redact, "redact", "", "")
+	fs.StringVar(&schemaPath, "schema", "", "")
+	fs.Var(&deprecFlags, "deprecated", "")
+	fs.Var(&denyFlags, "deny", "")
+	fs.Var(&debugAST, "debug-ast", "")
+	fs.BoolVar(&mapDeprec, "map-deprecated", false, "")
+	fs.BoolVar(&provenance, "provenance", false, "")
+	fs.StringVar(&eol, "eol", "preserve", "")
+	fs.String("config", "", "")
+	fs.Var(&reports, "report", "")
+	fs.Var(&transformed, "transform", "")
+	fs.BoolVar(&library, "library", false, "")
+	fs.StringVar(&builtinsFlag, "builtins", "", "")
+	fs.StringVar(&randomSeed, "random-seed", "", "")
+	fs.BoolVar(&resolve, "resolve", false, "")
+	fs.Var(&resolveCmds, "resolve-command", "")
+	fs.Var(&resolveEnv, "resolve-command-env", "")
+	fs.DurationVar(&resolveWait, "resolve-timeout", 10*time.Second, "")
+	fs.IntVar(&resolveMax, "resolve-max-size", 0, "")
+	fs.Var(&fileRoots, "resolve-file-root", "")
+	fs.Var(&envFiles, "env-file", "")
+	fs.Var(&envJSONFiles, "env-from-json", "")
+	fs.Var(&envYAMLFiles, "env-from-yaml", "")
+	fs.Var(&envTOMLFiles, "env-from-toml", "")
+	fs.Var(&envURLs, "env-from-url", "")
+	fs.Var(&urlHeaders, "env-url-header", "")
+	fs.DurationVar(&urlTimeout, "env-url-timeout", 30*time.Second, "")
+	fs.StringVar(&flattening.Sep, "flatten-sep", "_", "")
+	fs.StringVar(&flattenCase, "flatten-case", "upper", "")
+	fs.StringVar(&flattening.Join, "flatten-join", "", "")
+	fs.BoolVar(&sops, "sops", false, "")
+	fs.Var(&k8sSources, "from-k8s", "")
+	fs.Var(&vaultPaths, "vault-path", "")
+	fs.Var(&ssmPrefixes, "aws-ssm-prefix", "")
+	fs.Var(&awsSecrets, "aws-secret", "")
+	fs.StringVar(&awsRegion, "aws-region", "", "")
+	fs.StringVar(&awsProfile, "aws-profile", "", "")
+	fs.Var(&includes, "include", "")
+	fs.Var(&excludes, "exclude", "")
+}
+
+// permFlag is a flag for octal file permissions, e.g. 0640.
+// The zero value means the default permissions.
+type permFlag uint32
+
+func (p *permFlag) String() string {
+	return fmt.Sprintf("%#o", uint32(*p))
+}
+
+func (p *permFlag) Set(value string) error {
+	perm, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || perm > 0o777 {
+		return fmt.Errorf("expected octal permissions such as 0640, got %q", value)
+	}
+	*p = permFlag(perm)
+	return nil
+}
+
+// stringList is a flag that may be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+var usage = `Usage: envsubst [command] [options...] <input>...
+Commands:
+  render     Substitute the variables in the inputs. This is the default.
+  check      Report the failures of rendering the inputs without writing them.
+  vars       List the variables referenced by the inputs.
+  diff       Show the changes rendering the inputs makes, see -o.
+  compare    Show the substitutions of the inputs whose values differ between
+             two sets of variables, see -before and -after.
+  exec       Render the inputs, then run the command given after --, e.g.
+             envsubst exec -o /etc/app /templates -- app serve, replacing the
+             process with it, as the entrypoint of a container.
+  serve      Serve the files of -root rendered over HTTP, e.g. to debug the
+             templates or to serve configurations from a sidecar.
 Options:
-  -i         Specify file input, otherwise use last argument as input file.
-             If no input file is specified, read from stdin.
-  -o         Specify file output. If none is specified, write to stdout.
+%s  -i         Specify file input, otherwise use the arguments as input files.
+             If no input file is specified, read from stdin. Rendering stdin
+             to stdout is streamed line by line.
+  -files-from
+             Read the input files from this file, or stdin for -, one per line.
+  -0         Paths read with -files-from are separated by NUL characters,
+             as written by find -print0.
+  -include   Glob pattern of the files rendered from directory inputs. Patterns
+             with a slash match the path relative to the directory, others any
+             of its elements. May be repeated.
+  -exclude   Glob pattern of files and directories skipped in directory inputs,
+             matched like -include. May be repeated. Directory inputs may also
+             list the files to skip in a .envsubstignore file with gitignore
+             style patterns.
+  -jobs      Number of files rendered in parallel. Defaults to the number of CPUs.
   -no-digit  Do not replace variables starting with a digit. e.g. $1 and ${1}
   -no-unset  Fail if a variable is not set.
   -no-empty  Fail if a variable is set but empty.
+  -profile   Preset of restrictions the flags above add to:
+               relaxed   no restrictions (default)
+               no-unset  same as -no-unset
+               no-empty  same as -no-empty
+               strict    same as -no-unset -no-empty
+               compose   like docker compose, same as -no-digit
+               posix     like sh -u, same as -no-unset -no-digit
   -fail-fast Fail on first error otherwise display all failures if restrictions are set.
+  -mode      Syntax of the inputs, substituting only where it allows:
+               text      substitute everywhere (default)
+               yaml      substitute in scalar values only, quoting the results
+                         as needed; the documents are re-encoded
+               json      escape the values substituted in strings, the
+                         output must be valid JSON
+               toml      escape the values substituted in strings as their
+                         kind requires, leave comments alone
+               ini       continue multi-line values on indented lines, leave
+                         comments alone
+               properties
+                         escape the values substituted in Java properties
+                         keys and values, leave comments alone
+               xml       entity-escape the values substituted in text and
+                         attributes, the output must be well-formed XML
+               shell     leave single-quoted strings, here-documents, \$VAR
+                         and positional parameters such as $1 to the shell
+               hcl       leave ${...} and %%{...} to Terraform, substitute the
+                         $VAR form only, see -hcl-allow
+               helm      leave the {{ ... }} actions of Helm charts and Go
+                         templates byte for byte, failing if a substitution
+                         would alter one
+               csv       substitute in each field, quoting the fields as
+                         needed; the records are re-encoded
+               auto      choose by file extension, text for unknown ones
+  -transform Transform the substituted values, given as NAME for all variables
+             or PATTERN=NAME for the variables matching a glob pattern. The
+             first matching one applies. May be repeated. Supported:
+               shell-quote  quote as a single shell word, e.g. 'a b'
+               url          percent-encode all but letters, digits and -._~,
+                            e.g. -transform '*_PASSWORD=url' for credentials
+                            in connection strings
+  -redact    Render with every substituted value replaced, so the output can
+             be shared in bug reports without leaking secrets:
+               marker  <redacted:NAME>
+               hash    <sha256:...>, the start of the SHA-256 hash of the
+                       value, telling whether values differ; values that
+                       are easy to guess can be found from it
+             Overrides -transform.
+  -include-root
+             Enable ${include:path} references, including files that must be
+             in this directory, e.g. -include-root . for the current one.
+             Paths are relative to the directory of the including file and
+             included files are rendered too. Without it include is a
+             variable like the others.
+  -directives
+             Process the lines starting with #envsubst as directives, which
+             are text otherwise:
+               #envsubst if NAME, #envsubst else, #envsubst endif
+                         keep the first block if NAME is set to a value other
+                         than the empty string, 0, false, no and off, the
+                         else block otherwise; if !NAME negates it
+               #envsubst foreach ITEM in $LIST [sep SEP], #envsubst endforeach
+                         repeat the block for each element of LIST, separated
+                         by commas or SEP, setting ITEM and ITEM_INDEX
+             Lines holding a # envsubst:only comment alone, and the
+             # envsubst:end closing its block, are markers with or without
+             -directives: once an input has one, the variables are only
+             substituted within these blocks, the rest of it is kept as is,
+             but for the lines of stdin streamed before the first block.
+  -builtins  Comma separated groups of pseudo-variables substituted with
+             values from the runtime rather than the environment:
+               time  __NOW in RFC 3339 format, __DATE[:LAYOUT] in a Go time
+                     layout such as ${__DATE:2006-01-02}, its default, and
+                     __UNIX_TS in seconds. For reproducible outputs the time
+                     is read from SOURCE_DATE_EPOCH if set.
+               host  __HOSTNAME, __OS and __ARCH as Go names them, e.g.
+                     linux and amd64, and __UID, the user id
+               random
+                     __UUID, a version 4 UUID, and __RANDOM[:ENCODING[:N]],
+                     N random characters, 16 by default, in hex, alnum or
+                     base64, e.g. ${__RANDOM:alnum:32}, new for each
+                     reference
+  -random-seed
+             Integer seed of the random builtins, for reproducible outputs in
+             tests. Each input draws its values from the seed and its name,
+             whatever the order -jobs renders them in. The values are not
+             secure then.
+  -library   Enable the filters for hashing, padding, joining lists and
+             comparing versions in ${VAR|filter} substitutions besides upper,
+             lower, trim, replace, default, b64enc and b64dec:
+               sha256, sha1, sha512, md5  hex digest of the value
+               padleft N [CHAR]           pad to N characters, padright too
+               join SEP [DELIM]           join a list separated by DELIM, ","
+                                          by default, with SEP
+               semver CONSTRAINT          true or false, e.g. semver >=1.2.0
+  -provenance
+             Add a comment to the lines of the outputs in which variables
+             were substituted, listing them, e.g. # envsubst: HOST, PORT.
+             The comment syntax follows -mode; ini and properties comments
+             precede the line. Not supported in json and csv modes, whose
+             files are left without comments with -mode auto.
+  -eol       Line endings of the outputs, whatever those of the templates:
+               preserve  those of the templates (default)
+               lf        \n, e.g. when rendering on Windows for Linux
+               crlf      \r\n, e.g. when rendering on Linux for Windows
+  -hcl-allow Comma separated glob patterns of variables substituted from the
+             ${VAR} form in hcl mode as well, e.g. 'TF_*'.
+  -require-substitution
+             Fail for inputs in which no variable was substituted with a value
+             or a default, e.g. already rendered files or misspelled names.
+  -fail-on-empty-output
+             Fail for inputs rendering to an empty or whitespace-only output,
+             e.g. when the command piping the template failed.
+  -fail-on-empty-default
+             Fail for defaults substituted because their variable is set but
+             empty, as ${VAR:-default} does, which often hides a secret set to
+             nothing. Without it they are warned about.
+  -env-file  Load variables from a .env file of NAME=VALUE lines, which may
+             be quoted, span several lines and have comments. They override
+             the environment. May be repeated, later files win.
+  -env-from-json
+             Load variables from a JSON object. Nested keys are upper-cased and
+             joined with _, list elements are named by index, so
+             {"db": {"hosts": ["a"]}} sets DB_HOSTS_0=a. May be repeated.
+  -env-from-yaml
+             Like -env-from-json for a YAML mapping, such as a Helm values
+             file. May be repeated.
+  -env-from-toml
+             Like -env-from-json for a TOML document. May be repeated.
+  -env-from-url
+             Load variables from a JSON object or .env file served over HTTP(S),
+             read as JSON if served as application/json or named *.json.
+             Responses are cached by their ETag in the user cache directory.
+             Overrides variable files. May be repeated.
+  -env-url-header
+             Header of the requests of -env-from-url given as 'Name: value',
+             e.g. -env-url-header "Authorization: Bearer $TOKEN". May be
+             repeated.
+  -env-url-timeout
+             Timeout of the requests of -env-from-url, 30s by default.
+  -flatten-sep
+             Separator joining the keys of nested values of -env-from-json
+             and the like, _ by default.
+  -flatten-case
+             Case of the keys of nested values: upper (default), lower or
+             keep, e.g. -flatten-sep __ -flatten-case lower sets db__host.
+  -flatten-join
+             Join the elements of lists of scalars with this separator into a
+             single variable instead of naming them by index, e.g. with ,
+             {"hosts": ["a", "b"]} sets HOSTS=a,b rather than HOSTS_0=a.
+  -sops      Decrypt the files of -env-file and -env-from-json and the like
+             with sops before loading them, so they can be kept encrypted in
+             the repository. TOML files are encrypted as binary files. Their
+             variables are masked like -mask.
+  -resolve   Resolve the values of variables of the form SCHEME:REF when
+             substituting them, each one once per input. Supported:
+               vault:PATH#KEY  the key of the Vault secret at PATH, read
+                               like -vault-path
+               ssm:NAME        the AWS SSM parameter NAME, fetched in
+                               batches before rendering
+               secretsmanager:ID[#KEY]
+                               the AWS Secrets Manager secret ID, or the key
+                               of the JSON object it holds
+               keyring:SERVICE#ACCOUNT
+                               the password of ACCOUNT stored for SERVICE in
+                               the keyring of the operating system
+               file:PATH       the content of the file at PATH with white
+                               space trimmed, e.g. file:/run/secrets/db,
+                               with -resolve-file-root only
+             The values of ssm: references are masked like -mask. Templates
+             may reference files directly as ${file:PATH}, the other schemes
+             only resolve the values of variables.
+  -resolve-file-root
+             Directory the files of file: references must be in, e.g.
+             /run/secrets. No file is read if none is given. May be
+             repeated.
+  -resolve-command
+             Resolve the SCHEME:REF values of -resolve by running a command
+             given as SCHEME=COMMAND, replacing {} in it with REF or appending
+             REF, e.g. -resolve-command 'pass=pass show' for pass:db/password
+             or -resolve-command 'op=op read op://{}'. The command runs with
+             PATH and HOME only and its output, without the trailing line
+             break, is the value. May be repeated.
+  -resolve-command-env
+             Name of a variable of the environment passed to the commands of
+             -resolve-command as well, e.g. GNUPGHOME. May be repeated.
+  -resolve-timeout
+             Timeout of each resolution of -resolve, 10s by default. The
+             commands of -resolve-command are stopped.
+  -resolve-max-size
+             Fail resolutions of -resolve returning values larger than this
+             many bytes.
+  -from-k8s  Load the keys of a Kubernetes Secret or ConfigMap, given as
+             secret/NAME or configmap/NAME, as variables. Objects are read
+             with kubectl from the current context of the kubeconfig.
+             Overrides the environment and variable files. May be repeated.
+             The keys of Secrets are masked like -mask.
+  -aws-ssm-prefix
+             Load the AWS SSM parameters under this path, e.g. /myapp/prod/, as
+             variables named by the rest of their path, upper-cased with / as
+             _: /myapp/prod/db/password sets DB_PASSWORD. Parameters are read
+             with the aws CLI and its configured credentials and region.
+             Overrides Kubernetes and file sources, SecureString parameters are
+             masked like -mask. May be repeated.
+  -aws-secret
+             Load the keys of the JSON object held by an AWS Secrets Manager
+             secret, given by name or ARN, as variables. Overrides AWS SSM
+             parameters, the keys are masked like -mask. May be repeated.
+  -aws-region
+             AWS region of the AWS SSM and Secrets Manager requests, instead
+             of the one configured for the aws CLI.
+  -aws-profile
+             Profile of the aws CLI the AWS requests are made with.
+  -vault-path
+             Load the keys of a HashiCorp Vault secret, e.g. secret/data/app,
+             as variables, authenticating with VAULT_ADDR, VAULT_TOKEN and
+             VAULT_NAMESPACE like the vault CLI. KV version 1 and 2 engines are
+             supported. Overrides all other sources and is masked like -mask.
+             May be repeated.
+  -defaults-file
+             File of fallback values used for variables that are not set in the
+             environment. Either NAME=VALUE lines or, for .yaml and .yml files,
+             a mapping of names to values.
+  -interactive
+             When stdin is a terminal, ask for the values of the variables the
+             inputs need but which are not set before rendering. Input is hidden
+             for names matching the -mask patterns, or if none are given, names
+             containing PASSWORD, SECRET or TOKEN or ending in _KEY.
+  -schema    YAML file declaring the types and constraints of the values of
+             variables, failing the substitution of values violating them:
+               PORT: port
+               TIMEOUT: {type: duration}
+               LOG_LEVEL: {enum: [debug, info, warn]}
+               NAME: {pattern: '[a-z][a-z0-9-]*', max: 63}
+               REPLICAS: {type: int, min: 1, max: 10}
+               API_TOKEN: {non-empty: true}
+             Types: string, int, float, bool, duration, url and port. Patterns
+             match the whole value, min and max bound numbers and the length
+             of other values.
+  -deny      Fail the substitution of variables in outputs, given as NAMES or
+             NAMES:FILES, comma separated glob patterns of variable names
+             and of the output paths, e.g. '*_PASSWORD,*_TOKEN:*.yaml,*.json'
+             keeps secrets out of plain text configuration files. Outputs
+             written to stdout are named -. Defaults are allowed. May be
+             repeated.
+  -deprecated
+             Declare a deprecated variable as OLD=NEW, or OLD if it has no
+             replacement. May be repeated. References to OLD in the inputs
+             and OLD set in the environment are warned about, references
+             fail with -profile strict or -no-unset and -no-empty.
+  -map-deprecated
+             Set the replacements of the deprecated variables set in the
+             environment to their values, unless set, and substitute
+             references to the deprecated variables with the values of
+             their replacements.
+  -max-size  Abort rendering a file whose output exceeds this many bytes.
+  -max-input Abort rendering a file larger than this many bytes.
+  -max-depth Abort rendering when included files nest deeper than this,
+             e.g. a file included by an included file has a depth of 2, see
+             -include-root. References themselves nest 2 deep at most, as in
+             ${A:-$B}.
+  -mask      Comma separated glob patterns of variable names, e.g.
+             '*_TOKEN,*_PASSWORD'. The values of matching variables are still
+             substituted but redacted in all messages the command prints.
+  -format    Format of reported errors: text or json. The json format writes one
+             record per line with file, line, column, variable, kind, code and
+             message. Codes are stable: ENV001 unset, ENV002 empty, ENV003
+             syntax, ENV004 limit, ENV005 resolve, ENV006 schema, ENV007
+             deprecated, ENV008 no-substitution, ENV009 empty-output, ENV010
+             io, ENV011 policy, ENV012 lock and ENV013 empty-default. They
+             identify the rules of SARIF reports.
+  -explain   With the text format, print each error with the line of the input
+             it refers to, a caret under the reference, the restriction that
+             failed and a suggested fix, e.g. adding a default.
+  -audit     Write a record of each substitution to this file as a line of
+             JSON with the file, variable, line, column, source of the value,
+             whether the default was used and the value, redacted for the
+             variables masked like -mask. Lines and columns are those of the
+             scalars in yaml and csv mode.
+  -trace     Write the OpenTelemetry spans of the command, of each file and of
+             the parsing and substitution of their templates to this file as
+             JSON, with the template sizes and substitution counts. The spans
+             continue the trace of the TRACEPARENT variable if set.
+  -lock      Write the SHA-256 hashes of the values of the variables the
+             inputs were rendered with to this file as JSON, variables that
+             are not set as unset, so that the outputs can be shown to match
+             a known configuration without keeping the values. Not written if
+             rendering fails.
+  -verify-lock
+             Fail for inputs rendered with variables whose values differ from
+             those of this file written by -lock, or which it does not hold.
+             Variables of the file not used by the inputs are ignored.
+  -report    Write the findings to a report file given as format=path, e.g.
+             sarif=out.sarif for a SARIF log consumed by code scanning tools.
+  -annotate  Additionally print the findings as CI annotations. Supported: github.
+  -log-format
+             Format of the log written to stderr: text or json.
+  -log-level Least level of logged records: debug, info, warn or error.
+             Defaults to warn. info logs the files written, debug also the
+             variable sources loaded, the files rendered and the variables
+             substituted, without their values.
+  -debug-ast Print the parse tree of the inputs instead of running the
+             command, one node per line with its line and column, or with
+             -debug-ast=tokens the tokens the inputs are scanned into, to see
+             how escapes and operators are read. Nothing is substituted.
+  -config    Read default options from this YAML file instead of .envsubst.yaml
+             in the working directory. Its keys are option names, options that
+             may be repeated take lists:
+               profile: strict
+               mode: auto
+               exclude: ["*.png", vendor]
+             Options given on the command line take precedence. As it is read
+             without being asked for, .envsubst.yaml may only set the options
+             choosing how inputs are rendered and checked, such as -profile,
+             -mode, -include and -exclude, and not those reading or writing
+             files, running commands or loading variables, such as -o,
+             -env-file, -audit or -resolve, which a -config file may set.
 `
 
 func main() {
-	flag.Usage = func() {
-		fmt.Fprint(os.Stderr, fmt.Sprintf(usage))
+	args, name := os.Args[1:], "render"
+	if len(args) > 0 && commands[args[0]] != nil {
+		args, name = args[1:], args[0]
+	}
+	cmd := commands[name]
+	if name == "exec" {
+		for i, arg := range args {
+			if arg == "--" {
+				args, execArgs = args[:i], args[i+1:]
+				break
+			}
+		}
+	}
+	flags = flag.NewFlagSet(name, flag.ExitOnError)
+	commonFlags(flags)
+	cmd.flags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, usage, cmd.usage)
+	}
+	if path, explicit := configPath(args); path != "" {
+		if err := loadConfig(flags, path, explicit); err != nil {
+			usageAndExit(err.Error())
+		}
+	}
+	flags.Parse(args)
+	if format != "text" && format != "json" {
+		usageAndExit(fmt.Sprintf("Unknown format: %s.", format))
+	}
+	if annotate != "" && annotate != "github" {
+		usageAndExit(fmt.Sprintf("Unknown annotation system: %s.", annotate))
+	}
+	var err error
+	if logger, err = newLogger(os.Stderr, logFormat, logLevel); err != nil {
+		usageAndExit(err.Error())
 	}
-	flag.Parse()
-	var reader *bufio.Reader
-	if *input != "" {
-		file, err := os.Open(*input)
+	if _, ok := syntax.Lookup(mode); !ok && mode != "auto" {
+		usageAndExit(fmt.Sprintf("Unknown mode: %s.", mode))
+	}
+	if _, ok := syntax.Provenance(mode); provenance && !ok && mode != "auto" {
+		usageAndExit(fmt.Sprintf("Mode %s has no comments for -provenance.", mode))
+	}
+	if flattening.Case, err = source.ParseCase(flattenCase); err != nil {
+		usageAndExit(fmt.Sprintf("Unknown flatten case: %s.", flattenCase))
+	}
+	for _, h := range urlHeaders {
+		if name, _, ok := strings.Cut(h, ":"); !ok || strings.TrimSpace(name) == "" {
+			usageAndExit(fmt.Sprintf("Invalid header: %s, expected 'Name: value'.", h))
+		}
+	}
+	if builtins, err = parseBuiltins(builtinsFlag); err != nil {
+		usageAndExit(err.Error())
+	}
+	// Files are only read from the directories given.
+	if len(fileRoots) > 0 {
+		parse.RegisterResolver("file", resolver.File(fileRoots...))
+	}
+	for _, c := range resolveCmds {
+		scheme, command, _ := strings.Cut(c, "=")
+		args := strings.Fields(command)
+		if scheme == "" || len(args) == 0 {
+			usageAndExit(fmt.Sprintf("Invalid resolver command: %s, expected SCHEME=COMMAND.", c))
+		}
+		parse.RegisterResolver(scheme, resolver.Command{Args: args, Timeout: resolveWait, Env: resolveEnv})
+	}
+	if schemaPath != "" {
+		if schema, err = readSchema(schemaPath); err != nil {
+			usageAndExit(fmt.Sprintf("Error to read schema: %s: %v", schemaPath, err))
+		}
+	}
+	for _, d := range deprecFlags {
+		old, replacement, err := parse.ParseDeprecation(d)
 		if err != nil {
-			usageAndExit(fmt.Sprintf("Error to open file input: %s.", *input))
+			usageAndExit(fmt.Sprintf("Invalid deprecation: %v.", err))
+		}
+		if deprecated == nil {
+			deprecated = map[string]string{}
 		}
-		defer file.Close()
-		reader = bufio.NewReader(file)
-	} else {
+		deprecated[old] = replacement
+	}
+	var denied []denyRule
+	for _, spec := range denyFlags {
+		rule, err := parseDeny(spec)
+		if err != nil {
+			usageAndExit(err.Error())
+		}
+		denied = append(denied, rule)
+	}
+	if denied != nil {
+		policy = denyPolicy(denied)
+	}
+	if redact != "" && redact != "marker" && redact != "hash" {
+		usageAndExit(fmt.Sprintf("Unknown redaction: %s.", redact))
+	}
+	if eol != "preserve" && eol != "lf" && eol != "crlf" {
+		usageAndExit(fmt.Sprintf("Unknown line ending: %s.", eol))
+	}
+	if _, ok := profiles[profile]; !ok {
+		usageAndExit(fmt.Sprintf("Unknown profile: %s.", profile))
+	}
+	if maxSize < 0 || maxDepth < 0 || maxInput < 0 || resolveMax < 0 {
+		usageAndExit("Limits must not be negative.")
+	}
+	if numJobs < 1 {
+		usageAndExit("The number of jobs must be at least 1.")
+	}
+	if name == "exec" && len(execArgs) == 0 {
+		usageAndExit("exec needs a command after --.")
+	}
+	inputs := flags.Args()
+	if input != "" {
+		inputs = append([]string{input}, inputs...)
+	}
+	if filesFrom != "" {
+		files, err := readFileList(filesFrom, nulSep)
+		if err != nil {
+			failAndExit(filesFrom, fmt.Sprintf("Error to read input files from: %s.", filesFrom))
+		}
+		inputs = append(inputs, files...)
+	}
+	if inPlace && (output != "" || len(inputs) == 0 && filesFrom == "") {
+		usageAndExit("Rendering in place needs input files and no output.")
+	}
+	// exec and serve read no template from stdin, which is the command's.
+	readsStdin := name != "exec" && name != "serve"
+	if len(inputs) == 0 && filesFrom == "" && readsStdin {
 		stat, err := os.Stdin.Stat()
 		if err != nil || (stat.Mode()&os.ModeCharDevice) != 0 {
 			usageAndExit("")
 		}
-		reader = bufio.NewReader(os.Stdin)
 	}
-	// Collect input data.
-	var data string
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				data += line
-				break
+	awsClient.Region, awsClient.Profile = awsRegion, awsProfile
+	if env, err = environ(); err != nil {
+		failAndExit("", err.Error())
+	}
+	if deprecated != nil {
+		mapped, used := parse.MapDeprecated(env, deprecated)
+		for _, old := range used {
+			if replacement := deprecated[old]; replacement != "" {
+				logger.Warn(fmt.Sprintf("variable %s is deprecated, use %s", old, replacement), "variable", old)
+				if mapDeprec {
+					if _, ok := origins[replacement]; !ok {
+						origins[replacement] = origins[old]
+					}
+				}
+			} else {
+				logger.Warn(fmt.Sprintf("variable %s is deprecated", old), "variable", old)
 			}
-			usageAndExit("Failed to read input.")
 		}
-		data += line
+		if mapDeprec {
+			env = mapped
+		}
 	}
-	var (
-		err  error
-		file *os.File
-	)
-	if *output != "" {
-		file, err = os.Create(*output)
+	maskEnv := env
+	if resolve {
+		// Values of SSM parameters are fetched in batches up front.
+		resolved, err := awsClient.Prefetch(env)
 		if err != nil {
-			usageAndExit("Error to create the wanted output file.")
+			failAndExit("", fmt.Sprintf("Error to read variables from AWS SSM: %v", err))
+		}
+		secretVars = append(secretVars, names(resolved)...)
+		maskEnv = append(resolved, env...)
+	}
+	masks = newMasker(maskFlag, secretVars, maskEnv)
+	if interactive && len(inputs) > 0 && term.IsTerminal(int(os.Stdin.Fd())) {
+		// Ask for the variables of the inputs and the output path before
+		// substituting the output path, which may need them as well.
+		jobList, err := planJobs(inputs, "", false)
+		if err != nil {
+			failAndExit("", err.Error())
+		}
+		vars, err := promptUnset(jobList, output)
+		if err != nil {
+			failAndExit("", fmt.Sprintf("Failed to read variables: %v", err))
+		}
+		env = append(vars, env...)
+		for _, name := range names(vars) {
+			origins[name] = "prompt"
+		}
+		masks = newMasker(maskFlag, secretVars, env)
+	}
+	if auditPath != "" {
+		if audits, err = openAudit(auditPath); err != nil {
+			failAndExit("", fmt.Sprintf("Error to create audit file: %v", err))
+		}
+	}
+	if verifyPath != "" {
+		if locked, err = readLock(verifyPath); err != nil {
+			failAndExit("", fmt.Sprintf("Error to read lock file: %v", err))
+		}
+	}
+	if lockPath != "" {
+		lock = parse.NewLock()
+	}
+	if tracePath != "" {
+		if traces, err = openTrace(tracePath, name); err != nil {
+			failAndExit("", fmt.Sprintf("Error to create trace file: %v", err))
+		}
+	}
+	// The output path may reference variables itself.
+	op := newParser("-o")
+	if redact != "" {
+		// Only the outputs are redacted, not where they are written.
+		op.Transform = nil
+	}
+	if output, err = op.Parse(output); err != nil {
+		exitWithDiagnostics(diagnostics("-o", err))
+	}
+	var jobList []job
+	if len(inputs) > 0 || filesFrom == "" && readsStdin {
+		if jobList, err = planJobs(inputs, output, (name == "render" || name == "exec") && !inPlace); err != nil {
+			failAndExit("", err.Error())
+		}
+	}
+	if inPlace {
+		jobList = renderInPlace(jobList)
+	}
+	showFiles = len(jobList) > 1
+	start := time.Now()
+	run := cmd.run
+	if debugAST != "" {
+		run = func(jobs []job) []diagnostic {
+			return writeResults(runJobs(jobs, numJobs, job.dump))
+		}
+	}
+	diags := run(jobList)
+	if traces != nil {
+		if err := traces.close(len(jobList), len(diags)); err != nil {
+			logger.Warn("failed to write trace", "error", err)
 		}
-	} else {
-		file = os.Stdout
 	}
-	// Parse input string
+	if lock != nil && len(diags) == 0 {
+		if err := writeLock(lockPath); err != nil {
+			failAndExit("", fmt.Sprintf("Error to write lock file: %v", err))
+		}
+	}
+	logger.Info("finished", "command", name, "files", len(jobList), "diagnostics", len(diags), "duration", time.Since(start))
+	if len(diags) > 0 {
+		exitWithDiagnostics(diags)
+	}
+	if err := writeReports(nil); err != nil {
+		failAndExit("", err.Error())
+	}
+	if name == "exec" {
+		execAndExit()
+	}
+}
+
+// profiles are the restriction presets selectable with -profile.
+var profiles = map[string]*parse.Restrictions{
+	"relaxed":  parse.Relaxed,
+	"no-unset": parse.NoUnset,
+	"no-empty": parse.NoEmpty,
+	"strict":   parse.Strict,
+	// docker compose substitutes unset variables with an empty string
+	// and has no positional parameters.
+	"compose": {NoDigit: true},
+	// sh -u fails on unset variables, positional parameters are not
+	// part of the environment.
+	"posix": {NoUnset: true, NoDigit: true},
+}
+
+// newParser returns a parser for the template name configured from the command line.
+func newParser(name string) *parse.Parser {
 	parserMode := parse.AllErrors
-	if *failFast {
+	if failFast {
 		parserMode = parse.Quick
 	}
-	restrictions := &parse.Restrictions{*noUnset, *noEmpty, *noDigit, false}
-	result, err := (&parse.Parser{Name: "string", Env: os.Environ(), Restrict: restrictions, Mode: parserMode}).Parse(data)
-	if err != nil {
-		errorAndExit(err)
+	preset := profiles[profile]
+	restrictions := &parse.Restrictions{
+		NoUnset: preset.NoUnset || noUnset,
+		NoEmpty: preset.NoEmpty || noEmpty,
+		NoDigit: preset.NoDigit || noDigit,
 	}
-	if _, err := file.WriteString(result); err != nil {
-		filename := *output
-		if filename == "" {
-			filename = "STDOUT"
-		}
-		usageAndExit(fmt.Sprintf("Error writing output to: %s.", filename))
+	limits := parse.Limits{MaxOutput: maxSize, MaxDepth: maxDepth}
+	p := &parse.Parser{Name: name, Env: env, Restrict: restrictions, Mode: parserMode, Limits: limits}
+	// Inputs that are not files, such as stdin, include from the
+	// current directory.
+	if includeRoot != "" {
+		p.Includes = &parse.Includes{Root: includeRoot, Dir: filepath.Dir(name)}
+	}
+	p.Directives = directives
+	if resolve {
+		p.Resolvers = parse.DefaultResolvers
+		p.Referenced = []string{"file"}
+		p.Sandbox = &parse.Sandbox{Timeout: resolveWait, MaxValue: resolveMax}
+	}
+	p.Builtins = builtinsFor(name)
+	p.Schema = schema
+	p.Deprecated = deprecated
+	p.Policy = policy
+	p.MapDeprecated = mapDeprec
+	p.FailDeprecated = restrictions.NoUnset && restrictions.NoEmpty
+	p.FailEmptyDefault = failDefault
+	p.Logger = logger
+	if traces != nil {
+		p.Tracer = traces.tracer
+	}
+	if masks != nil {
+		p.Mask = masks.matches
+	}
+	if audits != nil {
+		p.Audit = audits.record
+		p.Source = func(name string) string { return origins[name] }
+	}
+	if library {
+		p.Filters = parse.DefaultFilters.With(parse.Library)
 	}
+	switch {
+	case redact == "marker":
+		p.Transform = parse.Redact
+	case redact == "hash":
+		p.Transform = parse.RedactHash
+	case len(transformed) > 0:
+		p.Transform = transformed.apply
+	}
+	return p
 }
 
 func usageAndExit(msg string) {
+	msg = masks.mask(msg)
 	if msg != "" {
 		fmt.Fprintf(os.Stderr, msg)
 		fmt.Fprintf(os.Stderr, "\n\n")
 	}
-	flag.Usage()
+	flags.Usage()
 	fmt.Fprintf(os.Stderr, "\n")
 	os.Exit(1)
 }
 
-func errorAndExit(e error) {
-	fmt.Fprintf(os.Stderr, "%v\n\n", e.Error())
+// failAndExit reports a failure that is not caused by the template itself.
+// In json format it is written as a single record instead of the usage text.
+func failAndExit(file, msg string) {
+	if format != "json" {
+		usageAndExit(msg)
+	}
+	writeDiagnostics(os.Stderr, format, []diagnostic{ioDiagnostic(file, masks.mask(msg))})
+	os.Exit(1)
+}
+
+// exitWithDiagnostics reports diags on stderr and in the requested reports.
+func exitWithDiagnostics(diags []diagnostic) {
+	diags = masks.maskDiagnostics(diags)
+	writeDiagnostics(os.Stderr, format, diags)
+	writeAnnotations(os.Stderr, annotate, diags)
+	if err := writeReports(diags); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
 	os.Exit(1)
 }
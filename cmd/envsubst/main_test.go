@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestMain runs main instead of the tests when the test binary is run by
+// runMain.
+func TestMain(m *testing.M) {
+	if os.Getenv("ENVSUBST_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+type cliTest struct {
+	name   string
+	args   []string
+	env    []string
+	stdin  string
+	files  map[string]string // files of the working directory, those in bin/ are commands on the PATH
+	code   int               // exit code
+	stdout string
+	stderr string                 // part of stderr expected
+	output map[string]string      // files expected once run
+	parts  map[string]string      // parts of files expected once run
+	absent []string               // files expected not to exist once run
+	modes  map[string]os.FileMode // permissions of files expected once run
+}
+
+// runMain runs main with the test in a temporary working directory and
+// checks its exit code and outputs.
+func runMain(t *testing.T, test cliTest) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, data := range test.files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		perm := os.FileMode(0o644)
+		if strings.HasPrefix(name, "bin/") {
+			perm = 0o755
+		}
+		if err := os.WriteFile(path, []byte(data), perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bin := filepath.Join(dir, "bin") + string(os.PathListSeparator)
+	cmd := exec.Command(os.Args[0], test.args...)
+	cmd.Dir = dir
+	cmd.Env = append([]string{"ENVSUBST_TEST_MAIN=1", "PATH=" + bin + os.Getenv("PATH"), "HOME=" + dir}, test.env...)
+	cmd.Stdin = strings.NewReader(test.stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	code := 0
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if !errors.As(err, &exit) {
+			t.Fatal(err)
+		}
+		code = exit.ExitCode()
+	}
+	if code != test.code {
+		t.Errorf("%s: got exit code %d, expected %d, stderr:\n%s", test.name, code, test.code, stderr.String())
+	}
+	if got := stdout.String(); got != test.stdout {
+		t.Errorf("%s: got stdout %q, expected %q", test.name, got, test.stdout)
+	}
+	if !strings.Contains(stderr.String(), test.stderr) {
+		t.Errorf("%s: got stderr %q, expected it to contain %q", test.name, stderr.String(), test.stderr)
+	}
+	for name, expected := range test.output {
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if got := string(b); got != expected || err != nil {
+			t.Errorf("%s: got %s %q, %v, expected %q", test.name, name, got, err, expected)
+		}
+	}
+	for _, name := range test.absent {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: got %s, %v, expected it not to exist", test.name, name, err)
+		}
+	}
+	for name, expected := range test.modes {
+		stat, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if stat.Mode().Perm() != expected {
+			t.Errorf("%s: got %s %v, expected %v", test.name, name, stat.Mode().Perm(), expected)
+		}
+	}
+	for name, part := range test.parts {
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if got := string(b); !strings.Contains(got, part) || err != nil {
+			t.Errorf("%s: got %s %q, %v, expected it to contain %q", test.name, name, got, err, part)
+		}
+	}
+}
+
+var renderTests = []cliTest{
+	{name: "stdin", env: []string{"A=1"}, stdin: "a=$A\nb=${B:-b}\n", stdout: "a=1\nb=b\n"},
+	{name: "unset", args: []string{"-no-unset"}, env: []string{"A=1"}, stdin: "a=$A\nx=$X\nc=$A\n",
+		code: 1, stdout: "a=1\n", stderr: "X"},
+	{name: "file", args: []string{"-o", "out/a.conf", "a.tmpl"}, env: []string{"A=1"},
+		files: map[string]string{"a.tmpl": "a=$A\n"}, output: map[string]string{"out/a.conf": "a=1\n"}},
+	{name: "render command", args: []string{"render", "a.tmpl", "b.tmpl"}, env: []string{"A=1"},
+		files: map[string]string{"a.tmpl": "a=$A\n", "b.tmpl": "b=$A$A\n"}, stdout: "a=1\nb=11\n"},
+	{name: "strip ext", args: []string{"-strip-ext", ".tmpl", "-o", "out", "in"}, env: []string{"A=1"},
+		files:  map[string]string{"in/a.conf.tmpl": "a=$A", "in/b.txt": "b=$A"},
+		output: map[string]string{"out/a.conf": "a=1"}},
+	{name: "env file", args: []string{"-env-file", ".env"}, env: []string{"A=env"}, stdin: "$A $B",
+		files: map[string]string{".env": "A=file\nB=b\n"}, stdout: "file b"},
+	{name: "directives", args: []string{"-directives"}, env: []string{"A=1"}, stdin: "#envsubst if A\na=$A\n#envsubst endif\n",
+		stdout: "a=1\n"},
+	{name: "no directives", env: []string{"A=1"}, stdin: "#envsubst is run by CI\na=$A\n",
+		stdout: "#envsubst is run by CI\na=1\n"},
+	// The lines streamed before the first block are substituted.
+	{name: "only markers stream", env: []string{"A=1"}, stdin: "a=$A\n# envsubst:only\nb=$A\n# envsubst:end\nc=$A\n",
+		stdout: "a=1\n# envsubst:only\nb=1\n# envsubst:end\nc=$A\n"},
+	{name: "only markers file", args: []string{"-no-unset", "a.sh"}, env: []string{"A=1"},
+		files:  map[string]string{"a.sh": "x=$X\n  # envsubst:only\na=$A\n  # envsubst:end\n"},
+		stdout: "x=$X\n  # envsubst:only\na=1\n  # envsubst:end\n"},
+	{name: "only markers unclosed", args: []string{"a.sh"}, files: map[string]string{"a.sh": "# envsubst:only\n$A\n"},
+		code: 1, stderr: "missing # envsubst:end"},
+	{name: "include", args: []string{"-include-root", "."}, env: []string{"A=1"}, stdin: "${include:inc.conf}",
+		files: map[string]string{"inc.conf": "a=$A\n"}, stdout: "a=1\n"},
+	{name: "include disabled", env: []string{"A=1"}, stdin: "x${include:.env}",
+		files: map[string]string{".env": "SECRET=s\n"}, stdout: "x"},
+	{name: "missing input", args: []string{"missing.tmpl"}, code: 1, stderr: "missing.tmpl"},
+	{name: "unknown format", args: []string{"-format", "xml"}, code: 1, stderr: "Unknown format: xml."},
+	{name: "check", args: []string{"check", "-no-unset", "a.tmpl"}, files: map[string]string{"a.tmpl": "$X"},
+		code: 1, stderr: "X"},
+}
+
+func TestRender(t *testing.T) {
+	for _, test := range renderTests {
+		runMain(t, test)
+	}
+}
+
+var execTests = []cliTest{
+	{name: "exec", args: []string{"exec", "-o", "out", "a.tmpl", "--", "cat", "out"}, env: []string{"A=1"},
+		files: map[string]string{"a.tmpl": "a=$A\n"}, stdout: "a=1\n"},
+	{name: "render env", args: []string{"exec", "-render-env", "--", "sh", "-c", "echo $URL"},
+		env: []string{"HOST=db", "URL=postgres://${HOST}/app"}, stdout: "postgres://db/app\n"},
+	{name: "exit code", args: []string{"exec", "--", "sh", "-c", "exit 3"}, code: 3},
+	{name: "render failure", args: []string{"exec", "-no-unset", "-o", "out", "a.tmpl", "--", "echo", "run"},
+		files: map[string]string{"a.tmpl": "$X"}, code: 1, stderr: "X"},
+	{name: "env file", args: []string{"exec", "-env-file", ".env", "--", "sh", "-c", "echo $A $B"}, env: []string{"A=env", "B=b"},
+		files: map[string]string{".env": "A=file\n"}, stdout: "file b\n"},
+	{name: "render env failure", args: []string{"exec", "-render-env", "-no-unset", "--", "true"},
+		env: []string{"URL=postgres://${HOST}/app"}, code: 1, stderr: "variable ${HOST} not set"},
+	{name: "env kept", args: []string{"exec", "--", "sh", "-c", "echo $URL"}, env: []string{"URL=${HOST}"},
+		stdout: "${HOST}\n"},
+	{name: "stdin", args: []string{"exec", "--", "cat"}, stdin: "$A", stdout: "$A"},
+	{name: "no command", args: []string{"exec", "a.tmpl"}, code: 1, stderr: "exec needs a command after --."},
+	{name: "missing command", args: []string{"exec", "--", "no-such-command"}, code: 1, stderr: "Error to run command"},
+}
+
+func TestExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands need a shell")
+	}
+	for _, test := range execTests {
+		runMain(t, test)
+	}
+}
+
+var configTests = []cliTest{
+	{name: "default config", stdin: "$X", files: map[string]string{".envsubst.yaml": "profile: strict\n"},
+		code: 1, stderr: "X"},
+	{name: "flags win", args: []string{"-profile", "relaxed"}, stdin: "x=$X",
+		files: map[string]string{".envsubst.yaml": "profile: strict\n"}, stdout: "x="},
+	{name: "repeated", args: []string{"-config", "conf.yaml"}, stdin: "$A$B", files: map[string]string{
+		"conf.yaml": "env-file: [a.env, b.env]\n", "a.env": "A=a\n", "b.env": "B=b\n"}, stdout: "ab"},
+	{name: "safe list", args: []string{"-o", "out", "in"}, env: []string{"A=a"}, files: map[string]string{
+		".envsubst.yaml": "exclude: [b.txt, c.txt]\n", "in/a.txt": "$A", "in/b.txt": "$A", "in/c.txt": "$A"},
+		output: map[string]string{"out/a.txt": "a"}},
+	{name: "explicit", args: []string{"-config", "conf.yaml"}, stdin: "$A",
+		files: map[string]string{"conf.yaml": "env-file: a.env\n", "a.env": "A=a\n"}, stdout: "a"},
+	{name: "unknown option", stdin: "$A", files: map[string]string{".envsubst.yaml": "nope: 1\n"},
+		code: 1, stderr: "nope"},
+	{name: "trusted option", stdin: "$A", files: map[string]string{".envsubst.yaml": "resolve: true\n"},
+		code: 1, stderr: "Option not allowed in config file"},
+	{name: "hostile output", env: []string{"SECRET=s"}, stdin: "$SECRET", files: map[string]string{
+		".envsubst.yaml": "o: victim.txt\n", "victim.txt": "keep"},
+		code: 1, stderr: "Option not allowed in config file", output: map[string]string{"victim.txt": "keep"}},
+	{name: "hostile in place", args: []string{"a.tmpl"}, stdin: "$A", files: map[string]string{
+		".envsubst.yaml": "in-place: true\n", "a.tmpl": "$A"},
+		code: 1, stderr: "Option not allowed in config file", output: map[string]string{"a.tmpl": "$A"}},
+	{name: "hostile audit", env: []string{"SECRET=s"}, stdin: "$SECRET", files: map[string]string{
+		".envsubst.yaml": "audit: victim.txt\n", "victim.txt": "keep"},
+		code: 1, stderr: "Option not allowed in config file", output: map[string]string{"victim.txt": "keep"}},
+	{name: "hostile env file", stdin: "$HOME", files: map[string]string{".envsubst.yaml": "env-file: /etc/passwd\n"},
+		code: 1, stderr: "Option not allowed in config file"},
+	{name: "hostile files from", stdin: "$A", files: map[string]string{".envsubst.yaml": "files-from: list\n"},
+		code: 1, stderr: "Option not allowed in config file"},
+	{name: "trusted option explicit", args: []string{"-config", ".envsubst.yaml"}, env: []string{"A=a"}, stdin: "$A",
+		files: map[string]string{".envsubst.yaml": "resolve: true\n"}, stdout: "a"},
+}
+
+func TestConfig(t *testing.T) {
+	for _, test := range configTests {
+		runMain(t, test)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// maskedValue replaces the values of masked variables in diagnostics.
+const maskedValue = "***"
+
+// masker redacts the values of variables matching the -mask patterns
+// and of variables loaded from secret stores.
+type masker struct {
+	patterns []string
+	secrets  map[string]bool
+	replacer *strings.Replacer
+}
+
+// newMasker returns a masker for the comma separated glob patterns and the
+// secret variable names, redacting the values they have in env.
+func newMasker(patterns string, secrets []string, env []string) *masker {
+	m := &masker{secrets: map[string]bool{}}
+	for _, name := range secrets {
+		m.secrets[name] = true
+	}
+	for _, p := range strings.Split(patterns, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+	var values []string
+	for _, pair := range env {
+		name, value, _ := strings.Cut(pair, "=")
+		if value != "" && m.matches(name) {
+			values = append(values, value)
+		}
+	}
+	// Replace longer values first, so a value containing another one
+	// is not revealed partially.
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	var oldnew []string
+	for _, v := range values {
+		oldnew = append(oldnew, v, maskedValue)
+	}
+	m.replacer = strings.NewReplacer(oldnew...)
+	return m
+}
+
+// matches reports whether the variable name is a secret or matches one
+// of the patterns.
+func (m *masker) matches(name string) bool {
+	if m.secrets[name] {
+		return true
+	}
+	for _, p := range m.patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// mask redacts the masked values in s.
+func (m *masker) mask(s string) string {
+	if m == nil {
+		return s
+	}
+	return m.replacer.Replace(s)
+}
+
+// maskDiagnostics redacts the masked values in the messages of diags.
+func (m *masker) maskDiagnostics(diags []diagnostic) []diagnostic {
+	masked := make([]diagnostic, len(diags))
+	for i, d := range diags {
+		d.Message = m.mask(d.Message)
+		d.source = m.mask(d.source)
+		masked[i] = d
+	}
+	return masked
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestMasker(t *testing.T) {
+	m := newMasker("*_TOKEN, DB_*", []string{"VAULT_SECRET"},
+		[]string{"API_TOKEN=abc", "LONG_TOKEN=abcdef", "DB_PASSWORD=pw", "VAULT_SECRET=v4ult", "USER=abc2", "EMPTY_TOKEN="})
+	for name, expected := range map[string]bool{"API_TOKEN": true, "DB_HOST": true, "VAULT_SECRET": true, "USER": false, "TOKEN": false} {
+		if got := m.matches(name); got != expected {
+			t.Errorf("%s: got %v, expected %v", name, got, expected)
+		}
+	}
+	// Longer values are masked first and empty values are left alone.
+	if got, expected := m.mask("abcdef abc pw v4ult user"), "*** *** *** *** user"; got != expected {
+		t.Errorf("got %q, expected %q", got, expected)
+	}
+	var none *masker
+	if got := none.mask("abc"); got != "abc" {
+		t.Errorf("got %q without a masker", got)
+	}
+}
+
+var maskTests = []cliTest{
+	{name: "mask diff", args: []string{"diff", "-mask", "*_TOKEN", "a.tmpl"}, env: []string{"API_TOKEN=s3cret"},
+		files: map[string]string{"a.tmpl": "x=$API_TOKEN\n"}, stdout: "--- a.tmpl\n+++ a.tmpl (rendered)\n@@ -1 +1 @@\n-x=$API_TOKEN\n+x=***\n"},
+	{name: "mask output", args: []string{"-mask", "*_TOKEN"}, env: []string{"API_TOKEN=s3cret"}, stdin: "x=$API_TOKEN",
+		stdout: "x=s3cret"},
+	{name: "mask message", args: []string{"-mask", "*_TOKEN", "s3cret.tmpl"}, env: []string{"API_TOKEN=s3cret"},
+		code: 1, stderr: "***.tmpl"},
+	{name: "mask error", args: []string{"-library", "-mask", "*_TOKEN"}, env: []string{"API_TOKEN=s3cret"}, stdin: "x=${API_TOKEN|semver >1}",
+		code: 1, stderr: `filter semver of ${API_TOKEN}: invalid version "***"`},
+}
+
+func TestMask(t *testing.T) {
+	for _, test := range maskTests {
+		runMain(t, test)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// denyRule is a rule of -deny, denying the variables matching names in the
+// outputs matching files, all outputs if there are none.
+type denyRule struct {
+	spec  string
+	names func(name string) bool
+	files []string
+}
+
+// parseDeny parses the rule NAMES[:FILES] of -deny, both comma separated
+// glob patterns.
+func parseDeny(spec string) (denyRule, error) {
+	names, files, _ := strings.Cut(spec, ":")
+	if names == "" {
+		return denyRule{}, fmt.Errorf("Invalid rule: %s, expected NAMES[:FILES].", spec)
+	}
+	rule := denyRule{spec: spec, names: parse.MaskGlobs(strings.Split(names, ",")...)}
+	if files != "" {
+		rule.files = strings.Split(files, ",")
+	}
+	return rule, nil
+}
+
+// denyPolicy returns the policy denying the substitutions matching one of
+// the rules. Outputs written to stdout are named -.
+func denyPolicy(rules []denyRule) parse.Policy {
+	return func(r parse.PolicyRequest) error {
+		if r.Default {
+			return nil
+		}
+		dest := r.Destination
+		if dest == "" {
+			dest = "-"
+		}
+		for _, rule := range rules {
+			if rule.names(r.Variable) && (rule.files == nil || matchAny(rule.files, dest)) {
+				return fmt.Errorf("-deny %s", rule.spec)
+			}
+		}
+		return nil
+	}
+}
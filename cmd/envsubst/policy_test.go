@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+var policyTests = []cliTest{
+	{name: "deny", args: []string{"-deny", "*_PASSWORD"}, env: []string{"DB_PASSWORD=s3cret"}, stdin: "$DB_PASSWORD",
+		code: 1, stderr: "${DB_PASSWORD}: denied: -deny *_PASSWORD"},
+	{name: "deny default", args: []string{"-deny", "*_PASSWORD"}, stdin: "${DB_PASSWORD:-dev}", stdout: "dev"},
+	{name: "deny files", args: []string{"render", "-deny", "*_PASSWORD,*_TOKEN:*.yaml", "-strip-ext", ".tmpl", "-o", "out/", "a.yaml.tmpl", "a.env.tmpl"},
+		env:   []string{"DB_PASSWORD=s3cret"},
+		files: map[string]string{"a.yaml.tmpl": "p: $DB_PASSWORD\n", "a.env.tmpl": "P=$DB_PASSWORD\n"},
+		code:  1, stderr: "a.yaml.tmpl: ${DB_PASSWORD}: denied: -deny *_PASSWORD,*_TOKEN:*.yaml",
+		output: map[string]string{"out/a.env": "P=s3cret\n"}, absent: []string{"out/a.yaml"}},
+	{name: "deny stdout", args: []string{"-deny", "*_PASSWORD:-"}, env: []string{"DB_PASSWORD=s3cret"}, stdin: "$DB_PASSWORD",
+		code: 1, stderr: "denied"},
+	{name: "invalid deny", args: []string{"-deny", ":*.yaml"}, stdin: "$A", code: 1, stderr: "Invalid rule: :*.yaml, expected NAMES[:FILES]."},
+}
+
+func TestPolicy(t *testing.T) {
+	for _, test := range policyTests {
+		runMain(t, test)
+	}
+}
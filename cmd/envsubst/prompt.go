@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+	"golang.org/x/term"
+)
+
+// secretPatterns are the names prompted for with hidden input
+// when no -mask patterns are given.
+const secretPatterns = "*PASSWORD*,*SECRET*,*TOKEN*,*_KEY"
+
+// promptUnset asks on the terminal for the values of the variables the output
+// path and the inputs of jobs need but which are not set, and returns them as
+// NAME=VALUE pairs. Variables that only have references with a default or
+// alternate value are not asked for. Input is hidden for names matching the
+// -mask patterns.
+func promptUnset(jobs []job, output string) ([]string, error) {
+	secrets := newMasker(maskFlag, nil, nil)
+	if len(secrets.patterns) == 0 {
+		secrets = newMasker(secretPatterns, nil, nil)
+	}
+	var names []string
+	seen := map[string]bool{}
+	templates := []string{output}
+	for _, j := range jobs {
+		if j.copy {
+			continue
+		}
+		if data, diags := j.read(); diags == nil {
+			templates = append(templates, data)
+		}
+	}
+	for _, data := range templates {
+		refs, err := newParser("").References(data)
+		if err != nil {
+			continue
+		}
+		for _, ref := range refs {
+			if ref.Optional || seen[ref.Name] || parse.Env(env).Has(ref.Name) {
+				continue
+			}
+			seen[ref.Name] = true
+			names = append(names, ref.Name)
+		}
+	}
+	var vars []string
+	reader := bufio.NewReader(os.Stdin)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "%s: ", name)
+		var value string
+		if secrets.matches(name) {
+			b, err := term.ReadPassword(int(os.Stdin.Fd()))
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				return nil, err
+			}
+			value = string(b)
+		} else {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return nil, err
+			}
+			value = strings.TrimRight(line, "\r\n")
+		}
+		vars = append(vars, name+"="+value)
+	}
+	return vars, nil
+}
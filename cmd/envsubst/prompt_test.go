@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPromptUnset(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.tmpl")
+	if err := os.WriteFile(path, []byte("$A ${B:-b} ${C} ${D:+d} $A $E"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	profile, env = "relaxed", []string{"C=c"}
+	defer func() { profile, env = "", nil }()
+	stdin, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, stderr, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(stdin, stderr *os.File) { os.Stdin, os.Stderr = stdin, stderr }(os.Stdin, os.Stderr)
+	os.Stdin, os.Stderr = stdin, stderr
+	io.WriteString(w, "out\n1\n5 6\r\n")
+	w.Close()
+	vars, err := promptUnset([]job{{in: path}, {in: "b.txt", copy: true}}, "${DIR}/a.conf")
+	stderr.Close()
+	prompts, _ := io.ReadAll(r)
+	if expected := []string{"DIR=out", "A=1", "E=5 6"}; !reflect.DeepEqual(vars, expected) || err != nil {
+		t.Errorf("got %q, %v, expected %q", vars, err, expected)
+	}
+	if got := string(prompts); got != "DIR: A: E: " {
+		t.Errorf("got prompts %q", got)
+	}
+	// The input ends before all variables are given.
+	stdin, w, err = os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.Stderr, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0); err != nil {
+		t.Fatal(err)
+	}
+	os.Stdin = stdin
+	io.WriteString(w, "1")
+	w.Close()
+	if _, err := promptUnset([]job{{in: path}}, ""); err == nil || !strings.Contains(err.Error(), "EOF") {
+		t.Errorf("got %v, expected the end of the input", err)
+	}
+}
+
+var promptTests = []cliTest{
+	// Without a terminal there is nobody to ask.
+	{name: "interactive without terminal", args: []string{"-interactive", "-no-unset", "a.tmpl"}, stdin: "1\n",
+		files: map[string]string{"a.tmpl": "$A"}, code: 1, stderr: "variable ${A} not set"},
+}
+
+func TestPrompt(t *testing.T) {
+	for _, test := range promptTests {
+		runMain(t, test)
+	}
+}
@@ -0,0 +1,608 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hellt/envsubst/parse"
+	"github.com/hellt/envsubst/syntax"
+)
+
+// job renders a single input into a single output.
+// An empty input reads stdin, an empty output writes to stdout.
+type job struct {
+	in   string
+	out  string
+	copy bool // copy the input verbatim instead of rendering it
+	// span traces the processing of the input, see -trace.
+	span parse.TraceSpan
+}
+
+// planJobs expands the inputs into jobs. Directories are walked recursively.
+// With several inputs output names a directory, which is mandatory for
+// directory inputs if needOutput is set.
+func planJobs(inputs []string, output string, needOutput bool) ([]job, error) {
+	if len(inputs) == 0 {
+		return []job{{in: "", out: output}}, nil
+	}
+	var dirs bool
+	for _, in := range inputs {
+		if stat, err := os.Stat(in); err == nil && stat.IsDir() {
+			dirs = true
+		}
+	}
+	if len(inputs) == 1 && !dirs {
+		return []job{{in: inputs[0], out: output}}, nil
+	}
+	if dirs && output == "" && needOutput {
+		return nil, errors.New("Directory input requires an output directory.")
+	}
+	var jobs []job
+	for _, in := range inputs {
+		stat, err := os.Stat(in)
+		if err != nil || !stat.IsDir() {
+			jobs = appendJob(jobs, in, filepath.Base(in), output)
+			continue
+		}
+		ignores, err := readIgnoreFile(in)
+		if err != nil {
+			return nil, fmt.Errorf("Error to read ignore file: %s.", filepath.Join(in, ignoreFile))
+		}
+		err = filepath.WalkDir(in, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(in, path)
+			if err != nil || rel == "." {
+				return err
+			}
+			if rel == ignoreFile || matchAny(excludes, rel) || ignores.ignored(rel, d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() || len(includes) > 0 && !matchAny(includes, rel) {
+				return nil
+			}
+			jobs = appendJob(jobs, path, rel, output)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Error to walk input directory: %s.", in)
+		}
+	}
+	return jobs, nil
+}
+
+// appendJob appends the job rendering the input at path to the path rel below
+// the output directory. With -strip-ext inputs without the extension are
+// skipped or, with -copy-other, copied.
+func appendJob(jobs []job, path, rel, output string) []job {
+	if stripExt == "" {
+		return append(jobs, job{in: path, out: outputPath(output, rel)})
+	}
+	if strings.HasSuffix(rel, stripExt) && len(rel) > len(stripExt) {
+		return append(jobs, job{in: path, out: outputPath(output, strings.TrimSuffix(rel, stripExt))})
+	}
+	if copyOther {
+		return append(jobs, job{in: path, out: outputPath(output, rel), copy: true})
+	}
+	return jobs
+}
+
+// matchAny reports whether the relative path rel matches one of the glob
+// patterns. Patterns containing a slash match the whole path, others match
+// any of its elements.
+func matchAny(patterns []string, rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, p := range patterns {
+		if strings.Contains(p, "/") {
+			if ok, _ := path.Match(strings.TrimPrefix(p, "/"), rel); ok {
+				return true
+			}
+			continue
+		}
+		for _, elem := range strings.Split(rel, "/") {
+			if ok, _ := path.Match(p, elem); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// outputPath returns the path of rel below the output directory,
+// or an empty string when writing to stdout.
+func outputPath(dir, rel string) string {
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, rel)
+}
+
+// runJobs runs the jobs with a pool of workers and returns their results
+// in the original order. With -fail-fast no further jobs are started once
+// a job failed.
+func runJobs(jobs []job, workers int, run func(job) jobResult) []jobResult {
+	results := make([]jobResult, len(jobs))
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed bool
+		next   = make(chan int)
+	)
+	for w := 0; w < workers && w < len(jobs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				mu.Lock()
+				skip := failed && failFast
+				mu.Unlock()
+				if skip {
+					continue
+				}
+				start := time.Now()
+				j := jobs[i]
+				if traces != nil {
+					j.span = traces.file(j)
+				}
+				results[i] = run(j)
+				if j.span != nil {
+					traces.end(j.span, results[i].diags)
+				}
+				logger.Debug("processed", "file", jobs[i].name(), "diagnostics", len(results[i].diags), "duration", time.Since(start))
+				if len(results[i].diags) > 0 {
+					mu.Lock()
+					failed = true
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for i := range jobs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return results
+}
+
+// jobResult holds the data a job writes to stdout,
+// or the diagnostics of a failed job.
+type jobResult struct {
+	data  string
+	diags []diagnostic
+}
+
+// writeResults collects the diagnostics of results. If there are none,
+// the data of the results is written to stdout in order.
+func writeResults(results []jobResult) []diagnostic {
+	var diags []diagnostic
+	for _, res := range results {
+		diags = append(diags, res.diags...)
+	}
+	if len(diags) > 0 {
+		return diags
+	}
+	for _, res := range results {
+		if _, err := io.WriteString(os.Stdout, res.data); err != nil {
+			return []diagnostic{ioDiagnostic("", "Error writing output to: STDOUT.")}
+		}
+	}
+	return nil
+}
+
+// name returns the name of the input in diagnostics.
+func (j job) name() string {
+	if j.in == "" {
+		return "-"
+	}
+	return j.in
+}
+
+// parser returns the parser of the input, traced within its span and
+// describing the output for the -deny policy.
+func (j job) parser() *parse.Parser {
+	p := newParser(j.name())
+	if j.span != nil {
+		p.Tracer = j.span
+	}
+	p.Destination, p.Syntax = j.out, j.syntax()
+	if v, ok := syntax.Provenance(p.Syntax); provenance && ok {
+		p.Provenance = v
+	}
+	if lock != nil || locked != nil {
+		p.Lock = parse.NewLock()
+	}
+	return p
+}
+
+// read returns the content of the input.
+func (j job) read() (string, []diagnostic) {
+	var r io.Reader = os.Stdin
+	if j.in != "" {
+		f, err := os.Open(j.in)
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", failed(j.name(), fmt.Sprintf("Error to open file input: %s.", j.in)).diags
+		}
+		if err != nil {
+			return "", failed(j.name(), "Failed to read input.").diags
+		}
+		defer f.Close()
+		r = f
+	}
+	if maxInput > 0 {
+		// Reading stops past the limit rather than reading a huge input.
+		r = io.LimitReader(r, int64(maxInput)+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", failed(j.name(), "Failed to read input.").diags
+	}
+	if maxInput > 0 && len(data) > maxInput {
+		return "", []diagnostic{inputTooLarge(j.name(), 0)}
+	}
+	return string(data), nil
+}
+
+// substitute renders data, the content of the input.
+func (j job) substitute(data string) (string, []diagnostic) {
+	p := j.parser()
+	result, subs, err := j.renderer()(p, data)
+	if err != nil {
+		return "", withSource(diagnostics(j.name(), err), data)
+	}
+	if diags := verifyLock(j.name(), p.Lock); diags != nil {
+		return "", diags
+	}
+	if requireSubst && subs == 0 {
+		return "", []diagnostic{noSubstitution(j.name())}
+	}
+	if failEmpty && strings.TrimSpace(result) == "" {
+		return "", []diagnostic{emptyOutput(j.name())}
+	}
+	return result, nil
+}
+
+// inputTooLarge returns the diagnostic of -max-input for file at line, if
+// known.
+func inputTooLarge(file string, line int) diagnostic {
+	return diagnostic{File: file, Line: line, Kind: string(parse.KindLimit), Severity: severityError,
+		Message: fmt.Sprintf("input size exceeds limit of %d bytes", maxInput)}
+}
+
+// noSubstitution returns the diagnostic of -require-substitution for file.
+func noSubstitution(file string) diagnostic {
+	return diagnostic{File: file, Kind: kindNoSubstitution, Severity: severityError, Message: "no variables substituted"}
+}
+
+// emptyOutput returns the diagnostic of -fail-on-empty-output for file.
+func emptyOutput(file string) diagnostic {
+	return diagnostic{File: file, Kind: kindEmptyOutput, Severity: severityError, Message: "rendered output is empty"}
+}
+
+// endOfLines converts the line endings of s as -eol sets.
+func endOfLines(s string) string {
+	switch eol {
+	case "lf":
+		return strings.ReplaceAll(s, "\r\n", "\n")
+	case "crlf":
+		return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+	}
+	return s
+}
+
+// syntax returns the name of the syntax of the input, see -mode.
+func (j job) syntax() string {
+	if mode == "auto" {
+		return syntax.ForFile(j.in)
+	}
+	return mode
+}
+
+// renderer returns the renderer of the syntax of the input.
+func (j job) renderer() syntax.Renderer {
+	name := j.syntax()
+	if name == "hcl" && hclAllow != "" {
+		return syntax.HCLAllowing(strings.Split(hclAllow, ","))
+	}
+	render, _ := syntax.Lookup(name)
+	return render
+}
+
+// render renders the input to the output.
+func (j job) render() jobResult {
+	// Outputs are only written once verified against the -verify-lock.
+	if j.in == "" && j.out == "" && j.syntax() == "text" && locked == nil {
+		return stream(j.parser(), os.Stdin, os.Stdout)
+	}
+	data, diags := j.read()
+	if diags != nil {
+		return jobResult{diags: diags}
+	}
+	result := data
+	if !j.copy {
+		if result, diags = j.substitute(data); diags != nil {
+			return jobResult{diags: diags}
+		}
+		result = endOfLines(result)
+	}
+	if j.out == "" {
+		return jobResult{data: result}
+	}
+	if ifChanged && unchanged(j.out, result) {
+		if fileMode != 0 {
+			if err := os.Chmod(j.out, os.FileMode(fileMode)); err != nil {
+				return failed(j.out, fmt.Sprintf("Error writing output to: %s.", j.out))
+			}
+		}
+		logger.Debug("unchanged", "file", j.out)
+		return jobResult{}
+	}
+	if j.out == j.in {
+		if err := replaceFile(j.out, []byte(result)); err != nil {
+			return failed(j.out, fmt.Sprintf("Error writing output to: %s.", j.out))
+		}
+		logger.Info("wrote", "file", j.out)
+		return jobResult{}
+	}
+	if err := mkdirAll(filepath.Dir(j.out)); err != nil {
+		return failed(j.out, "Error to create the wanted output file.")
+	}
+	if err := writeFile(j.out, []byte(result)); err != nil {
+		return failed(j.out, fmt.Sprintf("Error writing output to: %s.", j.out))
+	}
+	logger.Info("wrote", "file", j.out)
+	return jobResult{}
+}
+
+// unchanged reports whether the file at path exists with the content data.
+func unchanged(path, data string) bool {
+	stat, err := os.Stat(path)
+	if err != nil || !stat.Mode().IsRegular() || stat.Size() != int64(len(data)) {
+		return false
+	}
+	b, err := os.ReadFile(path)
+	return err == nil && string(b) == data
+}
+
+// mkdirAll creates the directory dir and its missing parents. Created
+// directories get the -dir-mode permissions if set.
+func mkdirAll(dir string) error {
+	if dirMode == 0 {
+		return os.MkdirAll(dir, 0o755)
+	}
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || d == filepath.Dir(d) {
+			break
+		}
+		missing = append(missing, d)
+	}
+	// Create the parents first, each with -dir-mode, which the umask may
+	// only narrow, then set it exactly.
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], os.FileMode(dirMode)); err != nil && !os.IsExist(err) {
+			return err
+		}
+		if err := os.Chmod(missing[i], os.FileMode(dirMode)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile writes data to the file at path, with the -chmod permissions
+// if set, regardless of the umask or the permissions of an existing file.
+func writeFile(path string, data []byte) error {
+	if fileMode == 0 {
+		return os.WriteFile(path, data, 0o666)
+	}
+	return renameInto(path, data, os.FileMode(fileMode))
+}
+
+// replaceFile atomically replaces the content of the existing file at path,
+// keeping its permissions unless -chmod is set, so an interrupted write never
+// leaves it truncated.
+func replaceFile(path string, data []byte) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	perm := stat.Mode().Perm()
+	if fileMode != 0 {
+		perm = os.FileMode(fileMode)
+	}
+	return renameInto(path, data, perm)
+}
+
+// renameInto writes data to a temporary file next to path, with the
+// permissions perm set before any data is written, then renames it to path.
+// The data is never in a file with other permissions, nor in a partly
+// written file at path.
+func renameInto(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readFileList reads the paths listed in the file at path, or stdin for -.
+// Paths are separated by newlines or, if nul is set, by NUL characters.
+func readFileList(path string, nul bool) ([]string, error) {
+	var (
+		b   []byte
+		err error
+	)
+	if path == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sep := "\n"
+	if nul {
+		sep = "\x00"
+	}
+	var paths []string
+	for _, p := range strings.Split(string(b), sep) {
+		if !nul {
+			p = strings.TrimSuffix(p, "\r")
+		}
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
+
+// stream renders r to w as it is read, see parse.Parser.Stream, so that
+// memory use is bounded by the longest line rather than the whole input.
+// Nothing is written past the first failure. With -require-substitution and
+// -fail-on-empty-output the output is held until the end, as they fail on
+// the whole of it, so that nothing is written on failure.
+func stream(parser *parse.Parser, r io.Reader, w io.Writer) jobResult {
+	name := parser.Name
+	parser.Limits.MaxInput = maxInput
+	out := &streamWriter{w: bufio.NewWriter(w), blank: true}
+	var held bytes.Buffer
+	if requireSubst || failEmpty {
+		out.w = bufio.NewWriter(&held)
+	}
+	in := &streamReader{r: r, out: out.w, keep: explain}
+	err := parser.Stream(out, in)
+	if ferr := out.flush(); err == nil {
+		err = ferr
+	}
+	var diags []diagnostic
+	var perr *parse.Error
+	var plist parse.ErrorList
+	switch {
+	case err == nil:
+	case errors.As(err, &plist), errors.As(err, &perr):
+		diags = withSource(diagnostics(name, err), in.text.String())
+	case out.err != nil:
+		return failed("", "Error writing output to: STDOUT.")
+	default:
+		return failed(name, "Failed to read input.")
+	}
+	diags = append(diags, verifyLock(name, parser.Lock)...)
+	if requireSubst && parser.Substitutions() == 0 && diags == nil {
+		diags = append(diags, noSubstitution(name))
+	}
+	if failEmpty && out.blank && diags == nil {
+		diags = append(diags, emptyOutput(name))
+	}
+	if diags == nil && held.Len() > 0 {
+		if _, err := w.Write(held.Bytes()); err != nil {
+			return failed("", "Error writing output to: STDOUT.")
+		}
+	}
+	return jobResult{diags: diags}
+}
+
+// streamReader reads the input of a stream, handing over the output
+// rendered so far whenever more input is needed, as it may take a while.
+// It keeps the text read if asked, for the sources of the diagnostics.
+type streamReader struct {
+	r    io.Reader
+	out  *bufio.Writer
+	keep bool
+	text strings.Builder
+}
+
+func (r *streamReader) Read(b []byte) (int, error) {
+	if err := r.out.Flush(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(b)
+	if r.keep {
+		r.text.Write(b[:n])
+	}
+	return n, err
+}
+
+// streamWriter writes the output of a stream with the line endings of
+// -eol, recording whether it is blank and the failure to write.
+type streamWriter struct {
+	w     *bufio.Writer
+	blank bool
+	cr    bool // the last byte given is a \r, not written yet with -eol lf
+	err   error
+}
+
+func (w *streamWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	w.blank = w.blank && len(bytes.TrimSpace(b)) == 0
+	if eol != "preserve" {
+		b = w.endOfLines(b)
+	}
+	if _, err := w.w.Write(b); err != nil {
+		w.err = err
+		return 0, err
+	}
+	return n, nil
+}
+
+// endOfLines converts the line endings of b as -eol sets, like endOfLines
+// does for whole outputs, a \r ending b being held until the next byte is
+// known.
+func (w *streamWriter) endOfLines(b []byte) []byte {
+	out := make([]byte, 0, len(b)+len(b)/8+1)
+	for _, c := range b {
+		switch {
+		case eol == "lf" && w.cr && c != '\n':
+			out = append(out, '\r')
+		case eol == "crlf" && c == '\n' && !w.cr:
+			out = append(out, '\r')
+		}
+		if c != '\r' || eol != "lf" {
+			out = append(out, c)
+		}
+		w.cr = c == '\r'
+	}
+	return out
+}
+
+// flush writes the output buffered.
+func (w *streamWriter) flush() error {
+	if w.cr && eol == "lf" {
+		w.w.WriteByte('\r')
+	}
+	if err := w.w.Flush(); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+func failed(file, msg string) jobResult {
+	return jobResult{diags: []diagnostic{ioDiagnostic(file, msg)}}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunJobs(t *testing.T) {
+	jobs := make([]job, 20)
+	for i := range jobs {
+		jobs[i].in = strconv.Itoa(i)
+	}
+	var running, most int32
+	results := runJobs(jobs, 3, func(j job) jobResult {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&most)
+			if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return jobResult{data: j.in}
+	})
+	for i, res := range results {
+		if res.data != strconv.Itoa(i) {
+			t.Errorf("got result %q at %d", res.data, i)
+		}
+	}
+	if most > 3 {
+		t.Errorf("got %d jobs running at once, expected at most 3", most)
+	}
+}
+
+var jobsTests = []cliTest{
+	{name: "jobs", args: []string{"render", "-jobs", "4", "a.tmpl", "b.tmpl", "c.tmpl", "d.tmpl", "e.tmpl"},
+		env: []string{"A=1"}, files: map[string]string{
+			"a.tmpl": "a=$A\n", "b.tmpl": "b=$A\n", "c.tmpl": "c=$A\n", "d.tmpl": "d=$A\n", "e.tmpl": "e=$A\n"},
+		stdout: "a=1\nb=1\nc=1\nd=1\ne=1\n"},
+	{name: "jobs output", args: []string{"-jobs", "2", "-o", "out", "in"}, env: []string{"A=1"},
+		files:  map[string]string{"in/a": "a=$A", "in/b/b": "b=$A", "in/b/c": "c=$A"},
+		output: map[string]string{"out/a": "a=1", "out/b/b": "b=1", "out/b/c": "c=1"}},
+	{name: "jobs failure", args: []string{"render", "-jobs", "2", "-no-unset", "a.tmpl", "b.tmpl"}, env: []string{"A=1"},
+		files: map[string]string{"a.tmpl": "a=$A\n", "b.tmpl": "b=$B\n"}, code: 1, stderr: "variable ${B} not set"},
+	{name: "no jobs", args: []string{"-jobs", "0"}, code: 1, stderr: "The number of jobs must be at least 1."},
+}
+
+func TestJobs(t *testing.T) {
+	for _, test := range jobsTests {
+		runMain(t, test)
+	}
+}
+
+var limitTests = []cliTest{
+	{name: "max size", args: []string{"-max-size", "3", "a.tmpl"}, env: []string{"A=long"},
+		files: map[string]string{"a.tmpl": "$A"}, code: 1, stderr: "output size exceeds limit of 3 bytes"},
+	{name: "max input", args: []string{"-max-input", "3", "a.tmpl"}, files: map[string]string{"a.tmpl": "$A$A"},
+		code: 1, stderr: "exceeds limit of 3 bytes"},
+	{name: "max input stdin", args: []string{"-max-input", "3"}, stdin: "abcd", code: 1, stderr: "input size exceeds limit of 3 bytes"},
+	{name: "max input within", args: []string{"-max-input", "3"}, stdin: "abc", stdout: "abc"},
+	{name: "limit kind", args: []string{"-max-size", "3", "-format", "json"}, env: []string{"A=long"}, stdin: "$A",
+		code: 1, stderr: `"kind":"limit"`},
+	{name: "max depth", args: []string{"-include-root", ".", "-max-depth", "1", "a.tmpl"}, files: map[string]string{
+		"a.tmpl": "${include:b.conf}", "b.conf": "${include:c.conf}", "c.conf": "c"},
+		code: 1, stderr: "include c.conf: depth 2 exceeds limit of 1"},
+	{name: "max depth within", args: []string{"-include-root", ".", "-max-depth", "2", "a.tmpl"}, files: map[string]string{
+		"a.tmpl": "${include:b.conf}", "b.conf": "${include:c.conf}", "c.conf": "c"}, stdout: "c"},
+	{name: "negative limit", args: []string{"-max-depth", "-1"}, code: 1, stderr: "Limits must not be negative."},
+}
+
+func TestLimits(t *testing.T) {
+	for _, test := range limitTests {
+		runMain(t, test)
+	}
+}
+
+var profileTests = []cliTest{
+	{name: "relaxed profile", args: []string{"-profile", "relaxed"}, env: []string{"E="}, stdin: "[$X][$E]", stdout: "[][]"},
+	{name: "no-unset profile", args: []string{"-profile", "no-unset"}, env: []string{"E="}, stdin: "[$E]$X",
+		code: 1, stderr: "variable ${X} not set"},
+	{name: "no-empty profile", args: []string{"-profile", "no-empty"}, env: []string{"E="}, stdin: "[$X]$E",
+		code: 1, stderr: "variable ${E} set but empty"},
+	{name: "strict profile", args: []string{"-profile", "strict"}, env: []string{"E="}, stdin: "$E",
+		code: 1, stderr: "variable ${E} set but empty"},
+	{name: "compose profile", args: []string{"-profile", "compose"}, env: []string{"A=a"}, stdin: "$A $1 [$X]",
+		stdout: "a $1 []"},
+	{name: "posix profile", args: []string{"-profile", "posix"}, env: []string{"A=a"}, stdin: "$A $1 $X",
+		code: 1, stderr: "variable ${X} not set"},
+	{name: "profile and flag", args: []string{"-profile", "relaxed", "-no-unset"}, stdin: "$X",
+		code: 1, stderr: "variable ${X} not set"},
+	{name: "unknown profile", args: []string{"-profile", "lax"}, code: 1, stderr: "Unknown profile: lax."},
+}
+
+func TestProfiles(t *testing.T) {
+	for _, test := range profileTests {
+		runMain(t, test)
+	}
+}
+
+var extTests = []cliTest{
+	{name: "strip ext skips", args: []string{"-strip-ext", ".tmpl", "-o", "out", "in"}, env: []string{"A=1"},
+		files:  map[string]string{"in/a.conf.tmpl": "a=$A", "in/b.txt": "b=$A", "in/.tmpl": "$A"},
+		output: map[string]string{"out/a.conf": "a=1"}, absent: []string{"out/b.txt", "out/.tmpl"}},
+	{name: "copy other", args: []string{"-strip-ext", ".tmpl", "-copy-other", "-o", "out", "in"}, env: []string{"A=1"},
+		files:  map[string]string{"in/a.conf.tmpl": "a=$A", "in/sub/b.txt": "b=$A"},
+		output: map[string]string{"out/a.conf": "a=1", "out/sub/b.txt": "b=$A"}},
+	{name: "copy other unchecked", args: []string{"-no-unset", "-strip-ext", ".tmpl", "-copy-other", "-o", "out", "in"},
+		files:  map[string]string{"in/a.tmpl": "a", "in/b.sh": "echo ${X"},
+		output: map[string]string{"out/a": "a", "out/b.sh": "echo ${X"}},
+}
+
+func TestExtensions(t *testing.T) {
+	for _, test := range extTests {
+		runMain(t, test)
+	}
+}
+
+var outputTests = []cliTest{
+	{name: "output path", args: []string{"-o", "out/${ENVIRONMENT}/", "in"}, env: []string{"ENVIRONMENT=prod", "A=1"},
+		files: map[string]string{"in/a.conf": "a=$A"}, output: map[string]string{"out/prod/a.conf": "a=1"}},
+	{name: "output file path", args: []string{"-o", "$ENVIRONMENT.conf", "a.tmpl"}, env: []string{"ENVIRONMENT=prod", "A=1"},
+		files: map[string]string{"a.tmpl": "a=$A"}, output: map[string]string{"prod.conf": "a=1"}},
+	{name: "output path default", args: []string{"-o", "${ENVIRONMENT:-dev}.conf", "a.tmpl"},
+		files: map[string]string{"a.tmpl": "a"}, output: map[string]string{"dev.conf": "a"}},
+	{name: "output path unset", args: []string{"-no-unset", "-o", "out/${ENVIRONMENT}/a.conf", "a.tmpl"},
+		files: map[string]string{"a.tmpl": "a"}, code: 1, stderr: "variable ${ENVIRONMENT} not set", absent: []string{"out"}},
+	{name: "output path syntax", args: []string{"-o", "out/${ENVIRONMENT", "a.tmpl"},
+		files: map[string]string{"a.tmpl": "a"}, code: 1, stderr: "closing brace expected"},
+}
+
+func TestOutputPath(t *testing.T) {
+	for _, test := range outputTests {
+		runMain(t, test)
+	}
+}
+
+var requireTests = []cliTest{
+	{name: "require substitution", args: []string{"-require-substitution", "-o", "a.conf", "a.tmpl"}, env: []string{"A=1"},
+		files: map[string]string{"a.tmpl": "a=$A"}, output: map[string]string{"a.conf": "a=1"}},
+	{name: "require substitution none", args: []string{"-require-substitution", "-o", "a.conf", "a.tmpl"},
+		files: map[string]string{"a.tmpl": "a=$$A"}, code: 1, stderr: "no variables substituted", absent: []string{"a.conf"}},
+	{name: "require substitution each", args: []string{"render", "-require-substitution", "a.tmpl", "b.tmpl"},
+		env: []string{"A=1"}, files: map[string]string{"a.tmpl": "$A", "b.tmpl": "b"},
+		code: 1, stderr: "b.tmpl: no variables substituted"},
+	{name: "require substitution json", args: []string{"-require-substitution", "-format", "json"}, stdin: "a",
+		code: 1, stderr: `"kind":"no-substitution","code":"ENV008"`},
+}
+
+func TestRequireSubstitution(t *testing.T) {
+	for _, test := range requireTests {
+		runMain(t, test)
+	}
+}
+
+var filesFromTests = []cliTest{
+	{name: "files from", args: []string{"render", "-files-from", "list"}, env: []string{"A=1"}, files: map[string]string{
+		"list": "a.tmpl\r\n\nb.tmpl\n", "a.tmpl": "a=$A\n", "b.tmpl": "b=$A\n"}, stdout: "a=1\nb=1\n"},
+	{name: "files from stdin", args: []string{"render", "-files-from", "-", "-0"}, env: []string{"A=1"},
+		stdin: "a b.tmpl\x00c.tmpl\x00", files: map[string]string{"a b.tmpl": "a=$A\n", "c.tmpl": "c=$A\n"},
+		stdout: "a=1\nc=1\n"},
+	{name: "files from and args", args: []string{"render", "-files-from", "list", "a.tmpl"}, env: []string{"A=1"},
+		files: map[string]string{"list": "b.tmpl\n", "a.tmpl": "a=$A\n", "b.tmpl": "b=$A\n"}, stdout: "a=1\nb=1\n"},
+	{name: "files from missing", args: []string{"-files-from", "missing"}, code: 1,
+		stderr: "Error to read input files from: missing."},
+	{name: "in place", args: []string{"-in-place", "a.conf", "b.conf"}, env: []string{"A=1"},
+		files:  map[string]string{"a.conf": "a=$A", "b.conf": "b=$A"},
+		output: map[string]string{"a.conf": "a=1", "b.conf": "b=1"}},
+	{name: "in place files from", args: []string{"-in-place", "-files-from", "-"}, env: []string{"A=1"},
+		stdin: "a.conf\n", files: map[string]string{"a.conf": "a=$A"}, output: map[string]string{"a.conf": "a=1"}},
+	{name: "in place failure", args: []string{"-in-place", "-no-unset", "a.conf", "b.conf"}, env: []string{"A=1"},
+		files: map[string]string{"a.conf": "a=$A", "b.conf": "b=$B"}, code: 1, stderr: "variable ${B} not set",
+		output: map[string]string{"b.conf": "b=$B"}},
+	{name: "in place output", args: []string{"-in-place", "-o", "out", "a.conf"}, files: map[string]string{"a.conf": "a"},
+		code: 1, stderr: "Rendering in place needs input files and no output."},
+}
+
+func TestFilesFrom(t *testing.T) {
+	for _, test := range filesFromTests {
+		runMain(t, test)
+	}
+}
+
+var modeTests = []cliTest{
+	{name: "chmod", args: []string{"-chmod", "0600", "-o", "a.conf", "a.tmpl"}, env: []string{"A=1"},
+		files: map[string]string{"a.tmpl": "a=$A"}, output: map[string]string{"a.conf": "a=1"},
+		modes: map[string]os.FileMode{"a.conf": 0o600}},
+	{name: "chmod existing", args: []string{"-chmod", "0640", "-o", "a.conf", "a.tmpl"}, env: []string{"A=1"},
+		files: map[string]string{"a.tmpl": "a=$A", "a.conf": "old"}, output: map[string]string{"a.conf": "a=1"},
+		modes: map[string]os.FileMode{"a.conf": 0o640}},
+	{name: "chmod wider than umask", args: []string{"-chmod", "0666", "-o", "a.conf", "a.tmpl"},
+		files: map[string]string{"a.tmpl": "a"}, modes: map[string]os.FileMode{"a.conf": 0o666}},
+	{name: "chmod in place", args: []string{"-chmod", "0600", "-in-place", "a.conf"}, env: []string{"A=1"},
+		files: map[string]string{"a.conf": "a=$A"}, output: map[string]string{"a.conf": "a=1"},
+		modes: map[string]os.FileMode{"a.conf": 0o600}},
+	{name: "in place keeps mode", args: []string{"-in-place", "bin/a.sh"}, env: []string{"A=1"},
+		files: map[string]string{"bin/a.sh": "echo $A"}, output: map[string]string{"bin/a.sh": "echo 1"},
+		modes: map[string]os.FileMode{"bin/a.sh": 0o755}},
+	{name: "dir mode", args: []string{"-dir-mode", "0700", "-o", "out/sub", "in"}, env: []string{"A=1"},
+		files: map[string]string{"in/x/a.conf": "a=$A"}, output: map[string]string{"out/sub/x/a.conf": "a=1"},
+		modes: map[string]os.FileMode{"out": 0o700, "out/sub": 0o700, "out/sub/x": 0o700}},
+	{name: "dir mode existing", args: []string{"-dir-mode", "0700", "-o", "in/out", "in/a.conf"},
+		files: map[string]string{"in/a.conf": "a"}, output: map[string]string{"in/out": "a"}, modes: map[string]os.FileMode{"in": 0o755}},
+	{name: "if changed chmod", args: []string{"-if-changed", "-chmod", "0600", "-o", "a.conf", "a.tmpl"}, env: []string{"A=1"},
+		files: map[string]string{"a.tmpl": "a=$A", "a.conf": "a=1"}, output: map[string]string{"a.conf": "a=1"},
+		modes: map[string]os.FileMode{"a.conf": 0o600}},
+	{name: "invalid mode", args: []string{"-chmod", "rw"}, code: 2, stderr: "invalid value"},
+}
+
+func TestModes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are not those of Unix")
+	}
+	for _, test := range modeTests {
+		runMain(t, test)
+	}
+}
+
+func TestIfChanged(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "a.tmpl"), filepath.Join(dir, "a.conf")
+	for path, data := range map[string]string{in: "a=$A", out: "a=1"} {
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(out, old, old); err != nil {
+		t.Fatal(err)
+	}
+	profile, mode, eol, ifChanged = "relaxed", "text", "preserve", true
+	defer func() { profile, mode, eol, ifChanged, env = "", "", "", false, nil }()
+	for _, value := range []string{"1", "2"} {
+		env = []string{"A=" + value}
+		if res := (job{in: in, out: out}).render(); res.diags != nil {
+			t.Fatal(res.diags)
+		}
+		stat, err := os.Stat(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := os.ReadFile(out)
+		if written := !stat.ModTime().Equal(old); string(b) != "a="+value || written != (value == "2") {
+			t.Errorf("A=%s: got %q, written %v", value, b, written)
+		}
+	}
+	if unchanged(filepath.Join(dir, "missing"), "") || unchanged(dir, "") {
+		t.Error("expected missing files and directories to be changed")
+	}
+}
+
+var ifChangedTests = []cliTest{
+	{name: "if changed", args: []string{"-if-changed", "-o", "a.conf", "a.tmpl"}, env: []string{"A=1"},
+		files: map[string]string{"a.tmpl": "a=$A", "a.conf": "a=0"}, output: map[string]string{"a.conf": "a=1"}},
+	{name: "if changed new", args: []string{"-if-changed", "-o", "out", "in"}, env: []string{"A=1"},
+		files: map[string]string{"in/a.conf": "a=$A"}, output: map[string]string{"out/a.conf": "a=1"}},
+}
+
+func TestIfChangedOutput(t *testing.T) {
+	for _, test := range ifChangedTests {
+		runMain(t, test)
+	}
+}
+
+var emptyOutputTests = []cliTest{
+	{name: "empty output", args: []string{"-fail-on-empty-output", "-o", "a.conf", "a.tmpl"},
+		files: map[string]string{"a.tmpl": "$E\n  \n"}, code: 1, stderr: "rendered output is empty", absent: []string{"a.conf"}},
+	{name: "empty output stdin", args: []string{"-fail-on-empty-output"}, stdin: "$E\n", code: 1,
+		stderr: "rendered output is empty"},
+	{name: "not empty output", args: []string{"-fail-on-empty-output"}, env: []string{"A=1"}, stdin: "\n$A\n",
+		stdout: "\n1\n"},
+	{name: "empty output json", args: []string{"-fail-on-empty-output", "-format", "json", "a.tmpl"},
+		files: map[string]string{"a.tmpl": ""}, code: 1, stderr: `"kind":"empty-output","code":"ENV009"`},
+}
+
+func TestEmptyOutput(t *testing.T) {
+	for _, test := range emptyOutputTests {
+		runMain(t, test)
+	}
+}
+
+var emptyDefaultTests = []cliTest{
+	{name: "empty default", env: []string{"A="}, stdin: "${A:-d} ${B:-e}", stdout: "d e",
+		stderr: `msg="variable ${A} is set but empty, its default is substituted" template=- variable=A line=1 col=1`},
+	{name: "fail on empty default", args: []string{"-fail-on-empty-default"}, env: []string{"A="}, stdin: "${A:-d}",
+		code: 1, stderr: "variable ${A} is set but empty, its default is substituted"},
+	{name: "fail on empty default unset", args: []string{"-fail-on-empty-default"}, stdin: "${A:-d}", stdout: "d"},
+	{name: "fail on empty default dash", args: []string{"-fail-on-empty-default"}, env: []string{"A="}, stdin: "[${A-d}]", stdout: "[]"},
+}
+
+func TestEmptyDefault(t *testing.T) {
+	for _, test := range emptyDefaultTests {
+		runMain(t, test)
+	}
+}
+
+func TestStreamEndOfLines(t *testing.T) {
+	defer func() { eol = "" }()
+	// The writes split a \r\n.
+	writes := []string{"a\r", "\nb\n", "c\r", "d\r"}
+	for mode, expected := range map[string]string{
+		"preserve": "a\r\nb\nc\rd\r",
+		"lf":       "a\nb\nc\rd\r",
+		"crlf":     "a\r\nb\r\nc\rd\r",
+	} {
+		eol = mode
+		var b bytes.Buffer
+		w := &streamWriter{w: bufio.NewWriter(&b)}
+		for _, s := range writes {
+			if n, err := w.Write([]byte(s)); n != len(s) || err != nil {
+				t.Fatalf("%s: got %d, %v writing %q", mode, n, err, s)
+			}
+		}
+		if err := w.flush(); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != expected {
+			t.Errorf("%s: got %q, expected %q", mode, got, expected)
+		}
+		if got := endOfLines(strings.Join(writes, "")); got != expected {
+			t.Errorf("%s: got %q converting the whole output, expected %q", mode, got, expected)
+		}
+	}
+}
+
+var eolTests = []cliTest{
+	{name: "eol lf", args: []string{"-eol", "lf", "-o", "a.conf", "a.tmpl"}, env: []string{"A=1"},
+		files: map[string]string{"a.tmpl": "a=$A\r\nb\n"}, output: map[string]string{"a.conf": "a=1\nb\n"}},
+	{name: "eol crlf", args: []string{"-eol", "crlf", "-o", "a.conf", "a.tmpl"}, env: []string{"A=1"},
+		files: map[string]string{"a.tmpl": "a=$A\r\nb\n"}, output: map[string]string{"a.conf": "a=1\r\nb\r\n"}},
+	{name: "eol of values", args: []string{"-eol", "crlf"}, env: []string{"A=1\n2"}, stdin: "a=$A\n",
+		stdout: "a=1\r\n2\r\n"},
+	{name: "eol stream lf", args: []string{"-eol", "lf"}, env: []string{"A=1"}, stdin: "a=$A\r\n\r\n",
+		stdout: "a=1\n\n"},
+	{name: "eol preserve", env: []string{"A=1"}, stdin: "a=$A\r\nb\n", stdout: "a=1\r\nb\n"},
+	{name: "eol copy other", args: []string{"-eol", "lf", "-strip-ext", ".tmpl", "-copy-other", "-o", "out", "in"},
+		files: map[string]string{"in/a.bin": "\r\n"}, output: map[string]string{"out/a.bin": "\r\n"}},
+	{name: "eol unknown", args: []string{"-eol", "cr"}, code: 1, stderr: "Unknown line ending: cr."},
+}
+
+func TestEndOfLines(t *testing.T) {
+	for _, test := range eolTests {
+		runMain(t, test)
+	}
+}
+
+var libraryTests = []cliTest{
+	{name: "library", args: []string{"-library"}, env: []string{"A=x", "V=1.3.0", "N=7", "L=a,b"},
+		stdin:  `${A|sha256} ${V|semver >=1.2.0} ${N|padleft 4 0} ${L|join ";"}`,
+		stdout: "2d711642b726b04401627ca9fbac32f5c8530fb1903cc4db02258717921a4881 true 0007 a;b"},
+	{name: "library disabled", env: []string{"A=x"}, stdin: "${A|sha256}", code: 1, stderr: `unknown filter "sha256"`},
+}
+
+func TestLibrary(t *testing.T) {
+	for _, test := range libraryTests {
+		runMain(t, test)
+	}
+}
+
+var provenanceTests = []cliTest{
+	{name: "provenance", args: []string{"-provenance"}, env: []string{"A=1"}, stdin: "a $A\nb\n", stdout: "a 1 # envsubst: A\nb\n"},
+	{name: "provenance yaml", args: []string{"-provenance", "-mode", "yaml"}, env: []string{"A=1"},
+		stdin: "a: $A\nb: x\nc: ${B:-d} $A\n", stdout: "a: \"1\" # envsubst: A\nb: x\nc: d 1 # envsubst: B, A\n"},
+	{name: "provenance ini", args: []string{"-provenance", "-mode", "ini"}, env: []string{"A=1"}, stdin: "a=$A\n",
+		stdout: "; envsubst: A\na=1\n"},
+	{name: "provenance auto", args: []string{"render", "-provenance", "-mode", "auto", "a.json", "a.yaml"}, env: []string{"A=1"},
+		files: map[string]string{"a.json": `{"a": "$A"}` + "\n", "a.yaml": "a: $A\n"}, stdout: "{\"a\": \"1\"}\na: \"1\" # envsubst: A\n"},
+	{name: "provenance json", args: []string{"-provenance", "-mode", "json"}, stdin: `{"a": "$A"}`,
+		code: 1, stderr: "Mode json has no comments for -provenance."},
+}
+
+func TestProvenance(t *testing.T) {
+	for _, test := range provenanceTests {
+		runMain(t, test)
+	}
+}
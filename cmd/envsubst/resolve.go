@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+	"github.com/hellt/envsubst/resolver"
+)
+
+func init() {
+	parse.RegisterResolver("vault", parse.ResolverFunc(resolveVault))
+	parse.RegisterResolver("keyring", resolver.Keyring())
+}
+
+// resolveVault returns the key of a Vault secret referenced as PATH#KEY,
+// see vaultVars.
+func resolveVault(ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("expected vault:PATH#KEY")
+	}
+	vars, err := vaultVars(path)
+	if err != nil {
+		return "", err
+	}
+	value, ok := parse.Env(vars).Lookup(key)
+	if !ok {
+		return "", fmt.Errorf("no key %s in %s", key, path)
+	}
+	return value, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+var resolveTests = []cliTest{
+	{name: "resolve keyring reference", args: []string{"-resolve"}, env: []string{"DB=keyring:myapp"}, stdin: "$DB",
+		code: 1, stderr: `resolve keyring:myapp: expected SERVICE#ACCOUNT, got "myapp"`},
+	{name: "resolve file", args: []string{"-resolve", "-resolve-file-root", "secrets"}, env: []string{"DB=file:secrets/db"},
+		stdin: "$DB ${file:secrets/db}", files: map[string]string{"secrets/db": "s3cret\n"}, stdout: "s3cret s3cret"},
+	{name: "resolve file outside root", args: []string{"-resolve", "-resolve-file-root", "secrets"}, env: []string{"DB=file:secrets/../a.env"},
+		stdin: "$DB", files: map[string]string{"secrets/db": "s3cret\n", "a.env": "A=1\n"}, code: 1, stderr: "secrets/../a.env is outside of secrets"},
+	{name: "resolve file no root", args: []string{"-resolve", "-no-unset"}, env: []string{"DB=file:secrets/db"},
+		stdin: "$DB ${file:secrets/db}", files: map[string]string{"secrets/db": "s3cret\n"}, code: 1, stderr: "variable ${file} not set"},
+	{name: "resolve max size", args: []string{"-resolve", "-resolve-file-root", "secrets", "-resolve-max-size", "3"}, env: []string{"DB=file:secrets/db"},
+		stdin: "$DB", files: map[string]string{"secrets/db": "s3cret\n"}, code: 1, stderr: "resolve file:secrets/db: value of 6 bytes exceeds limit of 3 bytes"},
+	{name: "resolve max size within", args: []string{"-resolve", "-resolve-file-root", "secrets", "-resolve-max-size", "6"}, env: []string{"DB=file:secrets/db"},
+		stdin: "$DB", files: map[string]string{"secrets/db": "s3cret\n"}, stdout: "s3cret"},
+	{name: "resolve negative max size", args: []string{"-resolve-max-size", "-1"}, code: 1, stderr: "Limits must not be negative."},
+}
+
+func TestResolve(t *testing.T) {
+	for _, test := range resolveTests {
+		runMain(t, test)
+	}
+}
+
+// fakePass is a stand-in for pass printing the password db/password, the
+// variables it is given, or hanging.
+const fakePass = `#!/bin/sh
+case "$2" in
+db/password) echo s3cret ;;
+env) echo "$GNUPGHOME[$SECRET]" ;;
+slow) sleep 5 ;;
+*) echo "Error: $2 is not in the password store." >&2; exit 1 ;;
+esac
+`
+
+var resolveCommandTests = []cliTest{
+	{name: "resolve command", args: []string{"-resolve", "-resolve-command", "pass=pass show"}, env: []string{"DB=pass:db/password"},
+		stdin: "$DB", files: map[string]string{"bin/pass": fakePass}, stdout: "s3cret"},
+	{name: "resolve command placeholder", args: []string{"-resolve", "-resolve-command", "pw=pass show {}"}, env: []string{"DB=pw:db/password"},
+		stdin: "$DB", files: map[string]string{"bin/pass": fakePass}, stdout: "s3cret"},
+	{name: "resolve command env", args: []string{"-resolve", "-resolve-command", "pass=pass show", "-resolve-command-env", "GNUPGHOME"},
+		env: []string{"DB=pass:env", "GNUPGHOME=/gpg", "SECRET=s3cret"}, stdin: "$DB", files: map[string]string{"bin/pass": fakePass}, stdout: "/gpg[]"},
+	{name: "resolve command failure", args: []string{"-resolve", "-resolve-command", "pass=pass show"}, env: []string{"DB=pass:db/nope"},
+		stdin: "$DB", files: map[string]string{"bin/pass": fakePass}, code: 1, stderr: "pass: exit status 1: Error: db/nope is not in the password store."},
+	{name: "resolve command timeout", args: []string{"-resolve", "-resolve-command", "pass=pass show", "-resolve-timeout", "100ms"}, env: []string{"DB=pass:slow"},
+		stdin: "$DB", files: map[string]string{"bin/pass": fakePass}, code: 1, stderr: "resolve pass:slow: timed out after 100ms"},
+	{name: "resolve command disabled", args: []string{"-resolve-command", "pass=pass show"}, env: []string{"DB=pass:db/password"},
+		stdin: "$DB", files: map[string]string{"bin/pass": fakePass}, stdout: "pass:db/password"},
+	{name: "resolve command invalid", args: []string{"-resolve", "-resolve-command", "pass"}, stdin: "$DB",
+		code: 1, stderr: "Invalid resolver command: pass, expected SCHEME=COMMAND."},
+}
+
+func TestResolveCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake commands need a shell")
+	}
+	for _, test := range resolveCommandTests {
+		runMain(t, test)
+	}
+}
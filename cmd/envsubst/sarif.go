@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// reportList holds the reports requested with -report as format=path pairs.
+type reportList []report
+
+type report struct {
+	format string
+	path   string
+}
+
+func (l *reportList) String() string {
+	var s []string
+	for _, r := range *l {
+		s = append(s, r.format+"="+r.path)
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *reportList) Set(value string) error {
+	format, path, ok := strings.Cut(value, "=")
+	if !ok || path == "" {
+		return fmt.Errorf("expected format=path, got %q", value)
+	}
+	switch format {
+	case "sarif":
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+	*l = append(*l, report{format, path})
+	return nil
+}
+
+// writeReports writes diags to every requested report file.
+func writeReports(diags []diagnostic) error {
+	for _, r := range reports {
+		var err error
+		switch r.format {
+		case "sarif":
+			err = writeSARIF(r.path, diags)
+		}
+		if err != nil {
+			return fmt.Errorf("Error writing %s report to: %s: %v", r.format, r.path, err)
+		}
+	}
+	return nil
+}
+
+// SARIF 2.1.0 document, reduced to the properties envsubst fills in.
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+}
+
+// sarifRules are the rules of the diagnostics, identified by their codes.
+var sarifRules = []sarifRule{
+	{code("unset"), "unset", sarifMessage{"Variable is not set"}},
+	{code("empty"), "empty", sarifMessage{"Variable is set but empty"}},
+	{code("syntax"), "syntax", sarifMessage{"Malformed substitution"}},
+	{code("limit"), "limit", sarifMessage{"Resource limit exceeded"}},
+	{code("resolve"), "resolve", sarifMessage{"Variable value could not be resolved"}},
+	{code("schema"), "schema", sarifMessage{"Variable value violates the schema"}},
+	{code("deprecated"), "deprecated", sarifMessage{"Variable is deprecated"}},
+	{code("policy"), "policy", sarifMessage{"Substitution denied by policy"}},
+	{code("no-substitution"), "no-substitution", sarifMessage{"No variables substituted"}},
+	{code("empty-output"), "empty-output", sarifMessage{"Rendered output is empty"}},
+	{code("lock"), "lock", sarifMessage{"Variable value differs from the lock"}},
+	{code("empty-default"), "empty-default", sarifMessage{"Default substituted for a variable set but empty"}},
+}
+
+// writeSARIF writes the template findings among diags as a SARIF log to path.
+func writeSARIF(path string, diags []diagnostic) error {
+	results := []sarifResult{}
+	for _, d := range diags {
+		if d.Kind == kindIO {
+			continue
+		}
+		res := sarifResult{RuleID: code(d.Kind), Level: d.Severity, Message: sarifMessage{d.Message}}
+		if d.File != "" && d.File != "-" {
+			loc := sarifLocation{sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{d.File}}}
+			if d.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{d.Line, d.Column}
+			}
+			res.Locations = []sarifLocation{loc}
+		}
+		results = append(results, res)
+	}
+	log := sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs: []sarifRun{{
+			Tool: sarifTool{sarifDriver{
+				Name:           "envsubst",
+				InformationURI: "https://github.com/hellt/envsubst",
+				Rules:          sarifRules,
+			}},
+			Results: results,
+		}},
+	}
+	b, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReportListSet(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		ok    bool
+	}{
+		{"sarif=out.sarif", true},
+		{"sarif=", false},
+		{"out.sarif", false},
+		{"xml=out.xml", false},
+	} {
+		var l reportList
+		if err := l.Set(test.value); (err == nil) != test.ok {
+			t.Errorf("%s: got error %v, expected ok %v", test.value, err, test.ok)
+		}
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.sarif")
+	diags := []diagnostic{
+		{File: "a.tmpl", Line: 2, Column: 3, Kind: "unset", Severity: severityError, Message: "variable ${A} not set"},
+		{File: "-", Kind: kindNoSubstitution, Severity: severityWarning, Message: "no variables substituted"},
+		ioDiagnostic("b.tmpl", "no such file"),
+	}
+	if err := writeSARIF(path, diags); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(b, &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("got version %q and %d runs", log.Version, len(log.Runs))
+	}
+	expected := []sarifResult{
+		{RuleID: "ENV001", Level: "error", Message: sarifMessage{"variable ${A} not set"}, Locations: []sarifLocation{{
+			sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{"a.tmpl"}, Region: &sarifRegion{2, 3}}}}},
+		{RuleID: "ENV008", Level: "warning", Message: sarifMessage{"no variables substituted"}},
+	}
+	if got := log.Runs[0].Results; !reflect.DeepEqual(got, expected) {
+		t.Errorf("got results %+v, expected %+v", got, expected)
+	}
+	rules := map[string]bool{}
+	for _, r := range log.Runs[0].Tool.Driver.Rules {
+		rules[r.ID] = true
+	}
+	for _, r := range expected {
+		if !rules[r.RuleID] {
+			t.Errorf("rule %s missing", r.RuleID)
+		}
+	}
+}
+
+var reportTests = []cliTest{
+	{name: "sarif report", args: []string{"-no-unset", "-report", "sarif=out.sarif", "a.tmpl"},
+		files: map[string]string{"a.tmpl": "$A"}, code: 1, stderr: "variable ${A} not set",
+		parts: map[string]string{"out.sarif": `"ruleId": "ENV001"`}},
+	{name: "unknown report", args: []string{"-report", "xml=out.xml"}, code: 2, stderr: `unknown report format "xml"`},
+}
+
+func TestReport(t *testing.T) {
+	for _, test := range reportTests {
+		runMain(t, test)
+	}
+}
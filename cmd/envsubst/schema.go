@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/hellt/envsubst/parse"
+	"gopkg.in/yaml.v3"
+)
+
+// schemaRule is a rule of the -schema file, either the name of a type or a
+// mapping of its properties.
+type schemaRule struct {
+	Type     string   `yaml:"type"`
+	Pattern  string   `yaml:"pattern"`
+	Enum     []string `yaml:"enum"`
+	NonEmpty bool     `yaml:"non-empty"`
+	Min      *float64 `yaml:"min"`
+	Max      *float64 `yaml:"max"`
+}
+
+func (r *schemaRule) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&r.Type)
+	}
+	type plain schemaRule
+	return node.Decode((*plain)(r))
+}
+
+// readSchema reads the -schema file at path, a mapping of variable names to
+// their rules:
+//
+//	PORT: port
+//	TIMEOUT: {type: duration}
+//	LOG_LEVEL: {enum: [debug, info, warn]}
+//
+// Patterns match the whole value.
+func readSchema(path string) (parse.Schema, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules map[string]schemaRule
+	if err := yaml.Unmarshal(b, &rules); err != nil {
+		return nil, err
+	}
+	schema := parse.Schema{}
+	for name, r := range rules {
+		rule := parse.Rule{Enum: r.Enum, NonEmpty: r.NonEmpty, Min: r.Min, Max: r.Max}
+		if r.Pattern != "" {
+			if rule.Pattern, err = regexp.Compile("^(?:" + r.Pattern + ")$"); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		if r.Type != "" {
+			if rule.Type, err = parse.ParseType(r.Type); err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		schema[name] = rule
+	}
+	return schema, nil
+}
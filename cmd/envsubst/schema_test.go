@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+// typesSchema is a -schema declaring types.
+const typesSchema = "PORT: port\nTIMEOUT: {type: duration}\n"
+
+// constraintsSchema is a -schema declaring constraints.
+const constraintsSchema = `LOG_LEVEL: {enum: [debug, info]}
+NAME: {pattern: '[a-z]+', max: 5}
+REPLICAS: {type: int, min: 1, max: 10}
+API_TOKEN: {non-empty: true}
+`
+
+var schemaTests = []cliTest{
+	{name: "schema types", args: []string{"-schema", "schema.yaml"}, env: []string{"PORT=80", "TIMEOUT=1s"}, stdin: "$PORT $TIMEOUT",
+		files: map[string]string{"schema.yaml": typesSchema}, stdout: "80 1s"},
+	{name: "schema port", args: []string{"-schema", "schema.yaml"}, env: []string{"PORT=eighty"}, stdin: "$PORT",
+		files: map[string]string{"schema.yaml": typesSchema}, code: 1, stderr: `${PORT}: value "eighty" is not of type port`},
+	{name: "schema duration", args: []string{"-schema", "schema.yaml"}, env: []string{"TIMEOUT=1"}, stdin: "$TIMEOUT",
+		files: map[string]string{"schema.yaml": typesSchema}, code: 1, stderr: `${TIMEOUT}: value "1" is not of type duration`},
+	{name: "schema constraints", args: []string{"-schema", "schema.yaml"}, env: []string{"LOG_LEVEL=info", "NAME=abc", "REPLICAS=3", "API_TOKEN=t"},
+		stdin: "$LOG_LEVEL $NAME $REPLICAS $API_TOKEN", files: map[string]string{"schema.yaml": constraintsSchema}, stdout: "info abc 3 t"},
+	{name: "schema enum", args: []string{"-schema", "schema.yaml"}, env: []string{"LOG_LEVEL=trace"}, stdin: "$LOG_LEVEL",
+		files: map[string]string{"schema.yaml": constraintsSchema}, code: 1, stderr: `${LOG_LEVEL}: value "trace" is not one of debug, info`},
+	{name: "schema pattern", args: []string{"-schema", "schema.yaml"}, env: []string{"NAME=Abc"}, stdin: "$NAME",
+		files: map[string]string{"schema.yaml": constraintsSchema}, code: 1, stderr: `${NAME}: value "Abc" does not match ^(?:[a-z]+)$`},
+	{name: "schema length", args: []string{"-schema", "schema.yaml"}, env: []string{"NAME=abcdefg"}, stdin: "$NAME",
+		files: map[string]string{"schema.yaml": constraintsSchema}, code: 1, stderr: `${NAME}: length of value "abcdefg" is greater than 5`},
+	{name: "schema min", args: []string{"-schema", "schema.yaml"}, env: []string{"REPLICAS=0"}, stdin: "$REPLICAS",
+		files: map[string]string{"schema.yaml": constraintsSchema}, code: 1, stderr: `${REPLICAS}: value "0" is less than 1`},
+	{name: "schema non-empty", args: []string{"-schema", "schema.yaml"}, env: []string{"API_TOKEN="}, stdin: "$API_TOKEN",
+		files: map[string]string{"schema.yaml": constraintsSchema}, code: 1, stderr: "${API_TOKEN}: value must not be empty"},
+	{name: "schema invalid pattern", args: []string{"-schema", "schema.yaml"}, stdin: "$NAME",
+		files: map[string]string{"schema.yaml": "NAME: {pattern: '[a-z'}\n"}, code: 1, stderr: "Error to read schema: schema.yaml: NAME: error parsing regexp"},
+	{name: "schema unknown type", args: []string{"-schema", "schema.yaml"}, stdin: "$PORT",
+		files: map[string]string{"schema.yaml": "PORT: number\n"}, code: 1,
+		stderr: `Error to read schema: schema.yaml: PORT: unknown type "number", expected string, int, float, bool, duration, url or port`},
+	{name: "schema missing", args: []string{"-schema", "schema.yaml"}, stdin: "$PORT", code: 1, stderr: "Error to read schema: schema.yaml"},
+}
+
+func TestSchema(t *testing.T) {
+	for _, test := range schemaTests {
+		runMain(t, test)
+	}
+}
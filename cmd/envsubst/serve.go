@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hellt/envsubst"
+)
+
+var serveUsage = `  -root      Directory of the templates served, the working directory by
+             default. Text files are rendered like the inputs of render, with
+             the options such as -mode, -env-file and -mask, see
+             envsubst.FileServer, others and directory listings are served
+             as they are.
+  -listen    Address to listen on, localhost:8080 by default. The outputs may
+             hold any variable loaded, listening on other interfaces serves
+             them to the network. Templates failing to render are answered
+             with an Internal Server Error and their errors are logged.
+`
+
+var (
+	serveRoot string
+	listen    string
+)
+
+// runServe serves the templates of -root rendered over HTTP until
+// interrupted.
+func runServe([]job) []diagnostic {
+	if info, err := os.Stat(serveRoot); err != nil || !info.IsDir() {
+		return []diagnostic{ioDiagnostic(serveRoot, fmt.Sprintf("Error to serve directory: %s.", serveRoot))}
+	}
+	handler := envsubst.FileServer(os.DirFS(serveRoot), envsubst.WithRender(serveFile))
+	srv := &http.Server{Addr: listen, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	logger.Info("serving", "root", serveRoot, "address", listen)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return []diagnostic{ioDiagnostic("", fmt.Sprintf("Error to serve: %v", err))}
+	}
+	return nil
+}
+
+// serveFile renders the template text of the file served for path like the
+// inputs of render, logging the diagnostics of those failing.
+func serveFile(path, text string) (string, error) {
+	j := job{in: filepath.Join(serveRoot, filepath.FromSlash(strings.TrimPrefix(path, "/")))}
+	var diags []diagnostic
+	result := text
+	if maxInput > 0 && len(text) > maxInput {
+		diags = []diagnostic{inputTooLarge(j.name(), 0)}
+	} else {
+		result, diags = j.substitute(text)
+	}
+	if diags != nil {
+		for _, d := range masks.maskDiagnostics(diags) {
+			logger.Error("rendering failed", "file", masks.mask(d.File), "line", d.Line, "column", d.Column, "code", code(d.Kind), "message", d.Message)
+		}
+		return "", errors.New("rendering failed")
+	}
+	return endOfLines(result), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the server is stopped with an interrupt")
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.js":   "window.API = '${API_URL}';\n",
+		"app.yaml":    "enabled: $ON\n",
+		"broken.txt":  "${API_URL",
+		"s3cret.txt":  "${TOKEN|nope}",
+		"vars.env":    "API_URL=https://api\nON=true\n",
+		"unset.txt":   "$NOTSET",
+		"logo.png":    "\x89PNG\r\n\x1a\n$API_URL",
+		"sub/a.txt":   "a=$API_URL",
+		".hidden.txt": "h",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	cmd := exec.Command(os.Args[0], "serve", "-root", dir, "-listen", addr, "-env-file", filepath.Join(dir, "vars.env"),
+		"-mode", "auto", "-no-unset", "-mask", "TOKEN")
+	cmd.Env = []string{"ENVSUBST_TEST_MAIN=1", "TOKEN=s3cret"}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Signal(os.Interrupt)
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("got %v stopping the server, stderr:\n%s", err, stderr.String())
+		}
+	}()
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if i == 100 {
+			t.Fatalf("server not listening: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	tests := []struct {
+		path, expected string
+		status         int
+	}{
+		{"/config.js", "window.API = 'https://api';\n", http.StatusOK},
+		// yaml is chosen for the file by -mode auto, keeping the string a string.
+		{"/app.yaml", "enabled: \"true\"\n", http.StatusOK},
+		{"/sub/a.txt", "a=https://api", http.StatusOK},
+		{"/logo.png", "\x89PNG\r\n\x1a\n$API_URL", http.StatusOK},
+		{"/unset.txt", "Internal Server Error\n", http.StatusInternalServerError},
+		{"/broken.txt", "Internal Server Error\n", http.StatusInternalServerError},
+		{"/s3cret.txt", "Internal Server Error\n", http.StatusInternalServerError},
+		{"/missing.txt", "404 page not found\n", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		resp, err := http.Get("http://" + addr + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != test.expected || resp.StatusCode != test.status {
+			t.Errorf("%s: got %d %q, expected %d %q", test.path, resp.StatusCode, b, test.status, test.expected)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	if log := stderr.String(); !strings.Contains(log, "variable ${NOTSET} not set") || !strings.Contains(log, "closing brace expected") {
+		t.Errorf("got log %q, expected the errors of the templates", log)
+	}
+	if strings.Contains(stderr.String(), "s3cret") {
+		t.Errorf("got log %q, expected TOKEN masked", stderr.String())
+	}
+}
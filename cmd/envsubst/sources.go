@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// kubectl is the command Kubernetes objects are read with. It uses the
+// kubeconfig and current context like any other kubectl invocation.
+var kubectl = "kubectl"
+
+// sopsCLI is the command variable files are decrypted with for -sops. It
+// finds the keys like any other sops invocation.
+var sopsCLI = "sops"
+
+// k8sVars returns the keys of a Secret or ConfigMap as variables. ref names
+// the object as secret/NAME or configmap/NAME in the current namespace.
+func k8sVars(ref string) ([]string, error) {
+	kind, name, _ := strings.Cut(ref, "/")
+	switch kind {
+	case "secret", "configmap":
+	default:
+		return nil, fmt.Errorf("expected secret/NAME or configmap/NAME")
+	}
+	if name == "" {
+		return nil, fmt.Errorf("expected secret/NAME or configmap/NAME")
+	}
+	b, err := run(kubectl, "get", kind, name, "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+	var object struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(b, &object); err != nil {
+		return nil, err
+	}
+	vars := make([]string, 0, len(object.Data))
+	for key, value := range object.Data {
+		if kind == "secret" {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("value of %s: %v", key, err)
+			}
+			value = string(decoded)
+		}
+		vars = append(vars, key+"="+value)
+	}
+	sort.Strings(vars)
+	return vars, nil
+}
+
+// run runs the command and returns its output. The error includes what the
+// command wrote to stderr.
+func run(name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	b, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %v: %s", name, err, msg)
+		}
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return b, nil
+}
+
+// vaultVars returns the keys of the Vault secret at path as variables. The
+// server and token are taken from VAULT_ADDR and VAULT_TOKEN, or the token
+// file of the vault CLI, as is the namespace from VAULT_NAMESPACE.
+func vaultVars(path string) ([]string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is not set")
+	}
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			b, _ := os.ReadFile(filepath.Join(home, ".vault-token"))
+			token = strings.TrimSpace(string(b))
+		}
+	}
+	if token == "" {
+		return nil, fmt.Errorf("VAULT_TOKEN is not set")
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	// Numbers are kept as written, 1000000 would print as 1e+06.
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&secret); err != nil {
+		return nil, err
+	}
+	data := secret.Data
+	// KV version 2 nests the keys next to the metadata of the version.
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	vars := make([]string, 0, len(data))
+	for key, value := range data {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("value of %s is not a scalar", key)
+		case nil:
+			value = ""
+		}
+		vars = append(vars, fmt.Sprintf("%s=%v", key, value))
+	}
+	sort.Strings(vars)
+	return vars, nil
+}
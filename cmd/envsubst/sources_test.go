@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// fakeKubectl is a stand-in for kubectl serving a Secret and a ConfigMap.
+const fakeKubectl = `#!/bin/sh
+case "$2/$3" in
+secret/db) echo '{"data": {"PASSWORD": "czNjcmV0", "USER": "YXBw"}}' ;;
+configmap/app) echo '{"data": {"LOG_LEVEL": "info", "USER": "cm"}}' ;;
+*) echo "Error from server (NotFound): $2 \"$3\" not found" >&2; exit 1 ;;
+esac
+`
+
+// fakeAWS is a stand-in for the AWS command line tool serving the
+// parameters below /app/ and the secret app/db in the region eu-west-1.
+const fakeAWS = `#!/bin/sh
+case "$*" in
+"ssm get-parameters-by-path --path /app/ --recursive --with-decryption --region eu-west-1 --output json")
+	echo '{"Parameters": [{"Name": "/app/db/password", "Type": "SecureString", "Value": "s3cret"}, {"Name": "/app/log-level", "Type": "String", "Value": "info"}]}' ;;
+"ssm get-parameters --with-decryption --names /app/db/password --region eu-west-1 --output json")
+	echo '{"Parameters": [{"Name": "/app/db/password", "Value": "s3cret"}], "InvalidParameters": []}' ;;
+"secretsmanager get-secret-value --secret-id app/db --region eu-west-1 --output json")
+	echo '{"SecretString": "{\\"USER\\": \\"app\\", \\"PASSWORD\\": \\"pw\\"}"}' ;;
+*) echo "An error occurred (AccessDeniedException)" >&2; exit 254 ;;
+esac
+`
+
+// fakeSOPS is a stand-in for sops decrypting the ENC[...] values of dotenv,
+// json and yaml files.
+const fakeSOPS = `#!/bin/sh
+case "$3" in
+dotenv|json|yaml) sed 's/ENC\[\([^]]*\)\]/\1/g' "$6" ;;
+*) echo "unsupported type $3" >&2; exit 1 ;;
+esac
+`
+
+var sourceTests = []cliTest{
+	{name: "from k8s", args: []string{"-from-k8s", "configmap/app", "-from-k8s", "secret/db"}, env: []string{"USER=env"},
+		stdin: "$LOG_LEVEL $USER $PASSWORD", files: map[string]string{"bin/kubectl": fakeKubectl}, stdout: "info app s3cret"},
+	{name: "from k8s masked", args: []string{"diff", "-from-k8s", "secret/db", "a.tmpl"},
+		files:  map[string]string{"bin/kubectl": fakeKubectl, "a.tmpl": "$PASSWORD\n"},
+		stdout: "--- a.tmpl\n+++ a.tmpl (rendered)\n@@ -1 +1 @@\n-$PASSWORD\n+***\n"},
+	{name: "from k8s not found", args: []string{"-from-k8s", "secret/nope"}, stdin: "$A",
+		files: map[string]string{"bin/kubectl": fakeKubectl}, code: 1, stderr: `secret "nope" not found`},
+	{name: "from k8s kind", args: []string{"-from-k8s", "pod/app"}, stdin: "$A",
+		code: 1, stderr: "expected secret/NAME or configmap/NAME"},
+	{name: "aws ssm prefix", args: []string{"-aws-ssm-prefix", "/app/", "-aws-region", "eu-west-1"}, env: []string{"LOG_LEVEL=debug"},
+		stdin: "$DB_PASSWORD $LOG_LEVEL", files: map[string]string{"bin/aws": fakeAWS}, stdout: "s3cret info"},
+	{name: "aws ssm masked", args: []string{"diff", "-aws-ssm-prefix", "/app/", "-aws-region", "eu-west-1", "a.tmpl"},
+		files:  map[string]string{"bin/aws": fakeAWS, "a.tmpl": "$DB_PASSWORD $LOG_LEVEL\n"},
+		stdout: "--- a.tmpl\n+++ a.tmpl (rendered)\n@@ -1 +1 @@\n-$DB_PASSWORD $LOG_LEVEL\n+*** info\n"},
+	{name: "aws ssm denied", args: []string{"-aws-ssm-prefix", "/other/", "-aws-region", "eu-west-1"}, stdin: "$A",
+		files: map[string]string{"bin/aws": fakeAWS}, code: 1, stderr: "Error to read variables from AWS SSM: /other/"},
+	{name: "aws secret", args: []string{"-aws-secret", "app/db", "-aws-region", "eu-west-1"}, env: []string{"USER=env"},
+		stdin: "$USER $PASSWORD", files: map[string]string{"bin/aws": fakeAWS}, stdout: "app pw"},
+	{name: "aws secret masked", args: []string{"diff", "-aws-secret", "app/db", "-aws-region", "eu-west-1", "a.tmpl"},
+		files:  map[string]string{"bin/aws": fakeAWS, "a.tmpl": "$PASSWORD\n"},
+		stdout: "--- a.tmpl\n+++ a.tmpl (rendered)\n@@ -1 +1 @@\n-$PASSWORD\n+***\n"},
+	{name: "resolve aws", args: []string{"-resolve", "-aws-region", "eu-west-1"},
+		env:   []string{"DB=ssm:/app/db/password", "API=secretsmanager:app/db#USER"},
+		stdin: "$DB $API", files: map[string]string{"bin/aws": fakeAWS}, stdout: "s3cret app"},
+	{name: "resolve aws denied", args: []string{"-resolve", "-aws-region", "eu-west-1"}, env: []string{"DB=ssm:/other"},
+		stdin: "$DB", files: map[string]string{"bin/aws": fakeAWS}, code: 1, stderr: "AccessDeniedException"},
+	{name: "sops", args: []string{"-sops", "-env-file", "a.env", "-env-from-json", "b.json"}, stdin: "$A $B_C",
+		files:  map[string]string{"bin/sops": fakeSOPS, "a.env": "A=ENC[a]\n", "b.json": `{"b": {"c": "ENC[c]"}}`},
+		stdout: "a c"},
+	{name: "sops yaml", args: []string{"-sops", "-env-from-yaml", "values.yaml"}, stdin: "$DB_PASSWORD",
+		files:  map[string]string{"bin/sops": fakeSOPS, "values.yaml": "db:\n  password: ENC[pw]\n"},
+		stdout: "pw"},
+	{name: "sops masked", args: []string{"diff", "-sops", "-env-file", "a.env", "a.tmpl"},
+		files:  map[string]string{"bin/sops": fakeSOPS, "a.env": "A=ENC[s3cret]\n", "a.tmpl": "$A\n"},
+		stdout: "--- a.tmpl\n+++ a.tmpl (rendered)\n@@ -1 +1 @@\n-$A\n+***\n"},
+	{name: "sops failure", args: []string{"-sops", "-env-from-toml", "a.toml"}, stdin: "$A",
+		files: map[string]string{"bin/sops": fakeSOPS, "a.toml": "a = 1\n"}, code: 1, stderr: "unsupported type binary"},
+}
+
+func TestSources(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake commands need a shell")
+	}
+	for _, test := range sourceTests {
+		runMain(t, test)
+	}
+}
+
+func TestVault(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("X-Vault-Token") != "t0ken":
+			http.Error(w, "permission denied", http.StatusForbidden)
+		case r.URL.Path == "/v1/secret/data/app":
+			w.Write([]byte(`{"data": {"data": {"PASSWORD": "s3cret", "MAX": 1000000, "NONE": null}, "metadata": {"version": 2}}}`))
+		case r.URL.Path == "/v1/kv/app":
+			w.Write([]byte(`{"data": {"PASSWORD": "kv1"}}`))
+		case r.URL.Path == "/v1/kv/slow":
+			time.Sleep(time.Second)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer vault.Close()
+	addr := "VAULT_ADDR=" + vault.URL
+	tests := []cliTest{
+		{name: "vault", args: []string{"-vault-path", "secret/data/app"}, env: []string{addr, "VAULT_TOKEN=t0ken"},
+			stdin: "$PASSWORD $MAX [$NONE]", stdout: "s3cret 1000000 []"},
+		{name: "vault kv1", args: []string{"-vault-path", "/kv/app"}, env: []string{addr, "VAULT_TOKEN=t0ken"},
+			stdin: "$PASSWORD", stdout: "kv1"},
+		{name: "vault token file", args: []string{"-vault-path", "kv/app"}, env: []string{addr},
+			files: map[string]string{".vault-token": "t0ken\n"}, stdin: "$PASSWORD", stdout: "kv1"},
+		{name: "vault masked", args: []string{"diff", "-vault-path", "kv/app", "a.tmpl"}, env: []string{addr, "VAULT_TOKEN=t0ken"},
+			files: map[string]string{"a.tmpl": "$PASSWORD\n"}, stdout: "--- a.tmpl\n+++ a.tmpl (rendered)\n@@ -1 +1 @@\n-$PASSWORD\n+***\n"},
+		{name: "vault denied", args: []string{"-vault-path", "kv/app"}, env: []string{addr, "VAULT_TOKEN=wrong"},
+			stdin: "$A", code: 1, stderr: "Error to read variables from Vault: kv/app: 403 Forbidden"},
+		{name: "vault no token", args: []string{"-vault-path", "kv/app"}, env: []string{addr},
+			stdin: "$A", code: 1, stderr: "VAULT_TOKEN is not set"},
+		{name: "resolve vault", args: []string{"-resolve"}, env: []string{addr, "VAULT_TOKEN=t0ken", "DB=vault:kv/app#PASSWORD", "URL=http://a"},
+			stdin: "$DB $URL", stdout: "kv1 http://a"},
+		{name: "resolve vault missing key", args: []string{"-resolve"}, env: []string{addr, "VAULT_TOKEN=t0ken", "DB=vault:kv/app#USER"},
+			stdin: "$DB", code: 1, stderr: "no key USER in kv/app"},
+		{name: "resolve timeout", args: []string{"-resolve", "-resolve-timeout", "100ms"}, env: []string{addr, "VAULT_TOKEN=t0ken", "DB=vault:kv/slow#PASSWORD"},
+			stdin: "$DB", code: 1, stderr: "resolve vault:kv/slow#PASSWORD: timed out after 100ms"},
+		{name: "resolve disabled", env: []string{"DB=vault:kv/app#PASSWORD"}, stdin: "$DB", stdout: "vault:kv/app#PASSWORD"},
+		{name: "vault no address", args: []string{"-vault-path", "kv/app"}, stdin: "$A", code: 1, stderr: "VAULT_ADDR is not set"},
+	}
+	for _, test := range tests {
+		runMain(t, test)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+var streamTests = []cliTest{
+	{name: "stream", env: []string{"A=1"}, stdin: "a=$A\n\n${A}${A}", stdout: "a=1\n\n11"},
+	{name: "stream syntax error", env: []string{"A=1"}, stdin: "a=$A\nb=${A\nc=$A\n",
+		code: 1, stdout: "a=1\n", stderr: "closing brace expected"},
+	{name: "stream all errors", args: []string{"-no-unset"}, stdin: "$X\n$Y\n",
+		code: 1, stderr: "variable ${Y} not set"},
+	{name: "stream require substitution", args: []string{"-require-substitution"}, stdin: "a\nb\n",
+		code: 1, stderr: "no variables"},
+	{name: "stream long line", env: []string{"A=1"}, stdin: strings.Repeat("x", 1<<17) + "$A\n$A",
+		stdout: strings.Repeat("x", 1<<17) + "1\n1"},
+	{name: "stream long line error", args: []string{"-format", "json"}, stdin: "a\n" + strings.Repeat("x", 1<<17) + " ${A",
+		code: 1, stdout: "a\n" + strings.Repeat("x", 1<<17) + " ",
+		stderr: `{"file":"-","line":2,"column":131077,"kind":"syntax","code":"ENV003","severity":"error","message":"closing brace expected"}`},
+	{name: "stream escapes", env: []string{"A=1"}, stdin: "$$A\n$${A}\n$A\r\n", stdout: "$A\n${A}\n1\r\n"},
+}
+
+func TestStream(t *testing.T) {
+	for _, test := range streamTests {
+		runMain(t, test)
+	}
+}
+
+// TestStreamLines checks that a line of stdin is rendered before the next one is written.
+func TestStreamLines(t *testing.T) {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = []string{"ENVSUBST_TEST_MAIN=1", "A=1"}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+	lines := make(chan string)
+	go func() {
+		r := bufio.NewReader(stdout)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- line
+		}
+	}()
+	for _, line := range []string{"a=$A\n", "b=${A}\n"} {
+		if _, err := stdin.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-lines:
+			if expected := line[:2] + "1\n"; got != expected {
+				t.Errorf("got %q, expected %q", got, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q not rendered before the end of the input", line)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+var syntaxTests = []cliTest{
+	{name: "yaml", args: []string{"-mode", "yaml"}, env: []string{"ON=true", "PORT=80", "MSG=a: b"},
+		stdin: "# $ON\non: $ON\nport: ${PORT}\nmsg: $MSG\n", stdout: "# $ON\non: \"true\"\nport: \"80\"\nmsg: 'a: b'\n"},
+	{name: "yaml auto", args: []string{"-mode", "auto", "a.yaml", "b.txt"}, env: []string{"ON=true"},
+		files: map[string]string{"a.yaml": "on: $ON\n", "b.txt": "on: $ON\n"}, stdout: "on: \"true\"\non: true\n"},
+	{name: "yaml invalid", args: []string{"-mode", "yaml"}, stdin: "a: [\n", code: 1, stderr: "invalid YAML"},
+	{name: "json", args: []string{"-mode", "json"}, env: []string{"A=x\"y", "N=3"}, stdin: `{"a": "$A", "n": $N}`,
+		stdout: `{"a": "x\"y", "n": 3}`},
+	{name: "json invalid output", args: []string{"-mode", "json"}, env: []string{"N=1 2"}, stdin: `{"n": $N}`,
+		code: 1, stderr: "rendered output is not valid JSON"},
+	{name: "toml", args: []string{"-mode", "toml"}, env: []string{"A=x\"y", "N=3"}, stdin: "a = \"$A\" # $A\nb = $N\nc = '$A'\n",
+		stdout: "a = \"x\\\"y\" # $A\nb = 3\nc = 'x\"y'\n"},
+	{name: "toml literal", args: []string{"-mode", "toml"}, env: []string{"A=it's"}, stdin: "a = '$A'\n",
+		code: 1, stderr: "can't be written in a TOML literal string"},
+	{name: "ini", args: []string{"-mode", "ini"}, env: []string{"A=x\ny"}, stdin: "a=$A\n; $A\n",
+		stdout: "a=x\n  y\n; $A\n"},
+	{name: "properties", args: []string{"-mode", "properties"}, env: []string{"A=a:b=c", "K= x"}, stdin: "k$K=$A\n# $A\n",
+		stdout: "k\\ x=a:b=c\n# $A\n"},
+	{name: "properties auto", args: []string{"-mode", "auto", "app.properties"}, env: []string{"K= x"},
+		files: map[string]string{"app.properties": "k$K=1\n"}, stdout: "k\\ x=1\n"},
+	{name: "xml", args: []string{"-mode", "xml"}, env: []string{"A=x<\"&"}, stdin: `<a b="$A">$A<!-- $A --></a>`,
+		stdout: `<a b="x&lt;&quot;&amp;">x&lt;"&amp;<!-- $A --></a>`},
+	{name: "xml invalid output", args: []string{"-mode", "xml"}, env: []string{"A=1"}, stdin: "<a>$A",
+		code: 1, stderr: "rendered output is not valid XML"},
+	{name: "shell", args: []string{"-mode", "shell"}, env: []string{"A=x"}, stdin: "a=$A\nb='$A'\nc=\\$A $1\ncat <<EOF\n$A\nEOF\n",
+		stdout: "a=x\nb='$A'\nc=\\$A $1\ncat <<EOF\n$A\nEOF\n"},
+	{name: "hcl", args: []string{"-mode", "hcl"}, env: []string{"A=x"}, stdin: "a = \"${var.x}\"\nb = \"$A\"\nc = \"%{ if x }\"\n",
+		stdout: "a = \"${var.x}\"\nb = \"x\"\nc = \"%{ if x }\"\n"},
+	{name: "hcl allow", args: []string{"-mode", "hcl", "-hcl-allow", "A"}, env: []string{"A=x"}, stdin: "a = \"${A}\"\nb = \"${B}\"\n",
+		stdout: "a = \"x\"\nb = \"${B}\"\n"},
+	{name: "helm", args: []string{"-mode", "helm"}, env: []string{"TAG=1", "A=x"}, stdin: "image: {{ .Values.image }}:$TAG\n{{ $A }}\n",
+		stdout: "image: {{ .Values.image }}:1\n{{ $A }}\n"},
+	{name: "helm altered", args: []string{"-mode", "helm"}, env: []string{"A=x"}, stdin: "${A:-{{ .x }}}\n",
+		code: 1, stderr: `template action "{{ .x }}" was altered by a substitution`},
+	{name: "csv", args: []string{"-mode", "csv"}, env: []string{"A=x,y", "B=q\""}, stdin: "a,b\n$A,$B\n",
+		stdout: "a,b\n\"x,y\",\"q\"\"\"\n"},
+	{name: "unknown mode", args: []string{"-mode", "cobol"}, code: 1, stderr: "cobol"},
+}
+
+func TestSyntaxes(t *testing.T) {
+	for _, test := range syntaxTests {
+		runMain(t, test)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+
+	"github.com/hellt/envsubst/oteltrace"
+	"github.com/hellt/envsubst/parse"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracing exports the OpenTelemetry spans of the command to the -trace file.
+type tracing struct {
+	provider *sdktrace.TracerProvider
+	root     trace.Span
+	// tracer starts the spans of the files as children of root.
+	tracer parse.Tracer
+}
+
+// openTrace starts tracing the command to the file at path, continuing the
+// trace of the TRACEPARENT and TRACESTATE variables of the process, if set,
+// such as that of the deployment running the command.
+func openTrace(path, command string) (*tracing, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "envsubst"))),
+	)
+	carrier := propagation.MapCarrier{"traceparent": os.Getenv("TRACEPARENT"), "tracestate": os.Getenv("TRACESTATE")}
+	ctx := propagation.TraceContext{}.Extract(context.Background(), carrier)
+	t := provider.Tracer("github.com/hellt/envsubst")
+	ctx, root := t.Start(ctx, "envsubst", trace.WithAttributes(attribute.String("envsubst.command", command)))
+	return &tracing{provider, root, oteltrace.Tracer(ctx, t)}, nil
+}
+
+// file starts the span of the processing of the input of j.
+func (t *tracing) file(j job) parse.TraceSpan {
+	return t.tracer.Start("envsubst.file", slog.String("envsubst.file", j.name()), slog.String("envsubst.output", j.out))
+}
+
+// end ends the span of a file with its diagnostics.
+func (t *tracing) end(span parse.TraceSpan, diags []diagnostic) {
+	var err error
+	if len(diags) > 0 {
+		err = errors.New(diags[0].Message)
+	}
+	span.End(err, slog.Int("envsubst.diagnostics", len(diags)))
+}
+
+// close ends the span of the command and writes the spans not written yet.
+func (t *tracing) close(files, diags int) error {
+	t.root.SetAttributes(attribute.Int("envsubst.files", files), attribute.Int("envsubst.diagnostics", diags))
+	t.root.End()
+	return t.provider.Shutdown(context.Background())
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+var traceTests = []cliTest{
+	{name: "trace parent", args: []string{"-trace", "trace.json"}, stdin: "$A", stdout: "1",
+		env:   []string{"A=1", "TRACEPARENT=00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+		parts: map[string]string{"trace.json": `"Parent":{"TraceID":"0af7651916cd43dd8448eb211c80319c","SpanID":"b7ad6b7169203331"`}},
+	{name: "trace files", args: []string{"render", "-trace", "trace.json", "a.tmpl"}, env: []string{"A=1"},
+		files: map[string]string{"a.tmpl": "a=$A\n"}, stdout: "a=1\n",
+		parts: map[string]string{"trace.json": `{"Key":"envsubst.template","Value":{"Type":"STRING","Value":"a.tmpl"}},{"Key":"envsubst.template.size","Value":{"Type":"INT64","Value":5}},{"Key":"envsubst.substitutions","Value":{"Type":"INT64","Value":1}}`}},
+	{name: "trace command", args: []string{"render", "-trace", "trace.json", "a.tmpl"},
+		files: map[string]string{"a.tmpl": "a"}, stdout: "a",
+		parts: map[string]string{"trace.json": `"Name":"envsubst","SpanContext"`}},
+	{name: "trace file error", args: []string{"-trace", "dir/trace.json"}, stdin: "$A",
+		code: 1, stderr: "Error to create trace file: open dir/trace.json"},
+}
+
+func TestTrace(t *testing.T) {
+	for _, test := range traceTests {
+		runMain(t, test)
+	}
+}
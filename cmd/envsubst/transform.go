@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// transforms are the functions applicable to substituted values with -transform.
+var transforms = map[string]func(string) string{
+	"shell-quote": parse.ShellQuote,
+	"url":         parse.URLEncode,
+}
+
+// transformList holds the rules given with -transform as [PATTERN=]NAME.
+type transformList []transformRule
+
+type transformRule struct {
+	pattern string // glob pattern of the variable names, * without one
+	name    string
+}
+
+func (l *transformList) String() string {
+	var s []string
+	for _, r := range *l {
+		s = append(s, r.pattern+"="+r.name)
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *transformList) Set(value string) error {
+	pattern, name, ok := strings.Cut(value, "=")
+	if !ok {
+		pattern, name = "*", value
+	}
+	if _, ok := transforms[name]; !ok {
+		names := make([]string, 0, len(transforms))
+		for name := range transforms {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("unknown transform %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	*l = append(*l, transformRule{pattern, name})
+	return nil
+}
+
+// apply applies the transform of the first rule matching the variable name
+// to value.
+func (l transformList) apply(name, value string) string {
+	for _, r := range l {
+		if ok, _ := path.Match(r.pattern, name); ok {
+			return transforms[r.name](value)
+		}
+	}
+	return value
+}
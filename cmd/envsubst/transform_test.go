@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+var transformTests = []cliTest{
+	{name: "shell quote", args: []string{"-transform", "shell-quote"}, env: []string{"A=a b'c", "B=plain"},
+		stdin: "a=$A b=$B", stdout: `a='a b'\''c' b=plain`},
+	{name: "shell quote pattern", args: []string{"-transform", "A=shell-quote"}, env: []string{"A=a b", "B=c d"},
+		stdin: "a=$A b=$B", stdout: "a='a b' b=c d"},
+	{name: "url", args: []string{"-transform", "*_PASSWORD=url"}, env: []string{"DB_PASSWORD=p@ss w/d", "USER=a b"},
+		stdin: "postgres://$USER:$DB_PASSWORD@db", stdout: "postgres://a b:p%40ss%20w%2Fd@db"},
+	{name: "first transform", args: []string{"-transform", "A=url", "-transform", "shell-quote"}, env: []string{"A=a b", "B=c d"},
+		stdin: "$A $B", stdout: "a%20b 'c d'"},
+	{name: "unknown transform", args: []string{"-transform", "nope"}, code: 2, stderr: `unknown transform "nope"`},
+	{name: "redact marker", args: []string{"-redact", "marker"}, env: []string{"A=s3cret"}, stdin: "$A ${B:-x}",
+		stdout: "<redacted:A> <redacted:B>"},
+	{name: "redact hash", args: []string{"-redact", "hash"}, env: []string{"A=s3cret", "B=s3cret"}, stdin: "$A $B ${C:-x}",
+		stdout: "<sha256:1ec1c26b50d5> <sha256:1ec1c26b50d5> <sha256:2d711642b726>"},
+	{name: "redact transform", args: []string{"-redact", "marker", "-transform", "shell-quote"}, env: []string{"A=a b"}, stdin: "$A",
+		stdout: "<redacted:A>"},
+	{name: "unknown redaction", args: []string{"-redact", "nope"}, stdin: "$A", code: 1, stderr: "Unknown redaction: nope."},
+}
+
+func TestTransforms(t *testing.T) {
+	for _, test := range transformTests {
+		runMain(t, test)
+	}
+}
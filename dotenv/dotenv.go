@@ -0,0 +1,232 @@
+// Package dotenv parses .env files of NAME=VALUE assignments into variables
+// that can be substituted by envsubst.
+//
+//	# comment
+//	export NAME=value # inline comment
+//	SINGLE='literal $value, no escapes'
+//	DOUBLE="escapes such as \n and \" are interpreted"
+//	MULTI="first line
+//	second line"
+//
+// Unquoted values have surrounding white space and inline comments, starting
+// with a # preceded by white space, removed. Quoted values may span several
+// lines. Double-quoted values interpret the escapes \n, \r, \t, \", \\ and
+// \$, single-quoted values are taken literally. Values are not expanded.
+package dotenv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Error is a syntax error in a .env file.
+type Error struct {
+	Line int    // 1-based line number
+	Msg  string // human readable description
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Parse parses the .env file read from r. Later assignments of a variable
+// override earlier ones.
+func Parse(r io.Reader) (map[string]string, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseBytes(b)
+}
+
+// ParseBytes is like Parse for the content of a .env file.
+func ParseBytes(b []byte) (map[string]string, error) {
+	p := &parser{text: string(bytes.TrimPrefix(b, []byte("\xef\xbb\xbf"))), line: 1}
+	vars := map[string]string{}
+	for {
+		p.skipBlank()
+		if p.done() {
+			return vars, nil
+		}
+		name, value, err := p.assignment()
+		if err != nil {
+			return nil, err
+		}
+		vars[name] = value
+	}
+}
+
+// Read parses the .env file at path.
+func Read(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Environ returns vars as NAME=VALUE pairs sorted by name, the form of
+// os.Environ and the environment of a parse.Parser.
+func Environ(vars map[string]string) []string {
+	env := make([]string, 0, len(vars))
+	for name, value := range vars {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// parser holds the state of parsing a .env file.
+type parser struct {
+	text string
+	pos  int
+	line int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.text)
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &Error{Line: p.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// skipBlank skips white space, empty lines and comment lines.
+func (p *parser) skipBlank() {
+	for !p.done() {
+		switch c := p.text[p.pos]; {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			p.skipLine()
+		default:
+			return
+		}
+	}
+}
+
+// skipLine skips the rest of the line, including its line break.
+func (p *parser) skipLine() {
+	if i := strings.IndexByte(p.text[p.pos:], '\n'); i >= 0 {
+		p.pos += i + 1
+		p.line++
+	} else {
+		p.pos = len(p.text)
+	}
+}
+
+// assignment parses a NAME=VALUE line, starting at the name.
+func (p *parser) assignment() (string, string, error) {
+	end := strings.IndexAny(p.text[p.pos:], "=\n")
+	if end < 0 || p.text[p.pos+end] != '=' {
+		return "", "", p.errorf("expected NAME=VALUE")
+	}
+	name := strings.TrimSpace(p.text[p.pos : p.pos+end])
+	if rest := strings.TrimPrefix(name, "export"); rest != name && rest != "" && (rest[0] == ' ' || rest[0] == '\t') {
+		name = strings.TrimSpace(rest)
+	}
+	if !validName(name) {
+		return "", "", p.errorf("invalid variable name %q", name)
+	}
+	p.pos += end + 1
+	for !p.done() && (p.text[p.pos] == ' ' || p.text[p.pos] == '\t') {
+		p.pos++
+	}
+	var value string
+	var err error
+	switch {
+	case p.done():
+	case p.text[p.pos] == '\'' || p.text[p.pos] == '"':
+		value, err = p.quoted(p.text[p.pos])
+	default:
+		value = p.unquoted()
+	}
+	return name, value, err
+}
+
+// unquoted parses an unquoted value up to the end of the line.
+func (p *parser) unquoted() string {
+	end := strings.IndexByte(p.text[p.pos:], '\n')
+	if end < 0 {
+		end = len(p.text) - p.pos
+	}
+	value := p.text[p.pos : p.pos+end]
+	p.pos += end
+	for i := 1; i < len(value); i++ {
+		if value[i] == '#' && (value[i-1] == ' ' || value[i-1] == '\t') {
+			value = value[:i]
+			break
+		}
+	}
+	return strings.TrimSpace(value)
+}
+
+// quoted parses a value quoted with q, followed by an optional comment.
+func (p *parser) quoted(q byte) (string, error) {
+	start := p.line
+	var value strings.Builder
+	for p.pos++; ; p.pos++ {
+		if p.done() {
+			return "", &Error{Line: start, Msg: fmt.Sprintf("missing closing %c", q)}
+		}
+		c := p.text[p.pos]
+		if c == q {
+			p.pos++
+			break
+		}
+		if c == '\n' {
+			p.line++
+		}
+		if c == '\\' && q == '"' && p.pos+1 < len(p.text) {
+			p.pos++
+			switch e := p.text[p.pos]; e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case '"', '\\', '$':
+				c = e
+			default:
+				if e == '\n' {
+					p.line++
+				}
+				value.WriteByte('\\')
+				c = e
+			}
+		}
+		value.WriteByte(c)
+	}
+	rest := p.text[p.pos:]
+	if i := strings.IndexByte(rest, '\n'); i >= 0 {
+		rest = rest[:i]
+	}
+	if trimmed := strings.TrimSpace(rest); trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+		return "", p.errorf("unexpected %q after quoted value", trimmed)
+	}
+	p.pos += len(rest)
+	return value.String(), nil
+}
+
+// validName reports whether name can be used as a variable name.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && r != '.' && r != '-' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,73 @@
+package dotenv
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	input := "\xef\xbb\xbf# comment\n" +
+		"PLAIN=value\n" +
+		"  export EXPORTED = spaced value  \n" +
+		"COMMENTED=value # comment\n" +
+		"HASH=a#b\n" +
+		"EMPTY=\n" +
+		"SINGLE='lit $X \\n' # comment\n" +
+		"DOUBLE=\"a\\tb\\n\\\"c\\\" \\$X \\d\"\n" +
+		"MULTI=\"line1\r\nline2\"\n" +
+		"\n" +
+		"CRLF=crlf\r\n" +
+		"PLAIN=override\n" +
+		"export=keyword"
+	expected := map[string]string{
+		"PLAIN":     "override",
+		"EXPORTED":  "spaced value",
+		"COMMENTED": "value",
+		"HASH":      "a#b",
+		"EMPTY":     "",
+		"SINGLE":    `lit $X \n`,
+		"DOUBLE":    "a\tb\n\"c\" $X \\d",
+		"MULTI":     "line1\r\nline2",
+		"CRLF":      "crlf",
+		"export":    "keyword",
+	}
+	vars, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Errorf("got %q, expected %q", vars, expected)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		line  int
+	}{
+		{"A=1\nnovalue\n", 2},
+		{"A=1\nB C=1\n", 2},
+		{"A=1\nB=\"open\n\nC=1\n", 2},
+		{"A='x' y\n", 1},
+		{"A=\"multi\nline\" y\n", 2},
+	}
+	for _, test := range tests {
+		_, err := ParseBytes([]byte(test.input))
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%q: got %v, expected an *Error", test.input, err)
+			continue
+		}
+		if e.Line != test.line {
+			t.Errorf("%q: got error on line %d, expected %d: %v", test.input, e.Line, test.line, e)
+		}
+	}
+}
+
+func TestEnviron(t *testing.T) {
+	env := Environ(map[string]string{"B": "2", "A": "1=1"})
+	if expected := []string{"A=1=1", "B=2"}; !reflect.DeepEqual(env, expected) {
+		t.Errorf("got %q, expected %q", env, expected)
+	}
+}
@@ -0,0 +1,97 @@
+package envsubst
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// RenderEnviron substitutes the variables referenced by the values of env,
+// NAME=VALUE pairs such as those of os.Environ, with the values of env
+// itself, e.g. for DATABASE_URL=postgres://${DB_USER}@${DB_HOST}/app. The
+// values referenced are rendered first, so that references chain, and the
+// variables referencing themselves, directly or not, fail. The restrictions
+// set by the options apply, the other options are ignored. It returns a new
+// environment in the order of env, or the errors of the variables joined.
+func RenderEnviron(env []string, opts ...Option) ([]string, error) {
+	o := newOptions(opts)
+	out := slices.Clone(env)
+	// first is the index of the pair in effect of each variable, as the
+	// first pair of a name is the one looked up.
+	first := map[string]int{}
+	for i, pair := range out {
+		name, _, _ := strings.Cut(pair, "=")
+		if _, ok := first[name]; !ok {
+			first[name] = i
+		}
+	}
+	const (
+		rendering = iota + 1
+		rendered
+	)
+	var (
+		state  = map[string]int{}
+		errs   []error
+		render func(name string)
+	)
+	render = func(name string) {
+		state[name] = rendering
+		i := first[name]
+		_, value, _ := strings.Cut(out[i], "=")
+		p := parse.New(name, out, o.restrict)
+		refs, err := p.References(value)
+		for _, ref := range refs {
+			if _, ok := first[ref.Name]; !ok {
+				continue
+			}
+			switch state[ref.Name] {
+			case 0:
+				render(ref.Name)
+			case rendering:
+				errs = append(errs, fmt.Errorf("%s: cyclic reference to %s", name, ref.Name))
+			}
+		}
+		if err == nil {
+			value, err = p.Parse(value)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+		out[i] = name + "=" + value
+		state[name] = rendered
+	}
+	for i, pair := range out {
+		if name, _, _ := strings.Cut(pair, "="); first[name] == i && state[name] == 0 {
+			render(name)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return out, nil
+}
+
+// RenderEnvironMap is like RenderEnviron for an environment mapping the
+// names of the variables to their values.
+func RenderEnvironMap(env map[string]string, opts ...Option) (map[string]string, error) {
+	pairs := make([]string, 0, len(env))
+	for name, value := range env {
+		pairs = append(pairs, name+"="+value)
+	}
+	// The errors are reported in the order of the names.
+	sort.Strings(pairs)
+	pairs, err := RenderEnviron(pairs, opts...)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		name, value, _ := strings.Cut(pair, "=")
+		out[name] = value
+	}
+	return out, nil
+}
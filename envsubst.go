@@ -1,6 +1,8 @@
 package envsubst
 
 import (
+	"bytes"
+	"io"
 	"os"
 
 	"github.com/hellt/envsubst/parse"
@@ -99,3 +101,44 @@ func ReadFileRestrictedNoReplace(filename string, noUnset, noEmpty, noDigit, noR
 	}
 	return BytesRestrictedNoReplace(b, noUnset, noEmpty, noDigit, noReplace)
 }
+
+// mapThreshold is the size from which ReadFileMapped maps files into memory,
+// smaller files are read.
+var mapThreshold int64 = 1 << 20
+
+// ReadFileMapped is like ReadFileRestrictedNoReplace but maps large files
+// into memory rather than reading them, so that templates of hundreds of
+// megabytes are not copied to the heap before being processed, and renders
+// them directly into the output returned. Files are
+// read where they can't be mapped, such as on systems other than Unix.
+func ReadFileMapped(filename string, noUnset, noEmpty, noDigit, noReplace bool) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	restrictions := &parse.Restrictions{NoUnset: noUnset, NoEmpty: noEmpty, NoDigit: noDigit, NoReplace: noReplace}
+	if size := info.Size(); info.Mode().IsRegular() && size >= mapThreshold && size > 0 && int64(int(size)) == size {
+		if data, unmap, err := mapFile(f, int(size)); err == nil {
+			defer unmap()
+			// Stream copies the parts of the input it keeps, such as the
+			// names of the variables in the errors, which must not outlive
+			// the mapping, and renders to a single buffer.
+			var out bytes.Buffer
+			out.Grow(len(data))
+			if err := parse.New("bytes", os.Environ(), restrictions).Stream(&out, bytes.NewReader(data)); err != nil {
+				return nil, err
+			}
+			return out.Bytes(), nil
+		}
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return BytesRestrictedNoReplace(b, noUnset, noEmpty, noDigit, noReplace)
+}
@@ -1,8 +1,22 @@
 package envsubst
 
 import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
+	"text/template"
+	"time"
+
+	"github.com/hellt/envsubst/parse"
 )
 
 func init() {
@@ -21,9 +35,246 @@ func TestIntegration(t *testing.T) {
 	if string(bytes) != expected || err != nil {
 		t.Error("Expect bytes integration test to pass")
 	}
+	// Lines starting with #envsubst are text unless directives are enabled.
+	if str, err := String("#envsubst is run by CI\n$BAR\n"); str != "#envsubst is run by CI\nbar\n" || err != nil {
+		t.Errorf("got %q, %v, expected the comment as text", str, err)
+	}
 	bytes, err = ReadFile("testdata/file.tmpl")
 	fexpected, err := os.ReadFile("testdata/file.out")
 	if string(bytes) != string(fexpected) || err != nil {
 		t.Error("Expect ReadFile integration test to pass")
 	}
 }
+
+func TestTemplate(t *testing.T) {
+	tmpl, err := ParseTemplate(template.New("test").Funcs(FuncMap()),
+		`{{ $x := .Name }}$BAR {{ $x }} {{ env "BAR" }} {{ envdefault "NOTSET" "d" }} {{ .Ref }}`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var b strings.Builder
+	if err := ExecuteTemplate(&b, tmpl, map[string]string{"Name": "n", "Ref": "${BAR}"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "bar n bar d bar"; b.String() != expected {
+		t.Errorf("got %q, expected %q", b.String(), expected)
+	}
+	tmpl = template.Must(template.New("required").Funcs(FuncMap()).Parse(`{{ envrequired "NOTSET" }}`))
+	if err := ExecuteTemplate(&b, tmpl, nil); err == nil {
+		t.Error("expected an error for a required variable that is not set")
+	}
+}
+
+func TestReadFileMapped(t *testing.T) {
+	defer func(threshold int64) { mapThreshold = threshold }(mapThreshold)
+	expected, err := os.ReadFile("testdata/file.out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, threshold := range []int64{0, 1 << 20} {
+		mapThreshold = threshold
+		b, err := ReadFileMapped("testdata/file.tmpl", false, false, false, false)
+		if string(b) != string(expected) || err != nil {
+			t.Errorf("threshold %d: got %q, %v, expected %q", threshold, b, err, expected)
+		}
+	}
+	mapThreshold = 0
+	path := filepath.Join(t.TempDir(), "unset.tmpl")
+	if err := os.WriteFile(path, []byte("foo $NOTSET_MAPPED"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReadFileMapped(path, true, false, false, false)
+	if err == nil || !strings.Contains(err.Error(), "NOTSET_MAPPED") {
+		t.Errorf("got %v, expected an error for the unset variable", err)
+	}
+}
+
+func TestRenderTree(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.conf":       {Data: []byte("a=$A"), Mode: 0o600},
+		"sub/b.conf":   {Data: []byte("b=${B:-b}")},
+		"sub/c.conf":   {Data: []byte("c=$NOTSET")},
+		"sub/skip.txt": {Data: []byte("$A")},
+	}
+	out := t.TempDir()
+	results, err := RenderTree(fsys, out, WithEnv([]string{"A=a"}), WithRestrictions(parse.NoUnset), WithWorkers(2),
+		WithMatch(func(path string) bool { return strings.HasSuffix(path, ".conf") }))
+	if err == nil || !strings.Contains(err.Error(), "sub/c.conf") {
+		t.Errorf("got %v, expected the error of sub/c.conf", err)
+	}
+	var paths []string
+	for _, res := range results {
+		paths = append(paths, res.Path)
+	}
+	if expected := []string{"a.conf", "sub/b.conf", "sub/c.conf"}; strings.Join(paths, " ") != strings.Join(expected, " ") {
+		t.Errorf("got %v, expected %v", paths, expected)
+	}
+	for path, expected := range map[string]string{"a.conf": "a=a", "sub/b.conf": "b=b"} {
+		b, err := os.ReadFile(filepath.Join(out, path))
+		if string(b) != expected || err != nil {
+			t.Errorf("%s: got %q, %v, expected %q", path, b, err, expected)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(out, "a.conf")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("got %v, %v, expected the permissions of the original", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "sub/c.conf")); !os.IsNotExist(err) {
+		t.Errorf("got %v, expected the failing file not to be written", err)
+	}
+}
+
+func TestNewReader(t *testing.T) {
+	input := strings.Repeat("line $A ${B:-b}\n", 1000)
+	b, err := io.ReadAll(iotest.OneByteReader(NewReader(strings.NewReader(input), WithEnv([]string{"A=a"}))))
+	if expected := strings.Repeat("line a b\n", 1000); string(b) != expected || err != nil {
+		t.Errorf("got %d bytes, %v, expected %d bytes", len(b), err, len(expected))
+	}
+	b, err = io.ReadAll(NewReader(strings.NewReader("a=$A\nb=$NOTSET\n"), WithEnv([]string{"A=a"}), WithRestrictions(parse.NoUnset)))
+	if string(b) != "a=a\n" || err == nil {
+		t.Errorf("got %q, %v, expected the first line and the error of the second", b, err)
+	}
+}
+
+func TestRenderEnviron(t *testing.T) {
+	env, err := RenderEnviron([]string{"URL=http://${HOST}:$PORT/", "HOST=$NAME.local", "NAME=db", "PORT=${PORT_ENV:-80}", "NAME=shadowed"})
+	if expected := "URL=http://db.local:80/ HOST=db.local NAME=db PORT=80 NAME=shadowed"; strings.Join(env, " ") != expected || err != nil {
+		t.Errorf("got %v, %v, expected %s", env, err, expected)
+	}
+	_, err = RenderEnviron([]string{"A=$B", "B=${C}", "C=$A", "D=$D", "E=$UNSET"}, WithRestrictions(parse.NoUnset))
+	for _, expected := range []string{"C: cyclic reference to A", "D: cyclic reference to D", "E: "} {
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("got %v, expected %q", err, expected)
+		}
+	}
+	vars, err := RenderEnvironMap(map[string]string{"DSN": "$USER@$HOST", "USER": "app", "HOST": "${USER}-db"})
+	if vars["DSN"] != "app@app-db" || err != nil {
+		t.Errorf("got %v, %v, expected DSN=app@app-db", vars, err)
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	fsys := fstest.MapFS{
+		"config.js":  {Data: []byte("window.API = '${API_URL}';\n"), ModTime: time.Unix(1e9, 0)},
+		"logo.png":   {Data: []byte("\x89PNG\r\n\x1a\n$API_URL")},
+		"broken.txt": {Data: []byte("${API_URL")},
+	}
+	srv := httptest.NewServer(FileServer(fsys, WithEnv([]string{"API_URL=https://api"})))
+	defer srv.Close()
+	for _, test := range []struct {
+		path, expected string
+		status         int
+	}{
+		{"/config.js", "window.API = 'https://api';\n", http.StatusOK},
+		{"/config.js", "window.API = 'https://api';\n", http.StatusOK},
+		{"/logo.png", "\x89PNG\r\n\x1a\n$API_URL", http.StatusOK},
+		{"/broken.txt", "Internal Server Error\n", http.StatusInternalServerError},
+		{"/missing.txt", "404 page not found\n", http.StatusNotFound},
+	} {
+		req, _ := http.NewRequest("GET", srv.URL+test.path, nil)
+		req.Header.Set("Range", "bytes=0-3")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != test.expected || resp.StatusCode != test.status || resp.ContentLength != int64(len(b)) {
+			t.Errorf("%s: got %d %q of length %d, expected %d %q", test.path, resp.StatusCode, b, resp.ContentLength, test.status, test.expected)
+		}
+	}
+}
+
+func TestWithRender(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.txt":   {Data: []byte("port: $PORT\n")},
+		"big.txt": {Data: []byte("name: $NAME\n")},
+	}
+	var mu sync.Mutex
+	var paths []string
+	render := WithRender(func(path, text string) (string, error) {
+		mu.Lock()
+		paths = append(paths, path)
+		mu.Unlock()
+		p := parse.New(path, []string{"PORT=80", "NAME=a long name"}, parse.Relaxed)
+		p.Limits.MaxOutput = 10
+		return p.Parse(text)
+	})
+	out := t.TempDir()
+	if _, err := RenderTree(fsys, out, render, WithEnv([]string{"PORT=1"})); err == nil || !strings.Contains(err.Error(), "output size exceeds limit") {
+		t.Errorf("got %v, expected the limit of the parser", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(out, "a.txt")); string(b) != "port: 80\n" || err != nil {
+		t.Errorf("got %q, %v, expected the output of the render function", b, err)
+	}
+	srv := httptest.NewServer(FileServer(fsys, render))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "port: 80\n" {
+		t.Errorf("got %q served, expected the output of the render function", b)
+	}
+	sort.Strings(paths)
+	if expected := "/a.txt a.txt big.txt"; strings.Join(paths, " ") != expected {
+		t.Errorf("got paths %q, expected %s", paths, expected)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	tmpl, envFile := filepath.Join(dir, "app.conf.tmpl"), filepath.Join(dir, ".env")
+	if err := os.WriteFile(tmpl, []byte("port=$PORT"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(envFile, []byte("PORT=80\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	results, err := Watch(ctx, []string{tmpl}, []string{envFile}, WithEnv([]string{"PORT=1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() WatchResult {
+		select {
+		case res := <-results:
+			return res
+		case <-time.After(10 * time.Second):
+			t.Fatal("no result")
+		}
+		return WatchResult{}
+	}
+	if res := next(); res.Output != "port=80" || res.Err != nil {
+		t.Errorf("got %+v, expected port=80", res)
+	}
+	// Files replaced by renames are followed.
+	if err := os.WriteFile(envFile+".new", []byte("PORT=8080\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(envFile+".new", envFile); err != nil {
+		t.Fatal(err)
+	}
+	if res := next(); res.Output != "port=8080" || res.Err != nil {
+		t.Errorf("got %+v, expected port=8080", res)
+	}
+	if err := os.WriteFile(tmpl, []byte("port=${PORT"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if res := next(); res.Err == nil {
+		t.Errorf("got %+v, expected a syntax error", res)
+	}
+	cancel()
+	for range results {
+	}
+}
+
+func BenchmarkString(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := String("postgres://${USER:-app}@$BAR:5432/db"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package envsubst
+
+import (
+	"bytes"
+	"io/fs"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Handler returns a handler serving the responses of h with the variables
+// of their text bodies substituted, such as those of index.html and
+// config.js, e.g. to configure a single-page application from the
+// environment of its container. The environment is the one when Handler is
+// called, unless set by the options.
+//
+// Only the successful responses of text types, including JavaScript, JSON
+// and XML, are rendered, the others are passed as they are. Requests for
+// ranges are served whole. The output of the responses with an ETag or a
+// Last-Modified header is cached by path until they change, so that files
+// served by http.FileServer are rendered once. Responses failing to render
+// are replaced with an Internal Server Error.
+func Handler(h http.Handler, opts ...Option) http.Handler {
+	return &handler{h: h, o: newOptions(opts), cache: map[string]cached{}}
+}
+
+// FileServer returns a handler serving the files of fsys like
+// http.FileServer, with the variables of the text files substituted, see
+// Handler.
+func FileServer(fsys fs.FS, opts ...Option) http.Handler {
+	return Handler(http.FileServer(http.FS(fsys)), opts...)
+}
+
+// handler renders the responses of a handler, see Handler.
+type handler struct {
+	h     http.Handler
+	o     options
+	mu    sync.Mutex
+	cache map[string]cached // outputs by path
+}
+
+// cached is the output of a response, valid while its version is the one of
+// the response.
+type cached struct {
+	version string
+	out     []byte
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Parts of templates can't be rendered.
+	if r.Header.Get("Range") != "" {
+		r = r.Clone(r.Context())
+		r.Header.Del("Range")
+	}
+	rw := &renderWriter{ResponseWriter: w}
+	h.h.ServeHTTP(rw, r)
+	if rw.through {
+		return
+	}
+	header := w.Header()
+	if rw.buf == nil {
+		// No body, as for HEAD requests, whose length is unknown.
+		if isText(header.Get("Content-Type")) {
+			header.Del("Content-Length")
+		}
+		w.WriteHeader(rw.status())
+		return
+	}
+	out, err := h.render(r.URL.Path, header.Get("ETag")+header.Get("Last-Modified"), rw.buf.Bytes())
+	if err != nil {
+		header.Del("Content-Length")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	// The validators of h remain those of the template, the output only
+	// changes with it.
+	header.Set("Content-Length", strconv.Itoa(len(out)))
+	w.WriteHeader(rw.status())
+	w.Write(out)
+}
+
+// render returns the output of the template text served for path, cached
+// while version is not empty and does not change.
+func (h *handler) render(path, version string, text []byte) ([]byte, error) {
+	if version != "" {
+		h.mu.Lock()
+		c, ok := h.cache[path]
+		h.mu.Unlock()
+		if ok && c.version == version {
+			return c.out, nil
+		}
+	}
+	s, err := h.o.renderText(path, string(text))
+	if err != nil {
+		return nil, err
+	}
+	out := []byte(s)
+	if version != "" {
+		h.mu.Lock()
+		h.cache[path] = cached{version, out}
+		h.mu.Unlock()
+	}
+	return out, nil
+}
+
+// renderWriter holds the body of a text response to render it, and passes
+// the other responses as they are.
+type renderWriter struct {
+	http.ResponseWriter
+	code    int           // status code written
+	buf     *bytes.Buffer // body of a text response
+	through bool          // the response is passed as it is
+}
+
+// status returns the status code written, 200 if none.
+func (w *renderWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+func (w *renderWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *renderWriter) Write(b []byte) (int, error) {
+	if w.buf == nil && !w.through {
+		header := w.Header()
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", http.DetectContentType(b))
+		}
+		if w.status() == http.StatusOK && isText(header.Get("Content-Type")) {
+			w.buf = new(bytes.Buffer)
+		} else {
+			w.through = true
+			w.ResponseWriter.WriteHeader(w.status())
+		}
+	}
+	if w.through {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.buf.Write(b)
+}
+
+// isText reports whether the media type of contentType is text rendered by
+// Handler.
+func isText(contentType string) bool {
+	t, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(t, "text/"), strings.HasSuffix(t, "+json"), strings.HasSuffix(t, "+xml"), strings.HasSuffix(t, "+yaml"):
+		return true
+	}
+	switch t {
+	case "application/javascript", "application/json", "application/xml", "application/yaml", "application/x-yaml":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package envsubst
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile fails, files are only mapped into memory on Unix systems.
+func mapFile(f *os.File, size int) ([]byte, func() error, error) {
+	return nil, nil, errors.ErrUnsupported
+}
@@ -0,0 +1,67 @@
+// Package oteltrace traces the rendering of templates with OpenTelemetry
+// spans, see parse.Tracer.
+package oteltrace
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/hellt/envsubst/parse"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer returns a parse.Tracer starting the spans with t as children of the
+// span of ctx, if any, e.g. that of the request a service renders for:
+//
+//	p.Tracer = oteltrace.Tracer(ctx, otel.Tracer("envsubst"))
+func Tracer(ctx context.Context, t trace.Tracer) parse.Tracer {
+	return tracer{ctx, t}
+}
+
+type tracer struct {
+	ctx context.Context
+	t   trace.Tracer
+}
+
+func (t tracer) Start(name string, attrs ...slog.Attr) parse.TraceSpan {
+	ctx, s := t.t.Start(t.ctx, name, trace.WithAttributes(attributes(attrs)...))
+	return span{tracer{ctx, t.t}, s}
+}
+
+type span struct {
+	tracer
+	s trace.Span
+}
+
+// End ends the span, recording err as an error event and status if set.
+func (s span) End(err error, attrs ...slog.Attr) {
+	s.s.SetAttributes(attributes(attrs)...)
+	if err != nil {
+		s.s.RecordError(err)
+		s.s.SetStatus(codes.Error, err.Error())
+	}
+	s.s.End()
+}
+
+// attributes converts the slog attributes to OpenTelemetry attributes.
+func attributes(attrs []slog.Attr) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		v := a.Value.Resolve()
+		switch v.Kind() {
+		case slog.KindBool:
+			kvs = append(kvs, attribute.Bool(a.Key, v.Bool()))
+		case slog.KindInt64:
+			kvs = append(kvs, attribute.Int64(a.Key, v.Int64()))
+		case slog.KindUint64:
+			kvs = append(kvs, attribute.Int64(a.Key, int64(v.Uint64())))
+		case slog.KindFloat64:
+			kvs = append(kvs, attribute.Float64(a.Key, v.Float64()))
+		default:
+			kvs = append(kvs, attribute.String(a.Key, v.String()))
+		}
+	}
+	return kvs
+}
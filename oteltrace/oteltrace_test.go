@@ -0,0 +1,55 @@
+package oteltrace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hellt/envsubst/parse"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	p := parse.New("template", []string{"A=1"}, parse.NoUnset)
+	p.Tracer = Tracer(ctx, provider.Tracer("envsubst"))
+	if _, err := p.Parse("$A $B"); err == nil {
+		t.Fatal("expected an error")
+	}
+	parent.End()
+	spans := recorder.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("got %d spans, expected 4", len(spans))
+	}
+	root := spans[2]
+	if root.Name() != "envsubst.Parse" || root.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("got %s with parent %s, expected envsubst.Parse in the request", root.Name(), root.Parent().SpanID())
+	}
+	for _, s := range spans[:2] {
+		if s.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Errorf("got %s with parent %s, expected a child of envsubst.Parse", s.Name(), s.Parent().SpanID())
+		}
+	}
+	expected := []attribute.KeyValue{
+		attribute.String("envsubst.template", "template"),
+		attribute.Int64("envsubst.template.size", 5),
+		attribute.Int64("envsubst.substitutions", 1),
+		attribute.Int64("envsubst.output.size", 0),
+	}
+	if got := root.Attributes(); len(got) != len(expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	} else {
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Errorf("got %v, expected %v", got[i], expected[i])
+			}
+		}
+	}
+	if root.Status().Code != codes.Error || len(root.Events()) != 1 {
+		t.Errorf("got status %v and events %v, expected the error", root.Status(), root.Events())
+	}
+}
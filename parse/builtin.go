@@ -0,0 +1,174 @@
+package parse
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Builtin returns the value of a pseudo-variable, such as ${__NOW}, from
+// the runtime rather than the environment. arg is the text after the colon
+// of a reference such as ${__DATE:2006-01-02}, or empty.
+type Builtin func(arg string) (string, error)
+
+// Builtins maps the names of pseudo-variables to their builtins.
+type Builtins map[string]Builtin
+
+// With returns the builtins of b and more, those of more replacing those of b
+// with the same name.
+func (b Builtins) With(more Builtins) Builtins {
+	all := make(Builtins, len(b)+len(more))
+	for name, fn := range b {
+		all[name] = fn
+	}
+	for name, fn := range more {
+		all[name] = fn
+	}
+	return all
+}
+
+// TimeBuiltins returns the pseudo-variables of the time of rendering, read
+// from now, or time.Now if nil:
+//
+//	__NOW           the time in RFC 3339 format, e.g. 2024-05-01T12:00:00Z
+//	__DATE[:LAYOUT] the time in the Go layout, 2006-01-02 by default
+//	__UNIX_TS       the seconds since the Unix epoch
+func TimeBuiltins(now func() time.Time) Builtins {
+	if now == nil {
+		now = time.Now
+	}
+	return Builtins{
+		"__NOW": func(arg string) (string, error) {
+			if arg != "" {
+				return "", noArgument("__NOW")
+			}
+			return now().Format(time.RFC3339), nil
+		},
+		"__DATE": func(arg string) (string, error) {
+			if arg == "" {
+				arg = "2006-01-02"
+			}
+			return now().Format(arg), nil
+		},
+		"__UNIX_TS": func(arg string) (string, error) {
+			if arg != "" {
+				return "", noArgument("__UNIX_TS")
+			}
+			return strconv.FormatInt(now().Unix(), 10), nil
+		},
+	}
+}
+
+// HostBuiltins returns the pseudo-variables of the host rendering:
+//
+//	__HOSTNAME  the host name reported by the kernel
+//	__OS        the operating system, e.g. linux, as runtime.GOOS
+//	__ARCH      the architecture, e.g. amd64, as runtime.GOARCH
+//	__UID       the numeric user id of the process, -1 on Windows
+func HostBuiltins() Builtins {
+	plain := func(name string, value func() (string, error)) Builtin {
+		return func(arg string) (string, error) {
+			if arg != "" {
+				return "", noArgument(name)
+			}
+			return value()
+		}
+	}
+	return Builtins{
+		"__HOSTNAME": plain("__HOSTNAME", os.Hostname),
+		"__OS":       plain("__OS", func() (string, error) { return runtime.GOOS, nil }),
+		"__ARCH":     plain("__ARCH", func() (string, error) { return runtime.GOARCH, nil }),
+		"__UID":      plain("__UID", func() (string, error) { return strconv.Itoa(os.Getuid()), nil }),
+	}
+}
+
+// RandomBuiltins returns the pseudo-variables generating random values from
+// the bytes read from r, crypto/rand.Reader if nil. A seeded generator such
+// as math/rand.New(rand.NewSource(1)) makes them reproducible, for tests.
+// Each reference has a new value.
+//
+//	__UUID                   a random version 4 UUID
+//	__RANDOM[:ENCODING[:N]]  N random characters, 16 by default, in the
+//	                         encoding hex (default), alnum or base64, the
+//	                         URL-safe alphabet
+func RandomBuiltins(r io.Reader) Builtins {
+	if r == nil {
+		r = rand.Reader
+	}
+	return Builtins{
+		"__UUID": func(arg string) (string, error) {
+			if arg != "" {
+				return "", noArgument("__UUID")
+			}
+			var b [16]byte
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return "", err
+			}
+			b[6] = b[6]&0x0f | 0x40 // version 4
+			b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+			h := hex.EncodeToString(b[:])
+			return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+		},
+		"__RANDOM": func(arg string) (string, error) {
+			encoding, length, _ := strings.Cut(arg, ":")
+			n := 16
+			if length != "" {
+				var err error
+				if n, err = strconv.Atoi(length); err != nil || n < 1 || n > 1024 {
+					return "", fmt.Errorf("__RANDOM: invalid length %q, expected 1 to 1024", length)
+				}
+			}
+			return random(r, encoding, n)
+		},
+	}
+}
+
+// alnum are the characters of the alnum encoding of random.
+const alnum = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// random returns n random characters of the encoding read from r.
+func random(r io.Reader, encoding string, n int) (string, error) {
+	switch encoding {
+	case "", "hex":
+		b := make([]byte, (n+1)/2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(b)[:n], nil
+	case "base64":
+		b := make([]byte, (n*3+3)/4)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		return base64.RawURLEncoding.EncodeToString(b)[:n], nil
+	case "alnum":
+		out := make([]byte, 0, n)
+		b := make([]byte, n)
+		for len(out) < n {
+			if _, err := io.ReadFull(r, b); err != nil {
+				return "", err
+			}
+			for _, c := range b {
+				// Bytes past the largest multiple of the alphabet
+				// size are skipped so that all characters are
+				// equally likely.
+				if c < 248 && len(out) < n {
+					out = append(out, alnum[c%62])
+				}
+			}
+		}
+		return string(out), nil
+	}
+	return "", fmt.Errorf("__RANDOM: unknown encoding %q, expected hex, alnum or base64", encoding)
+}
+
+func noArgument(name string) error {
+	return fmt.Errorf("%s takes no argument", name)
+}
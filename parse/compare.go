@@ -0,0 +1,113 @@
+package parse
+
+// Change is a substitution whose value differs between two renderings of a
+// template, see Parser.Compare.
+type Change struct {
+	Template string // name of the template, that of the file for included files
+	Variable string // variable, or reference resolved
+	Line     int    // 1-based line number
+	Col      int    // 1-based column, counted in bytes
+	// Before and After are the substitutions of the renderings, nil if
+	// the reference was not substituted in one of them, e.g. because the
+	// variable is not set or the block it is in was dropped.
+	Before, After *Substitution
+}
+
+// Changes returns the substitutions of two renderings of templates, as
+// recorded by Parser.Audit, whose values differ. Substitutions are matched
+// by template, position and variable, in order for the repetitions of
+// foreach blocks. The changes are in the order of before, followed by the
+// substitutions only made in after.
+func Changes(before, after []Substitution) []Change {
+	type key struct {
+		template, variable string
+		line, col, n       int
+	}
+	keyOf := func(seen map[key]int, s Substitution) key {
+		k := key{s.Template, s.Variable, s.Line, s.Col, 0}
+		k.n = seen[k]
+		seen[k]++
+		return k
+	}
+	afterSeen := map[key]int{}
+	made := map[key]*Substitution{}
+	for i := range after {
+		made[keyOf(afterSeen, after[i])] = &after[i]
+	}
+	var changes []Change
+	beforeSeen := map[key]int{}
+	matched := map[*Substitution]bool{}
+	for i := range before {
+		b := &before[i]
+		a := made[keyOf(beforeSeen, *b)]
+		if a != nil {
+			matched[a] = true
+			if a.Value == b.Value {
+				continue
+			}
+		}
+		changes = append(changes, Change{b.Template, b.Variable, b.Line, b.Col, b, a})
+	}
+	for i := range after {
+		if a := &after[i]; !matched[a] {
+			changes = append(changes, Change{a.Template, a.Variable, a.Line, a.Col, nil, a})
+		}
+	}
+	return changes
+}
+
+// Compare renders text with p in the environments before and after and
+// returns the substitutions whose values differ, see Changes, to review the
+// effect of changing the environment, such as promoting the variables of a
+// staging environment to production. The values of the variables matching
+// p.Mask are Masked, including in defaults, once compared. It fails if one
+// of the renderings fails.
+func (p *Parser) Compare(text string, before, after Env) ([]Change, error) {
+	render := func(env Env) ([]Substitution, error) {
+		var subs []Substitution
+		q := *p
+		q.Env = env
+		q.Mask = nil
+		q.Transform = nil
+		q.Provenance = nil
+		q.Lock = nil
+		q.Audit = func(s Substitution) { subs = append(subs, s) }
+		if _, err := q.Parse(text); err != nil {
+			return nil, err
+		}
+		return subs, nil
+	}
+	b, err := render(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := render(after)
+	if err != nil {
+		return nil, err
+	}
+	changes := Changes(b, a)
+	if p.Mask != nil {
+		p.maskIn(changes, before, func(c *Change) *Substitution { return c.Before })
+		p.maskIn(changes, after, func(c *Change) *Substitution { return c.After })
+	}
+	return changes, nil
+}
+
+// maskIn masks the values of the substitutions of the changes rendered
+// with env, as Audit records them.
+func (p *Parser) maskIn(changes []Change, env Env, sub func(*Change) *Substitution) {
+	q := *p
+	q.Env = env
+	q.masks = nil
+	r := q.masker()
+	for i := range changes {
+		s := sub(&changes[i])
+		switch {
+		case s == nil:
+		case p.Mask(s.Variable):
+			s.Value = Masked
+		case r != nil:
+			s.Value = r.Replace(s.Value)
+		}
+	}
+}
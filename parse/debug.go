@@ -0,0 +1,132 @@
+package parse
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// operators are the names of the operators of substitutions.
+var operators = map[itemType]string{
+	itemPlus:        "+",
+	itemDash:        "-",
+	itemEquals:      "=",
+	itemColonEquals: ":=",
+	itemColonDash:   ":-",
+	itemColonPlus:   ":+",
+}
+
+// DumpTokens writes the tokens the lexer scans text into to w, one per line
+// with its line, column, type and quoted value, for diagnosing how the
+// escapes and operators of a template are read. The restrictions and regions
+// of p apply as when parsing. It returns the syntax error the lexer stopped
+// at, if any.
+func (p *Parser) DumpTokens(w io.Writer, text string) error {
+	l := lex(text, p.Restrict.NoDigit, p.Directives, p.Regions)
+	for {
+		t := l.nextItem()
+		typ, ok := tokens[t.typ]
+		if !ok {
+			typ = "OP"
+		}
+		line, col := position(text, t.pos)
+		if _, err := fmt.Fprintf(w, "%d:%d\t%s\t%q\n", line, col, typ, t.val); err != nil {
+			return err
+		}
+		switch t.typ {
+		case itemEOF:
+			return nil
+		case itemError:
+			return p.locate(p.errorf(t), text)
+		}
+	}
+}
+
+// DumpTree writes the nodes text parses into to w, one per line with its
+// line, column and content, indented under the node containing it. Nothing
+// is substituted.
+func (p *Parser) DumpTree(w io.Writer, text string) error {
+	p.lex = lex(text, p.Restrict.NoDigit, p.Directives, p.Regions)
+	p.nodes = make([]Node, 0)
+	p.peekCount = 0
+	if err := p.parse(); err != nil {
+		return p.locate(err, text)
+	}
+	d := &dumper{w: w, text: text}
+	d.nodes(p.nodes, 0)
+	return d.err
+}
+
+// dumper writes the nodes of a template, see DumpTree.
+type dumper struct {
+	w    io.Writer
+	text string
+	err  error
+}
+
+// printf writes a line about the node at pos at the indentation depth.
+func (d *dumper) printf(depth int, pos Pos, format string, args ...interface{}) {
+	if d.err != nil {
+		return
+	}
+	line, col := position(d.text, pos)
+	_, d.err = fmt.Fprintf(d.w, "%d:%d\t%s%s\n", line, col, strings.Repeat("  ", depth), fmt.Sprintf(format, args...))
+}
+
+func (d *dumper) nodes(nodes []Node, depth int) {
+	for _, n := range nodes {
+		d.node(n, depth)
+	}
+}
+
+func (d *dumper) node(n Node, depth int) {
+	switch n := n.(type) {
+	case *TextNode:
+		d.printf(depth, n.Pos, "Text %q", n.Text)
+	case *VariableNode:
+		switch {
+		case n.builtin != nil && n.arg != "":
+			d.printf(depth, n.Pos, "Builtin %s arg=%q", n.Ident, n.arg)
+		case n.builtin != nil:
+			d.printf(depth, n.Pos, "Builtin %s", n.Ident)
+		case n.replaced != "":
+			d.printf(depth, n.Pos, "Variable %s replacing %s", n.Ident, n.replaced)
+		default:
+			d.printf(depth, n.Pos, "Variable %s", n.Ident)
+		}
+	case *SubstitutionNode:
+		if op, ok := operators[n.ExpType]; ok {
+			d.printf(depth, n.Pos, "Substitution op=%q", op)
+		} else {
+			d.printf(depth, n.Pos, "Substitution")
+		}
+		d.node(n.Variable, depth+1)
+		if n.Default != nil {
+			d.node(n.Default, depth+1)
+		}
+		for _, f := range n.Filters {
+			d.printf(depth+1, f.Pos, "Filter %s %q", f.Name, f.Args)
+		}
+	case *ResolveNode:
+		d.printf(depth, n.Pos, "Resolve %s:%s", n.Scheme, n.Ref)
+	case *IncludeNode:
+		d.printf(depth, n.Pos, "Include %s", n.Path)
+	case *IfNode:
+		cond := n.Name
+		if n.Negate {
+			cond = "!" + cond
+		}
+		d.printf(depth, n.Pos, "If %s", cond)
+		d.nodes(n.Then, depth+1)
+		if len(n.Else) > 0 {
+			d.printf(depth, n.thenEnd, "Else")
+			d.nodes(n.Else, depth+1)
+		}
+	case *ForeachNode:
+		d.printf(depth, n.Pos, "Foreach %s in %s sep=%q", n.Item, n.List, n.Sep)
+		d.nodes(n.Body, depth+1)
+	case *OnlyNode:
+		d.printf(depth, n.Pos, "Only")
+		d.nodes(n.Body, depth+1)
+	}
+}
@@ -0,0 +1,80 @@
+package parse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// KindDeprecated is the kind of the errors of references to deprecated
+// variables, see Parser.Deprecated.
+const KindDeprecated ErrorKind = "deprecated"
+
+// deprecation checks the reference to a deprecated variable of node in text,
+// logging a warning with the Logger or returning an error if
+// p.FailDeprecated is set.
+func (p *Parser) deprecation(node Node, text string) error {
+	if p.Deprecated == nil {
+		return nil
+	}
+	var v *VariableNode
+	switch n := node.(type) {
+	case *VariableNode:
+		v = n
+	case *SubstitutionNode:
+		v = n.Variable
+	default:
+		return nil
+	}
+	name := v.Ident
+	if v.replaced != "" {
+		name = v.replaced
+	}
+	replacement, ok := p.Deprecated[name]
+	if !ok {
+		return nil
+	}
+	msg := fmt.Sprintf("variable ${%s} is deprecated", name)
+	if replacement != "" {
+		msg += fmt.Sprintf(", use ${%s}", replacement)
+	}
+	if p.FailDeprecated {
+		return &Error{Pos: node.Position(), Variable: name, Kind: KindDeprecated, Msg: msg}
+	}
+	if p.Logger != nil {
+		line, col := position(text, node.Position())
+		p.Logger.Warn(msg, "template", p.Name, "variable", name, "line", line, "col", col)
+	}
+	return nil
+}
+
+// MapDeprecated returns env with the replacements of the deprecated variables
+// set in it, see Parser.Deprecated, set to their values unless set already,
+// so templates using the new names work with environments setting the old
+// ones. It also returns the names of the deprecated variables set, sorted.
+func MapDeprecated(env []string, deprecated map[string]string) ([]string, []string) {
+	var mapped, used []string
+	for old, replacement := range deprecated {
+		value, ok := Env(env).Lookup(old)
+		if !ok {
+			continue
+		}
+		used = append(used, old)
+		if replacement != "" && !Env(env).Has(replacement) {
+			mapped = append(mapped, replacement+"="+value)
+		}
+	}
+	sort.Strings(used)
+	sort.Strings(mapped)
+	return append(mapped, env...), used
+}
+
+// ParseDeprecation parses a deprecation given as OLD=NEW, or OLD if the
+// variable has no replacement.
+func ParseDeprecation(s string) (old, replacement string, err error) {
+	old, replacement, _ = strings.Cut(s, "=")
+	if !isName(old) || replacement != "" && !isName(replacement) {
+		return "", "", fmt.Errorf("expected OLD=NEW or OLD, got %q", s)
+	}
+	return old, replacement, nil
+}
@@ -0,0 +1,344 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IfNode is a block of lines kept or dropped depending on a variable:
+//
+//	#envsubst if FEATURE_X
+//	feature_x: enabled
+//	#envsubst else
+//	feature_x: disabled
+//	#envsubst endif
+//
+// The condition holds if the variable is set to a value other than the
+// empty string, 0, false, no and off, ignoring case. It is negated by a
+// leading !, as in #envsubst if !FEATURE_X. The else block is optional.
+type IfNode struct {
+	NodeType
+	Pos
+	Name   string // variable of the condition
+	Negate bool   // the condition holds if the variable is not true
+	Env    Env
+	Then   []Node
+	Else   []Node
+	// offsets of the blocks: the then block spans from thenStart to
+	// thenEnd, the else block from elseStart to elseEnd and the endif
+	// line ends at end
+	thenStart, thenEnd, elseStart, elseEnd, end Pos
+}
+
+// Span is a part of the input.
+type Span struct {
+	Start, End Pos // byte offsets of the span in the input
+}
+
+// String renders the block chosen by the condition.
+func (t *IfNode) String() (string, error) {
+	var out strings.Builder
+	nodes, _ := t.branch()
+	for _, n := range nodes {
+		s, err := n.String()
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(s)
+	}
+	return out.String(), nil
+}
+
+// branch returns the block chosen by the condition and the parts of the
+// input dropped with the other one, including the directive lines.
+func (t *IfNode) branch() ([]Node, []Span) {
+	if isTrue(t.Env, t.Name) != t.Negate {
+		return t.Then, []Span{{t.Pos, t.thenStart}, {t.thenEnd, t.end}}
+	}
+	return t.Else, []Span{{t.Pos, t.elseStart}, {t.elseEnd, t.end}}
+}
+
+// ForeachNode is a block of lines repeated for each element of a list held
+// by a variable:
+//
+//	#envsubst foreach NODE in $NODES
+//	server-${NODE_INDEX} = ${NODE}
+//	#envsubst endforeach
+//
+// The elements are separated by commas or the separator given after sep, as
+// in #envsubst foreach NODE in $NODES sep ;, and have white space trimmed.
+// Each repetition sets the variable named after the item to the element and
+// the one suffixed with _INDEX to its index, starting at 0. The block is
+// dropped if the list is unset or empty.
+type ForeachNode struct {
+	NodeType
+	Pos
+	Item string // variable set to each element
+	List string // variable holding the list
+	Sep  string // separator of the elements
+	Env  Env
+	Body []Node
+	// offsets of the body and of the end of the endforeach line
+	bodyStart, bodyEnd, end Pos
+}
+
+// String renders the body for each element of the list.
+func (t *ForeachNode) String() (string, error) {
+	var out strings.Builder
+	for _, env := range t.iterations() {
+		setEnv(t.Body, env)
+		for _, n := range t.Body {
+			s, err := n.String()
+			if err != nil {
+				setEnv(t.Body, t.Env)
+				return "", err
+			}
+			out.WriteString(s)
+		}
+	}
+	setEnv(t.Body, t.Env)
+	return out.String(), nil
+}
+
+// iterations returns the environment of each repetition of the body.
+func (t *ForeachNode) iterations() []Env {
+	list := t.Env.Get(t.List)
+	if strings.TrimSpace(list) == "" {
+		return nil
+	}
+	elems := strings.Split(list, t.Sep)
+	envs := make([]Env, len(elems))
+	for i, e := range elems {
+		envs[i] = append(Env{t.Item + "=" + strings.TrimSpace(e), fmt.Sprintf("%s_INDEX=%d", t.Item, i)}, t.Env...)
+	}
+	return envs
+}
+
+// dropped returns the parts of the input dropped when rendering the block:
+// the directive lines, or the whole block if there are no repetitions.
+func (t *ForeachNode) dropped(repeated bool) []Span {
+	if !repeated {
+		return []Span{{t.Pos, t.end}}
+	}
+	return []Span{{t.Pos, t.bodyStart}, {t.bodyEnd, t.end}}
+}
+
+// OnlyNode is a block of lines restricting the substitutions of the
+// template to the blocks of its kind, opened and closed by markers in
+// comments on lines of their own:
+//
+//	# envsubst:only
+//	image: ${IMAGE}
+//	# envsubst:end
+//
+// Once a template has such a block, the references outside of them are
+// left as they are, $$ included, so that large files with a small templated
+// section are not modified elsewhere. The markers are kept as they are too,
+// with or without Parser.Directives. The blocks do not nest.
+type OnlyNode struct {
+	NodeType
+	Pos
+	Body []Node // the lines of the markers included
+}
+
+// String renders the body.
+func (t *OnlyNode) String() (string, error) {
+	var out strings.Builder
+	for _, n := range t.Body {
+		s, err := n.String()
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(s)
+	}
+	return out.String(), nil
+}
+
+// HasOnly reports whether text has a # envsubst:only line, restricting the
+// substitutions to the blocks it opens, see OnlyNode.
+func HasOnly(text string) bool {
+	for i := 0; ; {
+		j := strings.Index(text[i:], markerOnly)
+		if j < 0 {
+			return false
+		}
+		j += i
+		start := strings.LastIndexByte(text[:j], '\n') + 1
+		if marker(text[start:]) == markerOnly {
+			return true
+		}
+		i = j + len(markerOnly)
+	}
+}
+
+// setEnv makes nodes look up their variables in env.
+func setEnv(nodes []Node, env Env) {
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *VariableNode:
+			n.Env = env
+		case *SubstitutionNode:
+			setEnv([]Node{n.Variable}, env)
+			if n.Default != nil {
+				setEnv([]Node{n.Default}, env)
+			}
+		case *IncludeNode:
+			n.Env = env
+		case *IfNode:
+			n.Env = env
+			setEnv(n.Then, env)
+			setEnv(n.Else, env)
+		case *ForeachNode:
+			n.Env = env
+			setEnv(n.Body, env)
+		case *OnlyNode:
+			setEnv(n.Body, env)
+		}
+	}
+}
+
+// isTrue reports whether the variable name is set to a true value.
+func isTrue(env Env, name string) bool {
+	value, ok := env.Lookup(name)
+	if !ok {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "0", "false", "no", "off":
+		return false
+	}
+	return true
+}
+
+// DirectiveNesting returns 1 if line opens a block of directives, -1 if it
+// closes one and 0 otherwise. Line-oriented callers use it to find the end
+// of a block before parsing it as a whole.
+func DirectiveNesting(line string) int {
+	if !isDirective(line) && marker(line) == "" {
+		return 0
+	}
+	switch name, _ := directive(line); name {
+	case "if", "foreach", markerOnly:
+		return 1
+	case "endif", "endforeach", markerEnd:
+		return -1
+	}
+	return 0
+}
+
+// directive splits a directive line into the name of the directive and its
+// arguments. The name of a marker line is its marker.
+func directive(line string) (string, []string) {
+	if m := marker(line); m != "" {
+		return m, nil
+	}
+	fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(line), directivePrefix))
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], fields[1:]
+}
+
+// parseDirective parses the block opened by the directive in item t.
+func (p *Parser) parseDirective(t item) (Node, error) {
+	name, args := directive(t.val)
+	switch name {
+	case "if":
+		if len(args) != 1 || !isName(strings.TrimPrefix(args[0], "!")) {
+			return nil, p.directiveErrorf(t, "expected #envsubst if NAME or #envsubst if !NAME")
+		}
+		n := &IfNode{NodeType: NodeIf, Pos: t.pos, Name: strings.TrimPrefix(args[0], "!"),
+			Negate: strings.HasPrefix(args[0], "!"), Env: p.Env}
+		nodes, end, err := p.parseNodes()
+		if err != nil {
+			return nil, err
+		}
+		n.Then = nodes
+		n.thenStart = t.pos + Pos(len(t.val))
+		n.thenEnd, n.elseStart, n.elseEnd = end.pos, end.pos, end.pos
+		if endName, _ := directive(end.val); endName == "else" {
+			n.elseStart = end.pos + Pos(len(end.val))
+			if n.Else, end, err = p.parseNodes(); err != nil {
+				return nil, err
+			}
+			n.elseEnd = end.pos
+		}
+		n.end = end.pos + Pos(len(end.val))
+		switch endName, endArgs := directive(end.val); {
+		case end.typ == itemEOF:
+			return nil, p.directiveErrorf(t, "missing #envsubst endif")
+		case endName != "endif" || len(endArgs) > 0:
+			return nil, p.directiveErrorf(end, "unexpected %s", strings.TrimSpace(end.val))
+		}
+		return n, nil
+	case "foreach":
+		usage := "expected #envsubst foreach NAME in $LIST [sep SEPARATOR]"
+		if len(args) != 3 && len(args) != 5 || args[1] != "in" || len(args) == 5 && args[3] != "sep" {
+			return nil, p.directiveErrorf(t, usage)
+		}
+		list := strings.TrimPrefix(args[2], "$")
+		if strings.HasPrefix(list, "{") && strings.HasSuffix(list, "}") {
+			list = list[1 : len(list)-1]
+		}
+		if !isName(args[0]) || !isName(list) {
+			return nil, p.directiveErrorf(t, usage)
+		}
+		n := &ForeachNode{NodeType: NodeForeach, Pos: t.pos, Item: args[0], List: list, Sep: ",", Env: p.Env}
+		if len(args) == 5 {
+			n.Sep = args[4]
+		}
+		nodes, end, err := p.parseNodes()
+		if err != nil {
+			return nil, err
+		}
+		n.Body = nodes
+		n.bodyStart, n.bodyEnd, n.end = t.pos+Pos(len(t.val)), end.pos, end.pos+Pos(len(end.val))
+		switch endName, endArgs := directive(end.val); {
+		case end.typ == itemEOF:
+			return nil, p.directiveErrorf(t, "missing #envsubst endforeach")
+		case endName != "endforeach" || len(endArgs) > 0:
+			return nil, p.directiveErrorf(end, "unexpected %s", strings.TrimSpace(end.val))
+		}
+		return n, nil
+	case markerOnly:
+		if p.only {
+			return nil, p.directiveErrorf(t, "nested # %s", markerOnly)
+		}
+		p.only = true
+		nodes, end, err := p.parseNodes()
+		p.only = false
+		if err != nil {
+			return nil, err
+		}
+		switch endName, endArgs := directive(end.val); {
+		case end.typ == itemEOF:
+			return nil, p.directiveErrorf(t, "missing # %s", markerEnd)
+		case endName != markerEnd || len(endArgs) > 0:
+			return nil, p.directiveErrorf(end, "unexpected %s", strings.TrimSpace(end.val))
+		}
+		// The markers are rendered as text.
+		n := &OnlyNode{NodeType: NodeOnly, Pos: t.pos}
+		n.Body = append(append([]Node{p.newText(t)}, nodes...), p.newText(end))
+		return n, nil
+	case "else", "endif", "endforeach", markerEnd:
+		return nil, p.directiveErrorf(t, "unexpected %s", strings.TrimSpace(t.val))
+	}
+	return nil, p.directiveErrorf(t, "unknown directive %q", name)
+}
+
+func (p *Parser) directiveErrorf(t item, format string, args ...interface{}) error {
+	return &Error{Pos: t.pos, Kind: KindSyntax, Msg: fmt.Sprintf(format, args...)}
+}
+
+// isName reports whether s is a valid variable name.
+func isName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !isAlphaNumeric(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,31 @@
+package parse
+
+import "fmt"
+
+// KindEmptyDefault is the kind of the errors of defaults substituted for
+// variables that are set but empty, see Parser.FailEmptyDefault.
+const KindEmptyDefault ErrorKind = "empty-default"
+
+// emptyDefault checks whether node in text substitutes its default because
+// its variable is set but empty, as ${VAR:-default} does, which often hides a
+// misconfigured secret such as a CI variable set to nothing. It logs a
+// warning with the Logger, or returns an error if p.FailEmptyDefault is set.
+func (p *Parser) emptyDefault(node Node, text string) error {
+	n, ok := node.(*SubstitutionNode)
+	if !ok || n.Variable.builtin != nil || n.ExpType != itemColonDash && n.ExpType != itemColonEquals {
+		return nil
+	}
+	if value, set := n.Variable.Env.Lookup(n.Variable.Ident); !set || value != "" || !substituted(n) {
+		return nil
+	}
+	name := n.Variable.Ident
+	msg := fmt.Sprintf("variable ${%s} is set but empty, its default is substituted", name)
+	if p.FailEmptyDefault {
+		return &Error{Pos: node.Position(), Variable: name, Kind: KindEmptyDefault, Msg: msg}
+	}
+	if p.Logger != nil {
+		line, col := position(text, node.Position())
+		p.Logger.Warn(msg, "template", p.Name, "variable", name, "line", line, "col", col)
+	}
+	return nil
+}
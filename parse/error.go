@@ -0,0 +1,166 @@
+package parse
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrorKind classifies the failures reported by the parser.
+type ErrorKind string
+
+// Kinds of errors reported by the parser
+const (
+	KindUnset  ErrorKind = "unset"  // variable is not set
+	KindEmpty  ErrorKind = "empty"  // variable is set but empty
+	KindSyntax ErrorKind = "syntax" // malformed substitution
+	KindLimit  ErrorKind = "limit"  // resource limit exceeded
+)
+
+// codes are the stable codes of the error kinds.
+var codes = map[ErrorKind]string{
+	KindUnset:        "ENV001",
+	KindEmpty:        "ENV002",
+	KindSyntax:       "ENV003",
+	KindLimit:        "ENV004",
+	KindResolve:      "ENV005",
+	KindSchema:       "ENV006",
+	KindDeprecated:   "ENV007",
+	KindPolicy:       "ENV011",
+	KindEmptyDefault: "ENV013",
+}
+
+// Code returns the stable code of k, such as ENV001 for KindUnset, for tools
+// selecting errors by kind, or the empty string for unknown kinds. Codes are
+// never reused for other kinds.
+func (k ErrorKind) Code() string {
+	return codes[k]
+}
+
+// Errors of the limits, see Limits, wrapped by the KindLimit errors so that
+// they can be told apart with errors.Is.
+var (
+	ErrInputTooLarge  = errors.New("input too large")        // see Limits.MaxInput
+	ErrOutputTooLarge = errors.New("output too large")       // see Limits.MaxOutput
+	ErrDepthExceeded  = errors.New("include depth exceeded") // see Limits.MaxDepth
+)
+
+// Error describes a single failure and where in the input it happened.
+type Error struct {
+	Name     string    // name of the processing template
+	Pos      Pos       // byte offset of the failure in the input
+	Line     int       // 1-based line number
+	Col      int       // 1-based column, counted in bytes
+	Variable string    // variable the failure refers to, if any
+	Kind     ErrorKind // kind of failure
+	Msg      string    // human readable description, see Message
+	Err      error     // error causing the failure, such as ErrOutputTooLarge, if any
+	// format of the description with Variable, formatted by Message if Msg
+	// is empty, so that the many errors of variables in AllErrors mode only
+	// cost their formatting if read
+	format string
+}
+
+func (e *Error) Error() string {
+	return e.Message()
+}
+
+// Message returns the human readable description of e: Msg, or the one
+// formatted by the parser on demand if Msg is empty.
+func (e *Error) Message() string {
+	if e.Msg == "" && e.format != "" {
+		return fmt.Sprintf(e.format, e.Variable)
+	}
+	return e.Msg
+}
+
+// Code returns the stable code of the kind of e, see ErrorKind.Code.
+func (e *Error) Code() string {
+	return e.Kind.Code()
+}
+
+// Unwrap returns the error causing e, if any.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorList is the collection of failures returned in AllErrors mode.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	var b strings.Builder
+	for i, err := range l {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// Unwrap returns the errors of l, so that errors.Is and errors.As find the
+// causes of any of them.
+func (l ErrorList) Unwrap() []error {
+	errs := make([]error, len(l))
+	for i, err := range l {
+		errs[i] = err
+	}
+	return errs
+}
+
+// locate fills in the name and line/column information of err
+// from its position in text.
+func (e *Error) locate(name, text string) *Error {
+	e.Name = name
+	if int(e.Pos) > len(text) {
+		e.Pos = Pos(len(text))
+	}
+	e.Line, e.Col = position(text, e.Pos)
+	return e
+}
+
+// locate fills in the name and line/column information of the errors of l
+// from their positions in text, in one pass over text rather than one for
+// each error.
+func (l ErrorList) locate(name, text string) ErrorList {
+	order := make([]*Error, len(l))
+	copy(order, l)
+	sort.SliceStable(order, func(i, j int) bool { return order[i].Pos < order[j].Pos })
+	loc := locator{text: text, line: 1}
+	for _, e := range order {
+		e.Name = name
+		if int(e.Pos) > len(text) {
+			e.Pos = Pos(len(text))
+		}
+		e.Line, e.Col = loc.position(e.Pos)
+	}
+	return l
+}
+
+// locator returns the lines and columns of increasing positions in text,
+// counting the lines from the last position rather than from the start.
+type locator struct {
+	text  string
+	pos   Pos // last position located
+	line  int // its line
+	start Pos // position of the start of the line
+}
+
+// position returns the 1-based line and column of pos, at or after the last
+// position located.
+func (l *locator) position(pos Pos) (line, col int) {
+	between := l.text[l.pos:pos]
+	l.line += strings.Count(between, "\n")
+	if i := strings.LastIndexByte(between, '\n'); i >= 0 {
+		l.start = l.pos + Pos(i+1)
+	}
+	l.pos = pos
+	return l.line, int(pos-l.start) + 1
+}
+
+// position returns the 1-based line and column of pos in text.
+func position(text string, pos Pos) (line, col int) {
+	before := text[:pos]
+	return 1 + strings.Count(before, "\n"), int(pos) - strings.LastIndexByte(before, '\n')
+}
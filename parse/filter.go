@@ -0,0 +1,167 @@
+package parse
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Filter is a filter applied to the value of a substitution,
+// such as upper in ${NAME|upper}.
+type Filter struct {
+	Pos
+	Name string
+	Args []string
+	fn   FilterFunc
+}
+
+// FilterFunc is the function of a filter. It returns value filtered
+// according to the arguments of the filter.
+type FilterFunc func(value string, args ...string) (string, error)
+
+// Filters maps the names of filters to their functions.
+type Filters map[string]FilterFunc
+
+// With returns the filters of f and more, with those of more taking
+// precedence.
+func (f Filters) With(more Filters) Filters {
+	all := make(Filters, len(f)+len(more))
+	for name, fn := range f {
+		all[name] = fn
+	}
+	for name, fn := range more {
+		all[name] = fn
+	}
+	return all
+}
+
+// DefaultFilters are the filters of parsers without Filters of their own.
+var DefaultFilters = Filters{
+	"upper": func(value string, args ...string) (string, error) {
+		if err := wantArgs(args, 0); err != nil {
+			return "", err
+		}
+		return strings.ToUpper(value), nil
+	},
+	"lower": func(value string, args ...string) (string, error) {
+		if err := wantArgs(args, 0); err != nil {
+			return "", err
+		}
+		return strings.ToLower(value), nil
+	},
+	// trim removes leading and trailing white space, or the characters
+	// of its argument.
+	"trim": func(value string, args ...string) (string, error) {
+		if len(args) > 1 {
+			return "", fmt.Errorf("expected at most 1 argument, got %d", len(args))
+		}
+		if len(args) == 1 {
+			return strings.Trim(value, args[0]), nil
+		}
+		return strings.TrimSpace(value), nil
+	},
+	// replace replaces all occurrences of its first argument with its second.
+	"replace": func(value string, args ...string) (string, error) {
+		if err := wantArgs(args, 2); err != nil {
+			return "", err
+		}
+		return strings.ReplaceAll(value, args[0], args[1]), nil
+	},
+	// default replaces an empty value with its argument.
+	"default": func(value string, args ...string) (string, error) {
+		if err := wantArgs(args, 1); err != nil {
+			return "", err
+		}
+		if value == "" {
+			return args[0], nil
+		}
+		return value, nil
+	},
+	"b64enc": func(value string, args ...string) (string, error) {
+		if err := wantArgs(args, 0); err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString([]byte(value)), nil
+	},
+	"b64dec": func(value string, args ...string) (string, error) {
+		if err := wantArgs(args, 0); err != nil {
+			return "", err
+		}
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return "", fmt.Errorf("invalid base64: %v", err)
+		}
+		return string(b), nil
+	},
+}
+
+// RegisterFilter adds the filter fn to the DefaultFilters under name,
+// replacing a filter of the same name. It is meant to be called from init
+// functions, not concurrently with parsing.
+func RegisterFilter(name string, fn FilterFunc) {
+	DefaultFilters[name] = fn
+}
+
+// wantArgs checks that a filter got n arguments.
+func wantArgs(args []string, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("expected %d arguments, got %d", n, len(args))
+	}
+	return nil
+}
+
+// newFilter parses the filter text, its name followed by its space separated
+// arguments, which may be double-quoted. pos is the position of the text.
+func newFilter(text string, pos Pos, filters Filters) (*Filter, error) {
+	fields, err := splitArgs(text)
+	if err != nil {
+		return nil, &Error{Pos: pos, Kind: KindSyntax, Msg: fmt.Sprintf("filter %q: %v", text, err)}
+	}
+	if len(fields) == 0 {
+		return nil, &Error{Pos: pos, Kind: KindSyntax, Msg: "filter name expected"}
+	}
+	fn, ok := filters[fields[0]]
+	if !ok {
+		return nil, &Error{Pos: pos, Kind: KindSyntax, Msg: fmt.Sprintf("unknown filter %q", fields[0])}
+	}
+	return &Filter{Pos: pos, Name: fields[0], Args: fields[1:], fn: fn}, nil
+}
+
+// apply applies the filter to value, the value of variable.
+func (f *Filter) apply(variable, value string) (string, error) {
+	value, err := f.fn(value, f.Args...)
+	if err != nil {
+		return "", &Error{Pos: f.Pos, Variable: variable, Kind: KindSyntax,
+			Msg: fmt.Sprintf("filter %s of ${%s}: %v", f.Name, variable, err)}
+	}
+	return value, nil
+}
+
+// splitArgs splits s into fields separated by spaces. Double-quoted fields
+// may contain spaces and are unquoted like Go strings.
+func splitArgs(s string) ([]string, error) {
+	var fields []string
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return fields, nil
+		}
+		if s[0] != '"' {
+			end := strings.IndexAny(s, " \t")
+			if end < 0 {
+				end = len(s)
+			}
+			fields = append(fields, s[:end])
+			s = s[end:]
+			continue
+		}
+		quoted, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("unterminated quoted argument")
+		}
+		field, _ := strconv.Unquote(quoted)
+		fields = append(fields, field)
+		s = s[len(quoted):]
+	}
+}
@@ -0,0 +1,116 @@
+package parse
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Includes configures the ${include:path} references of a parser, which are
+// replaced with the rendered content of the file at path. The paths are
+// relative to the directory of the file including them.
+type Includes struct {
+	Root string // directory the included files must be in
+	Dir  string // directory the paths included by the input are relative to, Root if empty
+}
+
+// IncludeNode is a ${include:path} reference.
+type IncludeNode struct {
+	NodeType
+	Pos
+	Path   string
+	Env    Env      // environment the included file is rendered with
+	parser *Parser  // parser of the including input
+	subs   int      // substitutions performed in the included file
+	names  []string // variables substituted in the included file
+	line   []string // variables substituted in its last line, not annotated yet
+}
+
+func (t *IncludeNode) String() (string, error) {
+	p := t.parser
+	if filepath.IsAbs(t.Path) {
+		return "", t.errorf("include path %q must be relative", t.Path)
+	}
+	root, err := filepath.Abs(p.Includes.Root)
+	if err == nil {
+		root, err = filepath.EvalSymlinks(root)
+	}
+	if err != nil {
+		return "", t.errorf("include root: %v", err)
+	}
+	dir := root
+	if p.Includes.Dir != "" {
+		if dir, err = filepath.Abs(p.Includes.Dir); err == nil {
+			dir, err = filepath.EvalSymlinks(dir)
+		}
+		if err != nil {
+			return "", t.errorf("include directory: %v", err)
+		}
+	}
+	if n := len(p.included); n > 0 {
+		dir = filepath.Dir(p.included[n-1])
+	}
+	path, err := filepath.Abs(filepath.Join(dir, filepath.FromSlash(t.Path)))
+	if err == nil && within(root, path) {
+		// Symbolic links are resolved so they can't lead out of the root.
+		path, err = filepath.EvalSymlinks(path)
+	}
+	if err != nil {
+		return "", t.errorf("include %s: %v", t.Path, err)
+	}
+	if !within(root, path) {
+		return "", t.errorf("include %s: outside of %s", t.Path, p.Includes.Root)
+	}
+	for i, included := range p.included {
+		if included == path {
+			cycle := append(append([]string{}, p.included[i:]...), path)
+			return "", t.errorf("include cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	if max := p.Limits.MaxDepth; max > 0 && len(p.included) >= max {
+		return "", &Error{Pos: t.Pos, Kind: KindLimit, Err: ErrDepthExceeded,
+			Msg: fmt.Sprintf("include %s: depth %d exceeds limit of %d", t.Path, len(p.included)+1, max)}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", t.errorf("include %s: %v", t.Path, err)
+	}
+	q := *p
+	q.Name = path
+	q.Env = t.Env
+	q.Regions = nil
+	q.included = append(p.included[:len(p.included):len(p.included)], path)
+	s, err := q.Parse(string(b))
+	t.subs = q.subs
+	t.names, t.line = q.substituted, q.line
+	if err != nil {
+		return "", t.includeError(err)
+	}
+	return s, nil
+}
+
+// within reports whether path is dir or in it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (t *IncludeNode) errorf(format string, args ...interface{}) error {
+	return &Error{Pos: t.Pos, Kind: KindSyntax, Msg: fmt.Sprintf(format, args...)}
+}
+
+// includeError reports the failures of rendering the included file at the
+// position of the reference, keeping their locations in the message.
+func (t *IncludeNode) includeError(err error) error {
+	list, ok := err.(ErrorList)
+	if !ok {
+		list = ErrorList{err.(*Error)}
+	}
+	msgs := make([]string, len(list))
+	for i, e := range list {
+		msgs[i] = fmt.Sprintf("%s:%d:%d: %s", e.Name, e.Line, e.Col, e.Message())
+	}
+	return &Error{Pos: t.Pos, Variable: list[0].Variable, Kind: list[0].Kind, Err: list[0].Err,
+		Msg: fmt.Sprintf("in included file %s", strings.Join(msgs, "; "))}
+}
@@ -2,7 +2,9 @@ package parse
 
 import (
 	"fmt"
+	"io"
 	"strings"
+	"sync"
 	"unicode"
 	"unicode/utf8"
 )
@@ -14,6 +16,12 @@ type itemType int
 // this template was parsed.
 type Pos int
 
+// Position returns itself and provides an easy default implementation
+// for embedding in a Node. Embedded in all non-trivial Nodes.
+func (p Pos) Position() Pos {
+	return p
+}
+
 // item represents a token or text string returned from the scanner.
 type item struct {
 	typ itemType // The type of this item.
@@ -43,6 +51,9 @@ const (
 	itemVariable    // variable starting with '$', such as '$hello' or '$1'
 	itemLeftDelim   // left action delimiter '${'
 	itemRightDelim  // right action delimiter '}'
+	itemPipe        // pipe('|') starting a filter
+	itemFilter      // filter name and arguments, such as 'b64enc'
+	itemDirective   // directive or marker line, such as '#envsubst if FEATURE_X'
 )
 
 var tokens = map[itemType]string{
@@ -52,31 +63,98 @@ var tokens = map[itemType]string{
 	itemVariable:   "VAR",
 	itemLeftDelim:  "START EXP",
 	itemRightDelim: "END EXP",
+	itemPipe:       "PIPE",
+	itemFilter:     "FILTER",
+	itemDirective:  "DIRECTIVE",
 }
 
 // stateFn represents the state of the lexer as a function that returns the next state.
 type stateFn func(*lexer) stateFn
 
+// readSize is the size of the reads of lexers reading their input, and of
+// the text items they emit at most.
+const readSize = 4096
+
 // lexer holds the state of the scanner
 type lexer struct {
-	input     string    // the string being lexed
+	input     string    // the part of the input being lexed, starting at base
+	base      Pos       // position of input in the whole input
+	src       io.Reader // rest of the input, nil once read or if lexing a string
+	err       error     // error reading src, ending the input
+	keep      Pos       // position from which the input is kept, see cut
 	state     stateFn   // the next lexing function to enter
 	pos       Pos       // current position in the input
 	start     Pos       // start position of this item
 	width     Pos       // width of last rune read from input
+	dollar    Pos       // position of the next '$' in the input read, see scan
 	lastPos   Pos       // position of most recent item returned by nextItem
-	items     chan item // channel of lexed items
+	lastTyp   itemType  // type of most recent item returned by nextItem
+	prevTyp   itemType  // type of the item returned before it
+	items     []item    // lexed items, those from head not returned yet
+	head      int       // index of the next item to return
 	subsDepth int       // depth of substitution
 	noDigit   bool      // if the lexer skips variables that start with a digit
+	skip      []Region  // regions left as text, see Region.Skip
+	direct    bool      // if lines starting with #envsubst are directives
+	only      bool      // if references are scanned within only blocks only
+	inOnly    bool      // if the lexer is within an only block
+}
+
+// fill reads the input until n bytes past the current position are
+// available, or the input is read. Only the input from the start of the
+// current item, or the kept input, is retained, with the byte before it.
+func (l *lexer) fill(n int) {
+	for l.src != nil && int(l.pos-l.base)+n > len(l.input) {
+		from := l.start
+		if l.keep < from {
+			from = l.keep
+		}
+		if from--; from < l.base {
+			from = l.base
+		}
+		buf := make([]byte, int(l.pos-from)+n+readSize)
+		m := copy(buf, l.input[from-l.base:])
+		k, err := io.ReadAtLeast(l.src, buf[m:], 1)
+		l.input, l.base = string(buf[:m+k]), from
+		if err != nil {
+			if err != io.EOF {
+				l.err = err
+			}
+			l.src = nil
+		}
+	}
+}
+
+// rest returns the available input from the current position.
+func (l *lexer) rest() string {
+	return l.input[l.pos-l.base:]
+}
+
+// value returns the input of the current item.
+func (l *lexer) value() string {
+	return l.input[l.start-l.base : l.pos-l.base]
+}
+
+// cut returns the input from the kept position to end, which must not be
+// past the current position, and keeps the input from end on.
+func (l *lexer) cut(end Pos) string {
+	s := l.input[l.keep-l.base : end-l.base]
+	l.keep = end
+	return s
 }
 
 // next returns the next rune in the input.
 func (l *lexer) next() rune {
-	if int(l.pos) >= len(l.input) {
+	// Only the bytes of the rune are waited for.
+	l.fill(1)
+	for l.src != nil && !utf8.FullRuneInString(l.rest()) {
+		l.fill(len(l.rest()) + 1)
+	}
+	if int(l.pos-l.base) >= len(l.input) {
 		l.width = 0
 		return eof
 	}
-	r, w := utf8.DecodeRuneInString(l.input[l.pos:])
+	r, w := utf8.DecodeRuneInString(l.rest())
 	l.width = Pos(w)
 	l.pos += l.width
 	return r
@@ -96,8 +174,9 @@ func (l *lexer) backup() {
 
 // emit passes an item back to the client.
 func (l *lexer) emit(t itemType) {
-	l.items <- item{t, l.start, l.input[l.start:l.pos]}
+	l.items = append(l.items, item{t, l.start, l.value()})
 	l.lastPos = l.start
+	l.prevTyp, l.lastTyp = l.lastTyp, t
 	l.start = l.pos
 }
 
@@ -109,42 +188,123 @@ func (l *lexer) ignore() {
 // errorf returns an error token and terminates the scan by passing
 // back a nil pointer that will be the next state, terminating l.nextItem.
 func (l *lexer) errorf(format string, args ...interface{}) stateFn {
-	l.items <- item{itemError, l.start, fmt.Sprintf(format, args...)}
+	l.items = append(l.items, item{itemError, l.start, fmt.Sprintf(format, args...)})
 	return nil
 }
 
-// nextItem returns the next item from the input.
-// Called by the parser, not in the lexing goroutine.
+// nextItem returns the next item from the input, running the state machine
+// until it emits one. Past the end of the input it returns the zero item.
 func (l *lexer) nextItem() item {
-	item := <-l.items
+	for l.head == len(l.items) {
+		if l.state == nil {
+			return item{}
+		}
+		// The array of the items returned is reused.
+		l.items, l.head = l.items[:0], 0
+		l.state = l.state(l)
+	}
+	item := l.items[l.head]
+	l.head++
 	return item
 }
 
-// lex creates a new scanner for the input string.
-func lex(input string, noDigit bool) *lexer {
-	l := &lexer{
+// lexers are the lexers of strings released once parsed, reused by lex to
+// spare their allocations on each Parse.
+var lexers = sync.Pool{New: func() any { return new(lexer) }}
+
+// lex creates a new scanner for the input string. References within the
+// regions to skip are scanned as text, and directives only if direct is set.
+func lex(input string, noDigit, direct bool, regions []Region) *lexer {
+	l := lexers.Get().(*lexer)
+	*l = lexer{
 		input:   input,
-		items:   make(chan item),
+		state:   lexText,
+		items:   l.items[:0],
 		noDigit: noDigit,
+		direct:  direct,
+		only:    HasOnly(input),
+	}
+	for _, r := range regions {
+		if r.Skip {
+			l.skip = append(l.skip, r)
+		}
 	}
-	go l.run()
 	return l
 }
 
-// run runs the state machine for the lexer.
-func (l *lexer) run() {
-	for l.state = lexText; l.state != nil; {
-		l.state = l.state(l)
+// release returns l to the pool of lexers once its input is parsed. The
+// items lexed are cleared so as not to retain the input.
+func (l *lexer) release() {
+	clear(l.items[:cap(l.items)])
+	*l = lexer{items: l.items[:0]}
+	lexers.Put(l)
+}
+
+// lexReader creates a new scanner reading its input from r. Only the input
+// of the item being scanned is held, and the input from the position kept
+// by cut, so that memory use is bounded by the longest reference rather
+// than by the input: text is emitted in items of readSize bytes at most.
+// A failure to read r ends the input, see err.
+func lexReader(r io.Reader, noDigit, direct bool) *lexer {
+	return &lexer{src: r, state: lexText, noDigit: noDigit, direct: direct}
+}
+
+// skipRegion moves past the region to skip containing the position
+// before the current one, if any. It reports whether it did.
+func (l *lexer) skipRegion() bool {
+	pos := l.pos - 1
+	for len(l.skip) > 0 && l.skip[0].End <= pos {
+		l.skip = l.skip[1:]
+	}
+	if len(l.skip) == 0 || l.skip[0].Start > pos {
+		return false
+	}
+	l.fill(int(l.skip[0].End - l.pos))
+	l.pos = l.skip[0].End
+	l.skip = l.skip[1:]
+	return true
+}
+
+// atDirective reports whether the input at the current position starts
+// with a directive or marker line, reading past its indentation, or the
+// whole of what may be a marker line, if needed.
+func (l *lexer) atDirective() bool {
+	for l.src != nil {
+		s := l.rest()
+		if (!l.direct || len(strings.TrimLeft(s, " \t")) > len(directivePrefix)) && !partialMarker(s) {
+			break
+		}
+		l.fill(len(s) + 1)
 	}
-	close(l.items)
+	return l.direct && isDirective(l.rest()) || marker(l.rest()) != ""
 }
 
 // lexText scans until encountering with "$" or an opening action delimiter, "${".
 func lexText(l *lexer) stateFn {
+	if l.pos == 0 || l.input[l.pos-l.base-1] == '\n' {
+		if l.atDirective() {
+			return lexDirective
+		}
+	}
 Loop:
 	for {
+		l.pos += Pos(l.scan())
 		switch r := l.next(); r {
+		case '\n':
+			// Lines read from a reader are emitted once read, so that
+			// they are rendered before the next one is waited for.
+			if l.src != nil {
+				l.emit(itemText)
+				return lexText
+			}
+			if l.atDirective() {
+				l.emit(itemText)
+				return lexDirective
+			}
 		case '$':
+			if l.only && !l.inOnly || l.skipRegion() {
+				continue
+			}
 			l.pos--
 			// emit the text we've found until here, if any.
 			if l.pos > l.start {
@@ -178,6 +338,11 @@ Loop:
 			}
 		case eof:
 			break Loop
+		default:
+			// Long text read from a reader is emitted in parts.
+			if l.src != nil && l.pos-l.start >= readSize {
+				l.emit(itemText)
+			}
 		}
 	}
 	// Correctly reached EOF.
@@ -188,27 +353,168 @@ Loop:
 	return nil
 }
 
+// scan returns the length of the plain text at the current position of the
+// input read, up to the next '$' or line break, which the lexer can skip
+// without looking at each rune. Text read from a reader is scanned up to the
+// size of the text items emitted.
+func (l *lexer) scan() int {
+	s := l.rest()
+	if l.dollar < l.pos {
+		// The position is kept while the lexer is before it.
+		l.dollar = l.base + Pos(len(l.input))
+		if i := strings.IndexByte(s, '$'); i >= 0 {
+			l.dollar = l.pos + Pos(i)
+		}
+	}
+	n := int(l.dollar - l.pos)
+	if i := strings.IndexByte(s[:n], '\n'); i >= 0 {
+		n = i
+	}
+	if max := int(l.start+readSize-l.pos) - 1; l.src != nil && n > max {
+		n = max
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// directivePrefix starts the lines holding a directive, optionally preceded
+// by white space and followed by the directive and its arguments.
+const directivePrefix = "#envsubst"
+
+// isDirective reports whether s starts with a directive line.
+func isDirective(s string) bool {
+	s = strings.TrimLeft(s, " \t")
+	if !strings.HasPrefix(s, directivePrefix) {
+		return false
+	}
+	s = s[len(directivePrefix):]
+	return s != "" && (s[0] == ' ' || s[0] == '\t')
+}
+
+// markerOnly and markerEnd open and close the blocks restricting the
+// substitutions to them, see OnlyNode, in comments on lines of their own
+// such as '# envsubst:only'. They are markers with or without directives.
+const (
+	markerOnly = "envsubst:only"
+	markerEnd  = "envsubst:end"
+)
+
+// marker returns the marker of the line s starts with, or the empty string
+// if it is not a marker line: a comment holding the marker alone.
+func marker(s string) string {
+	s = strings.TrimLeft(s, " \t")
+	if !strings.HasPrefix(s, "#") {
+		return ""
+	}
+	s = strings.TrimLeft(s[1:], " \t")
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	switch strings.TrimRight(s, " \t\r") {
+	case markerOnly:
+		return markerOnly
+	case markerEnd:
+		return markerEnd
+	}
+	return ""
+}
+
+// partialMarker reports whether s may start with a marker line whose line
+// break is yet to be read.
+func partialMarker(s string) bool {
+	if strings.IndexByte(s, '\n') >= 0 {
+		return false
+	}
+	s = strings.TrimLeft(s, " \t")
+	if s == "" {
+		return true
+	}
+	if s[0] != '#' {
+		return false
+	}
+	s = strings.TrimRight(strings.TrimLeft(s[1:], " \t"), " \t\r")
+	return strings.HasPrefix(markerOnly, s) || strings.HasPrefix(markerEnd, s)
+}
+
+// lexDirective scans a directive or marker line, including its line break.
+func lexDirective(l *lexer) stateFn {
+	for l.src != nil && strings.IndexByte(l.rest(), '\n') < 0 {
+		l.fill(len(l.rest()) + 1)
+	}
+	if i := strings.IndexByte(l.rest(), '\n'); i >= 0 {
+		l.pos += Pos(i + 1)
+	} else {
+		l.pos = l.base + Pos(len(l.input))
+	}
+	l.emit(itemDirective)
+	switch marker(l.items[len(l.items)-1].val) {
+	case markerOnly:
+		l.only, l.inOnly = true, true
+	case markerEnd:
+		l.inOnly = false
+	}
+	return lexText
+}
+
 // lexVariable scans a Variable: $Alphanumeric.
 // The $ has been scanned.
 func lexVariable(l *lexer) stateFn {
-	var r rune
 	for {
-		r = l.next()
-		if !isAlphaNumeric(r) {
+		// ASCII bytes are looked up in the table, other runes decoded.
+		if i := int(l.pos - l.base); i < len(l.input) && l.input[i] < utf8.RuneSelf {
+			if !identChars[l.input[i]] {
+				break
+			}
+			l.pos++
+			continue
+		}
+		if r := l.next(); !isAlphaNumeric(r) {
 			l.backup()
 			break
 		}
 	}
-	if v := l.input[l.start:l.pos]; v == "_" || v == "$_" {
+	if v := l.value(); v == "_" || v == "$_" {
 		return lexText
 	}
 	l.emit(itemVariable)
 	if l.subsDepth > 0 {
+		// Filters may only follow the variable of a substitution directly.
+		if l.peek() == '|' && l.prevTyp == itemLeftDelim {
+			return lexFilter
+		}
 		return lexSubstitution
 	}
 	return lexText
 }
 
+// lexFilter scans a filter: a pipe followed by the name of the filter and
+// its arguments. Pipes and braces within double-quoted arguments are part
+// of the filter.
+func lexFilter(l *lexer) stateFn {
+	l.next()
+	l.emit(itemPipe)
+	quoted := false
+	for {
+		switch r := l.next(); {
+		case r == eof || isEndOfLine(r):
+			return l.errorf("closing brace expected")
+		case quoted && r == '\\':
+			l.next()
+		case r == '"':
+			quoted = !quoted
+		case !quoted && (r == '|' || r == '}'):
+			l.backup()
+			l.emit(itemFilter)
+			if r == '|' {
+				return lexFilter
+			}
+			return lexSubstitution
+		}
+	}
+}
+
 // lexSubstitution scans the elements inside substitution delimiters.
 func lexSubstitution(l *lexer) stateFn {
 	switch r := l.next(); {
@@ -218,7 +524,7 @@ func lexSubstitution(l *lexer) stateFn {
 		return lexText
 	case r == eof || isEndOfLine(r):
 		return l.errorf("closing brace expected")
-	case isAlphaNumeric(r) && strings.HasPrefix(l.input[l.lastPos:], "${"):
+	case isAlphaNumeric(r) && l.lastTyp == itemLeftDelim:
 		fallthrough
 	case r == '$':
 		return lexVariable
@@ -252,5 +558,17 @@ func isEndOfLine(r rune) bool {
 
 // isAlphaNumeric reports whether r is an alphabetic, digit, or underscore.
 func isAlphaNumeric(r rune) bool {
-	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	if 0 <= r && r < utf8.RuneSelf {
+		return identChars[r]
+	}
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
 }
+
+// identChars are the ASCII characters of identifiers: letters, digits and
+// underscores.
+var identChars = func() (chars [utf8.RuneSelf]bool) {
+	for c := range chars {
+		chars[c] = c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+	}
+	return chars
+}()
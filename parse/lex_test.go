@@ -3,6 +3,7 @@ package parse
 import (
 	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 type lexTest struct {
@@ -21,6 +22,7 @@ var (
 	tColPlus   = item{itemColonPlus, 0, ":+"}
 	tLeft      = item{itemLeftDelim, 0, "${"}
 	tRight     = item{itemRightDelim, 0, "}"}
+	tPipe      = item{itemPipe, 0, "|"}
 )
 
 var lexTests = []lexTest{
@@ -33,6 +35,12 @@ var lexTests = []lexTest{
 		{itemVariable, 0, "$hello"},
 		tEOF,
 	}},
+	{"unicode var", "$héllo_wörld-é $日本", []item{
+		{itemVariable, 0, "$héllo_wörld"},
+		{itemText, 0, "-é "},
+		{itemVariable, 0, "$日本"},
+		tEOF,
+	}},
 	{"2 vars", "$hello $world", []item{
 		{itemVariable, 0, "$hello"},
 		{itemText, 0, " "},
@@ -111,6 +119,50 @@ var lexTests = []lexTest{
 		{itemText, 10, "}"},
 		tEOF,
 	}},
+	{"filters", `${A|b64enc|replace "|" "}"}`, []item{
+		tLeft,
+		{itemVariable, 0, "A"},
+		tPipe,
+		{itemFilter, 0, "b64enc"},
+		tPipe,
+		{itemFilter, 0, `replace "|" "}"`},
+		tRight,
+		tEOF,
+	}},
+	{"no filter in default", "${A:-x|y}", []item{
+		tLeft,
+		{itemVariable, 0, "A"},
+		tColDash,
+		{itemText, 0, "x"},
+		{itemText, 0, "|"},
+		{itemText, 0, "y"},
+		tRight,
+		tEOF,
+	}},
+	{"directives", "#envsubst if A\n  $B\n  #envsubst endif\n#envsubstx\n", []item{
+		{itemDirective, 0, "#envsubst if A\n"},
+		{itemText, 0, "  "},
+		{itemVariable, 0, "$B"},
+		{itemText, 0, "\n"},
+		{itemDirective, 0, "  #envsubst endif\n"},
+		{itemText, 0, "#envsubstx\n"},
+		tEOF,
+	}},
+	{"markers", "a\n# envsubst:only\n$A\n #envsubst:end \r\n# envsubst:only x", []item{
+		{itemText, 0, "a\n"},
+		{itemDirective, 0, "# envsubst:only\n"},
+		{itemVariable, 0, "$A"},
+		{itemText, 0, "\n"},
+		{itemDirective, 0, " #envsubst:end \r\n"},
+		{itemText, 0, "# envsubst:only x"},
+		tEOF,
+	}},
+	{"not directives", "#envsubst is run by CI\n$B\n", []item{
+		{itemText, 0, "#envsubst is run by CI\n"},
+		{itemVariable, 0, "$B"},
+		{itemText, 0, "\n"},
+		tEOF,
+	}},
 	{"no digit ${2ABC}", "hello ${2ABC}", []item{
 		{itemText, 0, "hello "},
 		{itemText, 7, "${2"},
@@ -128,10 +180,31 @@ func TestLex(t *testing.T) {
 	}
 }
 
+func TestLexReader(t *testing.T) {
+	for _, test := range lexTests {
+		noDigit := strings.HasPrefix(test.name, "no digit")
+		direct := strings.HasPrefix(test.name, "directives")
+		l := lexReader(iotest.OneByteReader(strings.NewReader(test.input)), noDigit, direct)
+		var items []item
+		for {
+			item := l.nextItem()
+			items = append(items, item)
+			if item.typ == itemEOF || item.typ == itemError {
+				break
+			}
+			l.cut(l.start)
+		}
+		if !equal(items, collect(&test), true) {
+			t.Errorf("%s:\ninput\n\t%q\ngot\n\t%+v\nexpected\n\t%v", test.name, test.input, items, test.items)
+		}
+	}
+}
+
 // collect gathers the emitted items into a slice.
 func collect(t *lexTest) (items []item) {
 	noDigit := strings.HasPrefix(t.name, "no digit")
-	l := lex(t.input, noDigit)
+	direct := strings.HasPrefix(t.name, "directives")
+	l := lex(t.input, noDigit, direct, nil)
 	for {
 		item := l.nextItem()
 		items = append(items, item)
@@ -159,3 +232,42 @@ func equal(i1, i2 []item, checkPos bool) bool {
 	}
 	return true
 }
+
+func TestLexAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the pool of lexers drops items with the race detector")
+	}
+	input := strings.Repeat("$HOST:${PORT:-80}/${NAME|upper}\n#envsubst if TLS\n$$ $CERT\n#envsubst endif\n", 100)
+	// The lexers and their items are reused, the items passed by value.
+	allocs := testing.AllocsPerRun(100, func() {
+		l := lex(input, false, true, nil)
+		for item := l.nextItem(); item.typ != itemEOF && item.typ != itemError; item = l.nextItem() {
+		}
+		l.release()
+	})
+	if allocs != 0 {
+		t.Errorf("got %v allocations, expected none", allocs)
+	}
+}
+
+func BenchmarkLexText(b *testing.B) {
+	input := strings.Repeat("a line of plain text, as most of a configuration file\n", 1000) + "$END"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l := lex(input, false, true, nil)
+		for item := l.nextItem(); item.typ != itemEOF && item.typ != itemError; item = l.nextItem() {
+		}
+		l.release()
+	}
+}
+
+func BenchmarkLexIdentifiers(b *testing.B) {
+	input := strings.Repeat("$DATABASE_HOST:$DATABASE_PORT/${DATABASE_NAME}?user=$DATABASE_USER\n", 1000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l := lex(input, false, true, nil)
+		for item := l.nextItem(); item.typ != itemEOF && item.typ != itemError; item = l.nextItem() {
+		}
+		l.release()
+	}
+}
@@ -0,0 +1,215 @@
+package parse
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Library are filters beyond the DefaultFilters for hashing, padding, joining
+// lists and comparing versions. They are not available unless enabled, e.g.
+// with p.Filters = DefaultFilters.With(Library).
+//
+//	sha256, sha1, sha512, md5  hex digest of the value
+//	padleft N [CHAR]           pad to N characters with spaces or CHAR
+//	padright N [CHAR]          likewise, padding on the right
+//	join SEP [DELIM]           join the elements of a list separated by DELIM,
+//	                           "," by default, with SEP, trimming white space
+//	semver CONSTRAINT          "true" if the version satisfies CONSTRAINT,
+//	                           such as ">=1.2.0", otherwise "false"
+var Library = Filters{
+	"sha256":   hashFilter(sha256.New),
+	"sha1":     hashFilter(sha1.New),
+	"sha512":   hashFilter(sha512.New),
+	"md5":      hashFilter(md5.New),
+	"padleft":  padFilter(true),
+	"padright": padFilter(false),
+	"join": func(value string, args ...string) (string, error) {
+		if len(args) < 1 || len(args) > 2 {
+			return "", fmt.Errorf("expected 1 or 2 arguments, got %d", len(args))
+		}
+		delim := ","
+		if len(args) == 2 {
+			delim = args[1]
+		}
+		if strings.TrimSpace(value) == "" {
+			return "", nil
+		}
+		elems := strings.Split(value, delim)
+		for i, e := range elems {
+			elems[i] = strings.TrimSpace(e)
+		}
+		return strings.Join(elems, args[0]), nil
+	},
+	"semver": func(value string, args ...string) (string, error) {
+		if err := wantArgs(args, 1); err != nil {
+			return "", err
+		}
+		ok, err := semverMatch(value, args[0])
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(ok), nil
+	},
+}
+
+// hashFilter returns a filter hashing the value with the hash of newHash.
+func hashFilter(newHash func() hash.Hash) FilterFunc {
+	return func(value string, args ...string) (string, error) {
+		if err := wantArgs(args, 0); err != nil {
+			return "", err
+		}
+		h := newHash()
+		h.Write([]byte(value))
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+}
+
+// padFilter returns a filter padding the value on the left or the right.
+func padFilter(left bool) FilterFunc {
+	return func(value string, args ...string) (string, error) {
+		if len(args) < 1 || len(args) > 2 {
+			return "", fmt.Errorf("expected 1 or 2 arguments, got %d", len(args))
+		}
+		width, err := strconv.Atoi(args[0])
+		if err != nil || width < 0 {
+			return "", fmt.Errorf("invalid width %q", args[0])
+		}
+		pad := " "
+		if len(args) == 2 {
+			if utf8.RuneCountInString(args[1]) != 1 {
+				return "", fmt.Errorf("expected a single padding character, got %q", args[1])
+			}
+			pad = args[1]
+		}
+		n := width - utf8.RuneCountInString(value)
+		if n <= 0 {
+			return value, nil
+		}
+		if left {
+			return strings.Repeat(pad, n) + value, nil
+		}
+		return value + strings.Repeat(pad, n), nil
+	}
+}
+
+// semverMatch reports whether version satisfies constraint, a version
+// preceded by one of the operators =, !=, <, <=, > and >=. Comparison
+// follows semantic versioning, missing minor and patch versions are 0
+// and a leading v is ignored.
+func semverMatch(version, constraint string) (bool, error) {
+	constraint = strings.TrimSpace(constraint)
+	rest := strings.TrimLeft(constraint, "=!<>")
+	op := constraint[:len(constraint)-len(rest)]
+	a, err := parseSemver(version)
+	if err != nil {
+		return false, err
+	}
+	b, err := parseSemver(rest)
+	if err != nil {
+		return false, err
+	}
+	c := a.compare(b)
+	switch op {
+	case "", "=", "==":
+		return c == 0, nil
+	case "!=":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return false, fmt.Errorf("unknown operator %q", op)
+}
+
+// semver is a parsed semantic version. Build metadata is dropped as it does
+// not take part in comparisons.
+type semver struct {
+	core       [3]int
+	prerelease []string
+}
+
+func parseSemver(s string) (semver, error) {
+	var v semver
+	rest := strings.TrimPrefix(strings.TrimSpace(s), "v")
+	rest, _, _ = strings.Cut(rest, "+")
+	rest, pre, hasPre := strings.Cut(rest, "-")
+	parts := strings.Split(rest, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		v.core[i] = n
+	}
+	if hasPre {
+		if pre == "" {
+			return v, fmt.Errorf("invalid version %q", s)
+		}
+		v.prerelease = strings.Split(pre, ".")
+	}
+	return v, nil
+}
+
+// compare returns -1, 0 or 1 as v is lower than, equal to or greater than w.
+func (v semver) compare(w semver) int {
+	for i := range v.core {
+		if c := compareInts(v.core[i], w.core[i]); c != 0 {
+			return c
+		}
+	}
+	// A prerelease is lower than the release.
+	switch {
+	case len(v.prerelease) == 0 && len(w.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(w.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.prerelease) && i < len(w.prerelease); i++ {
+		a, b := v.prerelease[i], w.prerelease[i]
+		na, errA := strconv.Atoi(a)
+		nb, errB := strconv.Atoi(b)
+		var c int
+		switch {
+		case errA == nil && errB == nil:
+			c = compareInts(na, nb)
+		case errA == nil:
+			c = -1 // numeric identifiers are lower than alphanumeric ones
+		case errB == nil:
+			c = 1
+		default:
+			c = strings.Compare(a, b)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(v.prerelease), len(w.prerelease))
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,166 @@
+package parse
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Unset is the hash a Lock records for variables that are not set.
+const Unset = "unset"
+
+// LockVersion is the version of the format of the files written by
+// Lock.Write.
+const LockVersion = 1
+
+// Lock records the values of the variables templates are rendered with as
+// their SHA-256 hashes, see Parser.Lock, so that a rendered output can be
+// shown to correspond to a known configuration without keeping the values,
+// which may be secrets. Variables that are not set, such as those replaced
+// with their default, are recorded as Unset. Its methods may be called
+// concurrently by parsers rendering in parallel.
+type Lock struct {
+	mu        sync.Mutex
+	variables map[string]string
+}
+
+// NewLock returns an empty Lock.
+func NewLock() *Lock {
+	return &Lock{variables: map[string]string{}}
+}
+
+// Hash returns the hash of value recorded by a Lock.
+func Hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Add records the hash of the variable name, as returned by Hash, or Unset.
+func (l *Lock) Add(name, hash string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.variables[name] = hash
+}
+
+// Merge records the variables of other in l.
+func (l *Lock) Merge(other *Lock) {
+	for name, hash := range other.Variables() {
+		l.Add(name, hash)
+	}
+}
+
+// Variables returns the hashes of the variables by name.
+func (l *Lock) Variables() map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	variables := make(map[string]string, len(l.variables))
+	for name, hash := range l.variables {
+		variables[name] = hash
+	}
+	return variables
+}
+
+// lockFile is the format of the files written by Write.
+type lockFile struct {
+	Version   int               `json:"version"`
+	Variables map[string]string `json:"variables"`
+}
+
+// Write writes l to w as JSON, sorted by variable name.
+func (l *Lock) Write(w io.Writer) error {
+	b, err := json.MarshalIndent(lockFile{LockVersion, l.Variables()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// ReadLock reads a Lock written by Write from r.
+func ReadLock(r io.Reader) (*Lock, error) {
+	var f lockFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, err
+	}
+	if f.Version != LockVersion {
+		return nil, fmt.Errorf("unsupported lock version %d", f.Version)
+	}
+	l := NewLock()
+	for name, hash := range f.Variables {
+		l.variables[name] = hash
+	}
+	return l, nil
+}
+
+// LockMismatch is a variable whose value differs from the one recorded in a
+// Lock, see Lock.Verify.
+type LockMismatch struct {
+	Variable string
+	Locked   string // hash recorded in the lock, empty if not recorded
+	Got      string // hash of the value used
+}
+
+func (m LockMismatch) String() string {
+	switch {
+	case m.Locked == "":
+		return fmt.Sprintf("variable ${%s} is not locked", m.Variable)
+	case m.Got == Unset:
+		return fmt.Sprintf("variable ${%s} is not set, unlike when locked", m.Variable)
+	case m.Locked == Unset:
+		return fmt.Sprintf("variable ${%s} is set, unlike when locked", m.Variable)
+	}
+	return fmt.Sprintf("variable ${%s} differs from its locked value", m.Variable)
+}
+
+// Verify returns the variables of used, as recorded when rendering, whose
+// value differs from the one recorded in l or which l does not record, in
+// order of their names. The variables of l that were not used are not
+// reported, so that the files locked together can be rendered apart.
+func (l *Lock) Verify(used *Lock) []LockMismatch {
+	locked := l.Variables()
+	var mismatches []LockMismatch
+	for name, hash := range used.Variables() {
+		if locked[name] != hash {
+			mismatches = append(mismatches, LockMismatch{name, locked[name], hash})
+		}
+	}
+	sort.Slice(mismatches, func(i, j int) bool { return mismatches[i].Variable < mismatches[j].Variable })
+	return mismatches
+}
+
+// lock records the value of the variable name of env in the Lock, unless it
+// is set by a foreach block being rendered.
+func (p *Parser) lock(env Env, name string, loop []string) {
+	if p.Lock == nil || contains(loop, name) {
+		return
+	}
+	hash := Unset
+	if value, ok := env.Lookup(name); ok {
+		hash = Hash(value)
+	}
+	p.Lock.Add(name, hash)
+}
+
+// lockNode records the value node substitutes in the Lock: that of its
+// variable, or the value resolved for resolved references.
+func (p *Parser) lockNode(node Node, value string, loop []string) {
+	if p.Lock == nil {
+		return
+	}
+	switch n := node.(type) {
+	case *VariableNode:
+		if n.builtin == nil {
+			p.lock(n.Env, n.Ident, loop)
+		}
+	case *SubstitutionNode:
+		if n.Variable.builtin == nil {
+			p.lock(n.Variable.Env, n.Variable.Ident, loop)
+		}
+	case *ResolveNode:
+		p.Lock.Add(ident(n), Hash(value))
+	}
+}
@@ -0,0 +1,94 @@
+package parse
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Masked replaces the values of masked variables in error messages.
+const Masked = "***"
+
+// MaskGlobs returns a predicate for Parser.Mask matching variable names
+// against glob patterns of path.Match, such as *_TOKEN.
+func MaskGlobs(patterns ...string) func(name string) bool {
+	return func(name string) bool {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// masker returns the replacer of the values of the variables matching
+// p.Mask with Masked, including the values they resolve to with the
+// Resolvers, or nil if there are none.
+func (p *Parser) masker() *strings.Replacer {
+	if p.Mask == nil {
+		return nil
+	}
+	// The replacer is kept until more values are resolved.
+	if p.masks != nil && p.maskedCache == len(p.cache) {
+		return p.masks
+	}
+	var values []string
+	for _, pair := range p.Env {
+		name, value, _ := strings.Cut(pair, "=")
+		if value == "" || !p.Mask(name) {
+			continue
+		}
+		values = append(values, value)
+		if res, ok := p.cache[value]; ok && res.value != "" {
+			values = append(values, res.value)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	// Replace longer values first, so a value containing another one is
+	// not revealed partially.
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	var oldnew []string
+	for _, v := range values {
+		oldnew = append(oldnew, v, Masked)
+	}
+	p.masks, p.maskedCache = strings.NewReplacer(oldnew...), len(p.cache)
+	return p.masks
+}
+
+// mask replaces the masked values in the messages of err, see masker.
+func (p *Parser) mask(err error) error {
+	r := p.masker()
+	if r == nil || err == nil {
+		return err
+	}
+	switch err := err.(type) {
+	case *Error:
+		err.Msg = r.Replace(err.Message())
+	case ErrorList:
+		for _, e := range err {
+			e.Msg = r.Replace(e.Message())
+		}
+	}
+	return err
+}
+
+// Redact is a Parser.Transform replacing every substituted value with a
+// <redacted:NAME> marker, so rendered files can be shared without their
+// values.
+func Redact(name, value string) string {
+	return "<redacted:" + name + ">"
+}
+
+// RedactHash is like Redact with a marker holding the first 12 hex digits
+// of the SHA-256 hash of the value, such as <sha256:2c26b46b68ff>, which
+// tells whether values differ between renderings without revealing them.
+// Values that are easy to guess can be found from their hash though.
+func RedactHash(name, value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return "<sha256:" + hex.EncodeToString(sum[:])[:12] + ">"
+}
@@ -7,6 +7,7 @@ import (
 type Node interface {
 	Type() NodeType
 	String() (string, error)
+	Position() Pos // byte position of start of node in full original input string
 }
 
 // NodeType identifies the type of a node.
@@ -22,15 +23,21 @@ const (
 	NodeText NodeType = iota
 	NodeSubstitution
 	NodeVariable
+	NodeInclude
+	NodeIf
+	NodeForeach
+	NodeResolve
+	NodeOnly
 )
 
 type TextNode struct {
 	NodeType
+	Pos
 	Text string
 }
 
 func NewText(text string) *TextNode {
-	return &TextNode{NodeText, text}
+	return &TextNode{NodeType: NodeText, Text: text}
 }
 
 func (t *TextNode) String() (string, error) {
@@ -39,30 +46,58 @@ func (t *TextNode) String() (string, error) {
 
 type VariableNode struct {
 	NodeType
+	Pos
 	Ident    string
 	Env      Env
 	Restrict *Restrictions
+	resolve  func(string) (string, error) // resolves the value, see Parser.Resolvers
+	builtin  Builtin                      // returns the value instead of Env, see Parser.Builtins
+	arg      string                       // argument of the builtin
+	replaced string                       // deprecated name replaced with Ident, see Parser.MapDeprecated
 }
 
 func NewVariable(ident string, env Env, restrict *Restrictions) *VariableNode {
-	return &VariableNode{NodeVariable, ident, env, restrict}
+	return &VariableNode{NodeType: NodeVariable, Ident: ident, Env: env, Restrict: restrict}
 }
 
 func (t *VariableNode) String() (string, error) {
 	if err := t.validateNoUnset(); err != nil {
 		return "", err
 	}
+	value, err := t.value()
+	if err != nil || t.builtin != nil {
+		return value, err
+	}
+	return t.validateNoEmpty(value)
+}
 
-	return t.validateNoEmpty(t.Env.Get(t.Ident))
+// value returns the value of the variable without checking the
+// restrictions, empty if it is not set.
+func (t *VariableNode) value() (string, error) {
+	if t.builtin != nil {
+		value, err := t.builtin(t.arg)
+		if err != nil {
+			return "", t.errorf(KindResolve, "%v", err)
+		}
+		return value, nil
+	}
+	value := t.Env.Get(t.Ident)
+	if t.resolve != nil {
+		var err error
+		if value, err = t.resolve(value); err != nil {
+			return "", t.errorf(KindResolve, "%v", err)
+		}
+	}
+	return value, nil
 }
 
 func (t *VariableNode) isSet() bool {
-	return t.Env.Has(t.Ident)
+	return t.builtin != nil || t.Env.Has(t.Ident)
 }
 
 func (t *VariableNode) validateNoUnset() error {
 	if t.Restrict.NoUnset && !t.isSet() {
-		return fmt.Errorf("variable ${%s} not set", t.Ident)
+		return t.failure(KindUnset, "variable ${%s} not set")
 	}
 	return nil
 }
@@ -72,25 +107,66 @@ func (t *VariableNode) validateNoEmpty(value string) (string, error) {
 		return fmt.Sprintf("$%s", t.Ident), nil
 	}
 	if t.Restrict.NoEmpty && value == "" && t.isSet() {
-		return "", fmt.Errorf("variable ${%s} set but empty", t.Ident)
+		return "", t.failure(KindEmpty, "variable ${%s} set but empty")
 	}
 	return value, nil
 }
 
+func (t *VariableNode) errorf(kind ErrorKind, format string, args ...interface{}) error {
+	return &Error{Pos: t.Pos, Variable: t.Ident, Kind: kind, Msg: fmt.Sprintf(format, args...)}
+}
+
+// failure returns the error of the variable whose message is format
+// formatted with its name once read, see Error.Message.
+func (t *VariableNode) failure(kind ErrorKind, format string) error {
+	return &Error{Pos: t.Pos, Variable: t.Ident, Kind: kind, format: format}
+}
+
 type SubstitutionNode struct {
 	NodeType
+	Pos
 	ExpType  itemType
 	Variable *VariableNode
-	Default  Node // Default could be variable or text
+	Default  Node      // Default could be variable or text
+	Filters  []*Filter // applied in order to the value of the variable
 }
 
 func (t *SubstitutionNode) String() (string, error) {
+	if len(t.Filters) > 0 {
+		// Restrictions are checked before filtering, and unset
+		// variables kept by NoReplace are kept unfiltered, unless a
+		// default filter replaces the unset and empty values as :- does.
+		var (
+			value string
+			err   error
+		)
+		if t.hasDefaultFilter() {
+			value, err = t.Variable.value()
+		} else {
+			value, err = t.Variable.String()
+			if t.Variable.Restrict.NoReplace && !t.Variable.isSet() {
+				return value, err
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		for _, f := range t.Filters {
+			if value, err = f.apply(t.Variable.Ident, value); err != nil {
+				return "", err
+			}
+		}
+		return value, nil
+	}
 	if t.ExpType >= itemPlus && t.Default != nil {
 		switch t.ExpType {
 		case itemColonDash, itemColonEquals:
-			s, _ := t.Variable.String()
+			s, err := t.Variable.String()
+			if e, ok := err.(*Error); ok && e.Kind == KindResolve {
+				return "", err
+			}
 			// if default is set and the returned string equals the var name, apply the default
-			if t.Default != nil && s == fmt.Sprintf("$%s", t.Variable.Ident) {
+			if t.Default != nil && len(s) == len(t.Variable.Ident)+1 && s[0] == '$' && s[1:] == t.Variable.Ident {
 				return t.Default.String()
 			}
 			if s != "" {
@@ -110,3 +186,47 @@ func (t *SubstitutionNode) String() (string, error) {
 	}
 	return t.Variable.String()
 }
+
+// hasDefaultFilter reports whether the filters of t include default.
+func (t *SubstitutionNode) hasDefaultFilter() bool {
+	for _, f := range t.Filters {
+		if f.Name == "default" {
+			return true
+		}
+	}
+	return false
+}
+
+// substituted reports whether n is replaced with the value of a set variable
+// or with a default value.
+func substituted(n Node) bool {
+	switch n := n.(type) {
+	case *VariableNode:
+		return n.isSet()
+	case *ResolveNode:
+		return true
+	case *SubstitutionNode:
+		if n.Variable.isSet() || n.hasDefaultFilter() {
+			return true
+		}
+		switch n.ExpType {
+		case itemDash, itemEquals, itemColonDash, itemColonEquals:
+			return n.Default != nil && (n.Default.Type() == NodeText || substituted(n.Default))
+		}
+	}
+	return false
+}
+
+// ident returns the name of the variable n substitutes, or the reference
+// it resolves.
+func ident(n Node) string {
+	switch n := n.(type) {
+	case *VariableNode:
+		return n.Ident
+	case *SubstitutionNode:
+		return n.Variable.Ident
+	case *ResolveNode:
+		return n.Scheme + ":" + n.Ref
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+package parse
+
+import (
+	"context"
+	"log/slog"
+)
+
+// defaulted reports whether n is replaced with its default value.
+func defaulted(n Node) bool {
+	s, ok := n.(*SubstitutionNode)
+	if !ok || s.Default == nil || s.Variable.builtin != nil {
+		return false
+	}
+	switch s.ExpType {
+	case itemDash, itemEquals:
+		return !s.Variable.isSet()
+	case itemColonDash, itemColonEquals:
+		return s.Variable.Env.Get(s.Variable.Ident) == ""
+	}
+	return false
+}
+
+// Metrics counts the events of rendering, so that long-running renderers
+// can monitor the health of their templates, e.g. with Prometheus counters.
+// Its methods may be called concurrently by parsers rendering in parallel.
+type Metrics interface {
+	// Substituted counts a variable substituted with its value.
+	Substituted(template, variable string)
+	// Defaulted counts a variable substituted with its default value.
+	Defaulted(template, variable string)
+	// Unset counts a reference to an unset variable without a default.
+	Unset(template, variable string)
+	// Failed counts an error of Parse, one for each of an ErrorList.
+	Failed(template string, kind ErrorKind)
+}
+
+// Substitution is the record of a reference replaced by Parse, see
+// Parser.Audit. Its position is relative to the text given to Parse, which
+// the renderers of structured syntaxes may call for parts of a file.
+type Substitution struct {
+	Template string // name of the template, that of the file for included files
+	Variable string // variable, or reference resolved such as vault:secret/db#pass
+	Pos      Pos    // byte offset of the reference
+	Line     int    // 1-based line number
+	Col      int    // 1-based column, counted in bytes
+	Source   string // source of the value as returned by Parser.Source, if any
+	Default  bool   // the default value was substituted
+	Value    string // value substituted, Masked for variables matching Parser.Mask
+}
+
+// observe records the substitution of node in text with value with the
+// Logger at debug level, the Metrics and Audit. Values are never
+// logged, as they may be secrets.
+func (p *Parser) observe(node Node, text, value string) {
+	logging := p.Logger != nil && p.Logger.Enabled(context.Background(), slog.LevelDebug)
+	if !logging && p.Metrics == nil && p.Audit == nil {
+		return
+	}
+	name := ident(node)
+	if name == "" {
+		return
+	}
+	line, col := position(text, node.Position())
+	if p.Audit != nil && substituted(node) {
+		sub := Substitution{Template: p.Name, Variable: name, Pos: node.Position(), Line: line, Col: col,
+			Default: defaulted(node), Value: value}
+		if p.Source != nil && !sub.Default {
+			sub.Source = p.Source(name)
+		}
+		if p.Mask != nil && p.Mask(name) {
+			sub.Value = Masked
+		} else if r := p.masker(); r != nil {
+			// Defaults may hold masked values as well.
+			sub.Value = r.Replace(sub.Value)
+		}
+		p.Audit(sub)
+	}
+	var msg string
+	switch {
+	case defaulted(node):
+		msg = "default applied"
+		if p.Metrics != nil {
+			p.Metrics.Defaulted(p.Name, name)
+		}
+	case substituted(node):
+		msg = "substituted"
+		if p.Metrics != nil {
+			p.Metrics.Substituted(p.Name, name)
+		}
+	default:
+		msg = "skipped unset variable"
+		if p.Metrics != nil {
+			p.Metrics.Unset(p.Name, name)
+		}
+	}
+	if logging {
+		p.Logger.Debug(msg, "template", p.Name, "variable", name, "line", line, "col", col)
+	}
+}
+
+// failed counts the errors returned by Parse with the Metrics.
+func (p *Parser) failed(err error) {
+	if p.Metrics == nil || err == nil {
+		return
+	}
+	switch err := err.(type) {
+	case *Error:
+		p.Metrics.Failed(p.Name, err.Kind)
+	case ErrorList:
+		for _, e := range err {
+			p.Metrics.Failed(p.Name, e.Kind)
+		}
+	}
+}
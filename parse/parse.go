@@ -2,7 +2,10 @@
 package parse
 
 import (
-	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
 	"strings"
 )
 
@@ -31,17 +34,133 @@ var (
 	Strict  = &Restrictions{true, true, false, false}
 )
 
+// Limits bounds the resources a single Parse may use.
+// A zero value means no limit.
+type Limits struct {
+	MaxInput  int // maximum size of the text given to Parse in bytes
+	MaxOutput int // maximum size of the rendered output in bytes
+	// MaxDepth is the maximum nesting depth of the included files, e.g. 2
+	// for a file included by an included file, see Includes. References
+	// themselves nest 2 deep at most, as in ${A:-$B}, as the grammar has
+	// no deeper defaults.
+	MaxDepth int
+}
+
+// Region is a part of the input handled specially, such as a string
+// literal of a JSON document.
+type Region struct {
+	Start, End Pos  // byte offsets of the region in the input
+	Skip       bool // leave the references in the region as they are
+	// Escape is applied to the values substituted in the region. It fails
+	// for values the region can't represent.
+	Escape func(string) (string, error)
+}
+
 // Parser type initializer
 type Parser struct {
 	Name     string // name of the processing template
 	Env      Env
 	Restrict *Restrictions
 	Mode     Mode
+	Limits   Limits
+	Regions  []Region // sorted, non-overlapping regions of the next input
+	// Filters are the filters available in substitutions such as
+	// ${NAME|upper}. DefaultFilters are used if nil.
+	Filters Filters
+	// Transform, if set, is applied to the values substituted for variables,
+	// e.g. to quote them for a shell, before the escaping of their region.
+	Transform func(name, value string) string
+	// Resolvers resolve the values of variables starting with their scheme,
+	// such as vault:secret/db#password, and references such as
+	// ${vault:secret/db#password}, if set.
+	Resolvers Resolvers
+	// Referenced, if not nil, are the schemes of the Resolvers templates may
+	// reference directly, as in ${file:/run/secrets/db}, rather than all of
+	// them. The others only resolve the values of variables.
+	Referenced []string
+	// Sandbox, if set, bounds the time the resolutions of the Resolvers
+	// take, the size of their values and the environment of the commands
+	// they run.
+	Sandbox *Sandbox
+	// Includes enables ${include:path} references if set. The references
+	// of included files are not reported by References.
+	Includes *Includes
+	// Directives enables the lines starting with #envsubst, such as
+	// #envsubst if FEATURE_X, see IfNode and ForeachNode. They are text
+	// otherwise. The markers of OnlyNode are enabled either way.
+	Directives bool
+	// Builtins are the pseudo-variables, such as ${__NOW}, substituted with
+	// values from the runtime instead of the environment. None if nil.
+	Builtins Builtins
+	// Logger, if set, records the substitutions, the defaults applied and
+	// the unset variables skipped at debug level, without their values.
+	Logger *slog.Logger
+	// Metrics, if set, counts the substitutions, the defaults applied, the
+	// unset variables skipped and the errors.
+	Metrics Metrics
+	// Mask, if set, reports whether the value of the variable name is
+	// secret. The values of such variables, and those they resolve to, are
+	// replaced with Masked in the messages of errors, e.g. with
+	// MaskGlobs("*_TOKEN", "*_PASSWORD").
+	Mask func(name string) bool
+	// Audit, if set, is called with the record of each substitution
+	// performed, including those in included files.
+	Audit func(Substitution)
+	// Source, if set, returns the source the value of the variable name
+	// comes from, such as a file, for the records of Audit.
+	Source func(name string) string
+	// Schema, if set, declares the types and constraints of the values of
+	// variables. Substituting a value violating them fails with a
+	// KindSchema error.
+	Schema Schema
+	// Deprecated maps deprecated variable names to their replacements, or
+	// to the empty string if there are none. References to them are logged
+	// as warnings with the Logger, or fail with KindDeprecated errors if
+	// FailDeprecated is set. If MapDeprecated is set, they substitute the
+	// value of their replacement instead.
+	Deprecated     map[string]string
+	FailDeprecated bool
+	MapDeprecated  bool
+	// FailEmptyDefault fails the defaults substituted for variables that
+	// are set but empty, as ${VAR:-default} does, with KindEmptyDefault
+	// errors. Without it they are logged as warnings with the Logger.
+	FailEmptyDefault bool
+	// Policy, if set, is consulted before each substitution, failing the
+	// ones it denies with KindPolicy errors. Destination and Syntax describe
+	// the output for it, such as the path of the file the output is written
+	// to and yaml.
+	Policy      Policy
+	Destination string
+	Syntax      string
+	// Provenance, if set, adds comments to the lines of the output in which
+	// variables are substituted, listing them.
+	Provenance *Provenance
+	// Lock, if set, records the values of the variables the templates are
+	// rendered with, including those of the conditions and lists of
+	// directives, see Lock.
+	Lock *Lock
+	// Tracer, if set, traces Parse, including the files it includes.
+	Tracer Tracer
+	// Only, if set, leaves the references outside of only blocks as they
+	// are even in texts without such blocks, see OnlyNode, for callers
+	// rendering a template in parts once a part has one.
+	Only bool
 	// parsing state;
-	lex       *lexer
-	token     [3]item // three-token lookahead
-	peekCount int
-	nodes     []Node
+	lex         *lexer
+	token       [3]item // three-token lookahead
+	peekCount   int
+	nodes       []Node
+	only        bool                // parsing an only block, which do not nest
+	blocks      nodeBlocks          // nodes allocated for the parse, see alloc
+	subs        int                 // number of substitutions performed by the last Parse
+	substituted []string            // variables substituted by the last Parse, in order
+	line        []string            // variables substituted in the last line of an included file
+	included    []string            // files being included, innermost last
+	dropped     []Span              // parts of the input dropped by the last Parse
+	cache       map[string]resolved // results of the resolvers
+	masks       *strings.Replacer   // replacer of the masked values, see masker
+	maskedCache int                 // number of resolved values when masks was made
+	span        TraceSpan           // span of the Parse or substitution being traced
 }
 
 // New allocates a new Parser with the given name.
@@ -54,94 +173,442 @@ func New(name string, env []string, r *Restrictions) *Parser {
 }
 
 // Parse parses the given string.
+// In Quick mode the returned error is an *Error, in AllErrors mode an ErrorList.
 func (p *Parser) Parse(text string) (string, error) {
-	p.lex = lex(text, p.Restrict.NoDigit)
+	// Included files are traced within the substitution including them.
+	tracer := p.Tracer
+	if p.span != nil {
+		tracer = p.span
+	}
+	if tracer == nil {
+		return p.parseText(text)
+	}
+	p.span = tracer.Start("envsubst.Parse",
+		slog.String("envsubst.template", p.Name), slog.Int("envsubst.template.size", len(text)))
+	out, err := p.parseText(text)
+	p.span.End(err, slog.Int("envsubst.substitutions", p.subs), slog.Int("envsubst.output.size", len(out)))
+	p.span = nil
+	return out, err
+}
+
+// parseText renders text, see Parse, with the errors masked.
+func (p *Parser) parseText(text string) (string, error) {
+	out, err := p.execute(text)
+	err = p.mask(err)
+	// The errors of included files are counted once, by the including file.
+	if len(p.included) == 0 {
+		p.failed(err)
+	}
+	return out, err
+}
+
+// execute renders text, see Parse.
+func (p *Parser) execute(text string) (string, error) {
+	if max := p.Limits.MaxInput; max > 0 && len(text) > max {
+		err := (&Error{Pos: Pos(max), Kind: KindLimit, Err: ErrInputTooLarge,
+			Msg: fmt.Sprintf("input size %d exceeds limit of %d bytes", len(text), max)}).locate(p.Name, text)
+		if p.Mode == Quick {
+			return "", err
+		}
+		return "", ErrorList{err}
+	}
+	p.lex = lex(text, p.Restrict.NoDigit, p.Directives, p.Regions)
+	p.lex.only = p.lex.only || p.Only
 	// Build internal array of all unset or empty vars here
-	var errs []error
+	var errs ErrorList
 	// clean parse state
 	p.nodes = make([]Node, 0)
 	p.peekCount = 0
-	if err := p.parse(); err != nil {
+	if len(p.included) == 0 {
+		p.cache = nil
+	}
+	span := p.start("envsubst.parse")
+	err := p.parse()
+	span.End(p.mask(err), slog.Int("envsubst.nodes", len(p.nodes)))
+	if err != nil {
 		switch p.Mode {
 		case Quick:
-			return "", err
+			return "", p.locate(err, text)
 		case AllErrors:
-			errs = append(errs, err)
+			errs = append(errs, p.error(err))
 		}
 	}
-	var out string
-	for _, node := range p.nodes {
-		s, err := node.String()
-		if err != nil {
-			switch p.Mode {
-			case Quick:
-				return "", err
-			case AllErrors:
-				errs = append(errs, err)
+	// Limit violations abort right away, whatever the mode.
+	abort := func(err *Error) (string, error) {
+		if p.Mode == Quick {
+			return "", err.locate(p.Name, text)
+		}
+		return "", append(errs, err).locate(p.Name, text)
+	}
+	out := make([]byte, 0, outputSize(text, p.Limits.MaxOutput))
+	var line provenance
+	var loop []string // variables set by the foreach blocks being rendered
+	p.subs = 0
+	p.substituted = nil
+	p.dropped = nil
+	p.masks = nil
+	// render appends the nodes to out. It returns an error if rendering
+	// has to stop.
+	var render func(nodes []Node) *Error
+	render = func(nodes []Node) *Error {
+		for _, node := range nodes {
+			if n, ok := node.(*IfNode); ok {
+				p.lock(n.Env, n.Name, loop)
+				nodes, dropped := n.branch()
+				p.dropped = append(p.dropped, dropped...)
+				if err := render(nodes); err != nil {
+					return err
+				}
+				continue
+			}
+			if n, ok := node.(*ForeachNode); ok {
+				p.lock(n.Env, n.List, loop)
+				envs := n.iterations()
+				p.dropped = append(p.dropped, n.dropped(len(envs) > 0)...)
+				outer := loop
+				loop = append(loop[:len(loop):len(loop)], n.Item, n.Item+"_INDEX")
+				for _, env := range envs {
+					setEnv(n.Body, env)
+					err := render(n.Body)
+					setEnv(n.Body, n.Env)
+					if err != nil {
+						return err
+					}
+				}
+				loop = outer
+				continue
+			}
+			if n, ok := node.(*OnlyNode); ok {
+				if err := render(n.Body); err != nil {
+					return err
+				}
+				continue
+			}
+			if substituted(node) {
+				p.subs++
+			}
+			err := p.deprecation(node, text)
+			if err == nil {
+				err = p.emptyDefault(node, text)
+			}
+			if err == nil {
+				err = p.policy(node)
+			}
+			if err != nil {
+				if p.Mode == Quick {
+					return p.locate(err, text)
+				}
+				errs = append(errs, p.error(err))
+				continue
+			}
+			s, err := node.String()
+			if err == nil && p.Schema != nil && substituted(node) {
+				if serr := p.Schema.check(ident(node), s); serr != nil {
+					err = &Error{Pos: node.Position(), Variable: ident(node), Kind: KindSchema,
+						Msg: fmt.Sprintf("${%s}: %v", ident(node), serr)}
+				}
+			}
+			if n, ok := node.(*IncludeNode); ok {
+				p.subs += n.subs
+				for _, name := range n.names {
+					if !contains(p.substituted, name) {
+						p.substituted = append(p.substituted, name)
+					}
+				}
+				for _, name := range n.line {
+					line.add(name)
+				}
+			}
+			if err != nil {
+				if p.Mode == Quick {
+					return p.locate(err, text)
+				}
+				errs = append(errs, p.error(err))
+			}
+			if err == nil {
+				p.lockNode(node, s, loop)
+			}
+			if p.Transform != nil && err == nil && substituted(node) {
+				s = p.Transform(ident(node), s)
+			}
+			if err == nil {
+				p.observe(node, text, s)
+				if name := ident(node); name != "" && substituted(node) {
+					if !contains(p.substituted, name) {
+						p.substituted = append(p.substituted, name)
+					}
+					if p.Provenance != nil {
+						line.add(name)
+					}
+				}
+			}
+			if r := p.region(node.Position()); node.Type() != NodeText && r != nil && r.Escape != nil && err == nil {
+				if s, err = r.Escape(s); err != nil {
+					err := &Error{Pos: node.Position(), Kind: KindSyntax, Msg: err.Error()}
+					if p.Mode == Quick {
+						return p.locate(err, text)
+					}
+					errs = append(errs, p.error(err))
+				}
+			}
+			if p.Provenance != nil && node.Type() == NodeText {
+				out = p.appendText(out, node.Position(), s, &line)
+			} else {
+				out = append(out, s...)
+			}
+			if max := p.Limits.MaxOutput; max > 0 && len(out) > max {
+				return &Error{Pos: node.Position(), Kind: KindLimit, Err: ErrOutputTooLarge,
+					Msg: fmt.Sprintf("output size exceeds limit of %d bytes", max)}
 			}
 		}
-		out += s
+		return nil
+	}
+	span = p.start("envsubst.execute")
+	parent := p.span
+	if p.span != nil {
+		p.span = span
+	}
+	rerr := render(p.nodes)
+	p.span = parent
+	switch {
+	case rerr != nil:
+		span.End(p.mask(rerr), slog.Int("envsubst.substitutions", p.subs))
+	case len(errs) > 0:
+		span.End(p.mask(errs), slog.Int("envsubst.substitutions", p.subs))
+	default:
+		span.End(nil, slog.Int("envsubst.substitutions", p.subs))
+	}
+	if err := rerr; err != nil {
+		if err.Kind == KindLimit {
+			return abort(err)
+		}
+		return "", err
 	}
 	if len(errs) > 0 {
-		var b strings.Builder
-		for i, err := range errs {
-			if i > 0 {
-				b.WriteByte('\n')
+		return "", errs.locate(p.Name, text)
+	}
+	// The last line of an included file is annotated with the line of the
+	// including file it ends up in.
+	if p.Provenance != nil && len(p.included) == 0 {
+		out = p.endLine(out, &line)
+	}
+	p.line = line.names
+	return string(out), nil
+}
+
+// outputSize estimates the size of the output of text to allocate it at
+// once: the values substituted are usually about the size of the references
+// they replace, a quarter more leaves room for longer ones. It is at most the
+// limit of the output, if any.
+func outputSize(text string, max int) int {
+	n := len(text) + len(text)/4
+	if max > 0 && n > max+1 {
+		n = max + 1
+	}
+	return n
+}
+
+// region returns the region containing pos, if any.
+func (p *Parser) region(pos Pos) *Region {
+	i := sort.Search(len(p.Regions), func(i int) bool { return p.Regions[i].End > pos })
+	if i < len(p.Regions) && p.Regions[i].Start <= pos {
+		return &p.Regions[i]
+	}
+	return nil
+}
+
+// Substituted returns the names of the variables the last Parse substituted,
+// in order of their first substitution.
+func (p *Parser) Substituted() []string {
+	return p.substituted
+}
+
+// Substitutions returns the number of references the last Parse replaced
+// with the value of a set variable or with a default value.
+func (p *Parser) Substitutions() int {
+	return p.subs
+}
+
+// Dropped returns the parts of the input the last Parse dropped: the lines
+// of directives and the blocks they left out, in no particular order.
+func (p *Parser) Dropped() []Span {
+	return p.dropped
+}
+
+// Reference is a variable referenced by a template.
+type Reference struct {
+	Name     string // name of the variable
+	Pos      Pos    // byte offset of the reference in the input
+	Line     int    // 1-based line number
+	Col      int    // 1-based column, counted in bytes
+	Optional bool   // reference has a default or alternate value, e.g. ${A:-default}
+}
+
+// References returns the variables referenced by text in order of appearance,
+// including the ones referenced by default values. Nothing is substituted,
+// so the restrictions are not checked.
+func (p *Parser) References(text string) ([]Reference, error) {
+	p.lex = lex(text, p.Restrict.NoDigit, p.Directives, p.Regions)
+	p.nodes = make([]Node, 0)
+	p.peekCount = 0
+	if err := p.parse(); err != nil {
+		return nil, p.locate(err, text)
+	}
+	var refs []Reference
+	var walk func(n Node, optional bool)
+	walk = func(n Node, optional bool) {
+		switch n := n.(type) {
+		case *VariableNode:
+			// Builtins need no value.
+			if n.builtin != nil {
+				return
+			}
+			line, col := position(text, n.Pos)
+			refs = append(refs, Reference{n.Ident, n.Pos, line, col, optional})
+		case *SubstitutionNode:
+			walk(n.Variable, n.ExpType >= itemPlus && n.Default != nil || n.hasDefaultFilter())
+			if n.Default != nil {
+				walk(n.Default, optional)
+			}
+		case *IfNode:
+			line, col := position(text, n.Pos)
+			refs = append(refs, Reference{n.Name, n.Pos, line, col, true})
+			for _, n := range n.Then {
+				walk(n, optional)
+			}
+			for _, n := range n.Else {
+				walk(n, optional)
+			}
+		case *ForeachNode:
+			line, col := position(text, n.Pos)
+			refs = append(refs, Reference{n.List, n.Pos, line, col, true})
+			// The variables set by the loop are not references.
+			outer := refs
+			refs = nil
+			for _, n := range n.Body {
+				walk(n, optional)
+			}
+			for _, ref := range refs {
+				if ref.Name != n.Item && ref.Name != n.Item+"_INDEX" {
+					outer = append(outer, ref)
+				}
+			}
+			refs = outer
+		case *OnlyNode:
+			for _, n := range n.Body {
+				walk(n, optional)
 			}
-			b.WriteString(err.Error())
 		}
-		return "", errors.New(b.String())
 	}
-	return out, nil
+	for _, node := range p.nodes {
+		walk(node, false)
+	}
+	return refs, nil
+}
+
+// locate converts err to an *Error carrying the line and column it refers to.
+func (p *Parser) locate(err error, text string) *Error {
+	return p.error(err).locate(p.Name, text)
+}
+
+// error converts err to an *Error. The errors of AllErrors mode are located
+// at once when all are known, see ErrorList.locate.
+func (p *Parser) error(err error) *Error {
+	e, ok := err.(*Error)
+	if !ok {
+		e = &Error{Kind: KindSyntax, Msg: err.Error()}
+	}
+	return e
 }
 
 // parse is the top-level parser for the template.
 // It runs to EOF and return an error if something isn't right.
 func (p *Parser) parse() error {
-Loop:
+	// The blocks are not shared with the copies of the parser.
+	p.blocks = nodeBlocks{}
+	nodes, end, err := p.parseNodes()
+	p.nodes = nodes
+	if err == nil && end.typ != itemEOF {
+		err = p.directiveErrorf(end, "unexpected %s", strings.TrimSpace(end.val))
+	}
+	p.lex.release()
+	p.lex = nil
+	return err
+}
+
+// parseNodes parses nodes up to the end of the input or a directive ending
+// a block, which it returns.
+func (p *Parser) parseNodes() (nodes []Node, end item, err error) {
 	for {
 		switch t := p.next(); t.typ {
 		case itemEOF:
-			break Loop
+			return nodes, t, nil
 		case itemError:
-			return p.errorf(t.val)
+			return nodes, t, p.errorf(t)
+		case itemDirective:
+			switch name, _ := directive(t.val); name {
+			case "else", "endif", "endforeach", markerEnd:
+				return nodes, t, nil
+			}
+			n, err := p.parseDirective(t)
+			if err != nil {
+				return nodes, t, err
+			}
+			nodes = append(nodes, n)
 		case itemVariable:
-			varNode := NewVariable(strings.TrimPrefix(t.val, "$"), p.Env, p.Restrict)
-			p.nodes = append(p.nodes, varNode)
+			nodes = append(nodes, p.newVariable(strings.TrimPrefix(t.val, "$"), t.pos))
 		case itemLeftDelim:
 			if p.peek().typ == itemVariable {
-				n, err := p.action()
+				n, err := p.action(t.pos)
 				if err != nil {
-					return err
+					return nodes, t, err
 				}
-				p.nodes = append(p.nodes, n)
+				nodes = append(nodes, n)
 				continue
 			}
 			fallthrough
 		default:
-			textNode := NewText(t.val)
-			p.nodes = append(p.nodes, textNode)
+			nodes = append(nodes, p.newText(t))
 		}
 	}
-	return nil
+}
+
+// newText returns the text node of item t.
+func (p *Parser) newText(t item) *TextNode {
+	n := alloc(&p.blocks.texts)
+	*n = TextNode{NodeText, t.pos, t.val}
+	return n
 }
 
 // Parse substitution. first item is a variable.
-func (p *Parser) action() (Node, error) {
+// pos is the position of the opening delimiter.
+func (p *Parser) action(pos Pos) (Node, error) {
 	var expType itemType
 	var defaultNode Node
-	varNode := NewVariable(p.next().val, p.Env, p.Restrict)
+	var filters []*Filter
+	varNode := p.newVariable(p.next().val, pos)
 Loop:
 	for {
 		switch t := p.next(); t.typ {
 		case itemRightDelim:
 			break Loop
 		case itemError:
-			return nil, p.errorf(t.val)
+			return nil, p.errorf(t)
+		case itemPipe:
+			t = p.next()
+			if t.typ == itemError {
+				return nil, p.errorf(t)
+			}
+			f, err := newFilter(t.val, t.pos, p.filters())
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, f)
 		case itemVariable:
-			defaultNode = NewVariable(strings.TrimPrefix(t.val, "$"), p.Env, p.Restrict)
+			defaultNode = p.newVariable(strings.TrimPrefix(t.val, "$"), t.pos)
 		case itemText:
-			n := NewText(t.val)
+			n := alloc(&p.blocks.texts)
+			*n = TextNode{NodeText, t.pos, t.val}
 		Text:
 			for {
 				switch p.peek().typ {
@@ -157,11 +624,70 @@ Loop:
 			expType = t.typ
 		}
 	}
-	return &SubstitutionNode{NodeSubstitution, expType, varNode, defaultNode}, nil
+	if text, ok := defaultNode.(*TextNode); ok && expType == 0 && strings.HasPrefix(text.Text, ":") {
+		if varNode.Ident == "include" && p.Includes != nil {
+			return &IncludeNode{NodeInclude, pos, text.Text[1:], p.Env, p, 0, nil, nil}, nil
+		}
+		if _, ok := p.Resolvers[varNode.Ident]; ok && (p.Referenced == nil || slices.Contains(p.Referenced, varNode.Ident)) {
+			return &ResolveNode{NodeResolve, pos, varNode.Ident, text.Text[1:], p}, nil
+		}
+		if varNode.builtin != nil {
+			varNode.arg = text.Text[1:]
+			return &SubstitutionNode{NodeSubstitution, pos, 0, varNode, nil, filters}, nil
+		}
+	}
+	n := alloc(&p.blocks.substitutions)
+	*n = SubstitutionNode{NodeSubstitution, pos, expType, varNode, defaultNode, filters}
+	return n, nil
+}
+
+// newVariable returns the node of a reference to the variable ident at pos.
+func (p *Parser) newVariable(ident string, pos Pos) *VariableNode {
+	n := alloc(&p.blocks.variables)
+	*n = VariableNode{NodeType: NodeVariable, Pos: pos, Ident: ident, Env: p.Env, Restrict: p.Restrict}
+	if p.Resolvers != nil {
+		n.resolve = p.resolve
+	}
+	n.builtin = p.Builtins[ident]
+	if replacement := p.Deprecated[ident]; p.MapDeprecated && replacement != "" {
+		n.Ident, n.replaced = replacement, ident
+	}
+	return n
+}
+
+// nodeBlocks are the nodes of the most frequent types allocated for a parse
+// in blocks, rather than one by one.
+type nodeBlocks struct {
+	texts         []TextNode
+	variables     []VariableNode
+	substitutions []SubstitutionNode
+}
+
+// nodeBlock is the number of nodes of a block.
+const nodeBlock = 32
+
+// alloc returns the next node of block, allocating a new block if it is
+// used up.
+func alloc[T any](block *[]T) *T {
+	if len(*block) == 0 {
+		*block = make([]T, nodeBlock)
+	}
+	n := &(*block)[0]
+	*block = (*block)[1:]
+	return n
+}
+
+// filters returns the filters available in substitutions.
+func (p *Parser) filters() Filters {
+	if p.Filters == nil {
+		return DefaultFilters
+	}
+	return p.Filters
 }
 
-func (p *Parser) errorf(s string) error {
-	return errors.New(s)
+// errorf returns the syntax error reported by the lexer in item t.
+func (p *Parser) errorf(t item) error {
+	return &Error{Pos: t.pos, Kind: KindSyntax, Msg: t.val}
 }
 
 // next returns the next token.
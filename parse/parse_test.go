@@ -1,7 +1,22 @@
 package parse
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	mathrand "math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 )
 
 var FakeEnv = []string{
@@ -219,3 +234,1412 @@ func TestNoReplace(t *testing.T) {
 		})
 	}
 }
+
+func TestErrorPosition(t *testing.T) {
+	input := "foo: $BAR\nbar: ${NOTSET}\nbaz: $EMPTY ${FOO"
+	_, err := (&Parser{Name: "pos", Env: FakeEnv, Restrict: Strict, Mode: AllErrors}).Parse(input)
+	list, ok := err.(ErrorList)
+	if !ok {
+		t.Fatalf("expected ErrorList, got %T: %v", err, err)
+	}
+	expected := []Error{
+		{Name: "pos", Line: 3, Col: 18, Kind: KindSyntax},
+		{Name: "pos", Line: 2, Col: 6, Variable: "NOTSET", Kind: KindUnset},
+		{Name: "pos", Line: 3, Col: 6, Variable: "EMPTY", Kind: KindEmpty},
+	}
+	if len(list) != len(expected) {
+		t.Fatalf("got %d errors, expected %d: %v", len(list), len(expected), err)
+	}
+	for i, e := range list {
+		x := expected[i]
+		if e.Name != x.Name || e.Line != x.Line || e.Col != x.Col || e.Variable != x.Variable || e.Kind != x.Kind {
+			t.Errorf("error %d: got %+v, expected %+v", i, *e, x)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	ttests := map[string]struct {
+		input  string
+		limits Limits
+		err    string
+		cause  error
+	}{
+		"input within limit":    {"$BAR$FOO", Limits{MaxInput: 8}, "", nil},
+		"input exceeds limit":   {"$BAR$FOO", Limits{MaxInput: 7}, "input size 8 exceeds limit of 7 bytes", ErrInputTooLarge},
+		"output within limit":   {"$BAR$FOO", Limits{MaxOutput: 6}, "", nil},
+		"output exceeds limit":  {"$BAR$FOO", Limits{MaxOutput: 5}, "output size exceeds limit of 5 bytes", ErrOutputTooLarge},
+		"depth is of includes":  {"${NOTSET:-$BAR}", Limits{MaxDepth: 1}, "", nil},
+		"limits abort on quick": {"${NOTSET}$BAR$FOO", Limits{MaxOutput: 3}, "output size exceeds limit of 3 bytes", ErrOutputTooLarge},
+	}
+	for name, test := range ttests {
+		t.Run(name, func(t *testing.T) {
+			_, err := (&Parser{Name: name, Env: FakeEnv, Restrict: Relaxed, Limits: test.limits}).Parse(test.input)
+			if test.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			e, ok := err.(*Error)
+			if !ok || e.Kind != KindLimit || e.Message() != test.err || !errors.Is(err, test.cause) {
+				t.Errorf("got error %v, expected %q", err, test.err)
+			}
+			_, err = (&Parser{Name: name, Env: FakeEnv, Restrict: Strict, Limits: test.limits, Mode: AllErrors}).Parse(test.input)
+			if !errors.Is(err, test.cause) {
+				t.Errorf("got error %v, expected a list with %v", err, test.cause)
+			}
+		})
+	}
+}
+
+func TestReferences(t *testing.T) {
+	input := "foo: $BAR\nbar: ${NOTSET:-$FOO} ${EMPTY:-text} $$ESCAPED"
+	refs, err := New("refs", FakeEnv, Strict).References(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Reference{
+		{Name: "BAR", Pos: 5, Line: 1, Col: 6},
+		{Name: "NOTSET", Pos: 15, Line: 2, Col: 6, Optional: true},
+		{Name: "FOO", Pos: 25, Line: 2, Col: 16},
+		{Name: "EMPTY", Pos: 31, Line: 2, Col: 22, Optional: true},
+	}
+	if len(refs) != len(expected) {
+		t.Fatalf("got %+v, expected %+v", refs, expected)
+	}
+	for i := range refs {
+		if refs[i] != expected[i] {
+			t.Errorf("reference %d: got %+v, expected %+v", i, refs[i], expected[i])
+		}
+	}
+}
+
+func TestSubstitutions(t *testing.T) {
+	ttests := map[string]int{
+		"no references":            0,
+		"$BAR and ${FOO}":          2,
+		"$NOTSET ${NOTSET}":        0,
+		"$EMPTY":                   1,
+		"${NOTSET:-default}":       1,
+		"${NOTSET:-$ALSO_NOTSET}":  0,
+		"${NOTSET-$BAR}":           1,
+		"${NOTSET+$BAR}":           0,
+		"$$BAR escaped":            0,
+		"${BAR:+alternate} $EMPTY": 2,
+	}
+	for input, expected := range ttests {
+		p := New(input, FakeEnv, Relaxed)
+		if _, err := p.Parse(input); err != nil {
+			t.Fatalf("%q: unexpected error: %v", input, err)
+		}
+		if got := p.Substitutions(); got != expected {
+			t.Errorf("%q: got %d substitutions, expected %d", input, got, expected)
+		}
+	}
+}
+
+func TestRegions(t *testing.T) {
+	input := `$BAR '$BAR' "$BAR $$FOO" ${NOTSET:-x}`
+	upper := func(s string) (string, error) { return strings.ToUpper(s), nil }
+	p := New("regions", FakeEnv, Relaxed)
+	p.Regions = []Region{
+		{Start: 5, End: 11, Skip: true},
+		{Start: 13, End: 24, Escape: upper},
+	}
+	result, err := p.Parse(input)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `bar '$BAR' "BAR $FOO" x`; result != expected {
+		t.Errorf("got %q, expected %q", result, expected)
+	}
+	refs, err := p.References(input)
+	if err != nil || len(refs) != 3 {
+		t.Errorf("expected the references outside the skipped region, got %v, %v", refs, err)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	p := New("transform", append(FakeEnv, "ARG=it's a $x"), Relaxed)
+	p.Transform = func(name, value string) string { return ShellQuote(value) }
+	result, err := p.Parse("echo $ARG ${BAR} ${NOTSET:-a b} $EMPTY $NOTSET")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `echo 'it'\''s a $x' bar 'a b' '' `; result != expected {
+		t.Errorf("got %q, expected %q", result, expected)
+	}
+}
+
+func TestURLEncode(t *testing.T) {
+	tests := map[string]string{
+		"plain-text_1.0~": "plain-text_1.0~",
+		"p@ss:w/rd?#":     "p%40ss%3Aw%2Frd%3F%23",
+		"a b+c%":          "a%20b%2Bc%25",
+		"grüße":           "gr%C3%BC%C3%9Fe",
+	}
+	for input, expected := range tests {
+		if got := URLEncode(input); got != expected {
+			t.Errorf("URLEncode(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestFilters(t *testing.T) {
+	env := append(FakeEnv, "CERT=line1\nline2", "SECRET_B64=c2VjcmV0", "BAD_B64=not base64!")
+	tests := []struct {
+		input, expected string
+		hasErr          bool
+	}{
+		{"${CERT|b64enc}", "bGluZTEKbGluZTI=", false},
+		{"${SECRET_B64|b64dec}", "secret", false},
+		{"${CERT|b64enc|b64dec}", "line1\nline2", false},
+		{"${NOTSET|b64enc}", "", false},
+		{"${BAR|upper}", "BAR", false},
+		{"${BAR|upper|lower}", "bar", false},
+		{`${BAR|replace a "o o"}`, "bo or", false},
+		{`${BAR|trim br}`, "a", false},
+		{"${NOTSET|default x}", "x", false},
+		{"${BAR|default x}", "bar", false},
+		{"${BAR|upper x}", "", true},
+		{"${BAD_B64|b64dec}", "", true},
+		{"${BAR|nosuchfilter}", "", true},
+		{"${BAR|b64enc", "", true},
+	}
+	for _, test := range tests {
+		result, err := New(test.input, env, Relaxed).Parse(test.input)
+		if (err != nil) != test.hasErr {
+			t.Errorf("%q: unexpected error result: %v", test.input, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("%q: got %q, expected %q", test.input, result, test.expected)
+		}
+	}
+}
+
+func TestDefaultFilterRestrictions(t *testing.T) {
+	restrictions := map[string]*Restrictions{"relaxed": Relaxed, "no-unset": NoUnset, "no-empty": NoEmpty,
+		"strict": Strict, "no-replace": {NoReplace: true}}
+	for name, r := range restrictions {
+		for _, v := range []string{"NOTSET", "EMPTY", "BAR"} {
+			expected, err := New("dash", FakeEnv, r).Parse("${" + v + ":-foo}")
+			if err != nil {
+				t.Fatalf("%s: ${%s:-foo}: %v", name, v, err)
+			}
+			result, err := New("filter", FakeEnv, r).Parse("${" + v + "|default foo}")
+			if result != expected || err != nil {
+				t.Errorf("%s: ${%s|default foo}: got %q, %v, expected %q like ${%s:-foo}", name, v, result, err, expected, v)
+			}
+			if v == "BAR" {
+				expected = "BAR"
+			}
+			result, err = New("filter", FakeEnv, r).Parse("${" + v + "|upper|default foo}")
+			if result != expected || err != nil {
+				t.Errorf("%s: ${%s|upper|default foo}: got %q, %v, expected %q", name, v, result, err, expected)
+			}
+		}
+	}
+	if _, err := New("filter", FakeEnv, NoUnset).Parse("${NOTSET|upper}"); err == nil {
+		t.Error("expected an error for an unset variable filtered without a default")
+	}
+	if _, err := New("filter", FakeEnv, NoEmpty).Parse("${EMPTY|upper}"); err == nil {
+		t.Error("expected an error for an empty variable filtered without a default")
+	}
+}
+
+func TestCustomFilters(t *testing.T) {
+	p := New("custom", FakeEnv, Relaxed)
+	p.Filters = DefaultFilters.With(Filters{
+		"quote": func(value string, args ...string) (string, error) { return "'" + value + "'", nil },
+	})
+	result, err := p.Parse("${BAR|upper|quote}")
+	if err != nil || result != "'BAR'" {
+		t.Errorf("got %q, %v, expected 'BAR'", result, err)
+	}
+	if _, err := New("default", FakeEnv, Relaxed).Parse("${BAR|quote}"); err == nil {
+		t.Error("expected custom filter to be unknown to other parsers")
+	}
+}
+
+func TestLibrary(t *testing.T) {
+	env := []string{"VERSION=v1.10.0-rc.1", "HOSTS=a, b,c", "PORT=80"}
+	tests := []struct {
+		input, expected string
+		hasErr          bool
+	}{
+		{"${PORT|sha256}", "48449a14a4ff7d79bb7a1b6f3d488eba397c36ef25634c111b49baf362511afc", false},
+		{"${PORT|md5}", "f033ab37c30201f73f142449d037028d", false},
+		{"${PORT|padleft 5 0}", "00080", false},
+		{"${PORT|padright 4}", "80  ", false},
+		{"${PORT|padleft 1}", "80", false},
+		{"${PORT|padleft x}", "", true},
+		{`${HOSTS|join ";"}`, "a;b;c", false},
+		{`${HOSTS|join " " ,}`, "a b c", false},
+		{"${VERSION|semver >=1.9}", "true", false},
+		{"${VERSION|semver >=1.10.0}", "false", false},
+		{"${VERSION|semver >1.10.0-rc.0}", "true", false},
+		{"${VERSION|semver >1.10.0-beta}", "true", false},
+		{"${VERSION|semver >=1.10.0-rc.2}", "false", false},
+		{"${VERSION|semver <1.10.0}", "true", false},
+		{"${VERSION|semver =1.10.0-rc.1+build}", "true", false},
+		{"${VERSION|semver ~1.10.0}", "", true},
+		{"${PORT|semver >1.x}", "", true},
+	}
+	for _, test := range tests {
+		p := New(test.input, env, Relaxed)
+		p.Filters = DefaultFilters.With(Library)
+		result, err := p.Parse(test.input)
+		if (err != nil) != test.hasErr {
+			t.Errorf("%q: unexpected error result: %v", test.input, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("%q: got %q, expected %q", test.input, result, test.expected)
+		}
+	}
+	if _, err := New("default", env, Relaxed).Parse("${PORT|sha256}"); err == nil {
+		t.Error("expected library filters to be unknown unless enabled")
+	}
+}
+
+func TestIncludes(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"main.conf":        "[main]\n${include:conf.d/db.conf}",
+		"conf.d/db.conf":   "host = ${BAR}\n${include:port.conf}",
+		"conf.d/port.conf": "port = ${PORT:-5432}\n",
+		"cycle.conf":       "${include:conf.d/back.conf}",
+		"conf.d/back.conf": "${include:../cycle.conf}",
+		"conf.d/bad.conf":  "${NOTSET}",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		input, expected string
+		errMsg          string
+	}{
+		{"${include:main.conf}", "[main]\nhost = bar\nport = 5432\n", ""},
+		{"${include:cycle.conf}", "", "include cycle"},
+		{"${include:../outside.conf}", "", "outside of"},
+		{"${include:missing.conf}", "", "no such file"},
+		{"${include:conf.d/bad.conf}", "", "bad.conf:1:1: variable ${NOTSET} not set"},
+	}
+	for _, test := range tests {
+		p := New(test.input, FakeEnv, NoUnset)
+		p.Includes = &Includes{Root: root}
+		result, err := p.Parse(test.input)
+		if test.errMsg != "" {
+			if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+				t.Errorf("%q: got error %v, expected %q", test.input, err, test.errMsg)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.input, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("%q: got %q, expected %q", test.input, result, test.expected)
+		}
+		if n := p.Substitutions(); n != 2 {
+			t.Errorf("%q: got %d substitutions, expected 3", test.input, n)
+		}
+	}
+	// main.conf includes db.conf which includes port.conf, 3 deep.
+	for max, expected := range map[int]bool{2: false, 3: true} {
+		p := New("depth", FakeEnv, Relaxed)
+		p.Includes = &Includes{Root: root}
+		p.Limits.MaxDepth = max
+		_, err := p.Parse("${include:main.conf}")
+		if (err == nil) != expected || err != nil && !errors.Is(err, ErrDepthExceeded) {
+			t.Errorf("depth limit %d: got %v", max, err)
+		}
+	}
+	// Unless enabled, the reference is the one of the variable include.
+	if result, err := New("disabled", []string{"include=inc"}, Relaxed).Parse("${include:main.conf}"); result != "inc" || err != nil {
+		t.Errorf("got %q, %v, expected the value of include unless enabled", result, err)
+	}
+}
+
+// withDirectives enables the directives of p.
+func withDirectives(p *Parser) *Parser {
+	p.Directives = true
+	return p
+}
+
+func TestDirectives(t *testing.T) {
+	env := append(FakeEnv, "ON=yes", "OFF=false")
+	tests := []struct {
+		input, expected string
+		hasErr          bool
+	}{
+		{"a\n#envsubst if ON\nb=$BAR\n#envsubst endif\nc\n", "a\nb=bar\nc\n", false},
+		{"a\n#envsubst if OFF\nb=$BAR\n#envsubst endif\nc\n", "a\nc\n", false},
+		{"#envsubst if NOTSET\nyes\n#envsubst else\nno\n#envsubst endif\n", "no\n", false},
+		{"#envsubst if !EMPTY\nyes\n#envsubst else\nno\n#envsubst endif", "yes\n", false},
+		{"#envsubst if ON\n  #envsubst if !OFF\nboth\n  #envsubst endif\n#envsubst endif\n", "both\n", false},
+		{"#envsubst if OFF\n${NOTSET}\n#envsubst endif\n", "", false},
+		{"#envsubst if ON\n${NOTSET}\n#envsubst endif\n", "", true},
+		{"#envsubst if ON\nno end\n", "", true},
+		{"#envsubst endif\n", "", true},
+		{"#envsubst if A B\n#envsubst endif\n", "", true},
+		{"#envsubst if ON\n#envsubst else\n#envsubst else\n#envsubst endif\n", "", true},
+		{"#envsubst unknown\n", "", true},
+	}
+	for _, test := range tests {
+		result, err := withDirectives(New(test.input, env, NoUnset)).Parse(test.input)
+		if (err != nil) != test.hasErr {
+			t.Errorf("%q: unexpected error result: %v", test.input, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("%q: got %q, expected %q", test.input, result, test.expected)
+		}
+	}
+	input := "a\n#envsubst if ON\nb\n#envsubst else\nc\n#envsubst endif\nd"
+	p := withDirectives(New("dropped", env, Relaxed))
+	if _, err := p.Parse(input); err != nil {
+		t.Fatal(err)
+	}
+	var dropped string
+	for _, s := range p.Dropped() {
+		dropped += input[s.Start:s.End]
+	}
+	if expected := "#envsubst if ON\n#envsubst else\nc\n#envsubst endif\n"; dropped != expected {
+		t.Errorf("dropped %q, expected %q", dropped, expected)
+	}
+}
+
+func TestDirectivesDisabled(t *testing.T) {
+	for _, input := range []string{
+		"#envsubst is run by CI\n$BAR\n",
+		"#envsubst if FOO\n$BAR\n#envsubst endif\n",
+	} {
+		expected := strings.ReplaceAll(input, "$BAR", "bar")
+		if result, err := New("disabled", FakeEnv, NoUnset).Parse(input); result != expected || err != nil {
+			t.Errorf("%q: got %q, %v, expected the lines as text", input, result, err)
+		}
+		var out strings.Builder
+		if err := New("disabled", FakeEnv, NoUnset).Stream(&out, strings.NewReader(input)); out.String() != expected || err != nil {
+			t.Errorf("%q: streamed %q, %v, expected the lines as text", input, out.String(), err)
+		}
+	}
+}
+
+func TestForeach(t *testing.T) {
+	env := append(FakeEnv, "NODES=a, b,c", "PORTS=80;443", "NONE=")
+	tests := []struct {
+		input, expected string
+		hasErr          bool
+	}{
+		{"#envsubst foreach NODE in $NODES\n${NODE_INDEX}=$NODE $BAR\n#envsubst endforeach\n", "0=a bar\n1=b bar\n2=c bar\n", false},
+		{"#envsubst foreach P in ${PORTS} sep ;\nport $P\n#envsubst endforeach\n", "port 80\nport 443\n", false},
+		{"a\n#envsubst foreach N in $NONE\n$N\n#envsubst endforeach\nb", "a\nb", false},
+		{"#envsubst foreach N in $NOTSET\n$N\n#envsubst endforeach\n", "", false},
+		{"#envsubst foreach N in $NODES\n#envsubst foreach P in $PORTS sep ;\n$N:$P\n#envsubst endforeach\n#envsubst endforeach\n",
+			"a:80\na:443\nb:80\nb:443\nc:80\nc:443\n", false},
+		{"#envsubst foreach N in $NODES\n#envsubst if N_INDEX\n,\n#envsubst endif\n$N\n#envsubst endforeach\n", "a\n,\nb\n,\nc\n", false},
+		{"#envsubst foreach N in $NODES\n$UNSET\n#envsubst endforeach\n", "", true},
+		{"#envsubst foreach N of $NODES\n#envsubst endforeach\n", "", true},
+		{"#envsubst foreach N in $NODES\n$N\n#envsubst endif\n", "", true},
+		{"#envsubst foreach N in $NODES\n$N\n", "", true},
+	}
+	for _, test := range tests {
+		result, err := withDirectives(New(test.input, env, NoUnset)).Parse(test.input)
+		if (err != nil) != test.hasErr {
+			t.Errorf("%q: unexpected error result: %v", test.input, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("%q: got %q, expected %q", test.input, result, test.expected)
+		}
+	}
+	refs, err := withDirectives(New("refs", env, Relaxed)).References("#envsubst foreach N in $NODES\n$N $BAR\n#envsubst endforeach\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[0].Name != "NODES" || refs[1].Name != "BAR" {
+		t.Errorf("got references %v, expected NODES and BAR", refs)
+	}
+}
+
+func TestOnly(t *testing.T) {
+	tests := []struct {
+		input, expected string
+		hasErr          bool
+	}{
+		{"a=$BAR $$\n# envsubst:only\nb=$BAR $$\n# envsubst:end\nc=${UNSET}\n",
+			"a=$BAR $$\n# envsubst:only\nb=bar $\n# envsubst:end\nc=${UNSET}\n", false},
+		{"#envsubst:only\n$BAR\n#envsubst:end\n$BAR\n  #  envsubst:only \r\n$FOO\n\t# envsubst:end",
+			"#envsubst:only\nbar\n#envsubst:end\n$BAR\n  #  envsubst:only \r\nfoo\n\t# envsubst:end", false},
+		{"# envsubst:only\n#envsubst if BAR\n$BAR\n#envsubst endif\n# envsubst:end\n", "# envsubst:only\nbar\n# envsubst:end\n", false},
+		{"# envsubst:only is a marker\n$BAR\n// envsubst:only\n$BAR\n", "# envsubst:only is a marker\nbar\n// envsubst:only\nbar\n", false},
+		{"# envsubst:only\n${UNSET}\n# envsubst:end\n", "", true},
+		{"# envsubst:only\n# envsubst:only\n# envsubst:end\n# envsubst:end\n", "", true},
+		{"# envsubst:only\n$BAR\n", "", true},
+		{"# envsubst:only\n#envsubst if BAR\n# envsubst:end\n", "", true},
+		{"# envsubst:end\n", "", true},
+	}
+	for _, test := range tests {
+		result, err := withDirectives(New(test.input, FakeEnv, NoUnset)).Parse(test.input)
+		if (err != nil) != test.hasErr {
+			t.Errorf("%q: unexpected error result: %v", test.input, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("%q: got %q, expected %q", test.input, result, test.expected)
+		}
+	}
+	// The markers do not need directives.
+	input := "$BAR\n# envsubst:only\n$BAR\n# envsubst:end\n"
+	if result, err := New("markers", FakeEnv, Relaxed).Parse(input); result != "$BAR\n# envsubst:only\nbar\n# envsubst:end\n" || err != nil {
+		t.Errorf("got %q, %v without directives", result, err)
+	}
+	p := New("only", FakeEnv, Relaxed)
+	p.Only = true
+	if result, _ := p.Parse("$BAR\n"); result != "$BAR\n" {
+		t.Errorf("got %q with Only, expected the text as is", result)
+	}
+	var out strings.Builder
+	input = "$BAR\n# envsubst:only\n$BAR\n# envsubst:end\n$BAR\n"
+	if err := New("stream", FakeEnv, Relaxed).Stream(&out, strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "bar\n# envsubst:only\nbar\n# envsubst:end\n$BAR\n"; out.String() != expected {
+		t.Errorf("streamed %q, expected %q", out.String(), expected)
+	}
+	if !HasOnly("a\n  # envsubst:only\n") || HasOnly("a # envsubst:only\n") || HasOnly("# envsubst:only here\n") ||
+		HasOnly("#envsubst only\n") {
+		t.Error("HasOnly reports only lines wrongly")
+	}
+	if DirectiveNesting("# envsubst:only\n") != 1 || DirectiveNesting("# envsubst:end\r\n") != -1 {
+		t.Error("only blocks not nesting")
+	}
+}
+
+func TestResolvers(t *testing.T) {
+	calls := 0
+	vault := ResolverFunc(func(ref string) (string, error) {
+		calls++
+		if ref == "secret/missing" {
+			return "", errors.New("not found")
+		}
+		return "value of " + ref, nil
+	})
+	env := []string{"DB_PASS=vault:secret/db#pass", "URL=http://example.com", "MISSING=vault:secret/missing"}
+	p := New("resolve", env, Relaxed)
+	p.Resolvers = Resolvers{"vault": vault}
+	result, err := p.Parse("$DB_PASS ${DB_PASS|upper} ${DB_PASS:-default} $URL")
+	if expected := "value of secret/db#pass VALUE OF SECRET/DB#PASS value of secret/db#pass http://example.com"; err != nil || result != expected {
+		t.Errorf("got %q, %v, expected %q", result, err, expected)
+	}
+	if calls != 1 {
+		t.Errorf("resolver called %d times, expected once", calls)
+	}
+	if _, err := p.Parse("$DB_PASS"); err != nil || calls != 2 {
+		t.Errorf("expected the cache to be reset for each Parse, got %d calls, %v", calls, err)
+	}
+	_, err = p.Parse("x ${MISSING:-default}")
+	if e, ok := err.(*Error); !ok || e.Kind != KindResolve || e.Variable != "MISSING" {
+		t.Errorf("got %v, expected a resolve error for MISSING", err)
+	}
+	p.Mode = AllErrors
+	result, err = p.Parse("pass: ${vault:secret/db#pass} ${vault:secret/missing}")
+	if list, ok := err.(ErrorList); !ok || len(list) != 1 || list[0].Kind != KindResolve || list[0].Col != 31 {
+		t.Errorf("got %q, %v, expected a resolve error at column 31", result, err)
+	}
+	p.Mode = Quick
+	if result, err = p.Parse("pass: ${vault:secret/db#pass}"); err != nil || result != "pass: value of secret/db#pass" {
+		t.Errorf("got %q, %v for a reference", result, err)
+	}
+	p.Referenced = []string{"file"}
+	if result, err = p.Parse("$DB_PASS ${vault:secret/db#pass}"); strings.Contains(result, "value of secret/db#pass value of") {
+		t.Errorf("got %q, %v, expected the reference not to be resolved", result, err)
+	}
+	if result, _ := New("disabled", env, Relaxed).Parse("$DB_PASS"); result != "vault:secret/db#pass" {
+		t.Errorf("got %q, expected values not to be resolved unless enabled", result)
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	now := func() time.Time { return time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC) }
+	p := New("builtins", []string{"__NOW=from env"}, Strict)
+	p.Builtins = TimeBuiltins(now)
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"$__NOW ${__UNIX_TS}", "2024-05-01T12:30:00Z 1714566600"},
+		{"${__DATE} ${__DATE:02.01.2006 15:04}", "2024-05-01 01.05.2024 12:30"},
+		{"${__DATE|upper} ${__NOW:-later}", "2024-05-01 2024-05-01T12:30:00Z"},
+	}
+	for _, test := range tests {
+		if result, err := p.Parse(test.input); err != nil || result != test.expected {
+			t.Errorf("%s: got %q, %v, expected %q", test.input, result, err, test.expected)
+		}
+	}
+	if _, err := p.Parse("${__NOW:2006}"); err == nil {
+		t.Error("expected an error for an argument of __NOW")
+	}
+	refs, err := p.References("$__NOW $HOST")
+	if err != nil || len(refs) != 1 || refs[0].Name != "HOST" {
+		t.Errorf("got %v, %v, expected builtins not to be references", refs, err)
+	}
+	p.Builtins = p.Builtins.With(HostBuiltins())
+	hostname, _ := os.Hostname()
+	expected := fmt.Sprintf("%s %s/%s %d", hostname, runtime.GOOS, runtime.GOARCH, os.Getuid())
+	if result, err := p.Parse("$__HOSTNAME ${__OS}/${__ARCH} $__UID"); err != nil || result != expected {
+		t.Errorf("got %q, %v, expected %q", result, err, expected)
+	}
+	if result, _ := New("disabled", nil, Relaxed).Parse("[$__NOW]"); result != "[]" {
+		t.Errorf("got %q, expected builtins to be disabled by default", result)
+	}
+}
+
+func TestRandomBuiltins(t *testing.T) {
+	p := New("random", nil, Relaxed)
+	render := func(input string) string {
+		p.Builtins = RandomBuiltins(mathrand.New(mathrand.NewSource(1)))
+		result, err := p.Parse(input)
+		if err != nil {
+			t.Fatalf("%s: %v", input, err)
+		}
+		return result
+	}
+	input := "$__UUID ${__UUID} ${__RANDOM} ${__RANDOM:hex:7} ${__RANDOM:alnum:40} ${__RANDOM:base64:10}"
+	result := render(input)
+	if again := render(input); again != result {
+		t.Errorf("got %q and %q, expected the same values for the same seed", result, again)
+	}
+	fields := strings.Fields(result)
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !uuid.MatchString(fields[0]) || fields[0] == fields[1] {
+		t.Errorf("got %q, %q, expected distinct version 4 UUIDs", fields[0], fields[1])
+	}
+	for i, pattern := range []string{`^[0-9a-f]{16}$`, `^[0-9a-f]{7}$`, `^[0-9A-Za-z]{40}$`, `^[0-9A-Za-z_-]{10}$`} {
+		if !regexp.MustCompile(pattern).MatchString(fields[i+2]) {
+			t.Errorf("got %q, expected %s", fields[i+2], pattern)
+		}
+	}
+	for _, input := range []string{"${__RANDOM:oct}", "${__RANDOM:hex:0}", "${__UUID:4}"} {
+		if _, err := p.Parse(input); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	p := New("log", []string{"SECRET=hunter2", "EMPTY="}, Relaxed)
+	p.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	if _, err := p.Parse("$SECRET\n${EMPTY:-x} $UNSET"); err != nil {
+		t.Fatal(err)
+	}
+	expected := `level=DEBUG msg=substituted template=log variable=SECRET line=1 col=1
+level=WARN msg="variable ${EMPTY} is set but empty, its default is substituted" template=log variable=EMPTY line=2 col=1
+level=DEBUG msg="default applied" template=log variable=EMPTY line=2 col=1
+level=DEBUG msg="skipped unset variable" template=log variable=UNSET line=2 col=13
+`
+	if buf.String() != expected {
+		t.Errorf("got\n%s\nexpected\n%s", buf.String(), expected)
+	}
+}
+
+// countingMetrics counts the events by kind and variable.
+type countingMetrics map[string]int
+
+func (m countingMetrics) Substituted(template, variable string)  { m["substituted "+variable]++ }
+func (m countingMetrics) Defaulted(template, variable string)    { m["defaulted "+variable]++ }
+func (m countingMetrics) Unset(template, variable string)        { m["unset "+variable]++ }
+func (m countingMetrics) Failed(template string, kind ErrorKind) { m["failed "+string(kind)]++ }
+
+func TestMetrics(t *testing.T) {
+	m := countingMetrics{}
+	p := New("metrics", []string{"FOO=foo", "EMPTY="}, Relaxed)
+	p.Metrics = m
+	if _, err := p.Parse("$FOO $FOO ${EMPTY:-x} ${UNSET-y} $UNSET"); err != nil {
+		t.Fatal(err)
+	}
+	p.Restrict = Strict
+	p.Mode = AllErrors
+	if _, err := p.Parse("$EMPTY $UNSET ${FOO"); err == nil {
+		t.Fatal("expected errors")
+	}
+	expected := countingMetrics{
+		"substituted FOO": 2, "defaulted EMPTY": 1, "defaulted UNSET": 1, "unset UNSET": 1,
+		"failed syntax": 1, "failed empty": 1, "failed unset": 1,
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("got %v, expected %v", m, expected)
+	}
+}
+
+func TestMask(t *testing.T) {
+	p := New("mask", []string{"DB_PASSWORD=hunter2", "API_TOKEN=vault:token", "VERSION=1.2"}, Relaxed)
+	p.Filters = DefaultFilters.With(Library)
+	p.Resolvers = Resolvers{"vault": ResolverFunc(func(ref string) (string, error) { return "s3cr3t", nil })}
+	p.Mask = MaskGlobs("*_PASSWORD", "*_TOKEN")
+	p.Mode = AllErrors
+	_, err := p.Parse("${DB_PASSWORD|semver >1} ${API_TOKEN|semver >1} ${VERSION|semver >x}")
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, s := range []string{"hunter2", "s3cr3t"} {
+		if strings.Contains(err.Error(), s) {
+			t.Errorf("%q leaked in %q", s, err)
+		}
+	}
+	if !strings.Contains(err.Error(), Masked) || !strings.Contains(err.Error(), `"x"`) {
+		t.Errorf("got %q, expected masked values and others kept", err)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	p := New("audit", []string{"HOST=db", "DB_PASSWORD=hunter2", "EMPTY="}, Relaxed)
+	var got []Substitution
+	p.Audit = func(s Substitution) { got = append(got, s) }
+	p.Mask = MaskGlobs("*_PASSWORD")
+	p.Source = func(name string) string { return "env" }
+	if _, err := p.Parse("$HOST\n${DB_PASSWORD} ${EMPTY:-x} ${UNSET:-$DB_PASSWORD} $UNSET"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Substitution{
+		{"audit", "HOST", 0, 1, 1, "env", false, "db"},
+		{"audit", "DB_PASSWORD", 6, 2, 1, "env", false, Masked},
+		{"audit", "EMPTY", 21, 2, 16, "", true, "x"},
+		{"audit", "UNSET", 33, 2, 28, "", true, Masked},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %+v, expected %+v", got, expected)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	p := New("redact", []string{"DB_PASSWORD=foo", "HOST=db"}, Relaxed)
+	p.Transform = Redact
+	if result, err := p.Parse("$HOST:${DB_PASSWORD} ${UNSET:-x} [$UNSET]"); err != nil || result != "<redacted:HOST>:<redacted:DB_PASSWORD> <redacted:UNSET> []" {
+		t.Errorf("got %q, %v", result, err)
+	}
+	p.Transform = RedactHash
+	if result, err := p.Parse("$DB_PASSWORD"); err != nil || result != "<sha256:2c26b46b68ff>" {
+		t.Errorf("got %q, %v", result, err)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	env := []string{"PORT=eighty", "GOOD_PORT=8080", "DEBUG=yes", "TIMEOUT=1m30s", "URL=https://example.com", "PATH_ONLY=/x", "RATIO=0.5"}
+	p := New("schema", env, Relaxed)
+	p.Schema = Schema{
+		"PORT": {Type: TypePort}, "GOOD_PORT": {Type: TypePort}, "DEBUG": {Type: TypeBool},
+		"TIMEOUT": {Type: TypeDuration}, "URL": {Type: TypeURL}, "PATH_ONLY": {Type: TypeURL},
+		"RATIO": {Type: TypeFloat}, "COUNT": {Type: TypeInt},
+	}
+	tests := []struct {
+		input string
+		fails bool
+	}{
+		{"$GOOD_PORT $TIMEOUT $URL $RATIO", false},
+		{"$PORT", true},
+		{"$DEBUG", true},
+		{"$PATH_ONLY", true},
+		{"${COUNT:-3}", false},
+		{"${COUNT:-three}", true},
+		{"[$COUNT]", false},
+		{"${GOOD_PORT|replace 8080 0}", true},
+	}
+	for _, test := range tests {
+		_, err := p.Parse(test.input)
+		if e, ok := err.(*Error); test.fails != (err != nil) || test.fails && (!ok || e.Kind != KindSchema) {
+			t.Errorf("%s: got %v, expected failure %v", test.input, err, test.fails)
+		}
+	}
+	if _, err := p.Parse("$PORT"); err == nil || err.Error() != `${PORT}: value "eighty" is not of type port` {
+		t.Errorf("got %v", err)
+	}
+	if _, err := ParseType("uuid"); err == nil {
+		t.Error("expected an error for an unknown type")
+	}
+}
+
+func TestSchemaConstraints(t *testing.T) {
+	min, max := 1.0, 100.0
+	schema := Schema{
+		"LEVEL":    {Enum: []string{"debug", "info"}},
+		"NAME":     {Pattern: regexp.MustCompile(`^[a-z][a-z0-9-]*$`), Max: &max},
+		"REPLICAS": {Type: TypeInt, Min: &min, Max: &max},
+		"TOKEN":    {NonEmpty: true, Min: &min},
+	}
+	tests := []struct {
+		env   string
+		fails string
+	}{
+		{"LEVEL=info", ""},
+		{"LEVEL=verbose", `${LEVEL}: value "verbose" is not one of debug, info`},
+		{"NAME=my-app", ""},
+		{"NAME=My_App", `${NAME}: value "My_App" does not match ^[a-z][a-z0-9-]*$`},
+		{"NAME=" + strings.Repeat("a", 101), "is greater than 100"},
+		{"REPLICAS=3", ""},
+		{"REPLICAS=0", `${REPLICAS}: value "0" is less than 1`},
+		{"REPLICAS=1000", "is greater than 100"},
+		{"TOKEN=", "${TOKEN}: value must not be empty"},
+	}
+	for _, test := range tests {
+		name, _, _ := strings.Cut(test.env, "=")
+		p := New("constraints", []string{test.env}, Relaxed)
+		p.Schema = schema
+		_, err := p.Parse("${" + name + "}")
+		if test.fails == "" && err != nil || test.fails != "" && (err == nil || !strings.Contains(err.Error(), test.fails)) {
+			t.Errorf("%s: got %v, expected %q", test.env, err, test.fails)
+		}
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	var buf bytes.Buffer
+	p := New("deprecated", []string{"DB_HOST=old", "DATABASE_HOST=new"}, Relaxed)
+	p.Deprecated = map[string]string{"DB_HOST": "DATABASE_HOST", "LEGACY": ""}
+	p.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	if result, err := p.Parse("$DB_HOST ${LEGACY:-x}"); err != nil || result != "old x" {
+		t.Errorf("got %q, %v", result, err)
+	}
+	expected := `level=WARN msg="variable ${DB_HOST} is deprecated, use ${DATABASE_HOST}" template=deprecated variable=DB_HOST line=1 col=1
+level=WARN msg="variable ${LEGACY} is deprecated" template=deprecated variable=LEGACY line=1 col=10
+`
+	if buf.String() != expected {
+		t.Errorf("got\n%s\nexpected\n%s", buf.String(), expected)
+	}
+	p.MapDeprecated = true
+	if result, err := p.Parse("$DB_HOST"); err != nil || result != "new" {
+		t.Errorf("got %q, %v, expected the value of the replacement", result, err)
+	}
+	p.FailDeprecated = true
+	if _, err := p.Parse("x $DB_HOST"); err == nil || err.(*Error).Kind != KindDeprecated || err.(*Error).Col != 3 {
+		t.Errorf("got %v, expected a deprecation error", err)
+	}
+	env, used := MapDeprecated([]string{"DB_HOST=old", "LEGACY=1"}, p.Deprecated)
+	if !reflect.DeepEqual(env, []string{"DATABASE_HOST=old", "DB_HOST=old", "LEGACY=1"}) || !reflect.DeepEqual(used, []string{"DB_HOST", "LEGACY"}) {
+		t.Errorf("got %q, %q", env, used)
+	}
+	if env, _ := MapDeprecated([]string{"DB_HOST=old", "DATABASE_HOST=new"}, p.Deprecated); len(env) != 2 {
+		t.Errorf("got %q, expected set replacements to be kept", env)
+	}
+}
+
+func TestDump(t *testing.T) {
+	p := withDirectives(New("dump", nil, Relaxed))
+	var buf bytes.Buffer
+	if err := p.DumpTokens(&buf, "a=${A:-$B}"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "1:1\tTEXT\t\"a=\"\n1:3\tSTART EXP\t\"${\"\n1:5\tVAR\t\"A\"\n1:6\tOP\t\":-\"\n1:8\tVAR\t\"$B\"\n1:10\tEND EXP\t\"}\"\n1:11\tEOF\t\"\"\n"
+	if buf.String() != expected {
+		t.Errorf("got\n%s\nexpected\n%s", buf.String(), expected)
+	}
+	buf.Reset()
+	err := p.DumpTree(&buf, "${A:-x}${B|upper}\n#envsubst if !F\n$C\n#envsubst endif\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = `1:1	Substitution op=":-"
+1:1	  Variable A
+1:6	  Text "x"
+1:8	Substitution
+1:8	  Variable B
+1:12	  Filter upper []
+1:18	Text "\n"
+2:1	If !F
+3:1	  Variable C
+3:3	  Text "\n"
+`
+	if buf.String() != expected {
+		t.Errorf("got\n%s\nexpected\n%s", buf.String(), expected)
+	}
+	buf.Reset()
+	if err := p.DumpTokens(&buf, "\n${A"); err == nil || err.(*Error).Line != 2 || !strings.HasSuffix(buf.String(), "ERROR\t\"closing brace expected\"\n") {
+		t.Errorf("got %v and\n%s\nexpected the error token", err, buf.String())
+	}
+	if err := p.DumpTree(&buf, "${A"); err == nil {
+		t.Error("expected a syntax error")
+	}
+}
+
+// recordingTracer records the spans started and ended, indented by depth.
+type recordingTracer struct {
+	events *[]string
+	depth  int
+}
+
+func (t recordingTracer) Start(name string, attrs ...slog.Attr) TraceSpan {
+	*t.events = append(*t.events, fmt.Sprintf("%*sstart %s %v", t.depth*2, "", name, attrs))
+	return recordingTracer{t.events, t.depth + 1}
+}
+
+func (t recordingTracer) End(err error, attrs ...slog.Attr) {
+	*t.events = append(*t.events, fmt.Sprintf("%*send %v %v", (t.depth-1)*2, "", err, attrs))
+}
+
+func TestTracer(t *testing.T) {
+	var events []string
+	p := New("traced", []string{"A=1"}, Relaxed)
+	p.Tracer = recordingTracer{events: &events}
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "port.conf"), []byte("port = ${PORT:-5432}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	included, err := filepath.EvalSymlinks(filepath.Join(root, "port.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Includes = &Includes{Root: root}
+	if _, err := p.Parse("$A ${include:port.conf}"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"start envsubst.Parse [envsubst.template=traced envsubst.template.size=23]",
+		"  start envsubst.parse []",
+		"  end <nil> [envsubst.nodes=3]",
+		"  start envsubst.execute []",
+		"    start envsubst.Parse [envsubst.template=" + included + " envsubst.template.size=21]",
+		"      start envsubst.parse []",
+		"      end <nil> [envsubst.nodes=3]",
+		"      start envsubst.execute []",
+		"      end <nil> [envsubst.substitutions=1]",
+		"    end <nil> [envsubst.substitutions=1 envsubst.output.size=12]",
+		"  end <nil> [envsubst.substitutions=2]",
+		"end <nil> [envsubst.substitutions=2 envsubst.output.size=14]",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("got\n%s\nexpected\n%s", strings.Join(events, "\n"), strings.Join(expected, "\n"))
+	}
+	events = nil
+	p.Restrict = NoUnset
+	if _, err := p.Parse("$B"); err == nil || !strings.HasPrefix(events[len(events)-1], "end variable ${B} not set") {
+		t.Errorf("got %q, expected the error to end the span", events)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	p := New("sandbox", []string{"SLOW=slow:x", "BIG=big:x", "CTX=ctx:x"}, Relaxed)
+	p.Resolvers = Resolvers{
+		"slow": ResolverFunc(func(ref string) (string, error) {
+			<-release
+			return ref, nil
+		}),
+		"big": ResolverFunc(func(ref string) (string, error) { return strings.Repeat(ref, 5), nil }),
+		"ctx": contextResolver(func(ctx context.Context, ref string) (string, error) {
+			env, ok := SandboxEnv(ctx)
+			return fmt.Sprint(env, ok), nil
+		}),
+	}
+	p.Sandbox = &Sandbox{Timeout: 10 * time.Millisecond, MaxValue: 4, Env: []string{"PATH"}}
+	tests := []struct {
+		input, expected, err string
+	}{
+		{"$SLOW", "", "resolve slow:x: timed out after 10ms"},
+		{"$BIG", "", "resolve big:x: value of 5 bytes exceeds limit of 4 bytes"},
+		{"${big:x}", "", "resolve big:x: value of 5 bytes exceeds limit of 4 bytes"},
+	}
+	for _, test := range tests {
+		result, err := p.Parse(test.input)
+		if result != test.expected || err == nil || err.Error() != test.err {
+			t.Errorf("%s: got %q, %v, expected %q, %s", test.input, result, err, test.expected, test.err)
+		}
+	}
+	p.Sandbox.MaxValue = 0
+	if result, err := p.Parse("$CTX"); err != nil || result != "[PATH] true" {
+		t.Errorf("got %q, %v, expected the environment of the sandbox", result, err)
+	}
+}
+
+// contextResolver adapts a function to a ContextResolver.
+type contextResolver func(ctx context.Context, ref string) (string, error)
+
+func (f contextResolver) Resolve(ref string) (string, error) {
+	return f(context.Background(), ref)
+}
+
+func (f contextResolver) ResolveContext(ctx context.Context, ref string) (string, error) {
+	return f(ctx, ref)
+}
+
+func TestErrorCodes(t *testing.T) {
+	// The codes are stable, tools rely on them.
+	expected := map[ErrorKind]string{
+		KindUnset:      "ENV001",
+		KindEmpty:      "ENV002",
+		KindSyntax:     "ENV003",
+		KindLimit:      "ENV004",
+		KindResolve:    "ENV005",
+		KindSchema:     "ENV006",
+		KindDeprecated: "ENV007",
+		KindPolicy:     "ENV011",
+		"unknown":      "",
+	}
+	for kind, code := range expected {
+		if got := kind.Code(); got != code {
+			t.Errorf("%s: got %q, expected %q", kind, got, code)
+		}
+	}
+	_, err := New("codes", nil, NoUnset).Parse("$NOTSET")
+	if e, ok := err.(*Error); !ok || e.Code() != "ENV001" {
+		t.Errorf("got %v, expected an error with code ENV001", err)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	var requests []PolicyRequest
+	p := New("policy", []string{"DB_PASSWORD=secret", "HOST=db"}, Relaxed)
+	p.Destination, p.Syntax = "out/app.yaml", "yaml"
+	p.Policy = func(r PolicyRequest) error {
+		requests = append(requests, r)
+		if strings.HasSuffix(r.Variable, "_PASSWORD") && !r.Default {
+			return errors.New("secrets must not be written in plain text")
+		}
+		return nil
+	}
+	if result, err := p.Parse("$HOST ${API_PASSWORD:-none} $UNSET"); err != nil || result != "db none " {
+		t.Errorf("got %q, %v", result, err)
+	}
+	expected := []PolicyRequest{
+		{"policy", "HOST", false, "out/app.yaml", "yaml"},
+		{"policy", "API_PASSWORD", true, "out/app.yaml", "yaml"},
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("got %+v, expected %+v", requests, expected)
+	}
+	_, err := p.Parse("host: $HOST\npassword: $DB_PASSWORD")
+	e, ok := err.(*Error)
+	if !ok || e.Kind != KindPolicy || e.Line != 2 || e.Message() != "${DB_PASSWORD}: denied: secrets must not be written in plain text" {
+		t.Errorf("got %v, expected a policy error", err)
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	p := New("provenance", []string{"HOST=db", "PORT=5432"}, Relaxed)
+	p.Provenance = &Provenance{Prefix: " # envsubst: "}
+	result, err := p.Parse("url=$HOST:$PORT/$HOST\r\nplain\nport=${PORT}")
+	expected := "url=db:5432/db # envsubst: HOST, PORT\r\nplain\nport=5432 # envsubst: PORT"
+	if err != nil || result != expected {
+		t.Errorf("got %q, %v, expected %q", result, err, expected)
+	}
+	if names := p.Substituted(); !reflect.DeepEqual(names, []string{"HOST", "PORT"}) {
+		t.Errorf("got substituted %v", names)
+	}
+	p.Provenance = &Provenance{Prefix: "; envsubst: ", Suffix: "\n", Before: true}
+	p.Regions = []Region{{Start: 2, End: 10}}
+	result, err = p.Parse("a=$HOST\n  b\nc=d\n")
+	expected = "; envsubst: HOST\na=db\n  b\nc=d\n"
+	if err != nil || result != expected {
+		t.Errorf("got %q, %v, expected %q", result, err, expected)
+	}
+}
+
+func TestLock(t *testing.T) {
+	p := withDirectives(New("lock", []string{"HOST=db", "NODES=a,b", "EMPTY="}, Relaxed))
+	p.Lock = NewLock()
+	text := "$HOST ${PORT:-80} $EMPTY\n#envsubst foreach NODE in $NODES\n$NODE\n#envsubst endforeach\n"
+	if _, err := p.Parse(text); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"HOST": Hash("db"), "PORT": Unset, "EMPTY": Hash(""), "NODES": Hash("a,b")}
+	if got := p.Lock.Variables(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+	var b bytes.Buffer
+	if err := p.Lock.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	locked, err := ReadLock(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := locked.Verify(p.Lock); m != nil {
+		t.Errorf("got mismatches %v for the same values", m)
+	}
+	p.Env = []string{"HOST=db2", "PORT=8080", "NODES=a,b"}
+	p.Lock = NewLock()
+	if _, err := p.Parse(text + "$NEW"); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range locked.Verify(p.Lock) {
+		got = append(got, m.String())
+	}
+	expectedMsgs := []string{
+		"variable ${EMPTY} is not set, unlike when locked",
+		"variable ${HOST} differs from its locked value",
+		"variable ${NEW} is not locked",
+		"variable ${PORT} is set, unlike when locked",
+	}
+	if !reflect.DeepEqual(got, expectedMsgs) {
+		t.Errorf("got %q, expected %q", got, expectedMsgs)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	p := New("compare", nil, Relaxed)
+	p.Mask = MaskGlobs("*_PASSWORD")
+	before := Env{"HOST=staging", "DB_PASSWORD=s3cret", "PORT=80"}
+	after := Env{"HOST=prod", "DB_PASSWORD=t0p", "PORT=80", "DEBUG=1"}
+	changes, err := p.Compare("$HOST:$PORT\n${DB_PASSWORD}\n${DEBUG:+debug}\n${URL:-$DB_PASSWORD}", before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type change struct {
+		variable      string
+		line          int
+		before, after string
+	}
+	var got []change
+	value := func(s *Substitution) string {
+		if s == nil {
+			return "-"
+		}
+		return s.Value
+	}
+	for _, c := range changes {
+		got = append(got, change{c.Variable, c.Line, value(c.Before), value(c.After)})
+	}
+	expected := []change{
+		{"HOST", 1, "staging", "prod"},
+		{"DB_PASSWORD", 2, Masked, Masked},
+		{"URL", 4, Masked, Masked},
+		{"DEBUG", 3, "-", "debug"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+}
+
+func TestEmptyDefault(t *testing.T) {
+	var buf bytes.Buffer
+	p := New("empty-default", []string{"TOKEN=", "HOST=db"}, Relaxed)
+	p.Logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	if result, err := p.Parse("${TOKEN:-none} ${TOKEN-x} ${UNSET:-y} ${HOST:-z}"); err != nil || result != "none  y db" {
+		t.Errorf("got %q, %v", result, err)
+	}
+	expected := `level=WARN msg="variable ${TOKEN} is set but empty, its default is substituted" template=empty-default variable=TOKEN line=1 col=1
+`
+	if buf.String() != expected {
+		t.Errorf("got\n%s\nexpected\n%s", buf.String(), expected)
+	}
+	p.FailEmptyDefault = true
+	_, err := p.Parse("a: ${HOST:-x}\nb: ${TOKEN:=y}")
+	if e, ok := err.(*Error); !ok || e.Kind != KindEmptyDefault || e.Line != 2 || e.Code() != "ENV013" {
+		t.Errorf("got %v, expected an empty default error", err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	long := strings.Repeat("x", 5000) + "$BAR" + strings.Repeat("y", 5000) + "${BAR}"
+	inputs := []string{
+		"foo=$FOO\r\nbar=${BAR:-x}\n$$HOME",
+		"a\n#envsubst if BAR\nb=$BAR\n#envsubst endif\nc\n",
+		long + "\n" + long,
+		"",
+	}
+	for _, input := range inputs {
+		expected, err := New("stream", FakeEnv, Relaxed).Parse(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		p := New("stream", FakeEnv, Relaxed)
+		if err := p.Stream(&buf, iotest.HalfReader(strings.NewReader(input))); err != nil {
+			t.Errorf("%.20q: %v", input, err)
+		}
+		if buf.String() != expected {
+			t.Errorf("%.20q: got %.40q, expected %.40q", input, buf.String(), expected)
+		}
+	}
+	var buf bytes.Buffer
+	p := New("stream", FakeEnv, NoUnset)
+	p.Mode = AllErrors
+	err := p.Stream(&buf, strings.NewReader("a=$FOO\nb=${NOTSET}\nc=$BAR ${EMPTY} ${UNSET2}\nd=$BAR\n"))
+	list, ok := err.(ErrorList)
+	if !ok || len(list) != 2 || list[0].Line != 2 || list[0].Col != 3 || list[1].Line != 3 || list[1].Col != 17 || list[1].Pos != 35 {
+		t.Errorf("got %v, expected errors at 2:3 and 3:17", err)
+	}
+	// The output stops at the first error.
+	if expected := "a=foo\n"; buf.String() != expected {
+		t.Errorf("got %q, expected %q", buf.String(), expected)
+	}
+	if p.Substitutions() != 4 {
+		t.Errorf("got %d substitutions, expected 4", p.Substitutions())
+	}
+	p = New("stream", FakeEnv, Relaxed)
+	p.Limits.MaxInput = 10
+	if err := p.Stream(io.Discard, strings.NewReader("a=$FOO\nb=$BAR\n")); !errors.Is(err, ErrInputTooLarge) {
+		t.Errorf("got %v, expected the input limit", err)
+	}
+	p = New("stream", FakeEnv, Relaxed)
+	if err := p.Stream(io.Discard, iotest.ErrReader(io.ErrUnexpectedEOF)); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v, expected the read error", err)
+	}
+	// Each line is rendered before the next one is read.
+	buf.Reset()
+	r := &lineReader{lines: []string{"a=$FOO\n", "b=${BAR}\n", "é\n"}, w: &buf}
+	if err := New("stream", FakeEnv, Relaxed).Stream(&buf, r); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"", "a=foo\n", "a=foo\nb=bar\n", "a=foo\nb=bar\né\n"}; !reflect.DeepEqual(r.written, expected) {
+		t.Errorf("got %q written at each read, expected %q", r.written, expected)
+	}
+}
+
+// lineReader reads lines one at a time, recording what was written to w
+// before each read.
+type lineReader struct {
+	lines   []string
+	w       *bytes.Buffer
+	written []string
+}
+
+func (r *lineReader) Read(b []byte) (int, error) {
+	r.written = append(r.written, r.w.String())
+	if len(r.lines) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, r.lines[0])
+	r.lines = r.lines[1:]
+	return n, nil
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		input    string
+		restrict *Restrictions
+		compiled bool
+	}{
+		{"foo=$FOO bar=${BAR} $FOO$$ ${EMPTY}.$NOTSET", Relaxed, true},
+		{"foo=$FOO bar=${BAR} $FOO$$ ${EMPTY}.$NOTSET", &Restrictions{NoReplace: true}, true},
+		{"a=$FOO\nb=${NOTSET}\nc=${EMPTY}", NoUnset, true},
+		{"a=$FOO\nb=${NOTSET}\nc=${EMPTY}", Strict, true},
+		{"${NOTSET:-$FOO} ${BAR|upper}", Relaxed, false},
+		{"#envsubst if FOO\n$BAR\n#envsubst endif\n", Relaxed, false},
+	}
+	env := Env{"FOO=foo", "BAR=bar", "EMPTY="}
+	for _, test := range tests {
+		for _, mode := range []Mode{Quick, AllErrors} {
+			p := withDirectives(New("compile", nil, test.restrict))
+			p.Mode = mode
+			tmpl, err := p.Compile(test.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if compiled := tmpl.segments != nil; compiled != test.compiled {
+				t.Errorf("%q: compiled %v, expected %v", test.input, compiled, test.compiled)
+			}
+			q := withDirectives(New("compile", env, test.restrict))
+			q.Mode = mode
+			expected, experr := q.Parse(test.input)
+			var buf bytes.Buffer
+			err = tmpl.Execute(&buf, env)
+			if buf.String() != expected || fmt.Sprint(err) != fmt.Sprint(experr) {
+				t.Errorf("%q: got %q, %v, expected %q, %v", test.input, buf.String(), err, expected, experr)
+			}
+			if e, ok := experr.(*Error); ok && !reflect.DeepEqual(err, e) {
+				t.Errorf("%q: got %#v, expected %#v", test.input, err, e)
+			}
+			if !test.compiled {
+				continue
+			}
+			b, err := tmpl.AppendExecute([]byte("prefix:"), env.Lookup)
+			if experr == nil {
+				expected = "prefix:" + expected
+			} else {
+				expected = "prefix:"
+			}
+			if string(b) != expected || fmt.Sprint(err) != fmt.Sprint(experr) {
+				t.Errorf("%q: appended %q, %v, expected %q, %v", test.input, b, err, expected, experr)
+			}
+		}
+	}
+	if _, err := New("compile", nil, Relaxed).Compile("${FOO"); err == nil {
+		t.Error("expected a syntax error")
+	}
+	tmpl, err := New("compile", nil, Relaxed).Compile(strings.Repeat("a line of text with $FOO\n", 5000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &chunks{}
+	if err := tmpl.Execute(w, env); err != nil || w.size != 5000*len("a line of text with foo\n") || w.largest > chunkSize || w.writes < 2 {
+		t.Errorf("got %+v, %v, expected the output in chunks", w, err)
+	}
+}
+
+func TestAppendExecute(t *testing.T) {
+	tmpl, err := New("append", nil, NoUnset).Compile("postgres://${USER}:$PASSWORD@${HOST}:5432/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]string{"USER": "app", "PASSWORD": "s3cret", "HOST": "db"}
+	lookup := func(name string) (string, bool) {
+		v, ok := values[name]
+		return v, ok
+	}
+	buf := make([]byte, 0, 64)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, err = tmpl.AppendExecute(buf[:0], lookup)
+	})
+	if expected := "postgres://app:s3cret@db:5432/db"; string(buf) != expected || err != nil || allocs != 0 {
+		t.Errorf("got %q, %v with %v allocations, expected %q without", buf, err, allocs, expected)
+	}
+	tmpl, err = New("append", nil, Relaxed).Compile("${FOO:-foo}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpl.AppendExecute(nil, lookup); err != ErrNotCompiled || tmpl.Compiled() {
+		t.Errorf("got %v, expected ErrNotCompiled", err)
+	}
+}
+
+// chunks records the writes to it.
+type chunks struct {
+	writes, largest, size int
+}
+
+func (c *chunks) Write(b []byte) (int, error) {
+	c.writes++
+	c.largest = max(c.largest, len(b))
+	c.size += len(b)
+	return len(b), nil
+}
+
+// benchTemplate is a configuration file of mostly text with a few
+// references.
+var benchTemplate = strings.Repeat("listen: ${HOST}:$PORT\n# the name of the service\nname: ${NAME}\nreplicas: 3\n", 50)
+
+var benchEnv = Env{"HOST=0.0.0.0", "PORT=8080", "NAME=envsubst"}
+
+func BenchmarkParse(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := New("bench", benchEnv, Relaxed).Parse(benchTemplate); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTemplateExecute(b *testing.B) {
+	tmpl, err := New("bench", nil, Relaxed).Compile(benchTemplate)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		if err := tmpl.Execute(io.Discard, benchEnv); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	text := "a\n${B}\n  ${C}\n${D}"
+	list := ErrorList{{Pos: 15}, {Pos: 4}, {Pos: 2}, {Pos: 9}, {Pos: 40}}
+	list.locate("positions", text)
+	var got []string
+	for _, e := range list {
+		got = append(got, fmt.Sprintf("%d:%d", e.Line, e.Col))
+	}
+	if expected := []string{"4:2", "2:3", "2:1", "3:3", "4:5"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+	p := New("positions", nil, NoUnset)
+	p.Mode = AllErrors
+	_, err := p.Parse(text)
+	list, ok := err.(ErrorList)
+	if !ok || len(list) != 3 || list[2].Line != 4 || list[2].Col != 1 || list[2].Name != "positions" {
+		t.Errorf("got %#v, expected errors on 3 lines", err)
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	_, err := New("message", nil, NoUnset).Parse("$A")
+	e, ok := err.(*Error)
+	if !ok || e.Msg != "" || e.Message() != "variable ${A} not set" || e.Error() != e.Message() {
+		t.Fatalf("got %#v, expected the message formatted on demand", err)
+	}
+	e.Msg = "replaced"
+	if e.Error() != "replaced" {
+		t.Errorf("got %q, expected Msg to replace the message formatted", e.Error())
+	}
+}
+
+// BenchmarkParseAllErrors reports the cost of the errors of AllErrors mode
+// whose messages are not read, BenchmarkParseAllErrorsMessages the cost of
+// those whose messages are.
+func BenchmarkParseAllErrors(b *testing.B) {
+	text := strings.Repeat("key: ${NOTSET} and some text\n", 5000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p := New("bench", nil, NoUnset)
+		p.Mode = AllErrors
+		if _, err := p.Parse(text); err == nil {
+			b.Fatal("expected errors")
+		}
+	}
+}
+
+func BenchmarkParseAllErrorsMessages(b *testing.B) {
+	text := strings.Repeat("key: ${NOTSET} and some text\n", 5000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p := New("bench", nil, NoUnset)
+		p.Mode = AllErrors
+		_, err := p.Parse(text)
+		if err == nil {
+			b.Fatal("expected errors")
+		}
+		for _, e := range err.(ErrorList) {
+			_ = e.Error()
+		}
+	}
+}
+
+func BenchmarkTemplateAppendExecute(b *testing.B) {
+	tmpl, err := New("bench", nil, Relaxed).Compile(benchTemplate)
+	if err != nil {
+		b.Fatal(err)
+	}
+	lookup := benchEnv.Lookup
+	var buf []byte
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if buf, err = tmpl.AppendExecute(buf[:0], lookup); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
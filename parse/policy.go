@@ -0,0 +1,38 @@
+package parse
+
+import "fmt"
+
+// KindPolicy is the kind of the errors of substitutions denied by the
+// Policy of a parser.
+const KindPolicy ErrorKind = "policy"
+
+// PolicyRequest is a substitution a Policy decides on.
+type PolicyRequest struct {
+	Template    string // name of the template
+	Variable    string // variable, or reference resolved such as vault:secret/db#pass
+	Default     bool   // the default value is substituted rather than that of the variable
+	Destination string // where the output is written, see Parser.Destination
+	Syntax      string // syntax of the output, see Parser.Syntax
+}
+
+// A Policy decides whether a substitution is allowed, e.g. denying secret
+// variables in outputs written in plain text. It returns the reason of a
+// denial, or nil.
+type Policy func(PolicyRequest) error
+
+// policy returns an error if the Policy denies the substitution of node.
+func (p *Parser) policy(node Node) error {
+	if p.Policy == nil || !substituted(node) {
+		return nil
+	}
+	name := ident(node)
+	if name == "" {
+		return nil
+	}
+	err := p.Policy(PolicyRequest{p.Name, name, defaulted(node), p.Destination, p.Syntax})
+	if err == nil {
+		return nil
+	}
+	return &Error{Pos: node.Position(), Variable: name, Kind: KindPolicy,
+		Msg: fmt.Sprintf("${%s}: denied: %v", name, err)}
+}
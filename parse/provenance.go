@@ -0,0 +1,75 @@
+package parse
+
+import (
+	"slices"
+	"strings"
+)
+
+// Provenance configures the comments recording the variables substituted in
+// the lines of the output, see Parser.Provenance. A comment is the names of
+// the variables, separated by commas, between Prefix and Suffix.
+type Provenance struct {
+	Prefix, Suffix string // around the names, e.g. " # envsubst: " and ""
+	// Before puts the comments before the lines they are about, for
+	// syntaxes without comments at the end of lines. Prefix and Suffix
+	// then make a line of their own, e.g. "# envsubst: " and "\n".
+	Before bool
+}
+
+// Comment returns the comment about the variables names.
+func (v *Provenance) Comment(names []string) string {
+	return v.Prefix + strings.Join(names, ", ") + v.Suffix
+}
+
+// provenance tracks the variables substituted in the line of the output
+// being rendered.
+type provenance struct {
+	start int      // offset of the line in the output
+	names []string // variables substituted in the line, in order
+}
+
+// add records the substitution of the variable name in the line.
+func (l *provenance) add(name string) {
+	if !contains(l.names, name) {
+		l.names = append(l.names, name)
+	}
+}
+
+// appendText appends the text s at pos of the input to out, annotating the
+// lines it ends. Line breaks in regions, such as multi-line strings, do not
+// end lines.
+func (p *Parser) appendText(out []byte, pos Pos, s string, l *provenance) []byte {
+	for {
+		i := 0
+		for {
+			j := strings.IndexByte(s[i:], '\n')
+			if j < 0 {
+				return append(out, s...)
+			}
+			if i += j; p.region(pos+Pos(i)) == nil {
+				break
+			}
+			i++
+		}
+		out = append(p.endLine(append(out, s[:i]...), l), '\n')
+		l.start = len(out)
+		s, pos = s[i+1:], pos+Pos(i+1)
+	}
+}
+
+// endLine annotates the last line of out with the variables substituted in
+// it, if any.
+func (p *Parser) endLine(out []byte, l *provenance) []byte {
+	if len(l.names) == 0 {
+		return out
+	}
+	comment := p.Provenance.Comment(l.names)
+	l.names = nil
+	if p.Provenance.Before {
+		return slices.Insert(out, l.start, []byte(comment)...)
+	}
+	if n := len(out); n > 0 && out[n-1] == '\r' {
+		return append(append(out[:n-1], comment...), '\r')
+	}
+	return append(out, comment...)
+}
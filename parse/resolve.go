@@ -0,0 +1,89 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// KindResolve is the kind of the errors of resolvers.
+const KindResolve ErrorKind = "resolve"
+
+// A Resolver fetches the value referenced by the value of a variable of the
+// form SCHEME:REF, such as vault:secret/db#password, from a backend.
+type Resolver interface {
+	Resolve(ref string) (string, error)
+}
+
+// ResolverFunc adapts a function to a Resolver.
+type ResolverFunc func(ref string) (string, error)
+
+// Resolve calls f(ref).
+func (f ResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+// Resolvers maps schemes to their resolvers.
+type Resolvers map[string]Resolver
+
+// DefaultResolvers are the resolvers registered with RegisterResolver. Unlike
+// filters they are not used unless a parser enables them, e.g. with
+// p.Resolvers = DefaultResolvers, as a value such as http://example.com is
+// not meant to be resolved unless asked for.
+var DefaultResolvers = Resolvers{}
+
+// RegisterResolver adds r to the DefaultResolvers for the values prefixed
+// with scheme and a colon, replacing a resolver of the same scheme. It is
+// meant to be called from init functions, not concurrently with parsing.
+func RegisterResolver(scheme string, r Resolver) {
+	DefaultResolvers[scheme] = r
+}
+
+// resolved is a cached result of a resolver.
+type resolved struct {
+	value string
+	err   error
+}
+
+// resolve returns the value referenced by value if it starts with the scheme
+// of one of the resolvers, and value itself otherwise. Results are cached for
+// the rest of the Parse, including the files it includes.
+func (p *Parser) resolve(value string) (string, error) {
+	scheme, ref, ok := strings.Cut(value, ":")
+	if !ok {
+		return value, nil
+	}
+	r, ok := p.Resolvers[scheme]
+	if !ok {
+		return value, nil
+	}
+	if res, ok := p.cache[value]; ok {
+		return res.value, res.err
+	}
+	v, err := p.Sandbox.resolve(r, ref)
+	if err != nil {
+		err = fmt.Errorf("resolve %s: %v", value, err)
+	}
+	if p.cache == nil {
+		p.cache = map[string]resolved{}
+	}
+	p.cache[value] = resolved{v, err}
+	return v, err
+}
+
+// ResolveNode is a reference resolved by a resolver, such as
+// ${cm:app-config#LOG_LEVEL}.
+type ResolveNode struct {
+	NodeType
+	Pos
+	Scheme string
+	Ref    string
+	parser *Parser
+}
+
+func (t *ResolveNode) String() (string, error) {
+	value, err := t.parser.resolve(t.Scheme + ":" + t.Ref)
+	if err != nil {
+		return "", &Error{Pos: t.Pos, Kind: KindResolve, Msg: err.Error()}
+	}
+	return value, nil
+}
@@ -0,0 +1,92 @@
+package parse
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// A ContextResolver is a Resolver whose resolutions stop when their context
+// is done, such as resolvers running commands, so that the Timeout of a
+// Sandbox stops them rather than leaving them running.
+type ContextResolver interface {
+	Resolver
+	ResolveContext(ctx context.Context, ref string) (string, error)
+}
+
+// Sandbox bounds the resolutions of the Resolvers of a parser, which may run
+// commands or call remote services, so that enabling them does not give the
+// templates and the environment unbounded use of these.
+type Sandbox struct {
+	// Timeout fails a resolution taking longer, none if zero. The context
+	// of ContextResolvers is canceled, the other resolutions are left to
+	// finish in the background with their result discarded.
+	Timeout time.Duration
+	// MaxValue fails a resolution returning a value larger than this many
+	// bytes, none if zero.
+	MaxValue int
+	// Env, if not nil, are the names of the variables of the process
+	// environment the commands run by ContextResolvers may see, see
+	// SandboxEnv. The others are removed from their environment.
+	Env []string
+}
+
+// sandboxEnvKey is the context key of the Env of a Sandbox.
+type sandboxEnvKey struct{}
+
+// SandboxEnv returns the names of the variables of the process environment
+// the commands run within ctx may see, as set by the Env of a Sandbox, and
+// whether they are restricted at all. ContextResolvers running commands
+// remove the other variables from their environment.
+func SandboxEnv(ctx context.Context) ([]string, bool) {
+	names, ok := ctx.Value(sandboxEnvKey{}).([]string)
+	return names, ok
+}
+
+// resolve resolves ref with r within the bounds of s, if not nil.
+func (s *Sandbox) resolve(r Resolver, ref string) (string, error) {
+	if s == nil {
+		return r.Resolve(ref)
+	}
+	ctx := context.Background()
+	if s.Env != nil {
+		ctx = context.WithValue(ctx, sandboxEnvKey{}, s.Env)
+	}
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	var (
+		value string
+		err   error
+	)
+	if cr, ok := r.(ContextResolver); ok {
+		value, err = cr.ResolveContext(ctx, ref)
+	} else {
+		type result struct {
+			value string
+			err   error
+		}
+		done := make(chan result, 1)
+		go func() {
+			value, err := r.Resolve(ref)
+			done <- result{value, err}
+		}()
+		select {
+		case res := <-done:
+			value, err = res.value, res.err
+		case <-ctx.Done():
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("timed out after %v", s.Timeout)
+	}
+	if err != nil {
+		return "", err
+	}
+	if s.MaxValue > 0 && len(value) > s.MaxValue {
+		return "", fmt.Errorf("value of %d bytes exceeds limit of %d bytes", len(value), s.MaxValue)
+	}
+	return value, nil
+}
@@ -0,0 +1,129 @@
+package parse
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// KindSchema is the kind of the errors of values violating the Schema.
+const KindSchema ErrorKind = "schema"
+
+// Type is the type the value of a variable must have.
+type Type string
+
+// Types of values
+const (
+	TypeString   Type = "string"   // any value
+	TypeInt      Type = "int"      // decimal integer, e.g. -1
+	TypeFloat    Type = "float"    // decimal number, e.g. 0.5
+	TypeBool     Type = "bool"     // true or false, also 1, 0, t, f and their capitalizations
+	TypeDuration Type = "duration" // Go duration, e.g. 1m30s
+	TypeURL      Type = "url"      // absolute URL, e.g. https://example.com
+	TypePort     Type = "port"     // port number from 1 to 65535
+)
+
+// ParseType returns the type named name.
+func ParseType(name string) (Type, error) {
+	switch t := Type(name); t {
+	case TypeString, TypeInt, TypeFloat, TypeBool, TypeDuration, TypeURL, TypePort:
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown type %q, expected string, int, float, bool, duration, url or port", name)
+}
+
+// Rule declares what the value of a variable must be.
+type Rule struct {
+	Type     Type           // type of the value, any if empty
+	Pattern  *regexp.Regexp // expression the value must match, if set
+	Enum     []string       // values allowed, any if empty
+	NonEmpty bool           // the value must not be empty
+	// Min and Max, if set, bound the number for the types int, float and
+	// port, and the length in characters otherwise.
+	Min, Max *float64
+}
+
+// Schema maps the names of variables to the rules their substituted values
+// must follow, so that a value such as PORT=eighty or LOG_LEVEL=verbose fails
+// rendering instead of the application using the rendered file.
+type Schema map[string]Rule
+
+// check returns an error if value violates the rule of the variable name.
+func (s Schema) check(name, value string) error {
+	rule, ok := s[name]
+	if !ok {
+		return nil
+	}
+	return rule.check(value)
+}
+
+// check returns an error if value violates r.
+func (r Rule) check(value string) error {
+	if r.NonEmpty && value == "" {
+		return fmt.Errorf("value must not be empty")
+	}
+	if !r.Type.valid(value) {
+		return fmt.Errorf("value %q is not of type %s", value, r.Type)
+	}
+	if r.Pattern != nil && !r.Pattern.MatchString(value) {
+		return fmt.Errorf("value %q does not match %s", value, r.Pattern)
+	}
+	if len(r.Enum) > 0 && !contains(r.Enum, value) {
+		return fmt.Errorf("value %q is not one of %s", value, strings.Join(r.Enum, ", "))
+	}
+	if r.Min == nil && r.Max == nil {
+		return nil
+	}
+	n, what := float64(utf8.RuneCountInString(value)), "length of value %q"
+	switch r.Type {
+	case TypeInt, TypeFloat, TypePort:
+		n, _ = strconv.ParseFloat(value, 64)
+		what = "value %q"
+	}
+	if r.Min != nil && n < *r.Min {
+		return fmt.Errorf(what+" is less than %v", value, *r.Min)
+	}
+	if r.Max != nil && n > *r.Max {
+		return fmt.Errorf(what+" is greater than %v", value, *r.Max)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// valid reports whether value is of type t.
+func (t Type) valid(value string) bool {
+	var err error
+	switch t {
+	case TypeInt:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case TypeFloat:
+		_, err = strconv.ParseFloat(value, 64)
+	case TypeBool:
+		_, err = strconv.ParseBool(value)
+	case TypeDuration:
+		_, err = time.ParseDuration(value)
+	case TypeURL:
+		var u *url.URL
+		if u, err = url.Parse(value); err == nil && (u.Scheme == "" || u.Host == "" && u.Opaque == "" && u.Path == "") {
+			return false
+		}
+	case TypePort:
+		var port uint64
+		if port, err = strconv.ParseUint(value, 10, 16); err == nil && port == 0 {
+			return false
+		}
+	}
+	return err == nil
+}
@@ -0,0 +1,220 @@
+package parse
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Stream renders the template read from r to w like Parse, line by line as
+// it is read rather than at once, e.g. to render a pipe. Memory use is
+// bounded by the longest line, or for long lines by the longest reference,
+// rather than by the input: long lines are rendered in parts between their
+// references, unless Provenance is set. Blocks of directives are rendered
+// once read entirely. The lines before the first only block, see OnlyNode,
+// are substituted as they are read before it.
+//
+// Positions of errors are those in the whole input, those of the records
+// of Audit as well, while those logged are relative to the line. Regions
+// are not supported, as they need the whole input. It returns the errors
+// of the template like Parse, and the errors reading r and writing w as
+// they are. Nothing is written past the first error of the template: the
+// output of the lines before it is, and in AllErrors mode the rest of the
+// input is read to report its errors only.
+func (p *Parser) Stream(w io.Writer, r io.Reader) error {
+	q := *p
+	q.Regions = nil
+	q.Limits.MaxInput, q.Limits.MaxOutput = 0, 0
+	q.subs, q.substituted = 0, nil
+	tracer := p.Tracer
+	if p.span != nil {
+		tracer = p.span
+	}
+	var span TraceSpan = noSpan{}
+	if tracer != nil {
+		span = tracer.Start("envsubst.Stream", slog.String("envsubst.template", p.Name))
+		q.span = span
+	}
+	s := &stream{p: p, q: &q, w: w, lex: lexReader(r, p.Restrict.NoDigit, p.Directives), line: 1, col: 1}
+	if p.Audit != nil {
+		q.Audit = func(sub Substitution) {
+			sub.Pos, sub.Line, sub.Col = s.move(sub.Pos, sub.Line, sub.Col)
+			p.Audit(sub)
+		}
+	}
+	err := s.run()
+	p.subs, p.substituted = q.subs, q.substituted
+	span.End(err, slog.Int("envsubst.substitutions", p.subs), slog.Int("envsubst.output.size", s.size))
+	return err
+}
+
+// stream renders a template read by a lexer in segments, see Parser.Stream.
+type stream struct {
+	p, q      *Parser // parser streaming, and the copy rendering the segments
+	w         io.Writer
+	lex       *lexer
+	errs      ErrorList
+	size      int // size of the output written
+	offset    Pos // position of the segment rendered in the input
+	line, col int // its line and column
+}
+
+// run reads the input, rendering each segment once read: the lines outside
+// of blocks of directives, the blocks, and the parts of long lines between
+// references.
+func (s *stream) run() error {
+	var (
+		depth  int // nesting of substitutions
+		blocks int // nesting of blocks of directives
+	)
+	for {
+		t := s.lex.nextItem()
+		end := t.pos + Pos(len(t.val))
+		switch t.typ {
+		case itemEOF, itemError:
+			// Syntax errors are reported by rendering the segment they
+			// are in.
+			return s.result(s.render(s.lex.pos))
+		case itemLeftDelim:
+			depth++
+		case itemRightDelim:
+			depth--
+		case itemDirective:
+			if blocks += DirectiveNesting(t.val); blocks == 0 {
+				if err := s.render(end); err != nil {
+					return s.result(err)
+				}
+			}
+			continue
+		case itemText:
+			if i := strings.LastIndexByte(t.val, '\n'); i >= 0 && depth == 0 && blocks == 0 {
+				if err := s.render(t.pos + Pos(i+1)); err != nil {
+					return s.result(err)
+				}
+				continue
+			}
+		}
+		// Long lines are cut after references and parts of text, unless
+		// what follows could be taken for a directive at the start of a
+		// line, or the provenance of the lines is commented.
+		if depth == 0 && blocks == 0 && end-s.lex.keep >= readSize && s.p.Provenance == nil && !s.directiveLike(end) {
+			if err := s.render(end); err != nil {
+				return s.result(err)
+			}
+		}
+	}
+}
+
+// directiveLike reports whether the input at pos may start a directive.
+func (s *stream) directiveLike(pos Pos) bool {
+	i := int(pos - s.lex.base)
+	return i < len(s.lex.input) && strings.IndexByte(" \t#", s.lex.input[i]) >= 0
+}
+
+// render renders the input up to end and writes the output. It returns the
+// errors stopping the rendering: write errors, the first error in Quick mode
+// and limit errors.
+func (s *stream) render(end Pos) error {
+	text := s.lex.cut(end)
+	if text == "" {
+		return nil
+	}
+	if max := s.p.Limits.MaxInput; max > 0 && int(end) > max {
+		return s.limit(&Error{Pos: Pos(max) - s.offset, Kind: KindLimit, Err: ErrInputTooLarge,
+			Msg: fmt.Sprintf("input size exceeds limit of %d bytes", max)}, text)
+	}
+	// The rest of the input is rendered in only mode once a block is.
+	s.q.Only = s.q.Only || HasOnly(text)
+	subs, names := s.q.subs, s.q.substituted
+	out, err := s.q.parseText(text)
+	for _, name := range s.q.substituted {
+		if !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	s.q.subs, s.q.substituted = subs+s.q.subs, names
+	if err == nil && len(s.errs) > 0 {
+		// The output stops at the first error.
+		s.advance(text)
+		return nil
+	}
+	if err == nil {
+		if s.size += len(out); s.p.Limits.MaxOutput > 0 && s.size > s.p.Limits.MaxOutput {
+			return s.limit(&Error{Kind: KindLimit, Err: ErrOutputTooLarge,
+				Msg: fmt.Sprintf("output size exceeds limit of %d bytes", s.p.Limits.MaxOutput)}, text)
+		}
+		_, err = io.WriteString(s.w, out)
+		s.advance(text)
+		return err
+	}
+	list, ok := err.(ErrorList)
+	if !ok {
+		list = ErrorList{err.(*Error)}
+	}
+	for _, e := range list {
+		e.Pos, e.Line, e.Col = s.move(e.Pos, e.Line, e.Col)
+		s.errs = append(s.errs, e)
+	}
+	s.advance(text)
+	if s.p.Mode == Quick {
+		return s.errs[0]
+	}
+	for _, e := range list {
+		if e.Kind == KindLimit {
+			return s.errs
+		}
+	}
+	return nil
+}
+
+// limit returns the limit error e at its position in the segment text.
+func (s *stream) limit(e *Error, text string) error {
+	e.locate(s.p.Name, text)
+	e.Pos, e.Line, e.Col = s.move(e.Pos, e.Line, e.Col)
+	s.errs = append(s.errs, e)
+	if s.p.Mode == Quick {
+		return e
+	}
+	return s.errs
+}
+
+// move moves the position pos at line and column in the segment rendered
+// to the whole input.
+func (s *stream) move(pos Pos, line, col int) (Pos, int, int) {
+	if line == 1 {
+		col += s.col - 1
+	}
+	return pos + s.offset, line + s.line - 1, col
+}
+
+// advance moves the position of the segment rendered past text.
+func (s *stream) advance(text string) {
+	s.offset += Pos(len(text))
+	if n := strings.Count(text, "\n"); n > 0 {
+		s.line += n
+		s.col = len(text) - strings.LastIndexByte(text, '\n')
+	} else {
+		s.col += len(text)
+	}
+}
+
+// result returns the error of the stream, given the one stopping it.
+func (s *stream) result(err error) error {
+	if s.lex.err != nil {
+		return s.lex.err
+	}
+	switch err.(type) {
+	case nil, *Error, ErrorList:
+	default:
+		// failure to write
+		return err
+	}
+	if len(s.errs) == 0 {
+		return nil
+	}
+	if s.p.Mode == Quick {
+		return s.errs[0]
+	}
+	return s.errs
+}
@@ -0,0 +1,206 @@
+package parse
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Template is a template parsed once by Compile to be rendered many times
+// with Execute, e.g. with the environment of each request of a service.
+type Template struct {
+	p    *Parser
+	text string
+	// names are the variables referenced, and segments the text between
+	// the references, if the template is only made of text and references
+	// to variables, and p of none of the features needing a Parse.
+	names    []string
+	segments []segment
+}
+
+// segment is the text before a reference of a Template and the reference.
+type segment struct {
+	text string
+	name int // index of the variable in names, -1 for the text at the end
+	pos  Pos // position of the reference
+}
+
+// Compile parses text to render it with Execute, returning the syntax errors
+// of text. Templates made of text and references such as $NAME and ${NAME}
+// only are compiled to the list of their references and the text between
+// them, rendered with a lookup of each variable, unless p has hooks or
+// features needing a Parse: Transform, Resolvers, Logger, Metrics, Audit,
+// Schema, Deprecated, Policy, Provenance, Lock, Tracer, Regions or Limits.
+// The others are rendered with a Parse of text by a copy of p. Later
+// changes to p are used by the latter only.
+func (p *Parser) Compile(text string) (*Template, error) {
+	p.lex = lex(text, p.Restrict.NoDigit, p.Directives, p.Regions)
+	p.lex.only = p.lex.only || p.Only
+	p.nodes = make([]Node, 0)
+	p.peekCount = 0
+	if err := p.parse(); err != nil {
+		return nil, p.locate(err, text)
+	}
+	t := &Template{p: p, text: text}
+	if p.Transform != nil || p.Resolvers != nil || p.Logger != nil || p.Metrics != nil ||
+		p.Audit != nil || p.Schema != nil || p.Deprecated != nil || p.Policy != nil || p.Provenance != nil ||
+		p.Lock != nil || p.Tracer != nil || p.Regions != nil || p.Limits != (Limits{}) {
+		return t, nil
+	}
+	var b strings.Builder
+	for _, node := range p.nodes {
+		switch n := node.(type) {
+		case *TextNode:
+			b.WriteString(n.Text)
+			continue
+		case *SubstitutionNode:
+			if n.ExpType >= itemPlus && n.Default != nil || len(n.Filters) > 0 || n.Variable.builtin != nil {
+				return t, nil
+			}
+			node = n.Variable
+		}
+		n, ok := node.(*VariableNode)
+		if !ok || n.builtin != nil {
+			return t, nil
+		}
+		i := 0
+		for i < len(t.names) && t.names[i] != n.Ident {
+			i++
+		}
+		if i == len(t.names) {
+			t.names = append(t.names, n.Ident)
+		}
+		t.segments = append(t.segments, segment{b.String(), i, node.Position()})
+		b.Reset()
+	}
+	t.segments = append(t.segments, segment{b.String(), -1, 0})
+	return t, nil
+}
+
+// Execute renders the template with the variables of env and writes the
+// output to w, like a Parse of the text of the template with env. Compiled
+// templates are written in chunks as they are rendered, once the variables
+// are known to satisfy the restrictions. It does not change the parser and
+// may be called concurrently.
+func (t *Template) Execute(w io.Writer, env Env) error {
+	if t.segments == nil {
+		p := *t.p
+		p.Env = env
+		out, err := p.Parse(t.text)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, out)
+		return err
+	}
+	var (
+		values = make([]string, len(t.names))
+		errs   = make([]error, len(t.names))
+		failed bool
+		size   int
+		r      = t.p.Restrict
+	)
+	for i, name := range t.names {
+		value, set := env.Lookup(name)
+		if value == "" && (r.NoUnset && !set || r.NoEmpty && set || r.NoReplace) {
+			// The node reports the restrictions failing like a Parse.
+			n := VariableNode{NodeType: NodeVariable, Ident: name, Env: env, Restrict: r}
+			value, errs[i] = n.String()
+			failed = failed || errs[i] != nil
+		}
+		values[i] = value
+	}
+	if failed {
+		// The errors are reported at each reference.
+		var list ErrorList
+		for _, s := range t.segments {
+			if s.name >= 0 && errs[s.name] != nil {
+				e := *errs[s.name].(*Error)
+				e.Pos = s.pos
+				if t.p.Mode == Quick {
+					return e.locate(t.p.Name, t.text)
+				}
+				list = append(list, &e)
+			}
+		}
+		return list.locate(t.p.Name, t.text)
+	}
+	for _, s := range t.segments {
+		size += len(s.text)
+		if s.name >= 0 {
+			size += len(values[s.name])
+		}
+	}
+	// The output is written in chunks as it is rendered, rather than once
+	// whole.
+	b := bufio.NewWriterSize(w, min(size, chunkSize))
+	for _, s := range t.segments {
+		b.WriteString(s.text)
+		if s.name >= 0 {
+			b.WriteString(values[s.name])
+		}
+	}
+	return b.Flush()
+}
+
+// chunkSize is the size of the chunks of output Execute writes at most.
+const chunkSize = 32 << 10
+
+// ErrNotCompiled is returned by AppendExecute for templates that are not
+// compiled to their references, see Compile.
+var ErrNotCompiled = errors.New("template not compiled")
+
+// Compiled reports whether the template is compiled to its references and
+// the text between them, see Compile, and can be rendered by AppendExecute.
+func (t *Template) Compiled() bool {
+	return t.segments != nil
+}
+
+// AppendExecute appends the output of the template rendered with the values
+// lookup returns for the variables to dst and returns the extended buffer,
+// like Execute, for services rendering templates at high rates. Beyond the
+// growth of dst and the errors, it allocates nothing: callers reusing their
+// buffer and lookup function render without garbage. Lookup may be called
+// several times for a variable. On error dst is returned as it was. It
+// fails with ErrNotCompiled for the templates that are not compiled.
+func (t *Template) AppendExecute(dst []byte, lookup func(name string) (string, bool)) ([]byte, error) {
+	if t.segments == nil {
+		return dst, ErrNotCompiled
+	}
+	var (
+		start = len(dst)
+		errs  ErrorList
+		r     = t.p.Restrict
+	)
+	for _, s := range t.segments {
+		dst = append(dst, s.text...)
+		if s.name < 0 {
+			break
+		}
+		name := t.names[s.name]
+		value, set := lookup(name)
+		switch {
+		case value != "":
+			dst = append(dst, value...)
+		case r.NoUnset && !set || r.NoEmpty && set && !r.NoReplace:
+			// The node reports the restrictions failing like a Parse.
+			var env Env
+			if set {
+				env = Env{name + "="}
+			}
+			n := VariableNode{NodeType: NodeVariable, Pos: s.pos, Ident: name, Env: env, Restrict: r}
+			_, err := n.String()
+			if t.p.Mode == Quick {
+				return dst[:start], t.p.locate(err, t.text)
+			}
+			errs = append(errs, t.p.error(err))
+		case r.NoReplace:
+			dst = append(append(dst, '$'), name...)
+		}
+	}
+	if len(errs) > 0 {
+		return dst[:start], errs.locate(t.p.Name, t.text)
+	}
+	return dst, nil
+}
@@ -0,0 +1,37 @@
+package parse
+
+import "log/slog"
+
+// Tracer starts the spans tracing the rendering of templates, such as
+// OpenTelemetry spans. Parse starts a span named envsubst.Parse with the
+// children envsubst.parse, scanning and parsing the template, and
+// envsubst.execute, substituting its nodes, which is the parent of the spans
+// of the included files. Its methods may be called concurrently by parsers
+// rendering in parallel.
+type Tracer interface {
+	// Start starts the span name with the attributes known at its start.
+	Start(name string, attrs ...slog.Attr) TraceSpan
+}
+
+// TraceSpan is an operation traced by a Tracer. Its Start method starts its
+// children.
+type TraceSpan interface {
+	Tracer
+	// End ends the span with the error of the operation, if any, and the
+	// attributes of its outcome.
+	End(err error, attrs ...slog.Attr)
+}
+
+// noSpan is the span of parsers without a Tracer.
+type noSpan struct{}
+
+func (noSpan) Start(string, ...slog.Attr) TraceSpan { return noSpan{} }
+func (noSpan) End(error, ...slog.Attr)              {}
+
+// start starts the span name as a child of the Parse being traced.
+func (p *Parser) start(name string, attrs ...slog.Attr) TraceSpan {
+	if p.span == nil {
+		return noSpan{}
+	}
+	return p.span.Start(name, attrs...)
+}
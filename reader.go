@@ -0,0 +1,39 @@
+package envsubst
+
+import (
+	"io"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// NewReader returns a reader of the template read from r with the variables
+// substituted as it is read, e.g. to hand it to a response writer or a
+// decoder without holding the whole output. The template is read as the
+// output is, line by line, see parse.Parser.Stream. The errors of the
+// template are returned by Read once the output of the lines without errors
+// is read, those reading r as they are. The reader must be read up to an
+// error, io.EOF included, for the rendering to end.
+func NewReader(r io.Reader, opts ...Option) io.Reader {
+	o := newOptions(opts)
+	return &reader{r: r, p: parse.New("reader", o.env, o.restrict)}
+}
+
+// reader renders a template as it is read, see NewReader.
+type reader struct {
+	r  io.Reader
+	p  *parse.Parser
+	pr *io.PipeReader // output of the rendering, once started
+}
+
+// Read reads the output of the template, starting its rendering on the first
+// call.
+func (r *reader) Read(b []byte) (int, error) {
+	if r.pr == nil {
+		var pw *io.PipeWriter
+		r.pr, pw = io.Pipe()
+		go func() {
+			pw.CloseWithError(r.p.Stream(pw, r.r))
+		}()
+	}
+	return r.pr.Read(b)
+}
@@ -0,0 +1,258 @@
+package resolver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hellt/envsubst/parse"
+	"github.com/hellt/envsubst/source"
+)
+
+// ssmBatchSize is the number of parameters AWS SSM returns per request.
+const ssmBatchSize = 10
+
+// AWS reads the parameters of AWS SSM Parameter Store and the secrets of AWS
+// Secrets Manager with the AWS command line tool, which uses its configured
+// credentials. The parameters read are cached. It is both a source of
+// variables, see Prefetch, Parameters and SecretVars, and a resolver, see
+// SSM and SecretsManager:
+//
+//	aws := &resolver.AWS{Region: "eu-west-1"}
+//	parse.RegisterResolver("ssm", aws.SSM())
+//	parse.RegisterResolver("secretsmanager", aws.SecretsManager())
+type AWS struct {
+	Region  string // region of the requests, the one configured if empty
+	Profile string // profile of the credentials, the one configured if empty
+	CLI     string // command run, aws if empty
+
+	mu     sync.Mutex
+	params map[string]string // parameters read by name
+}
+
+// run runs the AWS command line tool with args and returns its JSON output.
+func (a *AWS) run(args ...string) ([]byte, error) {
+	if a.Region != "" {
+		args = append(args, "--region", a.Region)
+	}
+	if a.Profile != "" {
+		args = append(args, "--profile", a.Profile)
+	}
+	name := a.CLI
+	if name == "" {
+		name = "aws"
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, append(args, "--output", "json")...)
+	cmd.Stderr = &stderr
+	b, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %v: %s", name, err, msg)
+		}
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return b, nil
+}
+
+// fetch reads the named parameters in batches.
+func (a *AWS) fetch(names []string) error {
+	for len(names) > 0 {
+		batch := names
+		if len(batch) > ssmBatchSize {
+			batch = batch[:ssmBatchSize]
+		}
+		names = names[len(batch):]
+		b, err := a.run(append([]string{"ssm", "get-parameters", "--with-decryption", "--names"}, batch...)...)
+		if err != nil {
+			return err
+		}
+		var result struct {
+			Parameters []struct {
+				Name  string
+				Value string
+			}
+			InvalidParameters []string
+		}
+		if err := json.Unmarshal(b, &result); err != nil {
+			return err
+		}
+		if len(result.InvalidParameters) > 0 {
+			return fmt.Errorf("parameters not found: %s", strings.Join(result.InvalidParameters, ", "))
+		}
+		a.mu.Lock()
+		if a.params == nil {
+			a.params = map[string]string{}
+		}
+		for _, p := range result.Parameters {
+			a.params[p.Name] = p.Value
+		}
+		a.mu.Unlock()
+	}
+	return nil
+}
+
+// parameter returns the parameter name, reading it unless it was read.
+func (a *AWS) parameter(name string) (string, error) {
+	a.mu.Lock()
+	value, ok := a.params[name]
+	a.mu.Unlock()
+	if ok {
+		return value, nil
+	}
+	if err := a.fetch([]string{name}); err != nil {
+		return "", err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.params[name], nil
+}
+
+// SSM returns a resolver of the names of AWS SSM parameters to their
+// values, e.g. for variables set to ssm:/myapp/prod/db/password.
+func (a *AWS) SSM() parse.ResolverFunc {
+	return a.parameter
+}
+
+// SecretsManager returns a resolver of the IDs of AWS Secrets Manager
+// secrets to their values, or of ID#KEY to the key of the JSON object the
+// secret holds.
+func (a *AWS) SecretsManager() parse.ResolverFunc {
+	return func(ref string) (string, error) {
+		id, key, hasKey := strings.Cut(ref, "#")
+		secret, err := a.secretString(id)
+		if err != nil || !hasKey {
+			return secret, err
+		}
+		vars, err := jsonSecretVars(id, secret)
+		if err != nil {
+			return "", err
+		}
+		value, ok := parse.Env(vars).Lookup(key)
+		if !ok {
+			return "", fmt.Errorf("no key %s in %s", key, id)
+		}
+		return value, nil
+	}
+}
+
+// Prefetch reads the parameters referenced by the ssm: values of env in
+// batches, rather than one by one while rendering. It returns the variables
+// holding them with their values.
+func (a *AWS) Prefetch(env []string) ([]string, error) {
+	var names, refs []string
+	seen := map[string]bool{}
+	for _, pair := range env {
+		name, value, _ := strings.Cut(pair, "=")
+		param, ok := strings.CutPrefix(value, "ssm:")
+		if !ok {
+			continue
+		}
+		refs = append(refs, name)
+		if !seen[param] {
+			seen[param] = true
+			names = append(names, param)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	sort.Strings(names)
+	if err := a.fetch(names); err != nil {
+		return nil, err
+	}
+	var resolved []string
+	for _, name := range refs {
+		value, err := a.parameter(strings.TrimPrefix(parse.Env(env).Get(name), "ssm:"))
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, name+"="+value)
+	}
+	return resolved, nil
+}
+
+// Parameters returns the AWS SSM parameters under the path prefix as
+// variables, and the names of those holding a SecureString. Their names are
+// the rest of the parameter name after prefix in upper case, so that the
+// parameter /myapp/prod/db/password is loaded from /myapp/prod/ as
+// DB_PASSWORD.
+func (a *AWS) Parameters(prefix string) (vars, secrets []string, err error) {
+	b, err := a.run("ssm", "get-parameters-by-path", "--path", prefix, "--recursive", "--with-decryption")
+	if err != nil {
+		return nil, nil, err
+	}
+	var result struct {
+		Parameters []struct {
+			Name  string
+			Type  string
+			Value string
+		}
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return nil, nil, err
+	}
+	for _, p := range result.Parameters {
+		name := source.Flattening{}.Name(strings.TrimPrefix(strings.TrimPrefix(p.Name, prefix), "/"))
+		vars = append(vars, name+"="+p.Value)
+		if p.Type == "SecureString" {
+			secrets = append(secrets, name)
+		}
+	}
+	sort.Strings(vars)
+	return vars, secrets, nil
+}
+
+// SecretVars returns the keys of the JSON object stored in the AWS Secrets
+// Manager secret id as variables.
+func (a *AWS) SecretVars(id string) ([]string, error) {
+	secret, err := a.secretString(id)
+	if err != nil {
+		return nil, err
+	}
+	return jsonSecretVars(id, secret)
+}
+
+// secretString returns the value of the AWS Secrets Manager secret id.
+func (a *AWS) secretString(id string) (string, error) {
+	b, err := a.run("secretsmanager", "get-secret-value", "--secret-id", id)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		SecretString *string
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return "", err
+	}
+	if result.SecretString == nil {
+		return "", fmt.Errorf("secret %s is binary", id)
+	}
+	return *result.SecretString, nil
+}
+
+// jsonSecretVars returns the keys of the JSON object secret as variables.
+func jsonSecretVars(id, secret string) ([]string, error) {
+	dec := json.NewDecoder(strings.NewReader(secret))
+	dec.UseNumber()
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("secret %s is not a JSON object", id)
+	}
+	vars := make([]string, 0, len(m))
+	for key, value := range m {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("value of %s is not a scalar", key)
+		case nil:
+			value = ""
+		}
+		vars = append(vars, fmt.Sprintf("%s=%v", key, value))
+	}
+	sort.Strings(vars)
+	return vars, nil
+}
@@ -0,0 +1,65 @@
+package resolver
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// fakeAWS is a stand-in for the AWS command line tool answering the requests
+// of AWS, recording the arguments it is run with to calls.
+const fakeAWS = `#!/bin/sh
+echo "$@" >> "$(dirname "$0")/calls"
+case "$1 $2" in
+"ssm get-parameters")
+	echo '{"Parameters": [{"Name": "/app/db", "Value": "db-pass"}, {"Name": "/app/port", "Value": "5432"}], "InvalidParameters": []}' ;;
+"ssm get-parameters-by-path")
+	echo '{"Parameters": [{"Name": "/app/db/password", "Type": "SecureString", "Value": "db-pass"}, {"Name": "/app/log-level", "Type": "String", "Value": "info"}]}' ;;
+"secretsmanager get-secret-value")
+	echo '{"SecretString": "{\"user\": \"app\", \"max\": 1000000}"}' ;;
+*)
+	echo "unknown command" >&2; exit 1 ;;
+esac
+`
+
+func TestAWS(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	dir := t.TempDir()
+	cli := filepath.Join(dir, "aws")
+	if err := os.WriteFile(cli, []byte(fakeAWS), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	a := &AWS{CLI: cli, Region: "eu-west-1"}
+	resolved, err := a.Prefetch([]string{"DB=ssm:/app/db", "PORT=ssm:/app/port", "HOST=localhost"})
+	if expected := "DB=db-pass PORT=5432"; strings.Join(resolved, " ") != expected || err != nil {
+		t.Errorf("got %v, %v, expected %s", resolved, err, expected)
+	}
+	// The parameters prefetched are cached.
+	if value, err := a.SSM()("/app/port"); value != "5432" || err != nil {
+		t.Errorf("got %q, %v, expected 5432", value, err)
+	}
+	vars, secrets, err := a.Parameters("/app/")
+	if strings.Join(vars, " ") != "DB_PASSWORD=db-pass LOG_LEVEL=info" || strings.Join(secrets, " ") != "DB_PASSWORD" || err != nil {
+		t.Errorf("got %v, %v, %v, expected DB_PASSWORD and LOG_LEVEL", vars, secrets, err)
+	}
+	if value, err := a.SecretsManager()("app#max"); value != "1000000" || err != nil {
+		t.Errorf("got %q, %v, expected 1000000", value, err)
+	}
+	if value, err := a.SecretsManager()("app#missing"); err == nil {
+		t.Errorf("got %q, expected a missing key error", value)
+	}
+	if vars, err := a.SecretVars("app"); strings.Join(vars, " ") != "max=1000000 user=app" || err != nil {
+		t.Errorf("got %v, %v, expected max and user", vars, err)
+	}
+	calls, err := os.ReadFile(filepath.Join(dir, "calls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(calls)), "\n"); len(lines) != 5 || !strings.HasSuffix(lines[0], "--region eu-west-1 --output json") {
+		t.Errorf("got calls %q, expected 5 with the region", lines)
+	}
+}
@@ -0,0 +1,112 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// Command resolves references by running a command and taking its output,
+// e.g. with Args pass show, ${pass:db/password} runs pass show db/password.
+// Commands are only run if registered as a resolver, they never come from
+// the templates or their variables.
+type Command struct {
+	// Args are the command and its arguments. The reference replaces the
+	// {} in them, as in op read op://{}, or is appended if there is none.
+	Args []string
+	// Timeout stops the command if it runs longer, 10s if zero.
+	Timeout time.Duration
+	// Env are the names of the variables of the environment passed to the
+	// command besides PATH and HOME, such as GNUPGHOME. The others are
+	// removed so that the command cannot depend on or leak them.
+	Env []string
+}
+
+// Resolve runs the command for ref and returns its output without the
+// trailing line break.
+func (c Command) Resolve(ref string) (string, error) {
+	return c.ResolveContext(context.Background(), ref)
+}
+
+// ResolveContext is Resolve stopping the command when ctx is done. The
+// command only sees the variables of its Env allowed by parse.SandboxEnv.
+func (c Command) ResolveContext(parent context.Context, ref string) (string, error) {
+	if len(c.Args) == 0 {
+		return "", errors.New("no command")
+	}
+	// A reference such as --help must not be taken for an option.
+	if ref == "" || strings.HasPrefix(ref, "-") {
+		return "", fmt.Errorf("invalid reference %q", ref)
+	}
+	args := make([]string, 0, len(c.Args)+1)
+	replaced := false
+	for _, arg := range c.Args[1:] {
+		if strings.Contains(arg, "{}") {
+			arg, replaced = strings.ReplaceAll(arg, "{}", ref), true
+		}
+		args = append(args, arg)
+	}
+	if !replaced {
+		args = append(args, ref)
+	}
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Args[0], args...)
+	cmd.Env = c.environ(parent)
+	cmd.Stderr = &stderr
+	// Children of the command keeping its output open must not delay
+	// stopping it.
+	cmd.WaitDelay = 100 * time.Millisecond
+	b, err := cmd.Output()
+	if err := parent.Err(); err != nil {
+		return "", fmt.Errorf("%s: %w", c.Args[0], err)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("%s: timed out after %v", c.Args[0], timeout)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %v: %s", c.Args[0], err, msg)
+		}
+		return "", fmt.Errorf("%s: %v", c.Args[0], err)
+	}
+	s := strings.TrimSuffix(string(b), "\n")
+	return strings.TrimSuffix(s, "\r"), nil
+}
+
+// environ returns the variables of the environment passed to the command
+// running within ctx.
+func (c Command) environ(ctx context.Context) []string {
+	allowed, sandboxed := parse.SandboxEnv(ctx)
+	env := []string{}
+	for _, name := range append([]string{"PATH", "HOME"}, c.Env...) {
+		if sandboxed && !contains(allowed, name) {
+			continue
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
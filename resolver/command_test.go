@@ -0,0 +1,60 @@
+package resolver
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+func TestCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	t.Setenv("KEPT", "kept")
+	t.Setenv("SCRUBBED", "scrubbed")
+	tests := []struct {
+		c        Command
+		ref      string
+		expected string
+		err      string
+	}{
+		{Command{Args: []string{"echo"}}, "db/password", "db/password", ""},
+		{Command{Args: []string{"echo", "op://{}/field"}}, "vault/item", "op://vault/item/field", ""},
+		{Command{Args: []string{"sh", "-c", `echo "$KEPT$SCRUBBED"`}, Env: []string{"KEPT"}}, "x", "kept", ""},
+		{Command{Args: []string{"sh", "-c", "echo not found >&2; exit 1"}}, "x", "", "not found"},
+		{Command{Args: []string{"sleep"}, Timeout: 10 * time.Millisecond}, "1", "", "timed out"},
+		{Command{Args: []string{"echo"}}, "--help", "", "invalid reference"},
+		{Command{}, "x", "", "no command"},
+	}
+	for _, test := range tests {
+		value, err := test.c.Resolve(test.ref)
+		if value != test.expected || test.err == "" && err != nil || test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%q %s: got %q, %v, expected %q, %s", test.c.Args, test.ref, value, err, test.expected, test.err)
+		}
+	}
+}
+
+func TestCommandSandbox(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	t.Setenv("KEPT", "kept")
+	t.Setenv("SCRUBBED", "scrubbed")
+	c := Command{Args: []string{"sh", "-c", `echo "$KEPT$SCRUBBED"; sleep {}`}, Env: []string{"KEPT", "SCRUBBED"}}
+	p := parse.New("sandbox", []string{"FAST=cmd:0", "SLOW=cmd:1"}, parse.Relaxed)
+	p.Resolvers = parse.Resolvers{"cmd": c}
+	p.Sandbox = &parse.Sandbox{Timeout: 200 * time.Millisecond, Env: []string{"PATH", "KEPT"}}
+	if value, err := p.Parse("$FAST"); err != nil || value != "kept" {
+		t.Errorf("got %q, %v, expected the scrubbed environment", value, err)
+	}
+	start := time.Now()
+	if _, err := p.Parse("$SLOW"); err == nil || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Errorf("got %v, expected a timeout", err)
+	}
+	if time.Since(start) > 900*time.Millisecond {
+		t.Error("expected the command to be stopped")
+	}
+}
@@ -0,0 +1,3 @@
+// Package resolver provides backends resolving the SCHEME:REF values and
+// ${SCHEME:REF} references of templates, see parse.Resolver.
+package resolver
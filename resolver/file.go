@@ -0,0 +1,63 @@
+package resolver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// File returns a resolver of paths to the contents of the files, with
+// surrounding white space trimmed, following the convention of Docker and
+// Kubernetes secrets mounted as files. Registered with
+// parse.RegisterResolver("file", resolver.File("/run/secrets")), it resolves
+// ${file:/run/secrets/db_password} as well as variables set to
+// file:/run/secrets/db_password. Relative paths are relative to the working
+// directory. The files, with symbolic links resolved, must be in one of the
+// roots: none can be read without roots.
+func File(roots ...string) parse.ResolverFunc {
+	return func(path string) (string, error) {
+		if len(roots) == 0 {
+			return "", fmt.Errorf("%s is outside of the directories files may be read from, none given", path)
+		}
+		ok, err := inRoots(path, roots)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", fmt.Errorf("%s is outside of %s", path, strings.Join(roots, ", "))
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+}
+
+// inRoots reports whether the file at path is in one of the directories.
+func inRoots(path string, roots []string) (bool, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return false, err
+	}
+	for _, root := range roots {
+		root, err := filepath.Abs(root)
+		if err != nil {
+			return false, err
+		}
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolved
+		}
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
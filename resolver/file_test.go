@@ -0,0 +1,35 @@
+package resolver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	dir, other := t.TempDir(), t.TempDir()
+	secret := filepath.Join(dir, "db_password")
+	if err := os.WriteFile(secret, []byte("hunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(other, "key")
+	if err := os.WriteFile(outside, []byte("key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := File()(outside); err == nil {
+		t.Errorf("got %q, expected no file to be readable without roots", value)
+	}
+	resolve := File(dir)
+	if value, err := resolve(secret); err != nil || value != "hunter2" {
+		t.Errorf("got %q, %v, expected hunter2", value, err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Skip(err)
+	}
+	for _, path := range []string{outside, link, filepath.Join(dir, "..", filepath.Base(other), "key"), filepath.Join(dir, "missing")} {
+		if value, err := resolve(path); err == nil {
+			t.Errorf("%s: got %q, expected an error", path, value)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package resolver
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hellt/envsubst/parse"
+	"github.com/zalando/go-keyring"
+)
+
+// Keyring returns a resolver of SERVICE#ACCOUNT references to the passwords
+// stored in the keyring of the operating system: the Keychain on macOS, the
+// Credential Manager on Windows and the Secret Service, such as GNOME
+// Keyring or KWallet, elsewhere. Registered with
+// parse.RegisterResolver("keyring", resolver.Keyring()), it resolves
+// ${keyring:myapp#db} to the password stored for the account db of the
+// service myapp, e.g. with secret-tool store service myapp username db.
+func Keyring() parse.ResolverFunc {
+	return func(ref string) (string, error) {
+		service, account, err := splitKey(ref)
+		if err != nil {
+			return "", fmt.Errorf("expected SERVICE#ACCOUNT, got %q", ref)
+		}
+		secret, err := keyring.Get(service, account)
+		if errors.Is(err, keyring.ErrNotFound) {
+			return "", fmt.Errorf("no password for %s in service %s", account, service)
+		}
+		return secret, err
+	}
+}
@@ -0,0 +1,23 @@
+package resolver
+
+import (
+	"testing"
+
+	"github.com/zalando/go-keyring"
+)
+
+func TestKeyring(t *testing.T) {
+	keyring.MockInit()
+	if err := keyring.Set("myapp", "db", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	resolve := Keyring()
+	if value, err := resolve("myapp#db"); err != nil || value != "hunter2" {
+		t.Errorf("got %q, %v, expected hunter2", value, err)
+	}
+	for _, ref := range []string{"myapp#missing", "myapp"} {
+		if _, err := resolve(ref); err == nil {
+			t.Errorf("%s: expected an error", ref)
+		}
+	}
+}
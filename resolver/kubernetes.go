@@ -0,0 +1,168 @@
+package resolver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// serviceAccountDir holds the credentials of the service account of a pod.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// KubeClient reads ConfigMaps and Secrets from a Kubernetes API server.
+type KubeClient struct {
+	Server    string       // URL of the API server
+	Token     string       // bearer token authenticating the requests
+	Namespace string       // namespace of the objects
+	HTTP      *http.Client // client making the requests
+}
+
+// InCluster returns a client for the API server of the cluster the program
+// runs in, authenticated as the service account of its pod.
+func InCluster() (*KubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster")
+	}
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, err
+	}
+	namespace, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return nil, err
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid CA certificate of the service account")
+	}
+	return &KubeClient{
+		Server:    "https://" + net.JoinHostPort(host, port),
+		Token:     strings.TrimSpace(string(token)),
+		Namespace: strings.TrimSpace(string(namespace)),
+		HTTP: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// data returns the data of the object of the kind, configmaps or secrets,
+// with the given name. The values of Secrets are decoded.
+func (c *KubeClient) data(kind, name string) (map[string]string, error) {
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/%s/%s", strings.TrimSuffix(c.Server, "/"),
+		url.PathEscape(c.Namespace), kind, url.PathEscape(name))
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s", strings.TrimSuffix(kind, "s"), name, resp.Status)
+	}
+	var object struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&object); err != nil {
+		return nil, err
+	}
+	if kind == "secrets" {
+		for key, value := range object.Data {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("value of %s: %v", key, err)
+			}
+			object.Data[key] = string(decoded)
+		}
+	}
+	return object.Data, nil
+}
+
+// ConfigMap returns a resolver of NAME#KEY references to the keys of the
+// ConfigMaps read with c, e.g. for ${cm:app-config#LOG_LEVEL} once
+// registered with parse.RegisterResolver("cm", resolver.ConfigMap(c)).
+func ConfigMap(c *KubeClient) parse.ResolverFunc {
+	return kubeResolver(c, "configmaps")
+}
+
+// Secret is like ConfigMap for the keys of Secrets.
+func Secret(c *KubeClient) parse.ResolverFunc {
+	return kubeResolver(c, "secrets")
+}
+
+func kubeResolver(c *KubeClient, kind string) parse.ResolverFunc {
+	return func(ref string) (string, error) {
+		name, key, err := splitKey(ref)
+		if err != nil {
+			return "", err
+		}
+		data, err := c.data(kind, name)
+		if err != nil {
+			return "", err
+		}
+		value, ok := data[key]
+		if !ok {
+			return "", fmt.Errorf("no key %s in %s", key, name)
+		}
+		return value, nil
+	}
+}
+
+// Mounted returns a resolver of NAME#KEY references to the keys of the
+// ConfigMaps or Secrets mounted as volumes in dir, as dir/NAME/KEY.
+func Mounted(dir string) parse.ResolverFunc {
+	return func(ref string) (string, error) {
+		name, key, err := splitKey(ref)
+		if err != nil {
+			return "", err
+		}
+		if !validPathElement(name) || !validPathElement(key) {
+			return "", fmt.Errorf("invalid reference %q", ref)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, name, key))
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
+
+// splitKey splits a NAME#KEY reference.
+func splitKey(ref string) (name, key string, err error) {
+	name, key, ok := strings.Cut(ref, "#")
+	if !ok || name == "" || key == "" {
+		return "", "", fmt.Errorf("expected NAME#KEY, got %q", ref)
+	}
+	return name, key, nil
+}
+
+// validPathElement reports whether s names a file within a directory.
+func validPathElement(s string) bool {
+	return s != "." && s != ".." && !strings.ContainsAny(s, `/\`)
+}
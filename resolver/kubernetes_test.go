@@ -0,0 +1,69 @@
+package resolver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKubernetes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/namespaces/apps/configmaps/app-config":
+			w.Write([]byte(`{"data": {"LOG_LEVEL": "debug"}}`))
+		case "/api/v1/namespaces/apps/secrets/db":
+			w.Write([]byte(`{"data": {"password": "aHVudGVyMg=="}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	c := &KubeClient{Server: server.URL, Token: "t", Namespace: "apps"}
+	tests := []struct {
+		resolve  func(string) (string, error)
+		ref      string
+		expected string
+		fails    bool
+	}{
+		{ConfigMap(c), "app-config#LOG_LEVEL", "debug", false},
+		{Secret(c), "db#password", "hunter2", false},
+		{ConfigMap(c), "app-config#MISSING", "", true},
+		{ConfigMap(c), "missing#LOG_LEVEL", "", true},
+		{Secret(c), "db", "", true},
+	}
+	for _, test := range tests {
+		value, err := test.resolve(test.ref)
+		if test.fails != (err != nil) || value != test.expected {
+			t.Errorf("%s: got %q, %v, expected %q", test.ref, value, err, test.expected)
+		}
+	}
+	c.Token = "wrong"
+	if _, err := ConfigMap(c)("app-config#LOG_LEVEL"); err == nil {
+		t.Error("expected an error for a rejected token")
+	}
+}
+
+func TestMounted(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "app-config"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app-config", "LOG_LEVEL"), []byte("debug"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	resolve := Mounted(dir)
+	if value, err := resolve("app-config#LOG_LEVEL"); err != nil || value != "debug" {
+		t.Errorf("got %q, %v, expected debug", value, err)
+	}
+	for _, ref := range []string{"app-config#MISSING", "..#app-config", "app-config#../x", "app-config"} {
+		if _, err := resolve(ref); err == nil {
+			t.Errorf("%s: expected an error", ref)
+		}
+	}
+}
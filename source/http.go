@@ -0,0 +1,145 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hellt/envsubst/dotenv"
+)
+
+// Remote is a document of variables served over HTTP(S), a JSON object or
+// a .env file. Responses are cached by their ETag, so a document that has not
+// changed is not downloaded again.
+type Remote struct {
+	URL     string
+	Header  http.Header   // headers of the request, such as Authorization
+	Timeout time.Duration // timeout of the request, 30s if zero
+	Client  *http.Client  // client making the request, http.DefaultClient if nil
+	// CacheDir, if set, keeps the cached responses in files in this
+	// directory across processes. Otherwise they are kept in memory.
+	CacheDir string
+
+	etag, contentType string
+	body              []byte
+}
+
+// Fetch returns the document and its media type, reusing the cached response
+// if the server answers 304 Not Modified to its ETag.
+func (r *Remote) Fetch() ([]byte, string, error) {
+	if r.body == nil && r.CacheDir != "" {
+		r.load()
+	}
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest(http.MethodGet, r.URL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	for name, values := range r.Header {
+		req.Header[name] = values
+	}
+	if r.etag != "" {
+		req.Header.Set("If-None-Match", r.etag)
+	}
+	c := *client
+	c.Timeout = timeout
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if r.body != nil {
+			return r.body, r.contentType, nil
+		}
+	case http.StatusOK:
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, "", err
+		}
+		r.contentType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		r.etag, r.body = resp.Header.Get("ETag"), body
+		if r.CacheDir != "" && r.etag != "" {
+			if err := r.save(); err != nil {
+				return nil, "", err
+			}
+		}
+		return body, r.contentType, nil
+	}
+	return nil, "", fmt.Errorf("%s", resp.Status)
+}
+
+// Vars returns the variables of the document. It is read as JSON, flattened
+// with f, if served as application/json or named *.json, and as a .env file
+// otherwise.
+func (r *Remote) Vars(f Flattening) ([]string, error) {
+	b, contentType, err := r.Fetch()
+	if err != nil {
+		return nil, err
+	}
+	if contentType == "application/json" || strings.HasSuffix(contentType, "+json") ||
+		path.Ext(strings.SplitN(r.URL, "?", 2)[0]) == ".json" {
+		return JSON(b, f)
+	}
+	vars, err := dotenv.ParseBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	return dotenv.Environ(vars), nil
+}
+
+// cacheFile returns the file caching the responses for the URL.
+func (r *Remote) cacheFile() string {
+	sum := sha256.Sum256([]byte(r.URL))
+	return filepath.Join(r.CacheDir, hex.EncodeToString(sum[:]))
+}
+
+// load reads the cached response, made of the ETag and media type lines
+// followed by the body. A missing or malformed cache is ignored.
+func (r *Remote) load() {
+	b, err := os.ReadFile(r.cacheFile())
+	if err != nil {
+		return
+	}
+	rd := bufio.NewReader(bytes.NewReader(b))
+	etag, err := rd.ReadString('\n')
+	if err != nil {
+		return
+	}
+	contentType, err := rd.ReadString('\n')
+	if err != nil {
+		return
+	}
+	body, _ := io.ReadAll(rd)
+	r.etag, r.contentType, r.body = strings.TrimSuffix(etag, "\n"), strings.TrimSuffix(contentType, "\n"), body
+}
+
+// save writes the response to the cache, only readable by the user as it
+// may hold secrets.
+func (r *Remote) save() error {
+	if err := os.MkdirAll(r.CacheDir, 0o700); err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n%s\n", r.etag, r.contentType)
+	b.Write(r.body)
+	return os.WriteFile(r.cacheFile(), b.Bytes(), 0o600)
+}
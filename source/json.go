@@ -0,0 +1,17 @@
+package source
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// JSON returns the variables of the JSON object b, see Flattening.
+func JSON(b []byte, f Flattening) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	return f.Flatten(m), nil
+}
@@ -0,0 +1,69 @@
+package source
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/hellt/envsubst/dotenv"
+)
+
+// SOPS decrypts files encrypted with sops, so that secret values are only
+// held in memory. It runs the sops command, which finds the keys like any
+// other sops invocation, rather than linking the key services of all cloud
+// providers into the program.
+type SOPS struct {
+	Command string // path of the sops command, sops if empty
+}
+
+// Decrypt returns the plaintext of the file at path, encrypted as a file of
+// the sops type dotenv, json, yaml or binary.
+func (s SOPS) Decrypt(path, fileType string) ([]byte, error) {
+	command := s.Command
+	if command == "" {
+		command = "sops"
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(command, "--decrypt", "--input-type", fileType, "--output-type", fileType, path)
+	cmd.Stderr = &stderr
+	b, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %v: %s", command, err, msg)
+		}
+		return nil, fmt.Errorf("%s: %v", command, err)
+	}
+	return b, nil
+}
+
+// Vars returns the variables of the encrypted file at path, chosen by its
+// extension: .json, .yaml, .yml and .toml documents are flattened with f,
+// other files are read as .env files.
+func (s SOPS) Vars(path string, f Flattening) ([]string, error) {
+	switch filepath.Ext(path) {
+	case ".json":
+		return s.vars(path, "json", func(b []byte) ([]string, error) { return JSON(b, f) })
+	case ".yaml", ".yml":
+		return s.vars(path, "yaml", func(b []byte) ([]string, error) { return YAML(b, f) })
+	case ".toml":
+		// sops encrypts TOML files as a whole.
+		return s.vars(path, "binary", func(b []byte) ([]string, error) { return TOML(b, f) })
+	}
+	return s.vars(path, "dotenv", func(b []byte) ([]string, error) {
+		vars, err := dotenv.ParseBytes(b)
+		if err != nil {
+			return nil, err
+		}
+		return dotenv.Environ(vars), nil
+	})
+}
+
+func (s SOPS) vars(path, fileType string, read func([]byte) ([]string, error)) ([]string, error) {
+	b, err := s.Decrypt(path, fileType)
+	if err != nil {
+		return nil, err
+	}
+	return read(b)
+}
@@ -0,0 +1,130 @@
+// Package source loads variables from structured documents such as JSON,
+// flattening nested values into NAME=VALUE pairs usable as the environment
+// of a parse.Parser.
+package source
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Case is the case mapping applied to the keys of a document.
+type Case int
+
+const (
+	Upper Case = iota // upper-case keys: db.host becomes DB_HOST
+	Lower             // lower-case keys: db.Host becomes db_host
+	Keep              // keep keys as they are: db.Host becomes db_Host
+)
+
+// ParseCase returns the case mapping named upper, lower or keep.
+func ParseCase(name string) (Case, error) {
+	switch name {
+	case "upper":
+		return Upper, nil
+	case "lower":
+		return Lower, nil
+	case "keep":
+		return Keep, nil
+	}
+	return 0, fmt.Errorf("unknown case %q, expected upper, lower or keep", name)
+}
+
+// Flattening configures how nested documents are turned into variables. The
+// zero value joins upper-cased keys with _, so {"db": {"hosts": ["a"]}}
+// becomes DB_HOSTS_0=a.
+type Flattening struct {
+	Sep  string // separator joining the keys of nested values, _ if empty
+	Case Case   // case mapping of the keys
+	// Join, if set, joins the elements of lists of scalars with it into a
+	// single variable, so {"hosts": ["a", "b"]} becomes HOSTS=a,b for ",".
+	// Other lists are named by index.
+	Join string
+}
+
+// Name turns key into a part of a variable name by mapping its case and
+// replacing all characters but letters, digits and _ with _.
+func (f Flattening) Name(key string) string {
+	key = strings.Map(func(r rune) rune {
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return '_'
+	}, key)
+	switch f.Case {
+	case Upper:
+		return strings.ToUpper(key)
+	case Lower:
+		return strings.ToLower(key)
+	}
+	return key
+}
+
+// Flatten returns the scalars of the decoded document v as variables sorted
+// by name. Their names are the keys leading to them, list elements are named
+// by their index.
+func (f Flattening) Flatten(v interface{}) []string {
+	vars := f.flatten("", v, nil)
+	sort.Strings(vars)
+	return vars
+}
+
+func (f Flattening) flatten(name string, v interface{}, vars []string) []string {
+	join := func(key string) string {
+		key = f.Name(key)
+		if name == "" {
+			return key
+		}
+		sep := f.Sep
+		if sep == "" {
+			sep = "_"
+		}
+		return name + sep + key
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			vars = f.flatten(join(k), e, vars)
+		}
+	case map[interface{}]interface{}:
+		for k, e := range v {
+			vars = f.flatten(join(fmt.Sprint(k)), e, vars)
+		}
+	case []map[string]interface{}:
+		for i, e := range v {
+			vars = f.flatten(join(strconv.Itoa(i)), e, vars)
+		}
+	case []interface{}:
+		if f.Join != "" && scalars(v) {
+			elems := make([]string, len(v))
+			for i, e := range v {
+				if e != nil {
+					elems[i] = fmt.Sprint(e)
+				}
+			}
+			return append(vars, name+"="+strings.Join(elems, f.Join))
+		}
+		for i, e := range v {
+			vars = f.flatten(join(strconv.Itoa(i)), e, vars)
+		}
+	case nil:
+		vars = append(vars, name+"=")
+	default:
+		vars = append(vars, fmt.Sprintf("%s=%v", name, v))
+	}
+	return vars
+}
+
+// scalars reports whether the list holds scalars only.
+func scalars(list []interface{}) bool {
+	for _, e := range list {
+		switch e.(type) {
+		case map[string]interface{}, map[interface{}]interface{}, []interface{}, []map[string]interface{}:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+package source
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	doc := []byte(`{"db": {"host": "x", "Port": 5432, "hosts": ["a", "b"]}, "debug": true, "none": null, "big": 12345678901234567890}`)
+	tests := []struct {
+		name     string
+		f        Flattening
+		expected []string
+	}{
+		{"default", Flattening{}, []string{
+			"BIG=12345678901234567890", "DB_HOST=x", "DB_HOSTS_0=a", "DB_HOSTS_1=b", "DB_PORT=5432", "DEBUG=true", "NONE=",
+		}},
+		{"dotted keep", Flattening{Sep: ".", Case: Keep}, []string{
+			"big=12345678901234567890", "db.Port=5432", "db.host=x", "db.hosts.0=a", "db.hosts.1=b", "debug=true", "none=",
+		}},
+		{"lower", Flattening{Sep: "__", Case: Lower}, []string{
+			"big=12345678901234567890", "db__host=x", "db__hosts__0=a", "db__hosts__1=b", "db__port=5432", "debug=true", "none=",
+		}},
+	}
+	for _, test := range tests {
+		vars, err := JSON(doc, test.f)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(vars, test.expected) {
+			t.Errorf("%s: got %q, expected %q", test.name, vars, test.expected)
+		}
+	}
+	if _, err := JSON([]byte(`["not an object"]`), Flattening{}); err == nil {
+		t.Error("expected an error for a list")
+	}
+}
+
+func TestName(t *testing.T) {
+	if name := (Flattening{}).Name("my-app.key"); name != "MY_APP_KEY" {
+		t.Errorf("got %q, expected MY_APP_KEY", name)
+	}
+	if _, err := ParseCase("title"); err == nil {
+		t.Error("expected an error for an unknown case")
+	}
+}
+
+func TestYAML(t *testing.T) {
+	doc := []byte("replicaCount: 2\nimage:\n  repository: nginx\n  tag: \"1.25\"\ningress:\n  hosts: [a.example, b.example]\n  tls:\n    - secretName: tls\n1: numeric key\n")
+	tests := []struct {
+		name     string
+		f        Flattening
+		expected []string
+	}{
+		{"indexed", Flattening{}, []string{
+			"1=numeric key", "IMAGE_REPOSITORY=nginx", "IMAGE_TAG=1.25", "INGRESS_HOSTS_0=a.example",
+			"INGRESS_HOSTS_1=b.example", "INGRESS_TLS_0_SECRETNAME=tls", "REPLICACOUNT=2",
+		}},
+		{"joined", Flattening{Join: ","}, []string{
+			"1=numeric key", "IMAGE_REPOSITORY=nginx", "IMAGE_TAG=1.25", "INGRESS_HOSTS=a.example,b.example",
+			"INGRESS_TLS_0_SECRETNAME=tls", "REPLICACOUNT=2",
+		}},
+	}
+	for _, test := range tests {
+		vars, err := YAML(doc, test.f)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(vars, test.expected) {
+			t.Errorf("%s: got %q, expected %q", test.name, vars, test.expected)
+		}
+	}
+}
+
+func TestTOML(t *testing.T) {
+	doc := []byte("title = \"app\"\nports = [80, 443]\n\n[db]\nhost = \"x\"\nenabled = true\n\n[[servers]]\nname = \"a\"\n\n[[servers]]\nname = \"b\"\n")
+	expected := []string{
+		"DB_ENABLED=true", "DB_HOST=x", "PORTS=80 443", "SERVERS_0_NAME=a", "SERVERS_1_NAME=b", "TITLE=app",
+	}
+	vars, err := TOML(doc, Flattening{Join: " "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Errorf("got %q, expected %q", vars, expected)
+	}
+	if _, err := TOML([]byte("a = "), Flattening{}); err == nil {
+		t.Error("expected an error for an invalid document")
+	}
+}
+
+func TestRemote(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Bearer t" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		switch r.URL.Path {
+		case "/app.json":
+			w.Write([]byte(`{"db": {"host": "x"}}`))
+		default:
+			w.Write([]byte("# app\nDB_HOST=y\n"))
+		}
+	}))
+	defer server.Close()
+	header := http.Header{"Authorization": {"Bearer t"}}
+	dir := t.TempDir()
+	for _, test := range []struct {
+		path     string
+		expected []string
+	}{
+		{"/app.json", []string{"DB_HOST=x"}},
+		{"/app.env", []string{"DB_HOST=y"}},
+	} {
+		r := &Remote{URL: server.URL + test.path, Header: header, CacheDir: dir}
+		vars, err := r.Vars(Flattening{})
+		if err != nil || !reflect.DeepEqual(vars, test.expected) {
+			t.Errorf("%s: got %q, %v, expected %q", test.path, vars, err, test.expected)
+		}
+		// A new Remote revalidates the response cached on disk.
+		r = &Remote{URL: server.URL + test.path, Header: header, CacheDir: dir}
+		vars, err = r.Vars(Flattening{})
+		if err != nil || !reflect.DeepEqual(vars, test.expected) {
+			t.Errorf("%s: got %q, %v from the cache, expected %q", test.path, vars, err, test.expected)
+		}
+	}
+	if requests != 4 {
+		t.Errorf("got %d requests, expected 4", requests)
+	}
+	if _, err := (&Remote{URL: server.URL + "/app.env"}).Vars(Flattening{}); err == nil {
+		t.Error("expected an error for an unauthorized request")
+	}
+	// A 304 is an error without a cached response.
+	r := &Remote{URL: server.URL + "/app.env", Header: http.Header{"Authorization": {"Bearer t"}, "If-None-Match": {`"v1"`}}}
+	if _, _, err := r.Fetch(); err == nil {
+		t.Error("expected an error for an unexpected 304")
+	}
+}
+
+func TestSOPS(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a shell script")
+	}
+	dir := t.TempDir()
+	// The fake sops prints the document of the type it is asked for.
+	script := `#!/bin/sh
+case "$3" in
+json) echo '{"db": {"password": "x"}}' ;;
+dotenv) echo 'DB_PASSWORD=y' ;;
+*) echo "unexpected type $3" >&2; exit 1 ;;
+esac
+`
+	command := filepath.Join(dir, "sops")
+	if err := os.WriteFile(command, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	s := SOPS{Command: command}
+	for path, expected := range map[string][]string{
+		"secrets.json": {"DB_PASSWORD=x"},
+		"secrets.env":  {"DB_PASSWORD=y"},
+	} {
+		vars, err := s.Vars(path, Flattening{})
+		if err != nil || !reflect.DeepEqual(vars, expected) {
+			t.Errorf("%s: got %q, %v, expected %q", path, vars, err, expected)
+		}
+	}
+	if _, err := s.Vars("secrets.yaml", Flattening{}); err == nil || !strings.Contains(err.Error(), "unexpected type yaml") {
+		t.Errorf("got %v, expected the error of sops", err)
+	}
+}
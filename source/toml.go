@@ -0,0 +1,15 @@
+package source
+
+import (
+	"github.com/BurntSushi/toml"
+)
+
+// TOML returns the variables of the TOML document b, see Flattening.
+// Arrays of tables are named by index like other lists.
+func TOML(b []byte, f Flattening) ([]string, error) {
+	var m map[string]interface{}
+	if err := toml.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return f.Flatten(m), nil
+}
@@ -0,0 +1,15 @@
+package source
+
+import (
+	"gopkg.in/yaml.v3"
+)
+
+// YAML returns the variables of the YAML mapping b, such as the values file
+// of a Helm chart, see Flattening.
+func YAML(b []byte, f Flattening) ([]string, error) {
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return f.Flatten(m), nil
+}
@@ -0,0 +1,66 @@
+package syntax
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// CSV renders the CSV file text with p, substituting in each field. The
+// records are re-encoded afterwards, quoting the fields that contain commas,
+// quotes or line breaks as RFC 4180 requires, so a substituted value can't
+// shift the columns.
+func CSV(p *parse.Parser, text string) (string, int, error) {
+	r := csv.NewReader(strings.NewReader(text))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	var (
+		records [][]string
+		subs    int
+		errs    = failures{mode: p.Mode}
+	)
+Records:
+	for {
+		record, err := r.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return "", 0, syntaxError(p.Name, "CSV", err)
+		}
+		for i, field := range record {
+			value, err := p.Parse(field)
+			subs += p.Substitutions()
+			if err != nil {
+				line, col := r.FieldPos(i)
+				if errs.add(moveError(p.Name, text, line, col, err)) {
+					break Records
+				}
+				continue
+			}
+			record[i] = value
+		}
+		records = append(records, record)
+	}
+	if err := errs.err(); err != nil {
+		return "", subs, err
+	}
+	if len(records) == 0 {
+		return Text(p, text)
+	}
+	var b strings.Builder
+	b.Grow(len(text))
+	w := csv.NewWriter(&b)
+	w.UseCRLF = strings.Contains(text, "\r\n")
+	if err := w.WriteAll(records); err != nil {
+		return "", subs, syntaxError(p.Name, "CSV", err)
+	}
+	out := b.String()
+	if !strings.HasSuffix(text, "\n") {
+		out = strings.TrimSuffix(strings.TrimSuffix(out, "\n"), "\r")
+	}
+	return out, subs, nil
+}
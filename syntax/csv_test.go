@@ -0,0 +1,28 @@
+package syntax
+
+import (
+	"testing"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+var csvTests = []renderTest{
+	{"plain", "name,replicas\n$NAME,$REPLICAS\n", "name,replicas\nweb,3\n"},
+	{"quoted", "a,${QUOTE}\n", "a,\"say \"\"hi\"\"\"\n"},
+	{"comma", "${LIST},c", "\"a,b\",c"},
+	{"line break", "x,${LINES}\r\n", "x,\"one\r\ntwo\"\r\n"},
+	{"quoted field", "\"a,$NAME\",b\n", "\"a,web\",b\n"},
+}
+
+func TestCSV(t *testing.T) {
+	doRenderTests(t, CSV, csvTests)
+}
+
+func TestCSVErrors(t *testing.T) {
+	p := parse.New("test", env, parse.NoUnset)
+	_, _, err := CSV(p, "a,b\nc,x$UNSET\n")
+	e, ok := err.(*parse.Error)
+	if !ok || e.Line != 2 || e.Col != 4 {
+		t.Errorf("expected an error at 2:4, got %#v", err)
+	}
+}
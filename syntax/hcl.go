@@ -0,0 +1,97 @@
+package syntax
+
+import (
+	"path"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// HCL renders the HCL document text with p, such as a Terraform
+// configuration. Its ${...} interpolations and %{...} directives belong to
+// Terraform and are left alone, as is the $${ escape. Variables are
+// substituted from the $NAME form, which has no meaning in HCL. See
+// HCLAllowing to substitute ${NAME} for some variables.
+func HCL(p *parse.Parser, text string) (string, int, error) {
+	return HCLAllowing(nil)(p, text)
+}
+
+// HCLAllowing returns a renderer like HCL that also substitutes the ${NAME}
+// references, including those with a default such as ${NAME:-x}, of the
+// variables matching one of the glob patterns.
+func HCLAllowing(patterns []string) Renderer {
+	allowed := func(name string) bool {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+	return func(p *parse.Parser, text string) (string, int, error) {
+		q := *p
+		q.Regions = hclRegions(text, allowed)
+		return Text(&q, text)
+	}
+}
+
+// hclRegions returns the interpolations, directives and escapes of the HCL
+// document text that are not references of allowed variables, to be skipped.
+func hclRegions(text string, allowed func(name string) bool) []parse.Region {
+	var regions []parse.Region
+	for i := 0; i < len(text)-1; i++ {
+		switch {
+		case text[i] == '$' && text[i+1] == '$', text[i] == '%' && text[i+1] == '%':
+			regions = append(regions, parse.Region{Start: parse.Pos(i), End: parse.Pos(i + 2), Skip: true})
+			i++
+		case (text[i] == '$' || text[i] == '%') && text[i+1] == '{':
+			end := closingBrace(text, i+2)
+			if text[i] == '$' && allowed(reference(text[i+2:end])) {
+				continue
+			}
+			if end < len(text) {
+				end++
+			}
+			regions = append(regions, parse.Region{Start: parse.Pos(i), End: parse.Pos(end), Skip: true})
+			i = end - 1
+		}
+	}
+	return regions
+}
+
+// closingBrace returns the offset of the brace closing the one before start,
+// or the end of text if there is none. Nested braces and braces in strings
+// are skipped.
+func closingBrace(text string, start int) int {
+	depth, inString := 0, false
+	for i := start; i < len(text); i++ {
+		switch c := text[i]; {
+		case inString && c == '\\':
+			i++
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return len(text)
+}
+
+// reference returns the variable name of the substitution expr, the content
+// of ${...}, or an empty string if expr is not a substitution.
+func reference(expr string) string {
+	i := 0
+	for i < len(expr) && (expr[i] == '_' || 'a' <= expr[i] && expr[i] <= 'z' || 'A' <= expr[i] && expr[i] <= 'Z' || i > 0 && '0' <= expr[i] && expr[i] <= '9') {
+		i++
+	}
+	if i == 0 || i < len(expr) && strings.IndexByte(":-=+", expr[i]) < 0 {
+		return ""
+	}
+	return expr[:i]
+}
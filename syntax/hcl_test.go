@@ -0,0 +1,30 @@
+package syntax
+
+import (
+	"testing"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+var hclTests = []renderTest{
+	{"bare reference", `name = "$NAME"`, `name = "web"`},
+	{"interpolation", `name = "${var.name}-${NAME}"`, `name = "${var.name}-${NAME}"`},
+	{"directive", `s = "%{ if $NAME }x%{ endif }"`, `s = "%{ if $NAME }x%{ endif }"`},
+	{"escape", `s = "$${NAME} $NAME"`, `s = "$${NAME} web"`},
+	{"nested", `s = "${join(",", {a = "}"})} $NAME"`, `s = "${join(",", {a = "}"})} web"`},
+}
+
+func TestHCL(t *testing.T) {
+	doRenderTests(t, HCL, hclTests)
+}
+
+func TestHCLAllowing(t *testing.T) {
+	p := parse.New("test", env, parse.Relaxed)
+	result, _, err := HCLAllowing([]string{"NAME", "TF_*"})(p, `a = "${NAME}-${var.x}-${TF_UNSET:-d}-${REPLICAS}"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `a = "web-${var.x}-d-${REPLICAS}"`; result != expected {
+		t.Errorf("got %q, expected %q", result, expected)
+	}
+}
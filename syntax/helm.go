@@ -0,0 +1,92 @@
+package syntax
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// Helm renders the Helm chart or Go template text with p, leaving its
+// {{ ... }} actions alone. The actions are guaranteed to be emitted byte for
+// byte: rendering fails if a substitution swallowed or altered one, as
+// ${A:-{{ .x }}} does if A is set.
+func Helm(p *parse.Parser, text string) (string, int, error) {
+	q := *p
+	actions := helmActions(text)
+	for _, a := range actions {
+		q.Regions = append(q.Regions, parse.Region{Start: parse.Pos(a[0]), End: parse.Pos(a[1]), Skip: true})
+	}
+	out, subs, err := Text(&q, text)
+	if err != nil {
+		return "", subs, err
+	}
+	// Actions in blocks dropped by directives are gone for good, those in
+	// repeated blocks are rendered several times.
+	rendered, i := helmActions(out), 0
+	for _, a := range actions {
+		if dropped(q.Dropped(), a[0]) {
+			continue
+		}
+		for i < len(rendered) && out[rendered[i][0]:rendered[i][1]] != text[a[0]:a[1]] {
+			i++
+		}
+		if i == len(rendered) {
+			line, col := 1+strings.Count(text[:a[0]], "\n"), a[0]-strings.LastIndexByte(text[:a[0]], '\n')
+			return "", subs, &parse.Error{Name: p.Name, Pos: parse.Pos(a[0]), Line: line, Col: col, Kind: parse.KindSyntax,
+				Msg: fmt.Sprintf("template action %.40q was altered by a substitution", text[a[0]:a[1]])}
+		}
+		i++
+	}
+	return out, subs, nil
+}
+
+// helmActions returns the start and end offsets of the {{ ... }} actions in
+// text. Quoted strings and comments within actions may hold }}.
+func helmActions(text string) [][2]int {
+	var actions [][2]int
+	for i := 0; i < len(text); {
+		start := strings.Index(text[i:], "{{")
+		if start < 0 {
+			break
+		}
+		start += i
+		end := len(text)
+		for j := start + 2; j < len(text); j++ {
+			switch c := text[j]; {
+			case strings.HasPrefix(text[j:], "}}"):
+				end = j + 2
+			case strings.HasPrefix(text[j:], "/*"):
+				if k := strings.Index(text[j+2:], "*/"); k >= 0 {
+					j += k + 3
+				} else {
+					j = len(text)
+				}
+				continue
+			case c == '"' || c == '`' || c == '\'':
+				for j++; j < len(text) && text[j] != c; j++ {
+					if text[j] == '\\' && c != '`' {
+						j++
+					}
+				}
+				continue
+			default:
+				continue
+			}
+			break
+		}
+		actions = append(actions, [2]int{start, end})
+		i = end
+	}
+	return actions
+}
+
+// dropped reports whether the offset pos is in one of the spans.
+func dropped(spans []parse.Span, pos int) bool {
+	for _, s := range spans {
+		if s.Start <= parse.Pos(pos) && parse.Pos(pos) < s.End {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package syntax
+
+import (
+	"testing"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+var helmTests = []renderTest{
+	{"action", "name: {{ .Values.name }}-$NAME", "name: {{ .Values.name }}-web"},
+	{"reference in action", `{{ printf "$NAME" }} $NAME`, `{{ printf "$NAME" }} web`},
+	{"braces in string", `{{ "}}$NAME" }} $NAME`, `{{ "}}$NAME" }} web`},
+	{"comment", "{{/* $NAME }} */}} $NAME", "{{/* $NAME }} */}} web"},
+	{"trim markers", "{{- $x := 1 -}}$NAME", "{{- $x := 1 -}}web"},
+	{"unset default", "${UNSET:-{{ .x }}}", "{{ .x }}"},
+	{"repeated block", "#envsubst foreach X in $LIST\n- {{ .x }}\n#envsubst endforeach\n", "- {{ .x }}\n- {{ .x }}\n"},
+	{"dropped block", "a: {{ .a }}\n#envsubst if UNSET\nb: {{ .b }}\n#envsubst endif\n$NAME", "a: {{ .a }}\nweb"},
+}
+
+func TestHelm(t *testing.T) {
+	doRenderTests(t, Helm, helmTests)
+}
+
+func TestHelmAltered(t *testing.T) {
+	p := parse.New("test", env, parse.Relaxed)
+	if _, _, err := Helm(p, "a: ${NAME:-{{ .x }}}"); err == nil {
+		t.Error("expected an error for a swallowed action")
+	}
+}
@@ -0,0 +1,166 @@
+package syntax
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// INI renders the INI file text with p. Values substituted in the value of
+// an entry continue on indented lines if they span several lines, so they
+// don't end the entry. References in comments are left alone.
+func INI(p *parse.Parser, text string) (string, int, error) {
+	q := *p
+	q.Regions = iniRegions(text)
+	return Text(&q, text)
+}
+
+// Properties renders the Java properties file text with p. Values
+// substituted in keys and values are escaped as the format requires:
+// backslashes, line breaks and characters outside of ASCII are escaped,
+// in keys also the = and : separators, spaces and comment characters.
+// References in comments are left alone.
+func Properties(p *parse.Parser, text string) (string, int, error) {
+	q := *p
+	q.Regions = propertiesRegions(text)
+	return Text(&q, text)
+}
+
+// lines calls fn with the offsets of each line of text, excluding the
+// line break.
+func lines(text string, fn func(start, end int)) {
+	for start := 0; start < len(text); {
+		end := strings.IndexByte(text[start:], '\n')
+		if end < 0 {
+			fn(start, len(text))
+			return
+		}
+		fn(start, start+end)
+		start += end + 1
+	}
+}
+
+// iniRegions returns the comments and values of the INI file text.
+func iniRegions(text string) []parse.Region {
+	var regions []parse.Region
+	lines(text, func(start, end int) {
+		line := text[start:end]
+		trimmed := strings.TrimLeft(line, " \t")
+		switch {
+		case trimmed == "" || trimmed[0] == '[':
+		case trimmed[0] == ';' || trimmed[0] == '#':
+			regions = append(regions, parse.Region{Start: parse.Pos(start), End: parse.Pos(end), Skip: true})
+		default:
+			if sep := strings.IndexAny(line, "=:"); sep >= 0 {
+				regions = append(regions, parse.Region{Start: parse.Pos(start + sep + 1), End: parse.Pos(end), Escape: iniValue})
+			}
+		}
+	})
+	return regions
+}
+
+// iniValue continues the lines of s on indented lines.
+func iniValue(s string) (string, error) {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\n", "\n  "), nil
+}
+
+// propertiesRegions returns the comments, keys and values of the properties
+// file text.
+func propertiesRegions(text string) []parse.Region {
+	var (
+		regions []parse.Region
+		cont    bool // the line continues the value of the previous one
+	)
+	lines(text, func(start, end int) {
+		continued := cont
+		cont = continues(text[start:end])
+		if continued {
+			regions[len(regions)-1].End = parse.Pos(end)
+			return
+		}
+		i := start
+		for i < end && (text[i] == ' ' || text[i] == '\t' || text[i] == '\f') {
+			i++
+		}
+		if i == end {
+			cont = false
+			return
+		}
+		if text[i] == '#' || text[i] == '!' {
+			cont = false
+			regions = append(regions, parse.Region{Start: parse.Pos(start), End: parse.Pos(end), Skip: true})
+			return
+		}
+		key := i
+		for ; i < end && !strings.ContainsRune("=: \t\f", rune(text[i])); i++ {
+			if text[i] == '\\' {
+				i++
+			}
+		}
+		if i > end {
+			i = end
+		}
+		regions = append(regions, parse.Region{Start: parse.Pos(key), End: parse.Pos(i), Escape: propertiesKey})
+		for i < end && (text[i] == ' ' || text[i] == '\t' || text[i] == '\f') {
+			i++
+		}
+		if i < end && (text[i] == '=' || text[i] == ':') {
+			i++
+		}
+		regions = append(regions, parse.Region{Start: parse.Pos(i), End: parse.Pos(end), Escape: propertiesValue})
+	})
+	return regions
+}
+
+// continues reports whether line ends with an odd number of backslashes,
+// continuing on the next line.
+func continues(line string) bool {
+	n := len(line) - len(strings.TrimRight(line, "\\"))
+	return n%2 == 1
+}
+
+// propertiesKey escapes s for the key of a property.
+func propertiesKey(s string) (string, error) {
+	return propertiesEscape(s, true), nil
+}
+
+// propertiesValue escapes s for the value of a property.
+func propertiesValue(s string) (string, error) {
+	return propertiesEscape(s, false), nil
+}
+
+// propertiesEscape escapes backslashes, line breaks and characters outside
+// of ASCII in s, and for keys the separators, spaces and comment characters.
+func propertiesEscape(s string, key bool) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case key && strings.ContainsRune("=: #!", r):
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			if r1, r2 := utf16.EncodeRune(r); r1 != '\uFFFD' {
+				fmt.Fprintf(&b, `\u%04x\u%04x`, r1, r2)
+			} else {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,30 @@
+package syntax
+
+import (
+	"testing"
+)
+
+var iniTests = []renderTest{
+	{"value", "[$NAME]\nname = $NAME\n", "[web]\nname = web\n"},
+	{"comments", "; $NAME\n# $NAME\nname=$NAME", "; $NAME\n# $NAME\nname=web"},
+	{"multi-line", "a = ${LINES}\nb = 1", "a = one\n  two\nb = 1"},
+}
+
+func TestINI(t *testing.T) {
+	doRenderTests(t, INI, iniTests)
+}
+
+var propertiesTests = []renderTest{
+	{"value", "name=$NAME", "name=web"},
+	{"separators", "name : ${TRICKY}", `name : a: b # c`},
+	{"key", "${TRICKY}=x", `a\:\ b\ \#\ c=x`},
+	{"line breaks", "a=${LINES}", `a=one\ntwo`},
+	{"unicode", "a=${UNICODE}", `a=gr\u00fc\u00dfe \ud83d\ude00`},
+	{"comments", "# $NAME\n! $NAME\na=$NAME", "# $NAME\n! $NAME\na=web"},
+	{"continuation", "a=x, \\\n  $NAME", "a=x, \\\n  web"},
+	{"backslash", `a=${BACKSLASH}`, `a=c:\\dir`},
+}
+
+func TestProperties(t *testing.T) {
+	doRenderTests(t, Properties, propertiesTests)
+}
@@ -0,0 +1,63 @@
+package syntax
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// JSON renders the JSON document text with p. Values substituted within
+// strings are escaped, so quotes, backslashes and newlines in them can't end
+// the string. References outside of strings are substituted as they are, so
+// "port": ${PORT} renders to a number. The rendered document must be valid.
+func JSON(p *parse.Parser, text string) (string, int, error) {
+	q := *p
+	q.Regions = stringRegions(text, '"', '\\', jsonEscape)
+	out, subs, err := Text(&q, text)
+	if err != nil {
+		return "", subs, err
+	}
+	if strings.TrimSpace(out) != "" {
+		var v interface{}
+		if err := json.Unmarshal([]byte(out), &v); err != nil {
+			return "", subs, renderedError(p.Name, "JSON", err)
+		}
+	}
+	return out, subs, nil
+}
+
+// jsonEscape escapes s for a JSON string.
+func jsonEscape(s string) (string, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	quoted := strings.TrimSuffix(b.String(), "\n")
+	return quoted[1 : len(quoted)-1], nil
+}
+
+// stringRegions returns the contents of the string literals in text as
+// regions escaped by escape. Literals are enclosed in quote characters, the
+// escape character makes the next character part of the literal.
+func stringRegions(text string, quote, escapeChar byte, escape func(string) (string, error)) []parse.Region {
+	var regions []parse.Region
+	for i := 0; i < len(text); i++ {
+		if text[i] != quote {
+			continue
+		}
+		start := i + 1
+		for i = start; i < len(text) && text[i] != quote; i++ {
+			if text[i] == escapeChar {
+				i++
+			}
+		}
+		end := i
+		if end > len(text) {
+			end = len(text)
+		}
+		regions = append(regions, parse.Region{Start: parse.Pos(start), End: parse.Pos(end), Escape: escape})
+	}
+	return regions
+}
@@ -0,0 +1,26 @@
+package syntax
+
+import (
+	"testing"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+var jsonTests = []renderTest{
+	{"string", `{"name": "$NAME"}`, `{"name": "web"}`},
+	{"escaped", `{"msg": "${QUOTE}"}`, `{"msg": "say \"hi\""}`},
+	{"number", `{"replicas": ${REPLICAS}}`, `{"replicas": 3}`},
+	{"escaped quote in literal", `{"a": "\"$NAME"}`, `{"a": "\"web"}`},
+	{"empty", "", ""},
+}
+
+func TestJSON(t *testing.T) {
+	doRenderTests(t, JSON, jsonTests)
+}
+
+func TestJSONInvalid(t *testing.T) {
+	p := parse.New("test", env, parse.Relaxed)
+	if _, _, err := JSON(p, `{"a": ${TRICKY}}`); err == nil {
+		t.Error("expected an error for invalid rendered JSON")
+	}
+}
@@ -0,0 +1,35 @@
+package syntax
+
+import "github.com/hellt/envsubst/parse"
+
+// provenances are the comments of the syntaxes recording the variables
+// substituted in the lines, see parse.Parser.Provenance. JSON and CSV have
+// no comments.
+var provenances = map[string]parse.Provenance{
+	"text":       {Prefix: " # envsubst: "},
+	"yaml":       {Prefix: " # envsubst: "},
+	"toml":       {Prefix: " # envsubst: "},
+	"shell":      {Prefix: " # envsubst: "},
+	"hcl":        {Prefix: " # envsubst: "},
+	"ini":        {Prefix: "; envsubst: ", Suffix: "\n", Before: true},
+	"properties": {Prefix: "# envsubst: ", Suffix: "\n", Before: true},
+	"xml":        {Prefix: " <!-- envsubst: ", Suffix: " -->"},
+	"helm":       {Prefix: " {{/* envsubst: ", Suffix: " */}}"},
+}
+
+// Provenance returns the comments of the syntax name recording the variables
+// substituted in the lines, and whether it has comments at all.
+func Provenance(name string) (*parse.Provenance, bool) {
+	v, ok := provenances[name]
+	return &v, ok
+}
+
+// contains reports whether list contains s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
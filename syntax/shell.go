@@ -0,0 +1,156 @@
+package syntax
+
+import (
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// Shell renders the shell script text with p, leaving the expansions meant
+// for the shell alone: single-quoted strings, here-document bodies, escaped
+// references such as \$HOME, positional parameters such as $1 and parameter
+// expansions envsubst does not support, such as ${FILE%%.*} or ${#LIST},
+// are not substituted. References in double-quoted strings and unquoted
+// words are, with the defaults of ${VAR:-default} and the like.
+func Shell(p *parse.Parser, text string) (string, int, error) {
+	q := *p
+	restrict := *p.Restrict
+	restrict.NoDigit = true
+	q.Restrict = &restrict
+	q.Regions = shellRegions(text)
+	return Text(&q, text)
+}
+
+// heredoc is a pending here-document, whose body starts on the next line.
+type heredoc struct {
+	delim     string
+	stripTabs bool // <<- strips leading tabs from the body and delimiter lines
+}
+
+// shellRegions returns the single-quoted strings, here-document bodies,
+// escaped dollar signs and shell parameter expansions of the shell script
+// text, to be skipped.
+func shellRegions(text string) []parse.Region {
+	var (
+		regions  []parse.Region
+		pending  []heredoc
+		inDouble bool
+	)
+	skip := func(start, end int) {
+		if end > len(text) {
+			end = len(text)
+		}
+		regions = append(regions, parse.Region{Start: parse.Pos(start), End: parse.Pos(end), Skip: true})
+	}
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\\':
+			if i+1 < len(text) && text[i+1] == '$' {
+				skip(i, i+2)
+			}
+			i++
+		case c == '$' && strings.HasPrefix(text[i:], "${"):
+			if end := braceEnd(text, i+1); shellExpansion(text[i+2 : end]) {
+				skip(i, end+1)
+				i = end
+			}
+		case inDouble:
+			inDouble = c != '"'
+		case c == '"':
+			inDouble = true
+		case c == '\'':
+			end := strings.IndexByte(text[i+1:], '\'')
+			if end < 0 {
+				end = len(text) - i - 1
+			}
+			skip(i, i+end+2)
+			i += end + 1
+		case c == '#' && (i == 0 || strings.IndexByte(" \t\n;&|(", text[i-1]) >= 0):
+			// A comment may hold unbalanced quotes, as in "don't".
+			for i+1 < len(text) && text[i+1] != '\n' {
+				i++
+			}
+		case c == '<' && strings.HasPrefix(text[i:], "<<") && !strings.HasPrefix(text[i:], "<<<"):
+			var h heredoc
+			i += 2
+			if i < len(text) && text[i] == '-' {
+				h.stripTabs = true
+				i++
+			}
+			for i < len(text) && (text[i] == ' ' || text[i] == '\t') {
+				i++
+			}
+			start := i
+			for i < len(text) && strings.IndexByte(" \t\n;&|<>()", text[i]) < 0 {
+				i++
+			}
+			h.delim = strings.NewReplacer(`'`, "", `"`, "", `\`, "").Replace(text[start:i])
+			if h.delim != "" {
+				pending = append(pending, h)
+			}
+			i--
+		case c == '\n' && len(pending) > 0:
+			i++
+			for _, h := range pending {
+				start := i
+				for i < len(text) {
+					end := strings.IndexByte(text[i:], '\n')
+					if end < 0 {
+						end = len(text) - i
+					}
+					line := text[i : i+end]
+					if h.stripTabs {
+						line = strings.TrimLeft(line, "\t")
+					}
+					if line == h.delim {
+						break
+					}
+					i += end + 1
+				}
+				skip(start, i)
+			}
+			pending = nil
+			i--
+		}
+	}
+	return regions
+}
+
+// braceEnd returns the offset of the brace closing the one at offset open of
+// text, or the length of text if there is none.
+func braceEnd(text string, open int) int {
+	depth := 0
+	for i := open; i < len(text); i++ {
+		switch text[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(text)
+}
+
+// shellExpansion reports whether the expansion ${expr} is one of the shell
+// only, such as ${FILE%%.*}, ${FILE/a/b}, ${#LIST} or ${!REF}, rather than a
+// reference envsubst substitutes: a variable, optionally followed by a
+// default such as :-default or a filter.
+func shellExpansion(expr string) bool {
+	i := 0
+	for i < len(expr) && (expr[i] == '_' || 'a' <= expr[i] && expr[i] <= 'z' ||
+		'A' <= expr[i] && expr[i] <= 'Z' || i > 0 && '0' <= expr[i] && expr[i] <= '9') {
+		i++
+	}
+	if i == 0 {
+		return true
+	}
+	op := expr[i:]
+	for _, prefix := range []string{"", "|", ":-", ":=", ":+", ":?", "-", "=", "+", "?"} {
+		if op == prefix || prefix != "" && strings.HasPrefix(op, prefix) {
+			return false
+		}
+	}
+	return true
+}
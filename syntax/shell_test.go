@@ -0,0 +1,29 @@
+package syntax
+
+import (
+	"testing"
+)
+
+var shellTests = []renderTest{
+	{"word", "echo $NAME", "echo web"},
+	{"double quoted", `echo "$NAME's"`, `echo "web's"`},
+	{"single quoted", `echo '$NAME' $NAME`, `echo '$NAME' web`},
+	{"positional", `exec app --name $NAME "$@" $1 ${2}`, `exec app --name web "$@" $1 ${2}`},
+	{"escaped", `echo \$NAME "\$NAME" $NAME`, `echo \$NAME "\$NAME" web`},
+	{"comment quote", "# don't\necho $NAME", "# don't\necho web"},
+	{"heredoc", "cat <<EOF\n$NAME\nEOF\necho $NAME", "cat <<EOF\n$NAME\nEOF\necho web"},
+	{"quoted heredoc", "cat <<-'EOF' > f\n\t$NAME\n\tEOF\necho $NAME", "cat <<-'EOF' > f\n\t$NAME\n\tEOF\necho web"},
+	{"here string", "cat <<< $NAME", "cat <<< web"},
+	{"default", `echo ${NAME:-x} ${UNSET:-x} "${UNSET-y}" ${NAME|upper}`, `echo web x "y" WEB`},
+	{"remove suffix", `base=${FILE%%.*} $NAME`, `base=${FILE%%.*} web`},
+	{"remove prefix", `echo ${NAME#w} "${NAME##*/}"`, `echo ${NAME#w} "${NAME##*/}"`},
+	{"replace", `echo ${NAME/a/b} ${NAME//a/b}`, `echo ${NAME/a/b} ${NAME//a/b}`},
+	{"case", `echo ${NAME^^} ${NAME,}`, `echo ${NAME^^} ${NAME,}`},
+	{"substring", `echo ${NAME:1:2} ${NAME: -1}`, `echo ${NAME:1:2} ${NAME: -1}`},
+	{"length", `echo ${#NAME} ${!NAME} ${NAME@Q} ${LIST[0]}`, `echo ${#NAME} ${!NAME} ${NAME@Q} ${LIST[0]}`},
+	{"nested", `echo ${NAME%${SUFFIX}} $NAME`, `echo ${NAME%${SUFFIX}} web`},
+}
+
+func TestShell(t *testing.T) {
+	doRenderTests(t, Shell, shellTests)
+}
@@ -0,0 +1,199 @@
+// Package syntax renders templates written in a specific file format, such
+// as YAML, substituting variables only where the format allows and escaping
+// the substituted values as the format requires.
+package syntax
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// A Renderer renders text with the parser p. It returns the rendered text
+// and the number of substitutions made, see parse.Parser.Substitutions.
+type Renderer func(p *parse.Parser, text string) (string, int, error)
+
+// renderers are the supported syntaxes by name.
+var renderers = map[string]Renderer{
+	"text":       Text,
+	"yaml":       YAML,
+	"json":       JSON,
+	"toml":       TOML,
+	"ini":        INI,
+	"properties": Properties,
+	"xml":        XML,
+	"shell":      Shell,
+	"hcl":        HCL,
+	"helm":       Helm,
+	"csv":        CSV,
+}
+
+// extensions maps file extensions to the name of their syntax.
+var extensions = map[string]string{
+	".yaml":       "yaml",
+	".yml":        "yaml",
+	".json":       "json",
+	".toml":       "toml",
+	".ini":        "ini",
+	".properties": "properties",
+	".xml":        "xml",
+	".sh":         "shell",
+	".bash":       "shell",
+	".hcl":        "hcl",
+	".tf":         "hcl",
+	".tfvars":     "hcl",
+	".gotmpl":     "helm",
+	".csv":        "csv",
+}
+
+// Lookup returns the renderer of the syntax name.
+func Lookup(name string) (Renderer, bool) {
+	r, ok := renderers[name]
+	return r, ok
+}
+
+// Names returns the names of the supported syntaxes in sorted order.
+func Names() []string {
+	names := make([]string, 0, len(renderers))
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ForFile returns the name of the syntax of the file path, determined by its
+// extension, or text if the extension is unknown. Template extensions such as
+// .tmpl are skipped, so config.yaml.tmpl is YAML.
+func ForFile(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case ".tmpl", ".tpl", ".template", ".in":
+		ext = strings.ToLower(filepath.Ext(strings.TrimSuffix(path, filepath.Ext(path))))
+	}
+	if name, ok := extensions[ext]; ok {
+		return name
+	}
+	return "text"
+}
+
+// Text renders text as plain text, substituting all references.
+func Text(p *parse.Parser, text string) (string, int, error) {
+	out, err := p.Parse(text)
+	return out, p.Substitutions(), err
+}
+
+// failures collects the failures of rendering the parts of a document.
+type failures struct {
+	mode parse.Mode
+	list parse.ErrorList
+}
+
+// add adds the failures of err. It reports whether rendering should stop.
+func (e *failures) add(err error) bool {
+	switch err := err.(type) {
+	case *parse.Error:
+		e.list = append(e.list, err)
+	case parse.ErrorList:
+		e.list = append(e.list, err...)
+	default:
+		e.list = append(e.list, &parse.Error{Kind: parse.KindSyntax, Msg: err.Error()})
+	}
+	if e.mode == parse.Quick {
+		return true
+	}
+	for _, err := range e.list {
+		if err.Kind == parse.KindLimit {
+			return true
+		}
+	}
+	return false
+}
+
+// err returns the collected failures as returned by parse.Parser.Parse in
+// the parsing mode, or nil if there are none.
+func (e *failures) err() error {
+	switch {
+	case len(e.list) == 0:
+		return nil
+	case e.mode == parse.Quick:
+		return e.list[0]
+	}
+	return e.list
+}
+
+// moveError moves the failures of rendering a part of text, which starts at
+// the 1-based line and column, to their position in text.
+func moveError(name, text string, line, col int, err error) error {
+	return mapErrors(err, func(e *parse.Error) *parse.Error {
+		moved := *e
+		moved.Name = name
+		moved.Line = line + e.Line - 1
+		if e.Line == 1 {
+			moved.Col = col + e.Col - 1
+		}
+		moved.Pos = offset(text, moved.Line, moved.Col)
+		return &moved
+	})
+}
+
+// relocateError moves the failures of rendering a part of text to the
+// offset in text that at returns for their offset in the part, for parts
+// that are not copied from text as they are.
+func relocateError(name, text string, at func(parse.Pos) parse.Pos, err error) error {
+	return mapErrors(err, func(e *parse.Error) *parse.Error {
+		moved := *e
+		moved.Name = name
+		if moved.Pos = at(e.Pos); int(moved.Pos) > len(text) {
+			moved.Pos = parse.Pos(len(text))
+		}
+		before := text[:moved.Pos]
+		moved.Line, moved.Col = 1+strings.Count(before, "\n"), int(moved.Pos)-strings.LastIndexByte(before, '\n')
+		return &moved
+	})
+}
+
+// mapErrors returns err with its failures replaced by move.
+func mapErrors(err error, move func(e *parse.Error) *parse.Error) error {
+	switch err := err.(type) {
+	case *parse.Error:
+		return move(err)
+	case parse.ErrorList:
+		moved := make(parse.ErrorList, len(err))
+		for i, e := range err {
+			moved[i] = move(e)
+		}
+		return moved
+	}
+	return err
+}
+
+// offset returns the byte offset of the 1-based line and column in text.
+func offset(text string, line, col int) parse.Pos {
+	pos := 0
+	for ; line > 1; line-- {
+		i := strings.IndexByte(text[pos:], '\n')
+		if i < 0 {
+			return parse.Pos(len(text))
+		}
+		pos += i + 1
+	}
+	if pos += col - 1; pos > len(text) {
+		pos = len(text)
+	}
+	return parse.Pos(pos)
+}
+
+// syntaxError returns the failure of text not being valid in the syntax.
+func syntaxError(name, syntax string, err error) *parse.Error {
+	return &parse.Error{Name: name, Kind: parse.KindSyntax, Msg: fmt.Sprintf("invalid %s: %v", syntax, err)}
+}
+
+// renderedError returns the failure of the rendered text not being valid
+// in the syntax.
+func renderedError(name, syntax string, err error) *parse.Error {
+	return &parse.Error{Name: name, Kind: parse.KindSyntax, Msg: fmt.Sprintf("rendered output is not valid %s: %v", syntax, err)}
+}
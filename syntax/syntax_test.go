@@ -0,0 +1,59 @@
+package syntax
+
+import (
+	"testing"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+var env = []string{
+	"NAME=web",
+	"REPLICAS=3",
+	"TRICKY=a: b # c",
+	"QUOTE=say \"hi\"",
+	"LINES=one\ntwo",
+	"UNICODE=grüße 😀",
+	"BACKSLASH=c:\\dir",
+	"MARKUP=<b> & c",
+	"CDATA=x]]>y",
+	"LIST=a,b",
+	"ON=true",
+	"NOTHING=null",
+}
+
+type renderTest struct {
+	name     string
+	input    string
+	expected string
+}
+
+func doRenderTests(t *testing.T, render Renderer, tests []renderTest) {
+	t.Helper()
+	for _, test := range tests {
+		p := parse.New(test.name, env, parse.Relaxed)
+		p.Directives = true
+		result, _, err := render(p, test.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if result != test.expected {
+			t.Errorf("%s: got\n\t%q\nexpected\n\t%q", test.name, result, test.expected)
+		}
+	}
+}
+
+func TestForFile(t *testing.T) {
+	tests := map[string]string{
+		"deploy.yaml":      "yaml",
+		"deploy.YML":       "yaml",
+		"deploy.yaml.tmpl": "yaml",
+		"README.md":        "text",
+		"Makefile":         "text",
+	}
+	for path, expected := range tests {
+		if got := ForFile(path); got != expected {
+			t.Errorf("ForFile(%q) = %q, expected %q", path, got, expected)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package syntax
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// TOML renders the TOML document text with p. Values substituted within
+// strings are escaped for the kind of string: basic strings escape quotes,
+// backslashes and control characters, multi-line basic strings keep newlines.
+// Literal strings can't escape anything, so values containing a quote, or for
+// single-line ones a newline, fail to render. References in comments are left
+// alone, those outside of strings are substituted as they are, so
+// port = ${PORT} renders to an integer.
+func TOML(p *parse.Parser, text string) (string, int, error) {
+	q := *p
+	q.Regions = tomlRegions(text)
+	return Text(&q, text)
+}
+
+// tomlRegions returns the strings and comments of the TOML document text.
+func tomlRegions(text string) []parse.Region {
+	var regions []parse.Region
+	add := func(start, end int, skip bool, escape func(string) (string, error)) {
+		if end > len(text) {
+			end = len(text)
+		}
+		regions = append(regions, parse.Region{Start: parse.Pos(start), End: parse.Pos(end), Skip: skip, Escape: escape})
+	}
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '#':
+			end := strings.IndexByte(text[i:], '\n')
+			if end < 0 {
+				end = len(text) - i
+			}
+			add(i, i+end, true, nil)
+			i += end
+		case strings.HasPrefix(text[i:], `"""`):
+			start := i + 3
+			for i = start; i < len(text) && !strings.HasPrefix(text[i:], `"""`); i++ {
+				if text[i] == '\\' {
+					i++
+				}
+			}
+			add(start, i, false, tomlMultiLineBasic)
+			i += 2
+		case strings.HasPrefix(text[i:], "'''"):
+			start := i + 3
+			end := strings.Index(text[start:], "'''")
+			if end < 0 {
+				end = len(text) - start
+			}
+			add(start, start+end, false, tomlMultiLineLiteral)
+			i = start + end + 2
+		case text[i] == '"':
+			start := i + 1
+			for i = start; i < len(text) && text[i] != '"' && text[i] != '\n'; i++ {
+				if text[i] == '\\' {
+					i++
+				}
+			}
+			add(start, i, false, tomlBasic)
+		case text[i] == '\'':
+			start := i + 1
+			end := strings.IndexAny(text[start:], "'\n")
+			if end < 0 {
+				end = len(text) - start
+			}
+			i = start + end
+			add(start, i, false, tomlLiteral)
+		}
+	}
+	return regions
+}
+
+// tomlBasic escapes s for a basic string.
+func tomlBasic(s string) (string, error) {
+	return tomlEscape(s, false), nil
+}
+
+// tomlMultiLineBasic escapes s for a multi-line basic string.
+func tomlMultiLineBasic(s string) (string, error) {
+	return tomlEscape(s, true), nil
+}
+
+// tomlEscape escapes backslashes, quotes and control characters in s.
+// With multiLine newlines and tabs are kept.
+func tomlEscape(s string, multiLine bool) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '"':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case multiLine && (r == '\n' || r == '\t'):
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\u%04X`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// tomlLiteral checks that s can be written in a literal string.
+func tomlLiteral(s string) (string, error) {
+	for _, r := range s {
+		if r == '\'' || r != '\t' && (r < 0x20 || r == 0x7f) {
+			return "", fmt.Errorf("substituted value %q can't be written in a TOML literal string", s)
+		}
+	}
+	return s, nil
+}
+
+// tomlMultiLineLiteral checks that s can be written in a multi-line literal string.
+func tomlMultiLineLiteral(s string) (string, error) {
+	if strings.Contains(s, "'''") {
+		return "", fmt.Errorf("substituted value %q can't be written in a TOML multi-line literal string", s)
+	}
+	return s, nil
+}
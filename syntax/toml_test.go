@@ -0,0 +1,29 @@
+package syntax
+
+import (
+	"testing"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+var tomlTests = []renderTest{
+	{"basic", `name = "$NAME"`, `name = "web"`},
+	{"basic escaped", `msg = "${QUOTE}"`, `msg = "say \"hi\""`},
+	{"integer", `replicas = ${REPLICAS}`, `replicas = 3`},
+	{"literal", `name = '$NAME'`, `name = 'web'`},
+	{"multi-line basic", "msg = \"\"\"\n${QUOTE}\n\"\"\"", "msg = \"\"\"\nsay \\\"hi\\\"\n\"\"\""},
+	{"multi-line literal", "msg = '''\n${QUOTE}'''", "msg = '''\nsay \"hi\"'''"},
+	{"comment", "# uses $NAME\nname = \"$NAME\" # $NAME", "# uses $NAME\nname = \"web\" # $NAME"},
+	{"hash in string", `url = "a#$NAME"`, `url = "a#web"`},
+}
+
+func TestTOML(t *testing.T) {
+	doRenderTests(t, TOML, tomlTests)
+}
+
+func TestTOMLLiteralFails(t *testing.T) {
+	p := parse.New("test", []string{"Q=it's"}, parse.Relaxed)
+	if _, _, err := TOML(p, `a = '$Q'`); err == nil {
+		t.Error("expected an error for a quote in a literal string")
+	}
+}
@@ -0,0 +1,109 @@
+package syntax
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// XML renders the XML document text with p. Values substituted in text and
+// attribute values are entity-escaped, in CDATA sections a ]]> is split across
+// sections. References in comments are left alone. The rendered document must
+// be well-formed.
+func XML(p *parse.Parser, text string) (string, int, error) {
+	q := *p
+	q.Regions = xmlRegions(text)
+	out, subs, err := Text(&q, text)
+	if err != nil {
+		return "", subs, err
+	}
+	dec := xml.NewDecoder(strings.NewReader(out))
+	dec.Strict = true
+	for {
+		if _, err := dec.Token(); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return "", subs, renderedError(p.Name, "XML", err)
+		}
+	}
+	return out, subs, nil
+}
+
+// xmlRegions returns the text, attribute values, CDATA sections and comments
+// of the XML document text.
+func xmlRegions(text string) []parse.Region {
+	var regions []parse.Region
+	add := func(start, end int, skip bool, escape func(string) (string, error)) {
+		regions = append(regions, parse.Region{Start: parse.Pos(start), End: parse.Pos(end), Skip: skip, Escape: escape})
+	}
+	// until returns the offset after the first delim from i, or the end of text.
+	until := func(i int, delim string) int {
+		if end := strings.Index(text[i:], delim); end >= 0 {
+			return i + end + len(delim)
+		}
+		return len(text)
+	}
+	for i := 0; i < len(text); {
+		switch {
+		case strings.HasPrefix(text[i:], "<!--"):
+			end := until(i, "-->")
+			add(i, end, true, nil)
+			i = end
+		case strings.HasPrefix(text[i:], "<![CDATA["):
+			start := i + len("<![CDATA[")
+			end := until(start, "]]>")
+			add(start, end, false, xmlCDATA)
+			i = end
+		case strings.HasPrefix(text[i:], "<?"):
+			i = until(i, "?>")
+		case strings.HasPrefix(text[i:], "<!"):
+			i = until(i, ">")
+		case text[i] == '<':
+			for i++; i < len(text) && text[i] != '>'; i++ {
+				if quote := text[i]; quote == '"' || quote == '\'' {
+					start := i + 1
+					end := strings.IndexByte(text[start:], quote)
+					if end < 0 {
+						end = len(text) - start
+					}
+					add(start, start+end, false, xmlAttr(quote))
+					i = start + end
+				}
+			}
+			i++
+		default:
+			end := strings.IndexByte(text[i:], '<')
+			if end < 0 {
+				end = len(text) - i
+			}
+			add(i, i+end, false, xmlText)
+			i += end
+		}
+	}
+	return regions
+}
+
+// xmlText escapes s for the text of an element.
+func xmlText(s string) (string, error) {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s), nil
+}
+
+// xmlAttr returns the escaping of an attribute value enclosed in quote.
+func xmlAttr(quote byte) func(string) (string, error) {
+	r := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+	if quote == '\'' {
+		r = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "'", "&apos;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+	}
+	return func(s string) (string, error) {
+		return r.Replace(s), nil
+	}
+}
+
+// xmlCDATA splits the ]]> in s across CDATA sections.
+func xmlCDATA(s string) (string, error) {
+	return strings.ReplaceAll(s, "]]>", "]]]]><![CDATA[>"), nil
+}
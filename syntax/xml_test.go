@@ -0,0 +1,28 @@
+package syntax
+
+import (
+	"testing"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+var xmlTests = []renderTest{
+	{"text", "<a>$NAME</a>", "<a>web</a>"},
+	{"escaped text", "<a>${MARKUP}</a>", "<a>&lt;b&gt; &amp; c</a>"},
+	{"attribute", `<a b="${QUOTE}"/>`, `<a b="say &quot;hi&quot;"/>`},
+	{"single quoted attribute", `<a b='${QUOTE}'/>`, `<a b='say "hi"'/>`},
+	{"comment", "<a><!-- $NAME -->$NAME</a>", "<a><!-- $NAME -->web</a>"},
+	{"cdata", "<a><![CDATA[${CDATA}]]></a>", "<a><![CDATA[x]]]]><![CDATA[>y]]></a>"},
+	{"declaration", `<?xml version="1.0"?><a>$NAME</a>`, `<?xml version="1.0"?><a>web</a>`},
+}
+
+func TestXML(t *testing.T) {
+	doRenderTests(t, XML, xmlTests)
+}
+
+func TestXMLInvalid(t *testing.T) {
+	p := parse.New("test", []string{"TAG=a"}, parse.Relaxed)
+	if _, _, err := XML(p, "<$TAG></b>"); err == nil {
+		t.Error("expected an error for malformed rendered XML")
+	}
+}
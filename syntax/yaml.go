@@ -0,0 +1,254 @@
+package syntax
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hellt/envsubst/parse"
+	"gopkg.in/yaml.v3"
+)
+
+// YAML renders the YAML documents in text with p, substituting only in scalar
+// values. Mapping keys, comments and the structure are left alone. The
+// documents are re-encoded afterwards, so substituted values are quoted as
+// needed: a value containing ": " or " #" can't corrupt the document.
+// Scalars keep their type: replicas: ${N} renders to the string "3" and
+// enabled: ${ON} to "true". Tag them to render another type, as in
+// replicas: !!int ${N}, failing if the value is not of that type. In streams
+// of several documents, messages name the document the failure is in, by
+// number and, for Kubernetes objects, kind and name. With a Provenance, the
+// variables substituted in a scalar are listed in a comment at the end of its
+// line.
+func YAML(p *parse.Parser, text string) (string, int, error) {
+	var (
+		docs []*yaml.Node
+		dec  = yaml.NewDecoder(strings.NewReader(text))
+	)
+	for {
+		var doc yaml.Node
+		if err := dec.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if len(docs) > 0 {
+				err = fmt.Errorf("document %d: %v", len(docs)+1, err)
+			}
+			return "", 0, syntaxError(p.Name, "YAML", err)
+		}
+		docs = append(docs, &doc)
+	}
+	if len(docs) == 0 {
+		return Text(p, text)
+	}
+	var (
+		subs int
+		errs = failures{mode: p.Mode}
+	)
+	// The comments are set on the nodes rather than added to the scalars.
+	provenance := p.Provenance
+	p.Provenance = nil
+	defer func() { p.Provenance = provenance }()
+	var (
+		walk   func(n, flow *yaml.Node) bool
+		prefix string // identifies the document in messages
+		// names are the variables substituted in the scalars of the nodes
+		// commented with them, the outermost flow collection containing
+		// the scalars, if any, or the scalars.
+		names = map[*yaml.Node][]string{}
+	)
+	walk = func(n, flow *yaml.Node) bool {
+		if flow == nil && n.Style&yaml.FlowStyle != 0 && n.Kind != yaml.ScalarNode {
+			flow = n
+		}
+		switch n.Kind {
+		case yaml.DocumentNode, yaml.SequenceNode:
+			for _, c := range n.Content {
+				if walk(c, flow) {
+					return true
+				}
+			}
+		case yaml.MappingNode:
+			for i := 1; i < len(n.Content); i += 2 {
+				if walk(n.Content[i], flow) {
+					return true
+				}
+			}
+		case yaml.ScalarNode:
+			value, err := p.Parse(n.Value)
+			subs += p.Substitutions()
+			if err != nil {
+				return errs.add(scalarError(p.Name, prefix, text, n, err))
+			}
+			if provenance != nil {
+				commented := n
+				if flow != nil {
+					commented = flow
+				}
+				for _, name := range p.Substituted() {
+					if !contains(names[commented], name) {
+						names[commented] = append(names[commented], name)
+					}
+				}
+			}
+			if value != n.Value {
+				if err := checkTag(n, value); err != nil {
+					return errs.add(scalarError(p.Name, prefix, text, n, err))
+				}
+				n.Value = value
+			}
+		}
+		return false
+	}
+	for i, doc := range docs {
+		if len(docs) > 1 {
+			prefix = documentName(i, doc)
+		}
+		if walk(doc, nil) {
+			break
+		}
+	}
+	for n, list := range names {
+		comment := strings.TrimSpace(provenance.Comment(list))
+		if n.LineComment != "" {
+			comment = n.LineComment + " " + comment
+		}
+		n.LineComment = comment
+	}
+	if err := errs.err(); err != nil {
+		return "", subs, err
+	}
+	var out bytes.Buffer
+	out.Grow(len(text))
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	for _, doc := range docs {
+		if err := enc.Encode(doc); err != nil {
+			return "", subs, syntaxError(p.Name, "YAML", err)
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return "", subs, syntaxError(p.Name, "YAML", err)
+	}
+	return out.String(), subs, nil
+}
+
+// documentName returns the prefix of the messages about the document doc
+// at index i of a stream: its 1-based number and, for Kubernetes objects,
+// their kind and name as in "document 2 (Deployment/web): ".
+func documentName(i int, doc *yaml.Node) string {
+	name := fmt.Sprintf("document %d", i+1)
+	if kind, objName := value(doc, "kind"), value(doc, "metadata", "name"); kind != "" {
+		name += " (" + kind
+		if objName != "" {
+			name += "/" + objName
+		}
+		name += ")"
+	}
+	return name + ": "
+}
+
+// value returns the value of the scalar at the path of mapping keys in n,
+// or an empty string if there is none.
+func value(n *yaml.Node, path ...string) string {
+	if n.Kind == yaml.DocumentNode && len(n.Content) == 1 {
+		n = n.Content[0]
+	}
+	for _, key := range path {
+		if n.Kind != yaml.MappingNode {
+			return ""
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == key {
+				next = n.Content[i+1]
+			}
+		}
+		if next == nil {
+			return ""
+		}
+		n = next
+	}
+	if n.Kind != yaml.ScalarNode {
+		return ""
+	}
+	return n.Value
+}
+
+// checkTag fails if the value substituted in the scalar n, which keeps its
+// tag, is not of the type of a tag such as !!int given in the template.
+func checkTag(n *yaml.Node, value string) error {
+	switch n.Tag {
+	case "!!int", "!!float", "!!bool", "!!null":
+	default:
+		return nil
+	}
+	var resolved yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &resolved); err != nil || len(resolved.Content) != 1 ||
+		resolved.Content[0].Kind != yaml.ScalarNode || resolved.Content[0].Tag != n.Tag {
+		return &parse.Error{Kind: parse.KindSyntax, Msg: fmt.Sprintf("value %q is not a %s", value, strings.TrimPrefix(n.Tag, "!!"))}
+	}
+	return nil
+}
+
+// scalarError moves the failures of rendering the value of the scalar n to
+// their position in text, prefixing their messages with prefix.
+func scalarError(name, prefix, text string, n *yaml.Node, err error) error {
+	start := scalarStart(text, n)
+	err = relocateError(name, text, func(pos parse.Pos) parse.Pos {
+		return parse.Pos(start + sourceOffset(text[start:], n.Value, int(pos)))
+	}, err)
+	switch err := err.(type) {
+	case *parse.Error:
+		err.Msg = prefix + err.Message()
+	case parse.ErrorList:
+		for _, e := range err {
+			e.Msg = prefix + e.Message()
+		}
+	}
+	return err
+}
+
+// scalarStart returns the offset in text of the source of the value of the
+// scalar n: past the opening quote of quoted scalars and past the header
+// line of block scalars.
+func scalarStart(text string, n *yaml.Node) int {
+	start := int(offset(text, n.Line, n.Column))
+	switch {
+	case n.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+		if i := strings.IndexByte(text[start:], '\n'); i >= 0 {
+			return start + i + 1
+		}
+		return len(text)
+	case n.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle) != 0:
+		return start + 1
+	}
+	return start
+}
+
+// scalarSkipped are the bytes of the source of a scalar its value may not
+// have: indentation, line breaks folded into spaces, quotes and escapes.
+const scalarSkipped = " \t\r\n\\\"'"
+
+// sourceOffset returns the offset in src, the source of the scalar whose
+// value is value, of the byte at offset pos of value. The value is matched
+// against the source, skipping the bytes of the source it does not have, so
+// that positions in block and multi-line scalars are found on their line.
+func sourceOffset(src, value string, pos int) int {
+	j := 0
+	for i := 0; i <= pos && i < len(value); i++ {
+		for j < len(src) && src[j] != value[i] && strings.IndexByte(scalarSkipped, src[j]) >= 0 {
+			j++
+		}
+		if i == pos {
+			break
+		}
+		j++
+	}
+	if j > len(src) {
+		j = len(src)
+	}
+	return j
+}
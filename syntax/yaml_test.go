@@ -0,0 +1,111 @@
+package syntax
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+var yamlTests = []renderTest{
+	{"plain", "name: $NAME\n", "name: web\n"},
+	{"typed", "replicas: ${REPLICAS}\n", "replicas: \"3\"\n"},
+	{"bool stays string", "enabled: ${ON}\nvalue: $NOTHING\n", "enabled: \"true\"\nvalue: \"null\"\n"},
+	{"tagged", "replicas: !!int ${REPLICAS}\nenabled: !!bool $ON\n", "replicas: !!int 3\nenabled: !!bool true\n"},
+	{"quoted stays string", "replicas: \"${REPLICAS}\"\n", "replicas: \"3\"\n"},
+	{"structure", "value: ${TRICKY}\n", "value: 'a: b # c'\n"},
+	{"escaped", "value: \"${QUOTE}\"\n", "value: \"say \\\"hi\\\"\"\n"},
+	{"keys untouched", "$NAME: $NAME\n", "$NAME: web\n"},
+	{"sequence", "- $NAME\n- x\n", "- web\n- x\n"},
+	{"comment", "# $NAME\nname: $NAME # keep\n", "# $NAME\nname: web # keep\n"},
+	{"documents", "a: $NAME\n---\nb: $NAME\n", "a: web\n---\nb: web\n"},
+	{"empty", "", ""},
+}
+
+func TestYAML(t *testing.T) {
+	doRenderTests(t, YAML, yamlTests)
+}
+
+func TestYAMLProvenance(t *testing.T) {
+	p := parse.New("test", env, parse.Relaxed)
+	p.Provenance, _ = Provenance("yaml")
+	out, _, err := YAML(p, "name: $NAME # keep\nlist: [$NAME, {n: $REPLICAS}]\nx: y\n")
+	expected := "name: web # keep # envsubst: NAME\nlist: [web, {n: \"3\"}] # envsubst: NAME, REPLICAS\nx: y\n"
+	if err != nil || out != expected {
+		t.Errorf("got %q, %v, expected %q", out, err, expected)
+	}
+}
+
+func TestYAMLErrors(t *testing.T) {
+	p := parse.New("test", env, parse.NoUnset)
+	p.Mode = parse.AllErrors
+	_, _, err := YAML(p, "a: $NAME\nb:\n  c: x ${UNSET}\n  d: \"$ALSO\"\n")
+	errs, ok := err.(parse.ErrorList)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", err)
+	}
+	for i, expected := range []struct{ line, col int }{{3, 8}, {4, 7}} {
+		if errs[i].Line != expected.line || errs[i].Col != expected.col {
+			t.Errorf("error %d at %d:%d, expected %d:%d", i, errs[i].Line, errs[i].Col, expected.line, expected.col)
+		}
+	}
+	if _, _, err := YAML(p, "a: [\n"); err == nil {
+		t.Error("expected an error for invalid YAML")
+	}
+	if _, _, err := YAML(p, "replicas: !!int $NAME\n"); err == nil {
+		t.Error("expected an error for a value not of the type of its tag")
+	}
+}
+
+func TestYAMLBlockErrors(t *testing.T) {
+	p := parse.New("test", env, parse.NoUnset)
+	p.Mode = parse.AllErrors
+	input := "a: |\n  one $NAME\n    two ${UNSET}\nb: >\n  folded\n  text ${UNSET}\nc: \"x\\\"\n  y $UNSET\"\n"
+	_, _, err := YAML(p, input)
+	errs, ok := err.(parse.ErrorList)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %v", err)
+	}
+	for i, expected := range []struct{ line, col int }{{3, 9}, {6, 8}, {8, 5}} {
+		if errs[i].Line != expected.line || errs[i].Col != expected.col {
+			t.Errorf("error %d at %d:%d, expected %d:%d", i, errs[i].Line, errs[i].Col, expected.line, expected.col)
+		}
+		if !strings.HasPrefix(input[errs[i].Pos:], "$") {
+			t.Errorf("error %d at offset %d, expected the offset of the reference", i, errs[i].Pos)
+		}
+	}
+}
+
+func TestYAMLDocumentErrors(t *testing.T) {
+	p := parse.New("test", env, parse.NoUnset)
+	p.Mode = parse.AllErrors
+	input := "a: $UNSET\n---\nkind: Deployment\nmetadata:\n  name: web\nspec: $UNSET\n"
+	_, _, err := YAML(p, input)
+	errs, ok := err.(parse.ErrorList)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", err)
+	}
+	for i, expected := range []string{
+		"document 1: variable ${UNSET} not set",
+		"document 2 (Deployment/web): variable ${UNSET} not set",
+	} {
+		if errs[i].Message() != expected {
+			t.Errorf("error %d: got %q, expected %q", i, errs[i].Message(), expected)
+		}
+	}
+	if errs[1].Line != 6 {
+		t.Errorf("error 1 on line %d, expected 6", errs[1].Line)
+	}
+	input = "a: x\n---\nkind: ConfigMap\nmetadata:\n  name: cfg\ndata:\n  conf: |\n    host=$NAME\n    port=${UNSET}\n"
+	_, _, err = YAML(p, input)
+	if errs, ok = err.(parse.ErrorList); !ok || len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", err)
+	}
+	if e := errs[0]; e.Message() != "document 2 (ConfigMap/cfg): variable ${UNSET} not set" || e.Line != 9 || e.Col != 10 {
+		t.Errorf("got %q at %d:%d, expected the error of document 2 at 9:10", e.Message(), e.Line, e.Col)
+	}
+	_, _, err = YAML(p, "a: 1\n---\nb: [\n")
+	if err == nil || !strings.Contains(err.Error(), "document 2") {
+		t.Errorf("expected an error naming document 2, got %v", err)
+	}
+}
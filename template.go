@@ -0,0 +1,64 @@
+package envsubst
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/hellt/envsubst/parse"
+	"github.com/hellt/envsubst/syntax"
+)
+
+// FuncMap returns functions for text/template templates reading the
+// environment:
+//
+//	{{ env "NAME" }}                 value of NAME, empty if not set
+//	{{ envdefault "NAME" "value" }}  value of NAME, or value if not set or empty
+//	{{ envrequired "NAME" }}         value of NAME, fails if not set or empty
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"env": os.Getenv,
+		"envdefault": func(name, value string) string {
+			if v := os.Getenv(name); v != "" {
+				return v
+			}
+			return value
+		},
+		"envrequired": func(name string) (string, error) {
+			if v := os.Getenv(name); v != "" {
+				return v, nil
+			}
+			return "", fmt.Errorf("variable ${%s} not set or empty", name)
+		},
+	}
+}
+
+// ParseTemplate substitutes the variables in text before parsing it as the
+// body of t, for templates whose structure depends on the environment. The
+// {{ ... }} actions of text are left alone, so $x template variables are not
+// mistaken for environment variables.
+func ParseTemplate(t *template.Template, text string) (*template.Template, error) {
+	s, _, err := syntax.Helm(parse.New(t.Name(), os.Environ(), parse.Relaxed), text)
+	if err != nil {
+		return nil, err
+	}
+	return t.Parse(s)
+}
+
+// ExecuteTemplate executes t with data and writes the result to w after
+// substituting the variables in it, for data holding references to the
+// environment.
+func ExecuteTemplate(w io.Writer, t *template.Template, data interface{}) error {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return err
+	}
+	s, err := parse.New(t.Name(), os.Environ(), parse.Relaxed).Parse(b.String())
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, s)
+	return err
+}
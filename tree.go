@@ -0,0 +1,160 @@
+package envsubst
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/hellt/envsubst/parse"
+)
+
+// Option configures RenderTree and NewReader.
+type Option func(*options)
+
+type options struct {
+	env      []string
+	restrict *parse.Restrictions
+	workers  int
+	match    func(path string) bool
+	render   func(path, text string) (string, error)
+}
+
+// WithEnv renders the templates with the variables of env rather than those
+// of the process environment.
+func WithEnv(env []string) Option {
+	return func(o *options) { o.env = env }
+}
+
+// WithRestrictions renders the templates with the restrictions r rather
+// than parse.Relaxed.
+func WithRestrictions(r *parse.Restrictions) Option {
+	return func(o *options) { o.restrict = r }
+}
+
+// WithWorkers makes RenderTree render n files at most at the same time
+// rather than the number of CPUs.
+func WithWorkers(n int) Option {
+	return func(o *options) { o.workers = n }
+}
+
+// WithMatch makes RenderTree render only the files whose path in the tree
+// match reports true for. The others are neither rendered nor copied.
+func WithMatch(match func(path string) bool) Option {
+	return func(o *options) { o.match = match }
+}
+
+// WithRender makes Handler, FileServer and RenderTree render the template
+// text of each path with render rather than with a parser of the environment
+// and restrictions of the options, e.g. to render the templates in their
+// syntax, see package syntax, or with parsers configured further.
+func WithRender(render func(path, text string) (string, error)) Option {
+	return func(o *options) { o.render = render }
+}
+
+// newOptions returns the options set by opts over the defaults.
+func newOptions(opts []Option) options {
+	o := options{restrict: parse.Relaxed, workers: runtime.NumCPU()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.env == nil {
+		o.env = os.Environ()
+	}
+	return o
+}
+
+// renderText renders the template text of path, see WithRender.
+func (o options) renderText(path, text string) (string, error) {
+	if o.render != nil {
+		return o.render(path, text)
+	}
+	return parse.New(path, o.env, o.restrict).Parse(text)
+}
+
+// FileResult is the outcome of rendering a file of a tree, see RenderTree.
+type FileResult struct {
+	Path   string // path of the file in the tree
+	Output string // path of the file written, empty if it failed
+	Err    error  // error reading, rendering or writing the file
+}
+
+// RenderTree renders the regular files of fsys concurrently, writing each
+// to the same path below outDir with the permissions of the original. It
+// returns the results of the files in the order of the walk of the tree,
+// and an error joining the errors of the files and of the walk, if any.
+// The files failing are not written, the others are whatever the errors.
+func RenderTree(fsys fs.FS, outDir string, opts ...Option) ([]FileResult, error) {
+	o := newOptions(opts)
+	if o.workers < 1 {
+		o.workers = 1
+	}
+	var paths []string
+	werr := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && (o.match == nil || o.match(path)) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	results := make([]FileResult, len(paths))
+	var (
+		wg   sync.WaitGroup
+		next = make(chan int)
+	)
+	for w := 0; w < o.workers && w < len(paths); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i] = renderFile(fsys, outDir, paths[i], o)
+			}
+		}()
+	}
+	for i := range paths {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	errs := []error{werr}
+	for _, res := range results {
+		errs = append(errs, res.Err)
+	}
+	return results, errors.Join(errs...)
+}
+
+// renderFile renders the file path of fsys to outDir.
+func renderFile(fsys fs.FS, outDir, path string, o options) FileResult {
+	res := FileResult{Path: path}
+	info, err := fs.Stat(fsys, path)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	b, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	s, err := o.renderText(path, string(b))
+	if err != nil {
+		res.Err = fmt.Errorf("%s: %w", path, err)
+		return res
+	}
+	out := filepath.Join(outDir, filepath.FromSlash(path))
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		res.Err = err
+		return res
+	}
+	if err := os.WriteFile(out, []byte(s), info.Mode().Perm()); err != nil {
+		res.Err = err
+		return res
+	}
+	res.Output = out
+	return res
+}
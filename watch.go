@@ -0,0 +1,115 @@
+package envsubst
+
+import (
+	"context"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/hellt/envsubst/dotenv"
+	"github.com/hellt/envsubst/parse"
+)
+
+// WatchResult is a rendering of a template watched by Watch.
+type WatchResult struct {
+	Path   string // path of the template
+	Output string // output rendered, empty on error
+	Err    error  // error reading the files or rendering the template
+}
+
+// settle is the time the changes of the files watched are gathered for
+// before rendering the templates, as writing a file may take several.
+const settle = 50 * time.Millisecond
+
+// Watch renders the templates at the given paths with the variables of the
+// env files over the environment, then renders them again whenever the
+// templates or the env files change, until ctx is done, for daemons keeping
+// the configurations derived from them up to date. The results are
+// delivered on the channel returned, closed once ctx is done: all of them
+// first, then those whose output or error changes. The variables of the
+// later env files, in the format of .env files, take precedence.
+//
+// Changes are notified by the system through fsnotify. The directories of
+// the files are watched so that files replaced by renames, such as those of
+// a Kubernetes ConfigMap, are followed. It fails if the files cannot be
+// watched.
+func Watch(ctx context.Context, templates, envFiles []string, opts ...Option) (<-chan WatchResult, error) {
+	o := newOptions(opts)
+	changes, err := watchFiles(ctx, append(slices.Clone(templates), envFiles...))
+	if err != nil {
+		return nil, err
+	}
+	results := make(chan WatchResult)
+	go func() {
+		defer close(results)
+		last := map[string]WatchResult{}
+		for {
+			env, envErr := readEnvFiles(envFiles, o.env)
+			for _, path := range templates {
+				res := WatchResult{Path: path, Err: envErr}
+				if envErr == nil {
+					res.Output, res.Err = renderWatched(path, env, o)
+				}
+				if prev, ok := last[path]; ok && prev.Output == res.Output && errorText(prev.Err) == errorText(res.Err) {
+					continue
+				}
+				last[path] = res
+				select {
+				case results <- res:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case _, ok := <-changes:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+			timer := time.NewTimer(settle)
+		Settle:
+			for {
+				select {
+				case <-changes:
+				case <-timer.C:
+					break Settle
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				}
+			}
+		}
+	}()
+	return results, nil
+}
+
+// readEnvFiles returns the variables of the env files over env.
+func readEnvFiles(paths, env []string) ([]string, error) {
+	for _, path := range paths {
+		vars, err := dotenv.Read(path)
+		if err != nil {
+			return nil, err
+		}
+		env = append(dotenv.Environ(vars), env...)
+	}
+	return env, nil
+}
+
+// renderWatched renders the template at path with env.
+func renderWatched(path string, env []string, o options) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return parse.New(path, env, o.restrict).Parse(string(b))
+}
+
+// errorText returns the message of err, empty if nil.
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
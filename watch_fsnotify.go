@@ -0,0 +1,59 @@
+package envsubst
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchFiles returns a channel receiving a value when the files at paths
+// change, closed once ctx is done. The directories of the files are watched
+// with fsnotify, to follow the files replaced by renames, including those
+// of the ..data link Kubernetes swaps to update the files of a volume.
+func watchFiles(ctx context.Context, paths []string) (<-chan struct{}, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]bool{}
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		files[abs] = true
+		if err := w.Add(filepath.Dir(abs)); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer close(changes)
+		defer w.Close()
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if files[ev.Name] || strings.HasPrefix(filepath.Base(ev.Name), "..") {
+					select {
+					case changes <- struct{}{}:
+					default:
+					}
+				}
+			case _, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes, nil
+}